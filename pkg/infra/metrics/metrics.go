@@ -61,6 +61,12 @@ var (
 	// MApiLoginSAML is a metric api login SAML counter
 	MApiLoginSAML prometheus.Counter
 
+	// MApiLoginDegraded is a metric counter for logins served without syncing the user
+	MApiLoginDegraded prometheus.Counter
+
+	// MApiLoginUserQuotaNearLimit is a metric counter for signups beyond the soft user quota limit
+	MApiLoginUserQuotaNearLimit prometheus.Counter
+
 	// MApiOrgCreate is a metric api org created counter
 	MApiOrgCreate prometheus.Counter
 
@@ -172,6 +178,9 @@ var (
 	// StatsTotalDataSources is a metric total number of defined datasources, labeled by pluginId
 	StatsTotalDataSources *prometheus.GaugeVec
 
+	// MStatOrgMembers is a metric amount of org members, labeled by org id and role
+	MStatOrgMembers *prometheus.GaugeVec
+
 	// StatsTotalAnnotations is a metric of total number of annotations stored in Grafana.
 	StatsTotalAnnotations prometheus.Gauge
 
@@ -304,6 +313,18 @@ func init() {
 		Namespace: ExporterName,
 	})
 
+	MApiLoginDegraded = newCounterStartingAtZero(prometheus.CounterOpts{
+		Name:      "api_login_degraded_total",
+		Help:      "api login degraded mode counter",
+		Namespace: ExporterName,
+	})
+
+	MApiLoginUserQuotaNearLimit = newCounterStartingAtZero(prometheus.CounterOpts{
+		Name:      "api_login_user_quota_near_limit_total",
+		Help:      "api login signups beyond the soft user quota limit counter",
+		Namespace: ExporterName,
+	})
+
 	MApiOrgCreate = newCounterStartingAtZero(prometheus.CounterOpts{
 		Name:      "api_org_create_total",
 		Help:      "api org created counter",
@@ -506,6 +527,12 @@ func init() {
 		Namespace: ExporterName,
 	}, []string{"plugin_id"})
 
+	MStatOrgMembers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      "org_members",
+		Help:      "amount of org members, labeled by org id and role",
+		Namespace: ExporterName,
+	}, []string{"org_id", "role"})
+
 	grafanaBuildVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name:      "build_info",
 		Help:      "A metric with a constant '1' value labeled by version, revision, branch, and goversion from which Grafana was built",
@@ -621,6 +648,8 @@ func initMetricVars() {
 		MApiLoginPost,
 		MApiLoginOAuth,
 		MApiLoginSAML,
+		MApiLoginDegraded,
+		MApiLoginUserQuotaNearLimit,
 		MApiOrgCreate,
 		MApiDashboardSnapshotCreate,
 		MApiDashboardSnapshotExternal,
@@ -653,6 +682,7 @@ func initMetricVars() {
 		StatsTotalActiveEditors,
 		StatsTotalActiveAdmins,
 		StatsTotalDataSources,
+		MStatOrgMembers,
 		grafanaBuildVersion,
 		grafanaPluginBuildInfoDesc,
 		StatsTotalDashboardVersions,
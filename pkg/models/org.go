@@ -84,7 +84,8 @@ type OrgDetailsDTO struct {
 }
 
 type UserOrgDTO struct {
-	OrgId int64    `json:"orgId"`
-	Name  string   `json:"name"`
-	Role  RoleType `json:"role"`
+	OrgId   int64    `json:"orgId"`
+	Name    string   `json:"name"`
+	Role    RoleType `json:"role"`
+	Expires *int64   `json:"-"`
 }
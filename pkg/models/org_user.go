@@ -87,6 +87,7 @@ type OrgUser struct {
 	Role    RoleType
 	Created time.Time
 	Updated time.Time
+	Expires *int64
 }
 
 // ---------------------
@@ -105,6 +106,8 @@ type AddOrgUserCommand struct {
 
 	OrgId  int64 `json:"-"`
 	UserId int64 `json:"-"`
+	// Expires is the unix timestamp at which the membership expires, nil means it never expires
+	Expires *int64 `json:"-"`
 }
 
 type UpdateOrgUserCommand struct {
@@ -112,6 +115,10 @@ type UpdateOrgUserCommand struct {
 
 	OrgId  int64 `json:"-"`
 	UserId int64 `json:"-"`
+	// Expires replaces the expiry of the membership when UpdateExpires is set,
+	// nil means it never expires
+	Expires       *int64 `json:"-"`
+	UpdateExpires bool   `json:"-"`
 }
 
 // ----------------------
@@ -127,6 +134,21 @@ type GetOrgUsersQuery struct {
 	Result []*OrgUserDTO
 }
 
+type GetExpiredOrgUsersQuery struct {
+	Now int64
+
+	Result []*OrgUser
+}
+
+// CountOrgAdminsQuery counts the enabled admins of an org, service accounts and
+// ExcludeUserIds aside.
+type CountOrgAdminsQuery struct {
+	OrgId          int64
+	ExcludeUserIds []int64
+
+	Result int64
+}
+
 type SearchOrgUsersQuery struct {
 	OrgID int64
 	Query string
@@ -41,8 +41,19 @@ type User struct {
 	Created    time.Time
 	Updated    time.Time
 	LastSeenAt time.Time
+
+	// LastSyncStatus and LastSyncError describe problems of the last external
+	// login sync of the user, both are empty if it had none.
+	LastSyncStatus UserSyncStatus `json:"-"`
+	LastSyncError  string         `json:"-"`
 }
 
+// UserSyncStatus is the outcome of the last external login sync of a user.
+type UserSyncStatus string
+
+// UserSyncHadErrors is the status of a sync that failed or had warnings.
+const UserSyncHadErrors UserSyncStatus = "had_errors"
+
 func (u *User) NameOrFallback() string {
 	if u.Name != "" {
 		return u.Name
@@ -215,6 +226,14 @@ type UpdateUserLastSeenAtCommand struct {
 	UserId int64
 }
 
+// UpdateUserSyncStatusCommand replaces the last sync status and error of a
+// user, empty values clear them.
+type UpdateUserSyncStatusCommand struct {
+	UserId int64
+	Status UserSyncStatus
+	Error  string
+}
+
 func (u *SignedInUser) HasRole(role RoleType) bool {
 	if u.IsGrafanaAdmin {
 		return true
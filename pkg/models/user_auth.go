@@ -1,6 +1,10 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
 	"time"
 
 	"github.com/grafana/grafana/pkg/setting"
@@ -22,6 +26,11 @@ type UserAuth struct {
 	OAuthIdToken      string
 	OAuthTokenType    string
 	OAuthExpiry       time.Time
+	// ProviderLabel is a human readable name of the identity provider, e.g. "Okta"
+	ProviderLabel string
+	// RawClaims is the JSON snapshot of the claims the user was last synced
+	// from, see ExternalUserInfo.RawClaims
+	RawClaims string
 }
 
 type ExternalUserInfo struct {
@@ -36,6 +45,100 @@ type ExternalUserInfo struct {
 	OrgRoles       map[int64]RoleType
 	IsGrafanaAdmin *bool // This is a pointer to know if we should sync this or not (nil = ignore sync)
 	IsDisabled     bool
+	// IsActive disables the user when false and re-enables it when true (nil = ignore sync)
+	IsActive *bool
+	// CustomRoles maps org ids to the UIDs of the custom roles the user should have in that org
+	CustomRoles map[int64][]string
+	// OrgRoleSources optionally describes the claim or group that produced each of the OrgRoles
+	OrgRoleSources map[int64]string
+	// OrgRoleExpiry optionally sets when the membership for each of the OrgRoles expires
+	OrgRoleExpiry map[int64]time.Time
+	// Locale and Timezone are synced to the user preferences when set
+	Locale   string
+	Timezone string
+	// OrgRolesByName are org roles keyed by org name, they're resolved to ids and
+	// merged into OrgRoles. Roles in OrgRoles take precedence.
+	OrgRolesByName map[string]RoleType
+	// EmailAliases are additional verified emails of the user, used to find an
+	// existing user when none matches the primary Email
+	EmailAliases []string
+	// ProviderLabel is a human readable name of the identity provider, it's
+	// stored with the auth info when set
+	ProviderLabel string
+	// EmailVerified is set when the identity provider verified the Email
+	EmailVerified bool
+	// Labels are stored with the user, e.g. to operate on a cohort later. They
+	// are only ever added, a label missing at a later login is kept
+	Labels []string
+	// RawClaims are the claims the identity provider sent, for debugging. A
+	// redacted and size bounded snapshot is stored with the auth info
+	RawClaims map[string]interface{}
+	// InitialPassword is set as the local password of users created from the
+	// external user, as a break-glass fallback. It's never encoded so it can't
+	// leak through serialized copies of the external user
+	InitialPassword string `json:"-"`
+}
+
+// Fingerprint returns a hash of everything synced from the external user, to
+// cheaply detect whether it changed since the last login. The OAuth token and
+// the provider label aren't included. Groups and email aliases are hashed
+// regardless of their order.
+func (e *ExternalUserInfo) Fingerprint() string {
+	customRoles := make(map[int64][]string, len(e.CustomRoles))
+	for orgID, uids := range e.CustomRoles {
+		customRoles[orgID] = sortedCopy(uids)
+	}
+
+	// maps are encoded with sorted keys, so the encoding is stable. Encoding
+	// these types can't fail.
+	encoded, _ := json.Marshal(struct {
+		AuthModule     string
+		AuthId         string
+		UserId         int64
+		Email          string
+		Login          string
+		Name           string
+		Groups         []string
+		OrgRoles       map[int64]RoleType
+		IsGrafanaAdmin *bool
+		IsDisabled     bool
+		IsActive       *bool
+		CustomRoles    map[int64][]string
+		OrgRoleSources map[int64]string
+		OrgRoleExpiry  map[int64]time.Time
+		Locale         string
+		Timezone       string
+		OrgRolesByName map[string]RoleType
+		EmailAliases   []string
+	}{
+		AuthModule:     e.AuthModule,
+		AuthId:         e.AuthId,
+		UserId:         e.UserId,
+		Email:          e.Email,
+		Login:          e.Login,
+		Name:           e.Name,
+		Groups:         sortedCopy(e.Groups),
+		OrgRoles:       e.OrgRoles,
+		IsGrafanaAdmin: e.IsGrafanaAdmin,
+		IsDisabled:     e.IsDisabled,
+		IsActive:       e.IsActive,
+		CustomRoles:    customRoles,
+		OrgRoleSources: e.OrgRoleSources,
+		OrgRoleExpiry:  e.OrgRoleExpiry,
+		Locale:         e.Locale,
+		Timezone:       e.Timezone,
+		OrgRolesByName: e.OrgRolesByName,
+		EmailAliases:   sortedCopy(e.EmailAliases),
+	})
+
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+func sortedCopy(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
 }
 
 type LoginInfo struct {
@@ -58,15 +161,204 @@ type UpsertUserCommand struct {
 	ReqContext    *ReqContext
 	ExternalUser  *ExternalUserInfo
 	SignupAllowed bool
+	// ProvisioningOrgID scopes the quota check for new users to an org instead of
+	// the org of ReqContext, for provisioning without a request
+	ProvisioningOrgID int64
+	// Overrides changes the configuration of the login service for this call only
+	Overrides *UpsertUserOverrides
+	// ForceFullSync syncs the user even if it's unchanged and doesn't defer any
+	// org, e.g. after a configuration change
+	ForceFullSync bool
+	// WithAccessSummary reads the access of the user back into AccessSummary
+	// after a successful upsert
+	WithAccessSummary bool
 
-	Result *User
+	Result        *User
+	SyncResult    *ExternalUserSyncResult
+	AccessSummary *UserAccessSummary
 }
 
-type SetAuthInfoCommand struct {
+// UserAccessSummary is the access a user has after an upsert, as stored. Orgs
+// and teams are sorted by id.
+type UserAccessSummary struct {
+	Orgs           []AccessSummaryOrg
+	Teams          []AccessSummaryTeam
+	IsGrafanaAdmin bool
+}
+
+type AccessSummaryOrg struct {
+	OrgId int64
+	Name  string
+	Role  RoleType
+}
+
+type AccessSummaryTeam struct {
+	OrgId  int64
+	TeamId int64
+	Name   string
+}
+
+// EffectiveRole combines the basic org role and the custom roles of a user in
+// an org into a single role.
+type EffectiveRole struct {
+	OrgId int64
+	// BasicRole is the org role of the user, empty if it isn't a member
+	BasicRole RoleType
+	// CustomRoles are the UIDs of the custom roles of the user, sorted
+	CustomRoles []string
+	// Role is the highest of BasicRole and the basic roles the custom roles are
+	// equivalent to, empty if there is none
+	Role RoleType
+	// ElevatedBy are the custom roles equivalent to Role when it's higher than
+	// BasicRole, sorted
+	ElevatedBy []string
+}
+
+// UpsertUserOverrides overrides the configuration of the login service for a
+// single UpsertUser call, e.g. to try a behavior out on some logins. Nil fields
+// keep the configured behavior.
+type UpsertUserOverrides struct {
+	// ObserveOnly records org role and server admin changes in the sync result
+	// instead of applying them
+	ObserveOnly                 *bool
+	StrictRoleValidation        *bool
+	AsyncOrgSync                *bool
+	AllowAutoCreateAboveMinimum *bool
+}
+
+// ExternalUserSyncResult describes the outcome of syncing an external user
+// beyond creating or updating the user itself.
+type ExternalUserSyncResult struct {
+	// Warnings are non fatal problems encountered during sync
+	Warnings []string
+	// DeferredOrgIds are the orgs whose sync was skipped and should be retried later
+	DeferredOrgIds []int64
+	// OrgRolesAdded are the orgs the user was added to, updated memberships aren't included
+	OrgRolesAdded []OrgRoleAdded
+	// ObservedChanges are the org and admin changes that weren't applied because
+	// sync was in observe only mode
+	ObservedChanges []ObservedSyncChange
+	// MatchedEmailAlias is the email alias the existing user was found by, empty
+	// if the user was found otherwise or created
+	MatchedEmailAlias string
+	// Degraded is set when the user was logged in from the last known good state
+	// without being synced because the auth info backend was unavailable
+	Degraded bool
+	// AdminGrantDeferred is set when the server admin flag of a degraded login
+	// wasn't granted since the user may be stale
+	AdminGrantDeferred bool
+	// AdminSyncFailed is set when updating the server admin flag failed and the
+	// failure was ignored
+	AdminSyncFailed bool
+	// TokenPersistFailed is set when storing the OAuth token failed and the
+	// failure was ignored
+	TokenPersistFailed bool
+	// ShadowTeamSyncDiffs are the team membership changes the shadow team sync
+	// would have made on top of the live one
+	ShadowTeamSyncDiffs []TeamSyncDiff
+	// PhaseDurations are the durations of the sync phases, e.g. "lookup" or
+	// "org_sync", when recording them is enabled. Skipped phases are missing.
+	PhaseDurations map[string]time.Duration
+}
+
+// ObservedSyncChange is a change an external sync would have made.
+type ObservedSyncChange struct {
+	Action  ObservedSyncAction
+	OrgId   int64
+	Role    RoleType
+	IsAdmin bool
+}
+
+type ObservedSyncAction string
+
+const (
+	ObservedAddOrgUser    ObservedSyncAction = "add_org_user"
+	ObservedUpdateOrgUser ObservedSyncAction = "update_org_user"
+	ObservedRemoveOrgUser ObservedSyncAction = "remove_org_user"
+	ObservedUpdateAdmin   ObservedSyncAction = "update_admin"
+)
+
+// TeamSyncDiff is a team membership change a shadow team sync would have made.
+type TeamSyncDiff struct {
+	OrgId  int64
+	TeamId int64
+	// Added is set when the user would have been added to the team, and unset
+	// when it would have been removed
+	Added bool
+}
+
+// OrgRoleAdded is an org membership created by an external sync.
+type OrgRoleAdded struct {
+	OrgId int64
+	Role  RoleType
+}
+
+// SuspiciousSyncDetectedEvent is published when an external sync would remove
+// an unusually large share of a user's org memberships, which usually means the
+// identity provider returned a truncated set of org roles.
+type SuspiciousSyncDetectedEvent struct {
+	UserId        int64
+	AuthModule    string
+	CurrentOrgIds []int64
+	RemovedOrgIds []int64
+}
+
+// SignupQuotaReachedEvent is published when an external user isn't created
+// because the user quota is reached. It only identifies the user, other user
+// info isn't included.
+type SignupQuotaReachedEvent struct {
 	AuthModule string
-	AuthId     string
-	UserId     int64
-	OAuthToken *oauth2.Token
+	Login      string
+	// OrgId is the org the quota was checked in, zero for the global quota
+	OrgId int64
+}
+
+// UserQuotaNearLimitEvent is published when an external user is created while
+// the global user quota is used beyond the soft limit of the login service.
+type UserQuotaNearLimitEvent struct {
+	AuthModule string
+	Login      string
+	// Used is the number of users before the user was created
+	Used  int64
+	Limit int64
+}
+
+// CircuitBreakerState is the state of a login dependency circuit breaker.
+type CircuitBreakerState string
+
+const (
+	CircuitBreakerClosed   CircuitBreakerState = "closed"
+	CircuitBreakerOpen     CircuitBreakerState = "open"
+	CircuitBreakerHalfOpen CircuitBreakerState = "half_open"
+)
+
+// LoginCircuitBreakerStateChangedEvent is published when the circuit breaker
+// of a dependency used by external logins changes state.
+type LoginCircuitBreakerStateChangedEvent struct {
+	Dependency string
+	State      CircuitBreakerState
+	// ConsecutiveFailures is the number of slow or failed calls that opened the
+	// breaker, zero for other states
+	ConsecutiveFailures int
+}
+
+// OrgRoleSyncedEvent is published when an external login adds, updates or
+// removes the membership of a user in an organization. Role is empty for
+// removals.
+type OrgRoleSyncedEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	UserId    int64     `json:"userId"`
+	OrgId     int64     `json:"orgId"`
+	Role      RoleType  `json:"role"`
+}
+
+type SetAuthInfoCommand struct {
+	AuthModule    string
+	AuthId        string
+	UserId        int64
+	OAuthToken    *oauth2.Token
+	ProviderLabel string
+	RawClaims     string
 }
 
 type UpdateAuthInfoCommand struct {
@@ -74,6 +366,13 @@ type UpdateAuthInfoCommand struct {
 	AuthId     string
 	UserId     int64
 	OAuthToken *oauth2.Token
+	// ClearOAuthToken removes the stored token when OAuthToken is nil, instead
+	// of keeping it
+	ClearOAuthToken bool
+	// ProviderLabel is updated when set, an empty label keeps the stored one
+	ProviderLabel string
+	// RawClaims is updated when set, like ProviderLabel
+	RawClaims string
 }
 
 type DeleteAuthInfoCommand struct {
@@ -99,8 +398,54 @@ type GetUserByAuthInfoQuery struct {
 	UserId     int64
 	Email      string
 	Login      string
+	// UserColumns restricts the user columns fetched for a user found by its
+	// auth info, if the store supports it. All columns are fetched when empty.
+	UserColumns []string
+	// SkipDisabledMatch makes a disabled user found by email or login instead of
+	// by its auth info not match, it's neither linked nor returned but set in
+	// SkippedDisabledUser.
+	SkipDisabledMatch   bool
+	SkippedDisabledUser *User
+	// AutoLink controls whether the auth module is linked to a user found by
+	// email or login. EmailVerified is used by AutoLinkIfEmailVerified.
+	AutoLink      AutoLinkPolicy
+	EmailVerified bool
+	// MultiMatch controls which user is returned when the email and the login
+	// match different users.
+	MultiMatch MultiMatchPolicy
 }
 
+// AutoLinkPolicy controls whether an external identity is linked to an existing
+// user that was found by its details instead of its auth info.
+type AutoLinkPolicy int
+
+const (
+	// AutoLinkAlways links the identity (default).
+	AutoLinkAlways AutoLinkPolicy = iota
+	// AutoLinkNever doesn't link the identity, the user is still found by its
+	// details on the next login.
+	AutoLinkNever
+	// AutoLinkIfEmailVerified links the identity if the user was found by an
+	// email that the identity provider verified.
+	AutoLinkIfEmailVerified
+)
+
+// MultiMatchPolicy controls which user an external identity is matched with
+// when its email and its login match different existing users.
+type MultiMatchPolicy int
+
+const (
+	// MultiMatchPreferEmail matches the user with the email (default).
+	MultiMatchPreferEmail MultiMatchPolicy = iota
+	// MultiMatchReject matches neither user, the lookup fails.
+	MultiMatchReject
+	// MultiMatchPreferOldest matches the user that was created first.
+	MultiMatchPreferOldest
+	// MultiMatchPreferByAuthId matches the user already linked to the auth
+	// module of the identity, the lookup fails if none or both are.
+	MultiMatchPreferByAuthId
+)
+
 type GetExternalUserInfoByLoginQuery struct {
 	LoginOrEmail string
 
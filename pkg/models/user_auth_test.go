@@ -0,0 +1,75 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExternalUserInfo_Fingerprint(t *testing.T) {
+	isAdmin := true
+	extUser := func() *ExternalUserInfo {
+		return &ExternalUserInfo{
+			AuthModule:     "oauth_okta",
+			AuthId:         "sub-alice",
+			Login:          "alice",
+			Email:          "alice@example.org",
+			Name:           "Alice",
+			Groups:         []string{"admins", "developers", "viewers"},
+			OrgRoles:       map[int64]RoleType{1: ROLE_ADMIN, 2: ROLE_EDITOR, 3: ROLE_VIEWER, 4: ROLE_VIEWER},
+			IsGrafanaAdmin: &isAdmin,
+			CustomRoles:    map[int64][]string{1: {"role-a", "role-b"}},
+		}
+	}
+	fingerprint := extUser().Fingerprint()
+
+	t.Run("is stable", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			other := extUser()
+			// maps are rebuilt in a different order
+			other.OrgRoles = map[int64]RoleType{4: ROLE_VIEWER, 3: ROLE_VIEWER, 2: ROLE_EDITOR, 1: ROLE_ADMIN}
+			other.Groups = []string{"viewers", "admins", "developers"}
+			other.CustomRoles = map[int64][]string{1: {"role-b", "role-a"}}
+			assert.Equal(t, fingerprint, other.Fingerprint())
+		}
+	})
+
+	t.Run("doesn't change the external user", func(t *testing.T) {
+		other := extUser()
+		other.Groups = []string{"viewers", "admins"}
+		other.Fingerprint()
+		assert.Equal(t, []string{"viewers", "admins"}, other.Groups)
+	})
+
+	t.Run("ignores the token and provider label", func(t *testing.T) {
+		other := extUser()
+		other.ProviderLabel = "Okta"
+		assert.Equal(t, fingerprint, other.Fingerprint())
+	})
+
+	notAdmin := false
+	changes := map[string]func(*ExternalUserInfo){
+		"auth id":          func(e *ExternalUserInfo) { e.AuthId = "sub-bob" },
+		"login":            func(e *ExternalUserInfo) { e.Login = "alice2" },
+		"email":            func(e *ExternalUserInfo) { e.Email = "alice@example.com" },
+		"name":             func(e *ExternalUserInfo) { e.Name = "Alice Liddell" },
+		"group added":      func(e *ExternalUserInfo) { e.Groups = append(e.Groups, "ops") },
+		"role changed":     func(e *ExternalUserInfo) { e.OrgRoles[2] = ROLE_VIEWER },
+		"org removed":      func(e *ExternalUserInfo) { delete(e.OrgRoles, 4) },
+		"admin flag":       func(e *ExternalUserInfo) { e.IsGrafanaAdmin = &notAdmin },
+		"admin flag unset": func(e *ExternalUserInfo) { e.IsGrafanaAdmin = nil },
+		"disabled":         func(e *ExternalUserInfo) { e.IsDisabled = true },
+		"custom roles":     func(e *ExternalUserInfo) { e.CustomRoles[1] = []string{"role-a"} },
+		"expiry":           func(e *ExternalUserInfo) { e.OrgRoleExpiry = map[int64]time.Time{1: time.Unix(0, 0)} },
+		"roles by name":    func(e *ExternalUserInfo) { e.OrgRolesByName = map[string]RoleType{"Main Org.": ROLE_VIEWER} },
+		"email aliases":    func(e *ExternalUserInfo) { e.EmailAliases = []string{"alice@example.net"} },
+	}
+	for name, change := range changes {
+		t.Run("changes with "+name, func(t *testing.T) {
+			other := extUser()
+			change(other)
+			assert.NotEqual(t, fingerprint, other.Fingerprint())
+		})
+	}
+}
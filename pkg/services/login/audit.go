@@ -0,0 +1,44 @@
+package login
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// LoginAuditEntry describes changes an external login sync made to a user.
+type LoginAuditEntry struct {
+	UserId     int64
+	Login      string
+	AuthModule string
+	Time       time.Time
+	Changes    []LoginAuditChange
+}
+
+// LoginAuditChange is a single change of a LoginAuditEntry.
+type LoginAuditChange struct {
+	Action LoginAuditAction
+	// OrgId and Role are set for org role changes, Role is empty for removals.
+	OrgId int64
+	Role  models.RoleType
+	// IsAdmin is the new server admin flag of AuditAdminUpdated.
+	IsAdmin bool
+}
+
+type LoginAuditAction string
+
+const (
+	AuditUserCreated    LoginAuditAction = "user_created"
+	AuditUserUpdated    LoginAuditAction = "user_updated"
+	AuditOrgRoleAdded   LoginAuditAction = "org_role_added"
+	AuditOrgRoleUpdated LoginAuditAction = "org_role_updated"
+	AuditOrgRoleRemoved LoginAuditAction = "org_role_removed"
+	AuditAdminUpdated   LoginAuditAction = "admin_updated"
+)
+
+// AuditSink receives the audit entries of external login syncs. Errors are
+// logged, they don't fail the sync.
+type AuditSink interface {
+	Audit(ctx context.Context, entry *LoginAuditEntry) error
+}
@@ -13,3 +13,12 @@ type AuthInfoService interface {
 	SetAuthInfo(ctx context.Context, cmd *models.SetAuthInfoCommand) error
 	UpdateAuthInfo(ctx context.Context, cmd *models.UpdateAuthInfoCommand) error
 }
+
+// OrphanedAuthInfoStore finds and removes auth info of users that don't exist,
+// e.g. because they were deleted without their auth info.
+type OrphanedAuthInfoStore interface {
+	// GetOrphanedAuthInfo returns the auth info rows without a user. Tokens
+	// aren't included.
+	GetOrphanedAuthInfo(ctx context.Context) ([]*models.UserAuth, error)
+	DeleteAuthInfo(ctx context.Context, cmd *models.DeleteAuthInfoCommand) error
+}
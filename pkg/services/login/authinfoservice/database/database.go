@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"time"
 
 	"github.com/grafana/grafana/pkg/bus"
@@ -44,13 +45,19 @@ func (s *AuthInfoStore) GetExternalUserInfoByLogin(ctx context.Context, query *m
 	}
 
 	query.Result = &models.ExternalUserInfo{
-		UserId:     userQuery.Result.Id,
-		Login:      userQuery.Result.Login,
-		Email:      userQuery.Result.Email,
-		Name:       userQuery.Result.Name,
-		IsDisabled: userQuery.Result.IsDisabled,
-		AuthModule: authInfoQuery.Result.AuthModule,
-		AuthId:     authInfoQuery.Result.AuthId,
+		UserId:        userQuery.Result.Id,
+		Login:         userQuery.Result.Login,
+		Email:         userQuery.Result.Email,
+		Name:          userQuery.Result.Name,
+		IsDisabled:    userQuery.Result.IsDisabled,
+		AuthModule:    authInfoQuery.Result.AuthModule,
+		AuthId:        authInfoQuery.Result.AuthId,
+		ProviderLabel: authInfoQuery.Result.ProviderLabel,
+	}
+	if authInfoQuery.Result.RawClaims != "" {
+		if err := json.Unmarshal([]byte(authInfoQuery.Result.RawClaims), &query.Result.RawClaims); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -108,10 +115,12 @@ func (s *AuthInfoStore) GetAuthInfo(ctx context.Context, query *models.GetAuthIn
 
 func (s *AuthInfoStore) SetAuthInfo(ctx context.Context, cmd *models.SetAuthInfoCommand) error {
 	authUser := &models.UserAuth{
-		UserId:     cmd.UserId,
-		AuthModule: cmd.AuthModule,
-		AuthId:     cmd.AuthId,
-		Created:    GetTime(),
+		UserId:        cmd.UserId,
+		AuthModule:    cmd.AuthModule,
+		AuthId:        cmd.AuthId,
+		Created:       GetTime(),
+		ProviderLabel: cmd.ProviderLabel,
+		RawClaims:     cmd.RawClaims,
 	}
 
 	if cmd.OAuthToken != nil {
@@ -151,10 +160,12 @@ func (s *AuthInfoStore) SetAuthInfo(ctx context.Context, cmd *models.SetAuthInfo
 
 func (s *AuthInfoStore) UpdateAuthInfo(ctx context.Context, cmd *models.UpdateAuthInfoCommand) error {
 	authUser := &models.UserAuth{
-		UserId:     cmd.UserId,
-		AuthModule: cmd.AuthModule,
-		AuthId:     cmd.AuthId,
-		Created:    GetTime(),
+		UserId:        cmd.UserId,
+		AuthModule:    cmd.AuthModule,
+		AuthId:        cmd.AuthId,
+		Created:       GetTime(),
+		ProviderLabel: cmd.ProviderLabel,
+		RawClaims:     cmd.RawClaims,
 	}
 
 	if cmd.OAuthToken != nil {
@@ -192,7 +203,17 @@ func (s *AuthInfoStore) UpdateAuthInfo(ctx context.Context, cmd *models.UpdateAu
 	}
 
 	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
-		upd, err := sess.Update(authUser, cond)
+		var upd int64
+		var err error
+		if cmd.OAuthToken == nil && cmd.ClearOAuthToken {
+			// empty columns are only written when listed, the condition can't be a
+			// struct then since it would require them to be empty too
+			upd, err = sess.Where("user_id = ? AND auth_module = ?", cmd.UserId, cmd.AuthModule).
+				MustCols("o_auth_access_token", "o_auth_refresh_token", "o_auth_id_token", "o_auth_token_type", "o_auth_expiry").
+				Update(authUser)
+		} else {
+			upd, err = sess.Update(authUser, cond)
+		}
 		s.logger.Debug("Updated user_auth", "user_id", cmd.UserId, "auth_module", cmd.AuthModule, "rows", upd)
 		return err
 	})
@@ -214,6 +235,26 @@ func (s *AuthInfoStore) GetUserById(ctx context.Context, id int64) (*models.User
 	return query.Result, nil
 }
 
+// GetUserColumnsById gets a user with only the given columns set. The id is
+// always fetched.
+func (s *AuthInfoStore) GetUserColumnsById(ctx context.Context, id int64, columns []string) (*models.User, error) {
+	user := &models.User{}
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		has, err := sess.ID(id).Cols(append([]string{"id"}, columns...)...).Get(user)
+		if err != nil {
+			return err
+		}
+		if !has {
+			return models.ErrUserNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 func (s *AuthInfoStore) GetUserByLogin(ctx context.Context, login string) (*models.User, error) {
 	query := models.GetUserByLoginQuery{LoginOrEmail: login}
 	if err := s.sqlStore.GetUserByLogin(ctx, &query); err != nil {
@@ -0,0 +1,92 @@
+package authinfoservice
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// lookupByDetails finds the user of query by id, email or login. When the email
+// and the login match different users, the MultiMatch policy of the query picks
+// one. With the default policy, the user with the email is found without
+// looking up the login.
+func (s *Implementation) lookupByDetails(ctx context.Context, query *models.GetUserByAuthInfoQuery) (*models.User, error) {
+	if query.MultiMatch == models.MultiMatchPreferEmail || query.Email == "" || query.Login == "" {
+		return s.LookupByOneOf(ctx, query.UserId, query.Email, query.Login)
+	}
+
+	if query.UserId != 0 {
+		user, err := s.LookupByOneOf(ctx, query.UserId, "", "")
+		if !errors.Is(err, models.ErrUserNotFound) {
+			return user, err
+		}
+	}
+	byEmail, err := s.LookupByOneOf(ctx, 0, query.Email, "")
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		return nil, err
+	}
+	byLogin, err := s.LookupByOneOf(ctx, 0, "", query.Login)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		return nil, err
+	}
+
+	switch {
+	case byEmail == nil && byLogin == nil:
+		return nil, models.ErrUserNotFound
+	case byLogin == nil:
+		return byEmail, nil
+	case byEmail == nil || byEmail.Id == byLogin.Id:
+		return byLogin, nil
+	}
+	return s.resolveMultiMatch(ctx, query, byEmail, byLogin)
+}
+
+// resolveMultiMatch picks the user matched by the email or the one matched by
+// the login according to the MultiMatch policy of query.
+func (s *Implementation) resolveMultiMatch(ctx context.Context, query *models.GetUserByAuthInfoQuery, byEmail, byLogin *models.User) (*models.User, error) {
+	ambiguous := &login.ErrAmbiguousUser{Email: query.Email, Login: query.Login, UserIds: []int64{byEmail.Id, byLogin.Id}}
+
+	var user *models.User
+	switch query.MultiMatch {
+	case models.MultiMatchPreferOldest:
+		user = byEmail
+		if byLogin.Created.Before(byEmail.Created) || (byLogin.Created.Equal(byEmail.Created) && byLogin.Id < byEmail.Id) {
+			user = byLogin
+		}
+	case models.MultiMatchPreferByAuthId:
+		emailLinked, err := s.isLinked(ctx, byEmail.Id, query.AuthModule)
+		if err != nil {
+			return nil, err
+		}
+		loginLinked, err := s.isLinked(ctx, byLogin.Id, query.AuthModule)
+		if err != nil {
+			return nil, err
+		}
+		if emailLinked == loginLinked {
+			return nil, ambiguous
+		}
+		user = byLogin
+		if emailLinked {
+			user = byEmail
+		}
+	default:
+		return nil, ambiguous
+	}
+
+	s.logger.Debug("Email and login match different users", "userIds", ambiguous.UserIds, "policy", query.MultiMatch, "userId", user.Id)
+	return user, nil
+}
+
+// isLinked reports whether the user has an auth id of the auth module.
+func (s *Implementation) isLinked(ctx context.Context, userID int64, authModule string) (bool, error) {
+	if authModule == "" {
+		return false, nil
+	}
+	err := s.authInfoStore.GetAuthInfo(ctx, &models.GetAuthInfoQuery{UserId: userID, AuthModule: authModule})
+	if errors.Is(err, models.ErrUserNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -3,6 +3,7 @@ package authinfoservice
 import (
 	"context"
 	"errors"
+	"strings"
 
 	"github.com/grafana/grafana/pkg/infra/log"
 	"github.com/grafana/grafana/pkg/models"
@@ -52,7 +53,7 @@ func (s *Implementation) LookupAndFix(ctx context.Context, query *models.GetUser
 
 				return false, nil, nil, models.ErrUserNotFound
 			} else {
-				user, err := s.authInfoStore.GetUserById(ctx, authQuery.Result.UserId)
+				user, err := s.getUserById(ctx, authQuery.Result.UserId, query.UserColumns)
 				if err != nil {
 					if errors.Is(err, models.ErrUserNotFound) {
 						// if the user has been deleted then remove the entry
@@ -76,6 +77,15 @@ func (s *Implementation) LookupAndFix(ctx context.Context, query *models.GetUser
 	return false, nil, nil, models.ErrUserNotFound
 }
 
+// getUserById gets a user with only the given columns if the store supports
+// it, falling back to fetching the full user.
+func (s *Implementation) getUserById(ctx context.Context, id int64, columns []string) (*models.User, error) {
+	if projectionStore, ok := s.authInfoStore.(login.UserProjectionStore); ok && len(columns) > 0 {
+		return projectionStore.GetUserColumnsById(ctx, id, columns)
+	}
+	return s.authInfoStore.GetUserById(ctx, id)
+}
+
 func (s *Implementation) LookupByOneOf(ctx context.Context, userId int64, email string, login string) (*models.User, error) {
 	var user *models.User
 	var err error
@@ -137,10 +147,14 @@ func (s *Implementation) LookupAndUpdate(ctx context.Context, query *models.GetU
 
 	// 2. FindByUserDetails
 	if !foundUser {
-		user, err = s.LookupByOneOf(ctx, query.UserId, query.Email, query.Login)
+		user, err = s.lookupByDetails(ctx, query)
 		if err != nil {
 			return nil, err
 		}
+		if query.SkipDisabledMatch && user.IsDisabled {
+			query.SkippedDisabledUser = user
+			return nil, models.ErrUserNotFound
+		}
 	}
 
 	if err := s.UserProtectionService.AllowUserMapping(user, query.AuthModule); err != nil {
@@ -158,7 +172,7 @@ func (s *Implementation) LookupAndUpdate(ctx context.Context, query *models.GetU
 		authInfo = ai
 	}
 
-	if authInfo == nil && query.AuthModule != "" {
+	if authInfo == nil && query.AuthModule != "" && s.shouldAutoLink(query, user) {
 		cmd := &models.SetAuthInfoCommand{
 			UserId:     user.Id,
 			AuthModule: query.AuthModule,
@@ -172,6 +186,22 @@ func (s *Implementation) LookupAndUpdate(ctx context.Context, query *models.GetU
 	return user, nil
 }
 
+// shouldAutoLink reports whether the auth module of query should be linked to
+// user, which was found by its details, according to the AutoLink policy.
+func (s *Implementation) shouldAutoLink(query *models.GetUserByAuthInfoQuery, user *models.User) bool {
+	switch query.AutoLink {
+	case models.AutoLinkNever:
+		s.logger.Debug("Not linking auth module to user found by its details", "userId", user.Id, "authModule", query.AuthModule)
+		return false
+	case models.AutoLinkIfEmailVerified:
+		if !query.EmailVerified || query.Email == "" || !strings.EqualFold(query.Email, user.Email) {
+			s.logger.Debug("Not linking auth module to user not found by a verified email", "userId", user.Id, "authModule", query.AuthModule)
+			return false
+		}
+	}
+	return true
+}
+
 func (s *Implementation) GetAuthInfo(ctx context.Context, query *models.GetAuthInfoQuery) error {
 	return s.authInfoStore.GetAuthInfo(ctx, query)
 }
@@ -0,0 +1,46 @@
+package authinfoservice
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/require"
+)
+
+func BenchmarkLookupAndUpdateFull(b *testing.B) { benchmarkLookupAndUpdate(b, nil) }
+
+func BenchmarkLookupAndUpdateProjected(b *testing.B) {
+	benchmarkLookupAndUpdate(b, []string{"login", "email", "name", "is_admin", "is_disabled", "org_id"})
+}
+
+func benchmarkLookupAndUpdate(b *testing.B, columns []string) {
+	sqlStore := sqlstore.InitTestDB(b)
+	secretsService := secretsManager.SetupTestService(b, secretstore.ProvideSecretsStore(sqlStore))
+	srv := ProvideAuthInfoService(&OSSUserProtectionImpl{}, database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService))
+
+	const users = 100
+	for i := 0; i < users; i++ {
+		user, err := sqlStore.CreateUser(context.Background(), models.CreateUserCommand{Login: fmt.Sprint("user", i), Email: fmt.Sprint("user", i, "@test.com")})
+		require.NoError(b, err)
+		err = srv.SetAuthInfo(context.Background(), &models.SetAuthInfoCommand{UserId: user.Id, AuthModule: "oauth_bench", AuthId: fmt.Sprint("id", i)})
+		require.NoError(b, err)
+	}
+	// We don't want to measure DB initialization
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, err := srv.LookupAndUpdate(context.Background(), &models.GetUserByAuthInfoQuery{
+			AuthModule:  "oauth_bench",
+			AuthId:      fmt.Sprint("id", i%users),
+			UserColumns: columns,
+		})
+		require.NoError(b, err)
+	}
+}
@@ -2,12 +2,14 @@ package authinfoservice
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
 	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
 	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
 	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
@@ -231,6 +233,92 @@ func TestUserAuth(t *testing.T) {
 			require.Equal(t, getAuthQuery.Result.AuthModule, "test1")
 		})
 
+		t.Run("Can fetch a projection of the user found by auth info", func(t *testing.T) {
+			user, err := srv.LookupAndUpdate(context.Background(), &models.GetUserByAuthInfoQuery{Login: "loginuser1", AuthModule: "test_projection", AuthId: "projection"})
+			require.Nil(t, err)
+
+			query := &models.GetUserByAuthInfoQuery{AuthModule: "test_projection", AuthId: "projection", UserColumns: []string{"login"}}
+			projected, err := srv.LookupAndUpdate(context.Background(), query)
+			require.Nil(t, err)
+			require.Equal(t, user.Id, projected.Id)
+			require.Equal(t, "loginuser1", projected.Login)
+			require.Empty(t, projected.Email)
+
+			// stores without projection support return the full user
+			fullSrv := ProvideAuthInfoService(&OSSUserProtectionImpl{}, struct{ login.Store }{authInfoStore})
+			full, err := fullSrv.LookupAndUpdate(context.Background(), query)
+			require.Nil(t, err)
+			require.Equal(t, "user1@test.com", full.Email)
+		})
+
+		t.Run("Can set & update the provider label", func(t *testing.T) {
+			login := "loginuser2"
+			user, err := srv.LookupAndUpdate(context.Background(), &models.GetUserByAuthInfoQuery{Login: login})
+			require.Nil(t, err)
+
+			token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer"}
+			err = authInfoStore.SetAuthInfo(context.Background(), &models.SetAuthInfoCommand{
+				UserId:        user.Id,
+				AuthModule:    "oauth_okta",
+				AuthId:        "label",
+				OAuthToken:    token,
+				ProviderLabel: "Okta",
+			})
+			require.Nil(t, err)
+
+			getAuthQuery := &models.GetAuthInfoQuery{UserId: user.Id, AuthModule: "oauth_okta"}
+			require.Nil(t, srv.GetAuthInfo(context.Background(), getAuthQuery))
+			require.Equal(t, "Okta", getAuthQuery.Result.ProviderLabel)
+
+			externalQuery := &models.GetExternalUserInfoByLoginQuery{LoginOrEmail: login}
+			require.Nil(t, srv.GetExternalUserInfoByLogin(context.Background(), externalQuery))
+			require.Equal(t, "Okta", externalQuery.Result.ProviderLabel)
+
+			err = authInfoStore.UpdateAuthInfo(context.Background(), &models.UpdateAuthInfoCommand{
+				UserId:        user.Id,
+				AuthModule:    "oauth_okta",
+				AuthId:        "label",
+				ProviderLabel: "Okta (EU)",
+			})
+			require.Nil(t, err)
+
+			getAuthQuery = &models.GetAuthInfoQuery{UserId: user.Id, AuthModule: "oauth_okta"}
+			require.Nil(t, srv.GetAuthInfo(context.Background(), getAuthQuery))
+			require.Equal(t, "Okta (EU)", getAuthQuery.Result.ProviderLabel)
+			// updating only the label keeps the token
+			require.Equal(t, "access", getAuthQuery.Result.OAuthAccessToken)
+		})
+
+		t.Run("Can clear the stored token", func(t *testing.T) {
+			login := "loginuser3"
+			user, err := srv.LookupAndUpdate(context.Background(), &models.GetUserByAuthInfoQuery{Login: login})
+			require.Nil(t, err)
+
+			token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+			err = authInfoStore.SetAuthInfo(context.Background(), &models.SetAuthInfoCommand{
+				UserId:     user.Id,
+				AuthModule: "oauth_okta",
+				AuthId:     "clear",
+				OAuthToken: token,
+			})
+			require.Nil(t, err)
+
+			err = authInfoStore.UpdateAuthInfo(context.Background(), &models.UpdateAuthInfoCommand{
+				UserId:          user.Id,
+				AuthModule:      "oauth_okta",
+				AuthId:          "clear",
+				ClearOAuthToken: true,
+			})
+			require.Nil(t, err)
+
+			getAuthQuery := &models.GetAuthInfoQuery{UserId: user.Id, AuthModule: "oauth_okta"}
+			require.Nil(t, srv.GetAuthInfo(context.Background(), getAuthQuery))
+			require.Empty(t, getAuthQuery.Result.OAuthAccessToken)
+			require.Empty(t, getAuthQuery.Result.OAuthRefreshToken)
+			require.Empty(t, getAuthQuery.Result.OAuthTokenType)
+			require.True(t, getAuthQuery.Result.OAuthExpiry.IsZero())
+		})
+
 		t.Run("Can set & locate by generic oauth auth module and user id", func(t *testing.T) {
 			// Find a user to set tokens on
 			login := "loginuser0"
@@ -255,3 +343,123 @@ func TestUserAuth(t *testing.T) {
 		})
 	})
 }
+
+func TestUserAuth_autoLink(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+	authInfoStore := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)
+	srv := ProvideAuthInfoService(&OSSUserProtectionImpl{}, authInfoStore)
+
+	user, err := sqlStore.CreateUser(context.Background(), models.CreateUserCommand{Login: "alice", Email: "alice@example.org"})
+	require.NoError(t, err)
+
+	isLinked := func(t *testing.T, authModule string) bool {
+		err := srv.GetAuthInfo(context.Background(), &models.GetAuthInfoQuery{UserId: user.Id, AuthModule: authModule})
+		if errors.Is(err, models.ErrUserNotFound) {
+			return false
+		}
+		require.NoError(t, err)
+		return true
+	}
+	lookup := func(t *testing.T, query *models.GetUserByAuthInfoQuery) {
+		found, err := srv.LookupAndUpdate(context.Background(), query)
+		require.NoError(t, err)
+		require.Equal(t, user.Id, found.Id)
+	}
+
+	t.Run("users found by their details are linked by default", func(t *testing.T) {
+		lookup(t, &models.GetUserByAuthInfoQuery{AuthModule: "oauth_github", AuthId: "1", Login: "alice"})
+		require.True(t, isLinked(t, "oauth_github"))
+	})
+
+	t.Run("users aren't linked with AutoLinkNever", func(t *testing.T) {
+		lookup(t, &models.GetUserByAuthInfoQuery{AuthModule: "oauth_gitlab", AuthId: "1", Email: "alice@example.org", EmailVerified: true, AutoLink: models.AutoLinkNever})
+		require.False(t, isLinked(t, "oauth_gitlab"))
+	})
+
+	t.Run("users are linked with AutoLinkIfEmailVerified if found by a verified email", func(t *testing.T) {
+		lookup(t, &models.GetUserByAuthInfoQuery{AuthModule: "oauth_okta", AuthId: "1", Email: "alice@example.org", AutoLink: models.AutoLinkIfEmailVerified})
+		require.False(t, isLinked(t, "oauth_okta"))
+
+		lookup(t, &models.GetUserByAuthInfoQuery{AuthModule: "oauth_okta", AuthId: "1", Login: "alice", EmailVerified: true, AutoLink: models.AutoLinkIfEmailVerified})
+		require.False(t, isLinked(t, "oauth_okta"), "users found by login aren't linked")
+
+		lookup(t, &models.GetUserByAuthInfoQuery{AuthModule: "oauth_okta", AuthId: "1", Email: "alice@example.org", EmailVerified: true, AutoLink: models.AutoLinkIfEmailVerified})
+		require.True(t, isLinked(t, "oauth_okta"))
+	})
+}
+
+func TestUserAuth_multiMatch(t *testing.T) {
+	ctx := context.Background()
+
+	// alice's email and login match bob and carol, bob was created after carol
+	setup := func(t *testing.T) (*Implementation, *models.User, *models.User) {
+		sqlStore := sqlstore.InitTestDB(t)
+		secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+		authInfoStore := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)
+		srv := ProvideAuthInfoService(&OSSUserProtectionImpl{}, authInfoStore)
+
+		bob, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "bob", Email: "alice@example.org"})
+		require.NoError(t, err)
+		carol, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "carol@example.org"})
+		require.NoError(t, err)
+		err = sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+			_, err := sess.Exec("UPDATE "+sqlStore.Dialect.Quote("user")+" SET created = ? WHERE id = ?", time.Now().Add(-time.Hour), carol.Id)
+			return err
+		})
+		require.NoError(t, err)
+		return srv, bob, carol
+	}
+	lookup := func(srv *Implementation, policy models.MultiMatchPolicy) (*models.User, error) {
+		return srv.LookupAndUpdate(ctx, &models.GetUserByAuthInfoQuery{
+			AuthModule: "oauth_okta",
+			AuthId:     "alice-id",
+			Email:      "alice@example.org",
+			Login:      "alice",
+			AutoLink:   models.AutoLinkNever,
+			MultiMatch: policy,
+		})
+	}
+
+	t.Run("the user with the email is found by default", func(t *testing.T) {
+		srv, bob, _ := setup(t)
+
+		user, err := lookup(srv, models.MultiMatchPreferEmail)
+		require.NoError(t, err)
+		require.Equal(t, bob.Id, user.Id)
+	})
+
+	t.Run("no user is found with MultiMatchReject", func(t *testing.T) {
+		srv, bob, carol := setup(t)
+
+		user, err := lookup(srv, models.MultiMatchReject)
+		var ambiguousErr *login.ErrAmbiguousUser
+		require.True(t, errors.As(err, &ambiguousErr))
+		require.Equal(t, []int64{bob.Id, carol.Id}, ambiguousErr.UserIds)
+		require.Nil(t, user)
+	})
+
+	t.Run("the oldest user is found with MultiMatchPreferOldest", func(t *testing.T) {
+		srv, _, carol := setup(t)
+
+		user, err := lookup(srv, models.MultiMatchPreferOldest)
+		require.NoError(t, err)
+		require.Equal(t, carol.Id, user.Id)
+	})
+
+	t.Run("the linked user is found with MultiMatchPreferByAuthId", func(t *testing.T) {
+		srv, bob, carol := setup(t)
+
+		_, err := lookup(srv, models.MultiMatchPreferByAuthId)
+		require.True(t, errors.As(err, new(*login.ErrAmbiguousUser)), "neither user is linked")
+
+		require.NoError(t, srv.SetAuthInfo(ctx, &models.SetAuthInfoCommand{UserId: carol.Id, AuthModule: "oauth_okta", AuthId: "old-alice-id"}))
+		user, err := lookup(srv, models.MultiMatchPreferByAuthId)
+		require.NoError(t, err)
+		require.Equal(t, carol.Id, user.Id)
+
+		require.NoError(t, srv.SetAuthInfo(ctx, &models.SetAuthInfoCommand{UserId: bob.Id, AuthModule: "oauth_okta", AuthId: "bob-id"}))
+		_, err = lookup(srv, models.MultiMatchPreferByAuthId)
+		require.True(t, errors.As(err, new(*login.ErrAmbiguousUser)), "both users are linked")
+	})
+}
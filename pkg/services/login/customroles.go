@@ -0,0 +1,13 @@
+package login
+
+import (
+	"context"
+)
+
+// CustomRoleService manages the custom (fine-grained) roles granted to users.
+// Roles are referenced by their UID.
+type CustomRoleService interface {
+	GetUserCustomRoles(ctx context.Context, orgID, userID int64) ([]string, error)
+	AddUserCustomRole(ctx context.Context, orgID, userID int64, roleUID string) error
+	RemoveUserCustomRole(ctx context.Context, orgID, userID int64, roleUID string) error
+}
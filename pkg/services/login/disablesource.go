@@ -0,0 +1,29 @@
+package login
+
+import (
+	"context"
+)
+
+// DisableSource is the reason a user was disabled.
+type DisableSource string
+
+const (
+	// DisableSourceLDAPAbsence is used for users disabled because they weren't found in LDAP.
+	DisableSourceLDAPAbsence DisableSource = "ldap_absence"
+	DisableSourceManual      DisableSource = "manual"
+	DisableSourceSecurity    DisableSource = "security"
+	// DisableSourceInactivity is used for users disabled because they weren't seen for too long.
+	DisableSourceInactivity DisableSource = "inactivity"
+	// DisableSourceIdPInactive is used for users disabled because the identity provider marked them inactive.
+	DisableSourceIdPInactive DisableSource = "idp_inactive"
+	// DisableSourceSoftDelete is used for users disabled because they were soft deleted.
+	DisableSourceSoftDelete DisableSource = "soft_delete"
+)
+
+// DisableSourceStore persists why users were disabled. GetDisableSource returns
+// an empty source when none was recorded.
+type DisableSourceStore interface {
+	GetDisableSource(ctx context.Context, userID int64) (DisableSource, error)
+	SetDisableSource(ctx context.Context, userID int64, source DisableSource) error
+	DeleteDisableSource(ctx context.Context, userID int64) error
+}
@@ -0,0 +1,15 @@
+package login
+
+import (
+	"context"
+)
+
+// UserLabelStore persists the labels of users, see
+// models.ExternalUserInfo.Labels.
+type UserLabelStore interface {
+	// AddUserLabels adds labels to a user, labels it already has are kept.
+	AddUserLabels(ctx context.Context, userID int64, labels []string) error
+	// GetUserIdsByLabel returns the ids of the users with the label, in
+	// ascending order.
+	GetUserIdsByLabel(ctx context.Context, label string) ([]int64, error)
+}
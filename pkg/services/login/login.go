@@ -3,19 +3,235 @@ package login
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/grafana/grafana/pkg/models"
 )
 
 var (
-	ErrInvalidCredentials = errors.New("invalid username or password")
-	ErrUsersQuotaReached  = errors.New("users quota reached")
-	ErrGettingUserQuota   = errors.New("error getting user quota")
-	ErrSignupNotAllowed   = errors.New("system administrator has disabled signup")
+	ErrInvalidCredentials  = errors.New("invalid username or password")
+	ErrUsersQuotaReached   = errors.New("users quota reached")
+	ErrGettingUserQuota    = errors.New("error getting user quota")
+	ErrSignupNotAllowed    = errors.New("system administrator has disabled signup")
+	ErrUserLockingDisabled = errors.New("user locking is not configured")
+	ErrRepairDisabled      = errors.New("auth info repair is not configured")
+	ErrSoftDeleteDisabled  = errors.New("soft delete is not configured")
+	ErrOutboxDisabled      = errors.New("the login outbox is not configured")
+	ErrUserLabelsDisabled  = errors.New("user labels are not configured")
+	ErrMemberStatsDisabled = errors.New("org member counts are not configured")
+	ErrBreakGlassExternal  = errors.New("break-glass login belongs to an external user")
+	ErrUserSoftDeleted     = errors.New("user is deleted")
+	ErrUserDisabled        = errors.New("user is disabled")
+	ErrMissingEmail        = errors.New("external user has no email")
+	ErrInvalidOrgId        = errors.New("invalid organization id")
+	ErrMissingAuthId       = errors.New("auth id is required")
+	ErrMissingLogin        = errors.New("external user has no login")
+	ErrInvalidLogin        = errors.New("invalid login")
+	ErrProvisioningPaused  = errors.New("user provisioning is paused for maintenance")
+	ErrWeakPassword        = errors.New("initial password is too weak")
 )
 
+// ErrUserLocked is returned when a locked user tries to log in.
+type ErrUserLocked struct {
+	Reason string
+}
+
+func (e *ErrUserLocked) Error() string {
+	return fmt.Sprintf("Your account is locked: %s", e.Reason)
+}
+
+// ErrCircuitOpen is returned without calling a dependency while its circuit
+// breaker is open.
+type ErrCircuitOpen struct {
+	Dependency string
+	// RetryAfter is when the breaker lets a trial call through.
+	RetryAfter time.Time
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	return fmt.Sprintf("circuit breaker of %s is open until %s", e.Dependency, e.RetryAfter.Format(time.RFC3339))
+}
+
+// ErrLoginThrottled is returned when a remote address made too many external
+// logins recently.
+type ErrLoginThrottled struct {
+	RemoteAddr string
+	RetryAfter time.Time
+}
+
+func (e *ErrLoginThrottled) Error() string {
+	return fmt.Sprintf("too many logins from %s, retry after %s", e.RemoteAddr, e.RetryAfter.Format(time.RFC3339))
+}
+
+// ErrOutsideLoginWindow is returned when an external user logs in outside of a
+// login window that applies to them.
+type ErrOutsideLoginWindow struct {
+	Login      string
+	AuthModule string
+}
+
+func (e *ErrOutsideLoginWindow) Error() string {
+	return fmt.Sprintf("user %q of auth module %s can't log in at this time", e.Login, e.AuthModule)
+}
+
+// ErrAmbiguousUser is returned when the email and the login of an external user
+// match different existing users and models.MultiMatchPolicy can't pick one.
+type ErrAmbiguousUser struct {
+	Email   string
+	Login   string
+	UserIds []int64
+}
+
+func (e *ErrAmbiguousUser) Error() string {
+	return fmt.Sprintf("email %q and login %q match different users %v", e.Email, e.Login, e.UserIds)
+}
+
+// ErrDisplayNameTaken is returned when the display name of an external user is
+// taken by another user and display names must be unique.
+type ErrDisplayNameTaken struct {
+	Name string
+}
+
+func (e *ErrDisplayNameTaken) Error() string {
+	return fmt.Sprintf("display name %q is taken by another user", e.Name)
+}
+
+// ErrLoginDeniedByGroup is returned when the groups of an external user don't
+// allow them to log in. Group is the denied group the user is in, empty if the
+// user isn't in any of the allowed groups.
+type ErrLoginDeniedByGroup struct {
+	Login string
+	Group string
+}
+
+func (e *ErrLoginDeniedByGroup) Error() string {
+	if e.Group == "" {
+		return fmt.Sprintf("user %q isn't in any of the groups allowed to log in", e.Login)
+	}
+	return fmt.Sprintf("user %q can't log in as a member of group %q", e.Login, e.Group)
+}
+
+// ErrMissingAuthModule is returned when an external user has an auth id but no
+// auth module, a user created from it couldn't be linked to its identity.
+type ErrMissingAuthModule struct {
+	AuthId string
+}
+
+func (e *ErrMissingAuthModule) Error() string {
+	return fmt.Sprintf("external user with auth id %q has no auth module", e.AuthId)
+}
+
+// ErrAuthIdInUse is returned when rotating an auth id to one that is already
+// linked to another user.
+type ErrAuthIdInUse struct {
+	AuthModule string
+	AuthId     string
+	UserId     int64
+}
+
+func (e *ErrAuthIdInUse) Error() string {
+	return fmt.Sprintf("auth id %q of auth module %s is already linked to user %d", e.AuthId, e.AuthModule, e.UserId)
+}
+
+// ErrAuthInfoLinkFailed is returned when a user was created but linking it to
+// its external identity failed. If RolledBack is false, removing the created
+// user failed as well and the user exists without an auth-info linkage.
+type ErrAuthInfoLinkFailed struct {
+	UserId     int64
+	AuthModule string
+	RolledBack bool
+	Err        error
+}
+
+func (e *ErrAuthInfoLinkFailed) Error() string {
+	if e.RolledBack {
+		return fmt.Sprintf("failed to link created user %d to auth module %s, user creation was rolled back: %v", e.UserId, e.AuthModule, e.Err)
+	}
+	return fmt.Sprintf("failed to link created user %d to auth module %s, user exists without auth info: %v", e.UserId, e.AuthModule, e.Err)
+}
+
+func (e *ErrAuthInfoLinkFailed) Unwrap() error {
+	return e.Err
+}
+
+// ErrInvalidOrgRole is returned when an external user has an org role that isn't
+// a known role or role alias.
+type ErrInvalidOrgRole struct {
+	OrgId int64
+	Role  models.RoleType
+}
+
+func (e *ErrInvalidOrgRole) Error() string {
+	return fmt.Sprintf("invalid role %q for organization %d", e.Role, e.OrgId)
+}
+
+// ErrInvariantViolation is returned with StrictInvariants when the stored state
+// of a user breaks an invariant that the sync otherwise tolerates.
+type ErrInvariantViolation struct {
+	UserId    int64
+	Invariant string
+}
+
+func (e *ErrInvariantViolation) Error() string {
+	return fmt.Sprintf("invariant violated for user %d: %s", e.UserId, e.Invariant)
+}
+
+// ErrSyncVerificationFailed is returned with VerifyAfterSync when the stored
+// state of a user doesn't match what the sync wrote.
+type ErrSyncVerificationFailed struct {
+	UserId     int64
+	Mismatches []string
+}
+
+func (e *ErrSyncVerificationFailed) Error() string {
+	return fmt.Sprintf("sync verification failed for user %d: %s", e.UserId, strings.Join(e.Mismatches, "; "))
+}
+
+// UpsertPhase is the step of UpsertUser in which an error occurred.
+type UpsertPhase string
+
+const (
+	UpsertPhaseLookup   UpsertPhase = "lookup"
+	UpsertPhaseCreate   UpsertPhase = "create"
+	UpsertPhaseUpdate   UpsertPhase = "update"
+	UpsertPhaseAuthInfo UpsertPhase = "auth info"
+	UpsertPhaseOrgSync  UpsertPhase = "org sync"
+	UpsertPhaseTeamSync UpsertPhase = "team sync"
+)
+
+// ErrUpsertUser wraps an error returned by UpsertUser with the phase it failed in.
+// Policy rejections such as ErrSignupNotAllowed or ErrUsersQuotaReached are
+// returned as is.
+type ErrUpsertUser struct {
+	Phase UpsertPhase
+	Err   error
+}
+
+func (e *ErrUpsertUser) Error() string {
+	return fmt.Sprintf("failed to upsert user, %s failed: %v", e.Phase, e.Err)
+}
+
+func (e *ErrUpsertUser) Unwrap() error {
+	return e.Err
+}
+
+// Is matches another *ErrUpsertUser with the same phase, or with an empty phase
+// to match any phase, e.g. errors.Is(err, &ErrUpsertUser{Phase: UpsertPhaseOrgSync}).
+func (e *ErrUpsertUser) Is(target error) bool {
+	t, ok := target.(*ErrUpsertUser)
+	if !ok {
+		return false
+	}
+	return t.Phase == "" || t.Phase == e.Phase
+}
+
 type TeamSyncFunc func(user *models.User, externalUser *models.ExternalUserInfo) error
 
+// UserFactoryFunc sets additional fields on the command creating an external user.
+type UserFactoryFunc func(externalUser *models.ExternalUserInfo, cmd *models.CreateUserCommand)
+
 type Service interface {
 	CreateUser(cmd models.CreateUserCommand) (*models.User, error)
 	UpsertUser(ctx context.Context, cmd *models.UpsertUserCommand) error
@@ -0,0 +1,59 @@
+package loginservice
+
+import (
+	"context"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// setAccessSummary reads the access of the upserted user back into
+// cmd.AccessSummary if cmd.WithAccessSummary is set. Reading the stored state
+// makes it accurate when syncs were skipped or deferred, org roles synced by
+// AsyncOrgSync are only included once the background sync ran. The summary is
+// left nil if it can't be read, the upsert itself succeeded.
+func (ls *Implementation) setAccessSummary(ctx context.Context, cmd *models.UpsertUserCommand) {
+	if !cmd.WithAccessSummary {
+		return
+	}
+
+	summary, err := ls.accessSummary(ctx, cmd.Result.Id)
+	if err != nil {
+		logger.Warn("Failed to read the access summary of the user", "userId", cmd.Result.Id, "error", err)
+		return
+	}
+	cmd.AccessSummary = summary
+}
+
+func (ls *Implementation) accessSummary(ctx context.Context, userID int64) (*models.UserAccessSummary, error) {
+	store := ls.readStore(ctx, true)
+
+	userQuery := &models.GetUserByIdQuery{Id: userID}
+	if err := store.GetUserById(ctx, userQuery); err != nil {
+		return nil, err
+	}
+	summary := &models.UserAccessSummary{
+		Orgs:           []models.AccessSummaryOrg{},
+		Teams:          []models.AccessSummaryTeam{},
+		IsGrafanaAdmin: userQuery.Result.IsAdmin,
+	}
+
+	orgQuery := &models.GetUserOrgListQuery{UserId: userID}
+	if err := store.GetUserOrgList(ctx, orgQuery); err != nil {
+		return nil, err
+	}
+	sort.Slice(orgQuery.Result, func(i, j int) bool { return orgQuery.Result[i].OrgId < orgQuery.Result[j].OrgId })
+	for _, org := range orgQuery.Result {
+		summary.Orgs = append(summary.Orgs, models.AccessSummaryOrg{OrgId: org.OrgId, Name: org.Name, Role: org.Role})
+
+		teamQuery := &models.GetTeamsByUserQuery{OrgId: org.OrgId, UserId: userID}
+		if err := store.GetTeamsByUser(ctx, teamQuery); err != nil {
+			return nil, err
+		}
+		sort.Slice(teamQuery.Result, func(i, j int) bool { return teamQuery.Result[i].Id < teamQuery.Result[j].Id })
+		for _, team := range teamQuery.Result {
+			summary.Teams = append(summary.Teams, models.AccessSummaryTeam{OrgId: team.OrgId, TeamId: team.Id, Name: team.Name})
+		}
+	}
+	return summary, nil
+}
@@ -0,0 +1,106 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	"github.com/grafana/grafana/pkg/services/quota"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_accessSummary(t *testing.T) {
+	ctx := context.Background()
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+
+	// the orgs of the owners are the orgs alice is synced to
+	var orgIDs []int64
+	for _, login := range []string{"owner1", "owner2", "owner3"} {
+		owner, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: login, Email: login + "@example.org"})
+		require.NoError(t, err)
+		orgIDs = append(orgIDs, owner.OrgId)
+	}
+	team, err := sqlStore.CreateTeam("platform", "", orgIDs[0])
+	require.NoError(t, err)
+
+	loginService := &Implementation{
+		SQLStore:          sqlStore,
+		QuotaService:      &quota.QuotaService{Cfg: setting.NewCfg()},
+		AuthInfoService:   authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)),
+		SyncTopNOrgs:      2,
+		SkipUnchangedSync: true,
+		TeamSync: func(user *models.User, externalUser *models.ExternalUserInfo) error {
+			err := sqlStore.AddTeamMember(user.Id, team.OrgId, team.Id, true, 0)
+			if errors.Is(err, models.ErrTeamMemberAlreadyAdded) {
+				return nil
+			}
+			return err
+		},
+	}
+	isAdmin := true
+	upsert := func(t *testing.T, withSummary bool) *models.UpsertUserCommand {
+		cmd := &models.UpsertUserCommand{
+			SignupAllowed: true,
+			ExternalUser: &models.ExternalUserInfo{
+				AuthModule:     "oauth_okta",
+				AuthId:         "sub-alice",
+				Login:          "alice",
+				Email:          "alice@example.org",
+				IsGrafanaAdmin: &isAdmin,
+				OrgRoles: map[int64]models.RoleType{
+					orgIDs[0]: models.ROLE_ADMIN,
+					orgIDs[1]: models.ROLE_EDITOR,
+					orgIDs[2]: models.ROLE_VIEWER,
+				},
+			},
+			WithAccessSummary: withSummary,
+		}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		return cmd
+	}
+	storedOrgs := func(t *testing.T, userID int64) []models.AccessSummaryOrg {
+		query := &models.GetUserOrgListQuery{UserId: userID}
+		require.NoError(t, sqlStore.GetUserOrgList(ctx, query))
+		orgs := []models.AccessSummaryOrg{}
+		for _, org := range query.Result {
+			orgs = append(orgs, models.AccessSummaryOrg{OrgId: org.OrgId, Name: org.Name, Role: org.Role})
+		}
+		return orgs
+	}
+
+	t.Run("the summary matches the state after a capped sync", func(t *testing.T) {
+		cmd := upsert(t, true)
+		require.Equal(t, []int64{orgIDs[2]}, cmd.SyncResult.DeferredOrgIds)
+
+		require.NotNil(t, cmd.AccessSummary)
+		assert.ElementsMatch(t, storedOrgs(t, cmd.Result.Id), cmd.AccessSummary.Orgs)
+		assert.Len(t, cmd.AccessSummary.Orgs, 2)
+		assert.Equal(t, []models.AccessSummaryTeam{{OrgId: team.OrgId, TeamId: team.Id, Name: "platform"}}, cmd.AccessSummary.Teams)
+		assert.True(t, cmd.AccessSummary.IsGrafanaAdmin)
+	})
+
+	t.Run("the summary is set when the sync of an unchanged user is skipped", func(t *testing.T) {
+		// the deferred org is synced by the second login, the third one is skipped
+		upsert(t, false)
+		cmd := upsert(t, true)
+
+		require.NotNil(t, cmd.AccessSummary)
+		assert.ElementsMatch(t, storedOrgs(t, cmd.Result.Id), cmd.AccessSummary.Orgs)
+		assert.Len(t, cmd.AccessSummary.Orgs, 3)
+	})
+
+	t.Run("the summary isn't read unless asked for", func(t *testing.T) {
+		cmd := upsert(t, false)
+		assert.Nil(t, cmd.AccessSummary)
+	})
+}
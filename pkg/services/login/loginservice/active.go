@@ -0,0 +1,28 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// syncActive disables or re-enables an existing user according to the active
+// claim of the identity provider. Only users it disabled itself are re-enabled
+// when a DisableSourceStore is configured.
+func (ls *Implementation) syncActive(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	if extUser.IsActive == nil || *extUser.IsActive != user.IsDisabled {
+		return nil
+	}
+
+	if *extUser.IsActive {
+		return ls.reenableUser(ctx, user, login.DisableSourceIdPInactive)
+	}
+
+	logger.Info("Disabling user marked inactive by the identity provider", "userId", user.Id, "authmodule", extUser.AuthModule)
+	if err := ls.DisableUserWithSource(ctx, user.Id, login.DisableSourceIdPInactive); err != nil {
+		return err
+	}
+	user.IsDisabled = true
+	return nil
+}
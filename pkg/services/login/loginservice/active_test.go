@@ -0,0 +1,89 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_isActive(t *testing.T) {
+	setup := func(user *models.User) (*Implementation, *fakeStore, *fakeDisableSourceStore) {
+		store := newFakeStore(user)
+		sources := &fakeDisableSourceStore{sources: map[int64]login.DisableSource{}}
+		return &Implementation{
+			SQLStore:           store,
+			AuthInfoService:    &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			DisableSourceStore: sources,
+		}, store, sources
+	}
+	upsert := func(loginService *Implementation, authModule string, isActive bool) *models.UpsertUserCommand {
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: authModule, Login: "alice", IsActive: &isActive}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		return cmd
+	}
+
+	t.Run("active user marked inactive is disabled", func(t *testing.T) {
+		loginService, store, sources := setup(&models.User{Id: 1, Login: "alice"})
+
+		cmd := upsert(loginService, "oauth_generic_oauth", false)
+
+		assert.True(t, cmd.Result.IsDisabled)
+		assert.True(t, store.users[1].IsDisabled)
+		assert.Equal(t, login.DisableSourceIdPInactive, sources.sources[1])
+	})
+
+	t.Run("user disabled as inactive is re-enabled when active", func(t *testing.T) {
+		loginService, store, sources := setup(&models.User{Id: 1, Login: "alice"})
+		upsert(loginService, "oauth_generic_oauth", false)
+
+		cmd := upsert(loginService, "oauth_generic_oauth", true)
+
+		assert.False(t, cmd.Result.IsDisabled)
+		assert.False(t, store.users[1].IsDisabled)
+		assert.NotContains(t, sources.sources, int64(1))
+	})
+
+	t.Run("user disabled for another reason stays disabled when active", func(t *testing.T) {
+		loginService, store, sources := setup(&models.User{Id: 1, Login: "alice"})
+		require.NoError(t, loginService.DisableUserWithSource(context.Background(), 1, login.DisableSourceSecurity))
+
+		upsert(loginService, "oauth_generic_oauth", true)
+
+		assert.True(t, store.users[1].IsDisabled)
+		assert.Equal(t, login.DisableSourceSecurity, sources.sources[1])
+	})
+
+	t.Run("inactive claim overrides the LDAP re-enable", func(t *testing.T) {
+		loginService, store, sources := setup(&models.User{Id: 1, Login: "alice"})
+		require.NoError(t, loginService.DisableUserWithSource(context.Background(), 1, login.DisableSourceLDAPAbsence))
+
+		upsert(loginService, models.AuthModuleLDAP, false)
+
+		assert.True(t, store.users[1].IsDisabled)
+		assert.Equal(t, login.DisableSourceLDAPAbsence, sources.sources[1])
+	})
+
+	t.Run("new user marked inactive is created disabled", func(t *testing.T) {
+		store := newFakeStore()
+		sources := &fakeDisableSourceStore{sources: map[int64]login.DisableSource{}}
+		loginService := &Implementation{
+			SQLStore:           store,
+			QuotaService:       &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService:    &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}},
+			DisableSourceStore: sources,
+		}
+		isActive := false
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "alice", IsActive: &isActive}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.True(t, store.users[cmd.Result.Id].IsDisabled)
+		assert.Equal(t, login.DisableSourceIdPInactive, sources.sources[cmd.Result.Id])
+	})
+}
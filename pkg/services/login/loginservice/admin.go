@@ -0,0 +1,138 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// AdminAllowlist lists the users that external sync may grant server admin to.
+// Logins are compared case insensitively.
+type AdminAllowlist struct {
+	UserIds []int64
+	Logins  []string
+}
+
+func (a *AdminAllowlist) allows(user *models.User) bool {
+	for _, id := range a.UserIds {
+		if id == user.Id {
+			return true
+		}
+	}
+	for _, login := range a.Logins {
+		if strings.EqualFold(login, user.Login) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminSyncFailurePolicy controls what happens when updating the server admin
+// flag of a user fails.
+type AdminSyncFailurePolicy int
+
+const (
+	// AdminSyncFailFatal fails the login (default).
+	AdminSyncFailFatal AdminSyncFailurePolicy = iota
+	// AdminSyncFailWarn logs in the user with the old flag and records the
+	// failure in the sync result.
+	AdminSyncFailWarn
+)
+
+// adminClaim is the last server admin flag claimed for a user that differs from
+// the current flag, and on how many consecutive logins it was claimed.
+type adminClaim struct {
+	isAdmin bool
+	count   int
+}
+
+// adminClaimTracker tracks pending server admin grants for AdminFlagStableLogins.
+type adminClaimTracker struct {
+	mu     sync.Mutex
+	claims map[int64]adminClaim
+}
+
+// observe records a claimed change of the flag and returns on how many
+// consecutive logins it has been claimed.
+func (t *adminClaimTracker) observe(userID int64, isAdmin bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.claims == nil {
+		t.claims = map[int64]adminClaim{}
+	}
+
+	claim := t.claims[userID]
+	if claim.count == 0 || claim.isAdmin != isAdmin {
+		claim = adminClaim{isAdmin: isAdmin}
+	}
+	claim.count++
+	t.claims[userID] = claim
+	return claim.count
+}
+
+func (t *adminClaimTracker) reset(userID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.claims, userID)
+}
+
+// syncGrafanaAdmin syncs the server admin flag. Grants to users outside
+// AdminGrantAllowlist are ignored, revocations are always applied. With
+// AdminFlagStableLogins, a grant is only applied once it has been claimed on
+// that many consecutive logins, while revocations are applied right away.
+func (ls *Implementation) syncGrafanaAdmin(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, state *syncState) error {
+	if extUser.IsGrafanaAdmin == nil {
+		return nil
+	}
+	if *extUser.IsGrafanaAdmin == user.IsAdmin {
+		ls.adminClaims.reset(user.Id)
+		return nil
+	}
+
+	isAdmin := *extUser.IsGrafanaAdmin
+	if isAdmin && ls.AdminGrantAllowlist != nil && !ls.AdminGrantAllowlist.allows(user) {
+		logger.Warn("Ignoring server admin grant for user outside the allowlist", "userId", user.Id, "login", user.Login, "authmodule", extUser.AuthModule)
+		state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("ignored server admin grant for user %q outside the allowlist", user.Login))
+		return nil
+	}
+
+	if isAdmin && state.degraded {
+		logger.Warn("Deferring server admin grant of degraded login", "userId", user.Id)
+		state.result.AdminGrantDeferred = true
+		return nil
+	}
+
+	if state.observing {
+		logger.Info("Observe only, not applying server admin change", "userId", user.Id, "isAdmin", isAdmin, "observeUntil", ls.ObserveUntil)
+		state.result.ObservedChanges = append(state.result.ObservedChanges, models.ObservedSyncChange{Action: models.ObservedUpdateAdmin, IsAdmin: isAdmin})
+		return nil
+	}
+
+	if isAdmin && ls.AdminFlagStableLogins > 1 {
+		if count := ls.adminClaims.observe(user.Id, isAdmin); count < ls.AdminFlagStableLogins {
+			logger.Debug("Not granting server admin until it's stable", "userId", user.Id, "logins", count, "required", ls.AdminFlagStableLogins)
+			return nil
+		}
+	}
+
+	if err := ls.SQLStore.UpdateUserPermissions(user.Id, isAdmin); err != nil {
+		if ls.OnAdminSyncFailure != AdminSyncFailWarn {
+			return err
+		}
+		logger.Warn("Failed to sync server admin flag, continuing", "userId", user.Id, "isAdmin", isAdmin, "error", err)
+		state.result.AdminSyncFailed = true
+		state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("failed to sync server admin flag of user %q: %v", user.Login, err))
+		return nil
+	}
+	user.IsAdmin = isAdmin
+	state.writtenAdmin = &isAdmin
+	ls.audit(ctx, state, user, extUser, login.LoginAuditChange{Action: login.AuditAdminUpdated, IsAdmin: isAdmin})
+	ls.adminClaims.reset(user.Id)
+	return nil
+}
@@ -0,0 +1,148 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_adminGrantAllowlist(t *testing.T) {
+	tests := []struct {
+		desc            string
+		user            *models.User
+		isGrafanaAdmin  bool
+		expectedIsAdmin bool
+		expectWarning   bool
+	}{
+		{
+			desc:            "grant to an allowed login",
+			user:            &models.User{Id: 1, Login: "Alice"},
+			isGrafanaAdmin:  true,
+			expectedIsAdmin: true,
+		},
+		{
+			desc:            "grant to an allowed id",
+			user:            &models.User{Id: 2, Login: "bob"},
+			isGrafanaAdmin:  true,
+			expectedIsAdmin: true,
+		},
+		{
+			desc:            "grant to a user outside the allowlist is ignored",
+			user:            &models.User{Id: 3, Login: "mallory"},
+			isGrafanaAdmin:  true,
+			expectedIsAdmin: false,
+			expectWarning:   true,
+		},
+		{
+			desc:            "revocation is honored for a user outside the allowlist",
+			user:            &models.User{Id: 3, Login: "mallory", IsAdmin: true},
+			isGrafanaAdmin:  false,
+			expectedIsAdmin: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			store := newFakeStore(tt.user)
+			loginService := &Implementation{
+				SQLStore:            store,
+				AuthInfoService:     &logintest.AuthInfoServiceFake{ExpectedUser: tt.user},
+				AdminGrantAllowlist: &AdminAllowlist{UserIds: []int64{2}, Logins: []string{"alice"}},
+			}
+
+			cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: tt.user.Login, IsGrafanaAdmin: &tt.isGrafanaAdmin}}
+			require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+			assert.Equal(t, tt.expectedIsAdmin, store.users[tt.user.Id].IsAdmin)
+			if tt.expectWarning {
+				assert.Len(t, cmd.SyncResult.Warnings, 1)
+			} else {
+				assert.Empty(t, cmd.SyncResult.Warnings)
+			}
+		})
+	}
+}
+
+func Test_UpsertUser_adminFlagStableLogins(t *testing.T) {
+	user := &models.User{Id: 1, Login: "alice"}
+	store := newFakeStore(user)
+	loginService := &Implementation{
+		SQLStore:              store,
+		AuthInfoService:       &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		AdminFlagStableLogins: 3,
+	}
+
+	login := func(isAdmin bool) bool {
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", IsGrafanaAdmin: &isAdmin}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		return store.users[user.Id].IsAdmin
+	}
+
+	// alternating claims never flip the flag
+	for i := 0; i < 3; i++ {
+		assert.False(t, login(true))
+		assert.False(t, login(false))
+	}
+
+	assert.False(t, login(true))
+	assert.False(t, login(true))
+	assert.Equal(t, adminClaim{isAdmin: true, count: 2}, loginService.adminClaims.claims[user.Id])
+	assert.True(t, login(true), "flag should flip on the third consecutive claim")
+	assert.NotContains(t, loginService.adminClaims.claims, user.Id)
+
+	assert.False(t, login(false), "revocations should be applied right away")
+
+	assert.False(t, login(true))
+	assert.False(t, login(false), "a claim matching the current flag resets the count")
+	assert.False(t, login(true))
+	assert.False(t, login(true))
+	assert.True(t, login(true))
+}
+
+// failingPermissionsStore fails every server admin flag update.
+type failingPermissionsStore struct {
+	*fakeStore
+	err error
+}
+
+func (s *failingPermissionsStore) UpdateUserPermissions(userID int64, isAdmin bool) error {
+	return s.err
+}
+
+func Test_UpsertUser_adminSyncFailurePolicy(t *testing.T) {
+	errPermissions := errors.New("permission denied")
+
+	setup := func(policy AdminSyncFailurePolicy) (*Implementation, *models.UpsertUserCommand) {
+		user := &models.User{Id: 1, Login: "alice"}
+		isAdmin := true
+		return &Implementation{
+			SQLStore:           &failingPermissionsStore{fakeStore: newFakeStore(user), err: errPermissions},
+			AuthInfoService:    &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			OnAdminSyncFailure: policy,
+		}, &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", IsGrafanaAdmin: &isAdmin}}
+	}
+
+	t.Run("failure is fatal by default", func(t *testing.T) {
+		loginService, cmd := setup(AdminSyncFailFatal)
+
+		err := loginService.UpsertUser(context.Background(), cmd)
+		require.ErrorIs(t, err, errPermissions)
+		assert.ErrorIs(t, err, &login.ErrUpsertUser{Phase: login.UpsertPhaseUpdate})
+		assert.False(t, cmd.SyncResult.AdminSyncFailed)
+	})
+
+	t.Run("failure is recorded when warning", func(t *testing.T) {
+		loginService, cmd := setup(AdminSyncFailWarn)
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		assert.False(t, cmd.Result.IsAdmin)
+		assert.True(t, cmd.SyncResult.AdminSyncFailed)
+		assert.Len(t, cmd.SyncResult.Warnings, 1)
+	})
+}
@@ -0,0 +1,65 @@
+package loginservice
+
+import (
+	"context"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// AdminReconcileReport is the result of ReconcileServerAdmins. Users are listed
+// by id, in ascending order.
+type AdminReconcileReport struct {
+	Granted   []int64
+	Revoked   []int64
+	Unchanged []int64
+	// Skipped are the users that weren't granted server admin because they're
+	// outside AdminGrantAllowlist.
+	Skipped []int64
+}
+
+// ReconcileServerAdmins sets the server admin flag of the users in desired to
+// their desired value where it differs, e.g. to correct drift periodically.
+// Users that aren't in desired are left alone. Like for synced users, grants
+// to users outside AdminGrantAllowlist are skipped while revocations are
+// always applied. It stops at the first user that can't be read or updated.
+func (ls *Implementation) ReconcileServerAdmins(ctx context.Context, desired map[int64]bool) (*AdminReconcileReport, error) {
+	userIDs := make([]int64, 0, len(desired))
+	for userID := range desired {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+
+	report := &AdminReconcileReport{}
+	for _, userID := range userIDs {
+		userQuery := &models.GetUserByIdQuery{Id: userID}
+		if err := ls.readStore(ctx, false).GetUserById(ctx, userQuery); err != nil {
+			return nil, err
+		}
+		user := userQuery.Result
+
+		isAdmin := desired[userID]
+		if user.IsAdmin == isAdmin {
+			report.Unchanged = append(report.Unchanged, userID)
+			continue
+		}
+		if isAdmin && ls.AdminGrantAllowlist != nil && !ls.AdminGrantAllowlist.allows(user) {
+			logger.Warn("Not granting server admin to user outside the allowlist", "userId", userID, "login", user.Login)
+			report.Skipped = append(report.Skipped, userID)
+			continue
+		}
+
+		if err := ls.SQLStore.UpdateUserPermissions(userID, isAdmin); err != nil {
+			return nil, err
+		}
+		ls.adminClaims.reset(userID)
+		if isAdmin {
+			report.Granted = append(report.Granted, userID)
+		} else {
+			report.Revoked = append(report.Revoked, userID)
+		}
+	}
+
+	logger.Info("Reconciled server admins", "granted", report.Granted, "revoked", report.Revoked, "skipped", report.Skipped)
+	return report, nil
+}
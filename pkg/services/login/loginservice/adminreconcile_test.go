@@ -0,0 +1,53 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileServerAdmins(t *testing.T) {
+	setup := func() (*Implementation, *fakeStore) {
+		store := newFakeStore(
+			&models.User{Id: 1, Login: "admin", IsAdmin: true},
+			&models.User{Id: 2, Login: "alice"},
+			&models.User{Id: 3, Login: "bob", IsAdmin: true},
+			&models.User{Id: 4, Login: "carol"},
+			&models.User{Id: 5, Login: "dave", IsAdmin: true},
+		)
+		return &Implementation{SQLStore: store}, store
+	}
+
+	t.Run("only users that differ are updated", func(t *testing.T) {
+		loginService, store := setup()
+
+		report, err := loginService.ReconcileServerAdmins(context.Background(), map[int64]bool{1: true, 2: true, 3: false, 4: false})
+		require.NoError(t, err)
+
+		assert.Equal(t, &AdminReconcileReport{Granted: []int64{2}, Revoked: []int64{3}, Unchanged: []int64{1, 4}}, report)
+		assert.True(t, store.users[2].IsAdmin)
+		assert.False(t, store.users[3].IsAdmin)
+		assert.True(t, store.users[5].IsAdmin, "users that aren't desired are left alone")
+	})
+
+	t.Run("grants outside the allowlist are skipped", func(t *testing.T) {
+		loginService, store := setup()
+		loginService.AdminGrantAllowlist = &AdminAllowlist{Logins: []string{"Carol"}}
+
+		report, err := loginService.ReconcileServerAdmins(context.Background(), map[int64]bool{2: true, 3: false, 4: true})
+		require.NoError(t, err)
+
+		assert.Equal(t, &AdminReconcileReport{Granted: []int64{4}, Revoked: []int64{3}, Skipped: []int64{2}}, report)
+		assert.False(t, store.users[2].IsAdmin)
+	})
+
+	t.Run("unknown users fail the reconciliation", func(t *testing.T) {
+		loginService, _ := setup()
+
+		_, err := loginService.ReconcileServerAdmins(context.Background(), map[int64]bool{42: true})
+		require.ErrorIs(t, err, models.ErrUserNotFound)
+	})
+}
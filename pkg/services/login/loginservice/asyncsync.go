@@ -0,0 +1,118 @@
+package loginservice
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// orgSyncJob is a queued org and team sync of a user.
+type orgSyncJob struct {
+	user        *models.User
+	extUser     *models.ExternalUserInfo
+	userCreated bool
+	overrides   *models.UpsertUserOverrides
+}
+
+// orgSyncQueue queues org and team syncs for AsyncOrgSync. A single worker runs
+// the queued syncs in order and only while there are any. There is at most one
+// queued sync per user, a newer one replaces it.
+type orgSyncQueue struct {
+	mu      sync.Mutex
+	pending map[int64]orgSyncJob
+	order   []int64
+	// done is closed when the running worker exits, nil if none is running.
+	done chan struct{}
+}
+
+func (q *orgSyncQueue) enqueue(ls *Implementation, user *models.User, extUser *models.ExternalUserInfo, userCreated bool, overrides *models.UpsertUserOverrides) {
+	// the caller keeps using the user, the worker gets its own copies
+	userCopy := *user
+	extUserCopy := *extUser
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending == nil {
+		q.pending = map[int64]orgSyncJob{}
+	}
+	if _, ok := q.pending[user.Id]; ok {
+		logger.Debug("Superseding queued org sync", "userId", user.Id)
+	} else {
+		q.order = append(q.order, user.Id)
+	}
+	q.pending[user.Id] = orgSyncJob{user: &userCopy, extUser: &extUserCopy, userCreated: userCreated, overrides: overrides}
+
+	if q.done == nil {
+		q.done = make(chan struct{})
+		go q.work(ls, q.done)
+	}
+}
+
+func (q *orgSyncQueue) next() (orgSyncJob, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.order) == 0 {
+		q.done = nil
+		return orgSyncJob{}, false
+	}
+	userID := q.order[0]
+	q.order = q.order[1:]
+	job := q.pending[userID]
+	delete(q.pending, userID)
+	return job, true
+}
+
+func (q *orgSyncQueue) work(ls *Implementation, done chan struct{}) {
+	defer close(done)
+
+	for {
+		job, ok := q.next()
+		if !ok {
+			return
+		}
+		ls.runOrgSyncJob(job)
+	}
+}
+
+func (ls *Implementation) runOrgSyncJob(job orgSyncJob) {
+	ctx := context.Background()
+	state := ls.newSyncState()
+	state.applyOverrides(job.overrides)
+	state.userCreated = job.userCreated
+	defer ls.flushAudit(ctx, state, job.user, job.extUser)
+
+	err := ls.syncOrgs(ctx, job.user, job.extUser, state)
+	if err == nil {
+		err = ls.syncTeams(ctx, job.user, job.extUser, state)
+	}
+	ls.recordSyncStatus(ctx, job.user, state, err)
+	if err != nil {
+		logger.Error("Background org sync failed", "userId", job.user.Id, "authmodule", job.extUser.AuthModule, "error", err)
+		return
+	}
+	if len(state.result.Warnings) > 0 {
+		logger.Warn("Background org sync finished with warnings", "userId", job.user.Id, "warnings", state.result.Warnings)
+	}
+}
+
+// FlushOrgSync waits until the org syncs queued by AsyncOrgSync have run, e.g.
+// on shutdown.
+func (ls *Implementation) FlushOrgSync(ctx context.Context) error {
+	for {
+		ls.orgSyncs.mu.Lock()
+		done := ls.orgSyncs.done
+		ls.orgSyncs.mu.Unlock()
+		if done == nil {
+			return nil
+		}
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
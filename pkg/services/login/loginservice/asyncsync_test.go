@@ -0,0 +1,73 @@
+package loginservice
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingStore blocks the first GetUserOrgList call until release is closed.
+type blockingStore struct {
+	*fakeStore
+	once    sync.Once
+	entered chan struct{}
+	release chan struct{}
+
+	mu    sync.Mutex
+	syncs int
+}
+
+func (s *blockingStore) GetUserOrgList(ctx context.Context, query *models.GetUserOrgListQuery) error {
+	s.once.Do(func() {
+		close(s.entered)
+		<-s.release
+	})
+	s.mu.Lock()
+	s.syncs++
+	s.mu.Unlock()
+	return s.fakeStore.GetUserOrgList(ctx, query)
+}
+
+func Test_UpsertUser_asyncOrgSync(t *testing.T) {
+	user := &models.User{Id: 1, Login: "alice"}
+	store := &blockingStore{fakeStore: newFakeStore(user), entered: make(chan struct{}), release: make(chan struct{})}
+	store.addOrg(1)
+	loginService := &Implementation{
+		SQLStore:        store,
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		AsyncOrgSync:    true,
+	}
+	login := func(role models.RoleType) {
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", OrgRoles: map[int64]models.RoleType{1: role}}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+	}
+
+	login(models.ROLE_VIEWER)
+	select {
+	case <-store.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("org sync didn't start")
+	}
+
+	// queued while the first sync is running, the newest login supersedes the other
+	login(models.ROLE_EDITOR)
+	login(models.ROLE_ADMIN)
+	close(store.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, loginService.FlushOrgSync(ctx))
+
+	assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][user.Id])
+	assert.Equal(t, 2, store.syncs, "superseded sync should not run")
+
+	t.Run("flush without queued syncs", func(t *testing.T) {
+		require.NoError(t, loginService.FlushOrgSync(context.Background()))
+	})
+}
@@ -0,0 +1,54 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// AuditMode controls how the changes of a sync are sent to the AuditSink.
+type AuditMode int
+
+const (
+	// AuditPerOperation sends an entry for each change as it's made (default).
+	AuditPerOperation AuditMode = iota
+	// AuditAggregated sends a single entry with all the changes of a sync at
+	// its end, e.g. of UpsertUser.
+	AuditAggregated
+)
+
+// audit sends a change the sync made to user to the AuditSink, or collects it
+// for the aggregated entry sent by flushAudit.
+func (ls *Implementation) audit(ctx context.Context, state *syncState, user *models.User, extUser *models.ExternalUserInfo, change login.LoginAuditChange) {
+	if ls.AuditSink == nil {
+		return
+	}
+	if ls.AuditMode == AuditAggregated {
+		state.auditChanges = append(state.auditChanges, change)
+		return
+	}
+	ls.sendAudit(ctx, user, extUser, []login.LoginAuditChange{change})
+}
+
+// flushAudit sends the changes collected with AuditAggregated as one entry.
+func (ls *Implementation) flushAudit(ctx context.Context, state *syncState, user *models.User, extUser *models.ExternalUserInfo) {
+	if len(state.auditChanges) == 0 || user == nil {
+		return
+	}
+	ls.sendAudit(ctx, user, extUser, state.auditChanges)
+	state.auditChanges = nil
+}
+
+func (ls *Implementation) sendAudit(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, changes []login.LoginAuditChange) {
+	entry := &login.LoginAuditEntry{
+		UserId:     user.Id,
+		Login:      user.Login,
+		AuthModule: extUser.AuthModule,
+		Time:       ls.now(),
+		Changes:    changes,
+	}
+	if err := ls.AuditSink.Audit(ctx, entry); err != nil {
+		logger.Warn("Failed to send login audit entry", "userId", user.Id, "changes", len(changes), "error", err)
+	}
+}
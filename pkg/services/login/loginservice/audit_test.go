@@ -0,0 +1,126 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuditSink struct {
+	entries []*login.LoginAuditEntry
+	err     error
+}
+
+func (f *fakeAuditSink) Audit(ctx context.Context, entry *login.LoginAuditEntry) error {
+	f.entries = append(f.entries, entry)
+	return f.err
+}
+
+func Test_UpsertUser_audit(t *testing.T) {
+	isAdmin := true
+	extUser := &models.ExternalUserInfo{
+		AuthModule:     "oauth_generic",
+		Login:          "alice",
+		Name:           "Alice Smith",
+		OrgRoles:       map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: models.ROLE_VIEWER},
+		IsGrafanaAdmin: &isAdmin,
+	}
+	changes := []login.LoginAuditChange{
+		{Action: login.AuditUserUpdated},
+		{Action: login.AuditOrgRoleUpdated, OrgId: 1, Role: models.ROLE_EDITOR},
+		{Action: login.AuditOrgRoleAdded, OrgId: 2, Role: models.ROLE_VIEWER},
+		{Action: login.AuditAdminUpdated, IsAdmin: true},
+	}
+
+	setup := func(mode AuditMode) (*Implementation, *fakeAuditSink) {
+		user := &models.User{Id: 1, Login: "alice", Name: "Alice", OrgId: 1}
+		store := newFakeStore(user)
+		store.addOrg(1)
+		store.addOrg(2)
+		store.addOrgUser(1, 1, models.ROLE_VIEWER)
+		sink := &fakeAuditSink{}
+		return &Implementation{
+			SQLStore:        store,
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			AuditSink:       sink,
+			AuditMode:       mode,
+		}, sink
+	}
+
+	t.Run("an entry is sent per change", func(t *testing.T) {
+		loginService, sink := setup(AuditPerOperation)
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: extUser}))
+
+		require.Len(t, sink.entries, len(changes))
+		for i, entry := range sink.entries {
+			assert.Equal(t, int64(1), entry.UserId)
+			assert.Equal(t, "oauth_generic", entry.AuthModule)
+			assert.Equal(t, []login.LoginAuditChange{changes[i]}, entry.Changes)
+		}
+	})
+
+	t.Run("aggregated mode sends a single entry at the end", func(t *testing.T) {
+		loginService, sink := setup(AuditAggregated)
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: extUser}))
+
+		require.Len(t, sink.entries, 1)
+		assert.Equal(t, int64(1), sink.entries[0].UserId)
+		assert.Equal(t, "alice", sink.entries[0].Login)
+		assert.Equal(t, changes, sink.entries[0].Changes)
+	})
+
+	t.Run("aggregated mode includes the creation of the user", func(t *testing.T) {
+		store := newFakeStore()
+		store.addOrg(2)
+		sink := &fakeAuditSink{}
+		loginService := &Implementation{
+			SQLStore:        store,
+			QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService: &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}},
+			AuditSink:       sink,
+			AuditMode:       AuditAggregated,
+		}
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			Login:    "bob",
+			Email:    "bob@example.org",
+			OrgRoles: map[int64]models.RoleType{2: models.ROLE_EDITOR},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		require.Len(t, sink.entries, 1)
+		assert.Equal(t, cmd.Result.Id, sink.entries[0].UserId)
+		require.NotEmpty(t, sink.entries[0].Changes)
+		assert.Equal(t, login.AuditUserCreated, sink.entries[0].Changes[0].Action)
+		assert.Contains(t, sink.entries[0].Changes, login.LoginAuditChange{Action: login.AuditOrgRoleAdded, OrgId: 2, Role: models.ROLE_EDITOR})
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][cmd.Result.Id])
+	})
+
+	t.Run("nothing is sent without changes", func(t *testing.T) {
+		for _, mode := range []AuditMode{AuditPerOperation, AuditAggregated} {
+			loginService, sink := setup(mode)
+			unchanged := &models.ExternalUserInfo{Login: "alice", OrgRoles: map[int64]models.RoleType{1: models.ROLE_VIEWER}}
+
+			require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: unchanged}))
+			assert.Empty(t, sink.entries)
+		}
+	})
+
+	t.Run("sink errors don't fail the sync", func(t *testing.T) {
+		loginService, sink := setup(AuditAggregated)
+		sink.err = errors.New("sink unavailable")
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: extUser}))
+		assert.Len(t, sink.entries, 1)
+	})
+}
@@ -0,0 +1,51 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// RotateAuthId changes the auth id the user is linked to in authModule, e.g. when
+// the identity provider migrates its subject identifiers. Tokens and other auth
+// info are kept. Rotating to an auth id linked to another user fails with
+// login.ErrAuthIdInUse.
+func (ls *Implementation) RotateAuthId(ctx context.Context, userID int64, authModule, newAuthId string) error {
+	if authModule == "" {
+		return &login.ErrMissingAuthModule{AuthId: newAuthId}
+	}
+	if newAuthId == "" {
+		return login.ErrMissingAuthId
+	}
+
+	current := &models.GetAuthInfoQuery{UserId: userID, AuthModule: authModule}
+	if err := ls.AuthInfoService.GetAuthInfo(ctx, current); err != nil {
+		return err
+	}
+	if current.Result.AuthId == newAuthId {
+		return nil
+	}
+
+	linked := &models.GetAuthInfoQuery{AuthModule: authModule, AuthId: newAuthId}
+	err := ls.AuthInfoService.GetAuthInfo(ctx, linked)
+	if err == nil && linked.Result.UserId != userID {
+		return &login.ErrAuthIdInUse{AuthModule: authModule, AuthId: newAuthId, UserId: linked.Result.UserId}
+	}
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		return err
+	}
+
+	// Without a token only the auth id is updated, the stored tokens are kept
+	if err := ls.AuthInfoService.UpdateAuthInfo(ctx, &models.UpdateAuthInfoCommand{
+		UserId:     userID,
+		AuthModule: authModule,
+		AuthId:     newAuthId,
+	}); err != nil {
+		return err
+	}
+
+	logger.Info("Rotated auth id of user", "id", userID, "authmodule", authModule, "from", current.Result.AuthId, "to", newAuthId)
+	return nil
+}
@@ -0,0 +1,80 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func Test_RotateAuthId(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+	authInfoService := authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService))
+	loginService := &Implementation{SQLStore: sqlStore, AuthInfoService: authInfoService}
+	ctx := context.Background()
+	const authModule = "oauth_generic_oauth"
+
+	createLinkedUser := func(login, authId string) *models.User {
+		user, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: login, Email: login + "@example.org"})
+		require.NoError(t, err)
+		require.NoError(t, authInfoService.SetAuthInfo(ctx, &models.SetAuthInfoCommand{
+			UserId:     user.Id,
+			AuthModule: authModule,
+			AuthId:     authId,
+			OAuthToken: &oauth2.Token{AccessToken: login + "-access", RefreshToken: login + "-refresh", TokenType: "Bearer"},
+		}))
+		return user
+	}
+	alice := createLinkedUser("alice", "old-alice")
+	bob := createLinkedUser("bob", "bob")
+
+	t.Run("rotates the auth id and keeps the token", func(t *testing.T) {
+		require.NoError(t, loginService.RotateAuthId(ctx, alice.Id, authModule, "new-alice"))
+
+		query := &models.GetAuthInfoQuery{AuthModule: authModule, AuthId: "new-alice"}
+		require.NoError(t, authInfoService.GetAuthInfo(ctx, query))
+		assert.Equal(t, alice.Id, query.Result.UserId)
+		assert.Equal(t, "alice-access", query.Result.OAuthAccessToken)
+		assert.Equal(t, "alice-refresh", query.Result.OAuthRefreshToken)
+
+		err := authInfoService.GetAuthInfo(ctx, &models.GetAuthInfoQuery{AuthModule: authModule, AuthId: "old-alice"})
+		assert.ErrorIs(t, err, models.ErrUserNotFound)
+	})
+
+	t.Run("rotating to the current auth id is a no-op", func(t *testing.T) {
+		require.NoError(t, loginService.RotateAuthId(ctx, alice.Id, authModule, "new-alice"))
+	})
+
+	t.Run("rejects an auth id linked to another user", func(t *testing.T) {
+		err := loginService.RotateAuthId(ctx, alice.Id, authModule, "bob")
+
+		var inUse *login.ErrAuthIdInUse
+		require.ErrorAs(t, err, &inUse)
+		assert.Equal(t, bob.Id, inUse.UserId)
+
+		query := &models.GetAuthInfoQuery{UserId: alice.Id, AuthModule: authModule}
+		require.NoError(t, authInfoService.GetAuthInfo(ctx, query))
+		assert.Equal(t, "new-alice", query.Result.AuthId)
+	})
+
+	t.Run("fails for users without auth info", func(t *testing.T) {
+		err := loginService.RotateAuthId(ctx, alice.Id, "oauth_github", "alice")
+		assert.ErrorIs(t, err, models.ErrUserNotFound)
+	})
+
+	t.Run("requires an auth id", func(t *testing.T) {
+		err := loginService.RotateAuthId(ctx, alice.Id, authModule, "")
+		assert.ErrorIs(t, err, login.ErrMissingAuthId)
+	})
+}
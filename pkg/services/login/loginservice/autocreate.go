@@ -0,0 +1,47 @@
+package loginservice
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// exceedsRole reports whether role grants more than limit.
+func exceedsRole(role, limit models.RoleType) bool {
+	return role != limit && role.Includes(limit)
+}
+
+// capAutoCreateDefaultRole lowers the role a new user without org roles gets in
+// the auto assigned org to MinimumAutoCreateRole.
+func (ls *Implementation) capAutoCreateDefaultRole(cmd *models.CreateUserCommand) {
+	if ls.MinimumAutoCreateRole == "" || cmd.SkipOrgSetup {
+		return
+	}
+
+	role := models.RoleType(cmd.DefaultOrgRole)
+	if role == "" {
+		role = models.RoleType(setting.AutoAssignOrgRole)
+	}
+	if exceedsRole(role, ls.MinimumAutoCreateRole) {
+		cmd.DefaultOrgRole = string(ls.MinimumAutoCreateRole)
+	}
+}
+
+// capAutoCreateOrgRoles lowers the org roles of a user about to be created to
+// MinimumAutoCreateRole, unless AllowAutoCreateAboveMinimum is set.
+func (ls *Implementation) capAutoCreateOrgRoles(extUser *models.ExternalUserInfo, state *syncState) {
+	if ls.MinimumAutoCreateRole == "" || state.allowAboveMinimum {
+		return
+	}
+
+	for orgID := range extUser.OrgRoles {
+		role := ls.externalOrgRole(extUser, orgID)
+		if !exceedsRole(role, ls.MinimumAutoCreateRole) {
+			continue
+		}
+		logger.Debug("Capping role of new user", "login", extUser.Login, "orgId", orgID, "role", role, "cappedRole", ls.MinimumAutoCreateRole)
+		state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("capped role %q of new user in organization %d to %q", role, orgID, ls.MinimumAutoCreateRole))
+		extUser.OrgRoles[orgID] = ls.MinimumAutoCreateRole
+	}
+}
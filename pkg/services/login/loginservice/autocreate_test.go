@@ -0,0 +1,97 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAutoCreate(minimumRole models.RoleType, users ...*models.User) (*Implementation, *fakeStore) {
+	store := newFakeStore(users...)
+	store.addOrg(1)
+	store.addOrg(2)
+	return &Implementation{
+		SQLStore:              store,
+		QuotaService:          &quota.QuotaService{Cfg: setting.NewCfg()},
+		AuthInfoService:       &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}},
+		MinimumAutoCreateRole: minimumRole,
+	}, store
+}
+
+func Test_UpsertUser_minimumAutoCreateRole(t *testing.T) {
+	autoAssignOrgRole := setting.AutoAssignOrgRole
+	t.Cleanup(func() { setting.AutoAssignOrgRole = autoAssignOrgRole })
+
+	tests := []struct {
+		desc              string
+		autoAssignOrgRole string
+		minimumRole       models.RoleType
+		expectedRole      string
+	}{
+		{desc: "auto assigned role is capped", autoAssignOrgRole: "Editor", minimumRole: models.ROLE_VIEWER, expectedRole: "Viewer"},
+		{desc: "lower auto assigned role is kept", autoAssignOrgRole: "Viewer", minimumRole: models.ROLE_EDITOR, expectedRole: ""},
+		{desc: "no cap", autoAssignOrgRole: "Admin", expectedRole: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			setting.AutoAssignOrgRole = tt.autoAssignOrgRole
+			loginService, store := setupAutoCreate(tt.minimumRole)
+
+			cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "alice"}}
+			require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+			require.Len(t, store.createUserCmds, 1)
+			assert.False(t, store.createUserCmds[0].SkipOrgSetup)
+			assert.Equal(t, tt.expectedRole, store.createUserCmds[0].DefaultOrgRole)
+		})
+	}
+
+	t.Run("roles from the identity provider are capped on creation", func(t *testing.T) {
+		loginService, store := setupAutoCreate(models.ROLE_VIEWER)
+		loginService.DefaultRolePerOrg = map[int64]models.RoleType{2: models.ROLE_EDITOR}
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			OrgRoles: map[int64]models.RoleType{1: models.ROLE_ADMIN, 2: ""},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][cmd.Result.Id])
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[2][cmd.Result.Id])
+		assert.Len(t, cmd.SyncResult.Warnings, 2)
+	})
+
+	t.Run("roles from the identity provider are kept when allowed", func(t *testing.T) {
+		loginService, store := setupAutoCreate(models.ROLE_VIEWER)
+		loginService.AllowAutoCreateAboveMinimum = true
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			OrgRoles: map[int64]models.RoleType{1: models.ROLE_ADMIN},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][cmd.Result.Id])
+		assert.Empty(t, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("existing users aren't capped", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice"}
+		loginService, store := setupAutoCreate(models.ROLE_VIEWER, user)
+		loginService.AuthInfoService = &logintest.AuthInfoServiceFake{ExpectedUser: user}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			OrgRoles: map[int64]models.RoleType{1: models.ROLE_ADMIN},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][user.Id])
+	})
+}
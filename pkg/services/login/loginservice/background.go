@@ -0,0 +1,33 @@
+package loginservice
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Run runs the background jobs of the login service until ctx is done. It's
+// registered as a background service, see IsDisabled.
+func (ls *Implementation) Run(ctx context.Context) error {
+	g, ctx := errgroup.WithContext(ctx)
+	if ls.sampleOrgMembers() {
+		g.Go(func() error { return ls.RunOrgMemberSampler(ctx, ls.OrgMemberSampleInterval) })
+	}
+	if ls.relayOutbox() {
+		g.Go(func() error { return ls.RunOutboxRelay(ctx, ls.OutboxRelayInterval) })
+	}
+	return g.Wait()
+}
+
+// IsDisabled reports whether Run has nothing to run.
+func (ls *Implementation) IsDisabled() bool {
+	return !ls.sampleOrgMembers() && !ls.relayOutbox()
+}
+
+func (ls *Implementation) sampleOrgMembers() bool {
+	return ls.OrgMemberCountStore != nil && ls.OrgMemberSampleInterval > 0
+}
+
+func (ls *Implementation) relayOutbox() bool {
+	return ls.Outbox != nil && ls.Bus != nil && ls.OutboxRelayInterval > 0
+}
@@ -0,0 +1,83 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/sqlstore/mockstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeOrgMemberCountStore struct {
+	onGet func()
+}
+
+func (f *fakeOrgMemberCountStore) GetOrgMemberCounts(ctx context.Context) ([]*login.OrgMemberCount, error) {
+	f.onGet()
+	return []*login.OrgMemberCount{}, nil
+}
+
+func TestRun(t *testing.T) {
+	t.Run("nothing to run without a store", func(t *testing.T) {
+		assert.True(t, (&Implementation{OrgMemberSampleInterval: time.Minute}).IsDisabled())
+		assert.True(t, (&Implementation{OrgMemberCountStore: &fakeOrgMemberCountStore{}}).IsDisabled())
+	})
+
+	t.Run("org members are sampled until ctx is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		sampled := 0
+		loginService := &Implementation{
+			Clock: clock.NewMock(),
+			OrgMemberCountStore: &fakeOrgMemberCountStore{onGet: func() {
+				sampled++
+				cancel()
+			}},
+			OrgMemberSampleInterval: time.Minute,
+		}
+		require.False(t, loginService.IsDisabled())
+
+		require.ErrorIs(t, loginService.Run(ctx), context.Canceled)
+		assert.Equal(t, 1, sampled)
+	})
+
+	t.Run("the outbox is relayed until ctx is done", func(t *testing.T) {
+		loginService, _, _, eventBus := setupOutbox(t)
+		loginService.OutboxRelayInterval = time.Millisecond
+		require.False(t, loginService.IsDisabled())
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org"}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var created []*events.UserCreated
+		eventBus.AddEventListener(func(_ context.Context, e *events.UserCreated) error {
+			created = append(created, e)
+			cancel()
+			return nil
+		})
+
+		require.ErrorIs(t, loginService.Run(ctx), context.Canceled)
+		require.Len(t, created, 1)
+		assert.Equal(t, cmd.Result.Id, created[0].Id)
+	})
+
+	t.Run("the outbox is only wired when enabled", func(t *testing.T) {
+		cfg := setting.NewCfg()
+		loginService := ProvideService(cfg, mockstore.NewSQLStoreMock(), bus.New(), nil, nil)
+		assert.Nil(t, loginService.Outbox)
+
+		cfg.LoginOutboxEnabled = true
+		cfg.LoginOutboxRelayInterval = time.Minute
+		loginService = ProvideService(cfg, mockstore.NewSQLStoreMock(), bus.New(), nil, nil)
+		assert.NotNil(t, loginService.Outbox)
+		assert.Equal(t, time.Minute, loginService.OutboxRelayInterval)
+	})
+}
@@ -0,0 +1,44 @@
+package loginservice
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// runBounded calls fn for the indexes 0 to n-1 with at most concurrency calls
+// running at a time, serially if concurrency is below 2. It stops at the first
+// error and returns it. Callers keep results in order by storing them at the index.
+func runBounded(ctx context.Context, n, concurrency int, fn func(ctx context.Context, i int) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	indexes := make(chan int)
+	g.Go(func() error {
+		defer close(indexes)
+		for i := 0; i < n; i++ {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	})
+	for w := 0; w < concurrency; w++ {
+		g.Go(func() error {
+			for i := range indexes {
+				if err := fn(ctx, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
@@ -0,0 +1,76 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runBounded(t *testing.T) {
+	run := func(n, concurrency int) ([]int, int32) {
+		var inFlight, maxInFlight int32
+		var mu sync.Mutex
+		results := make([]int, n)
+
+		err := runBounded(context.Background(), n, concurrency, func(ctx context.Context, i int) error {
+			current := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			mu.Lock()
+			if current > maxInFlight {
+				maxInFlight = current
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+			results[i] = i * i
+			return nil
+		})
+		require.NoError(t, err)
+		return results, maxInFlight
+	}
+
+	t.Run("concurrency is bounded and results map back to inputs", func(t *testing.T) {
+		results, maxInFlight := run(20, 4)
+
+		assert.LessOrEqual(t, maxInFlight, int32(4))
+		for i, r := range results {
+			assert.Equal(t, i*i, r)
+		}
+	})
+
+	t.Run("serial by default", func(t *testing.T) {
+		results, maxInFlight := run(5, 0)
+
+		assert.Equal(t, int32(1), maxInFlight)
+		assert.Equal(t, []int{0, 1, 4, 9, 16}, results)
+	})
+
+	t.Run("no items", func(t *testing.T) {
+		results, maxInFlight := run(0, 4)
+
+		assert.Empty(t, results)
+		assert.Zero(t, maxInFlight)
+	})
+
+	t.Run("stops at the first error", func(t *testing.T) {
+		errFailed := errors.New("failed")
+		var calls int32
+
+		err := runBounded(context.Background(), 100, 1, func(ctx context.Context, i int) error {
+			atomic.AddInt32(&calls, 1)
+			if i == 2 {
+				return errFailed
+			}
+			return nil
+		})
+
+		assert.ErrorIs(t, err, errFailed)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	})
+}
@@ -0,0 +1,45 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// bootstrapFirstUser grants server admin and org admin to a user that was just
+// created, if it's the only user, see BootstrapFirstUser. The user table is
+// counted after the creation so that concurrent first logins don't both get
+// the grant.
+func (ls *Implementation) bootstrapFirstUser(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	if !ls.BootstrapFirstUser {
+		return nil
+	}
+
+	query := &models.SearchUsersQuery{Page: 1, Limit: 1}
+	if err := ls.SQLStore.SearchUsers(ctx, query); err != nil {
+		return err
+	}
+	if query.Result.TotalCount != 1 {
+		return nil
+	}
+
+	logger.Info("Granting server admin and org admin to the first user", "id", user.Id, "login", user.Login, "authmodule", extUser.AuthModule)
+	if err := ls.SQLStore.UpdateUserPermissions(user.Id, true); err != nil {
+		return err
+	}
+	user.IsAdmin = true
+
+	// the rest of the sync must not undo the grant
+	isAdmin := true
+	extUser.IsGrafanaAdmin = &isAdmin
+
+	orgRoles := make(map[int64]models.RoleType, len(extUser.OrgRoles)+1)
+	for orgID := range extUser.OrgRoles {
+		orgRoles[orgID] = models.ROLE_ADMIN
+	}
+	if len(orgRoles) == 0 && user.OrgId > 0 {
+		orgRoles[user.OrgId] = models.ROLE_ADMIN
+	}
+	extUser.OrgRoles = orgRoles
+	return nil
+}
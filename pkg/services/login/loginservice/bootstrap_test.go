@@ -0,0 +1,72 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupBootstrap(bootstrap bool) (*Implementation, *fakeStore) {
+	store := newFakeStore()
+	store.addOrg(1)
+	store.addOrg(2)
+	return &Implementation{
+		SQLStore:           store,
+		QuotaService:       &quota.QuotaService{Cfg: setting.NewCfg()},
+		AuthInfoService:    &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}},
+		BootstrapFirstUser: bootstrap,
+	}, store
+}
+
+func Test_UpsertUser_bootstrapFirstUser(t *testing.T) {
+	notAdmin := false
+	signup := func(loginService *Implementation, login string) *models.User {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule:     "oauth_generic_oauth",
+			Login:          login,
+			IsGrafanaAdmin: &notAdmin,
+			OrgRoles:       map[int64]models.RoleType{1: models.ROLE_VIEWER, 2: models.ROLE_EDITOR},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		return cmd.Result
+	}
+
+	t.Run("first user becomes admin, second user doesn't", func(t *testing.T) {
+		loginService, store := setupBootstrap(true)
+
+		first := signup(loginService, "alice")
+		assert.True(t, store.users[first.Id].IsAdmin)
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][first.Id])
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[2][first.Id])
+
+		second := signup(loginService, "bob")
+		assert.False(t, store.users[second.Id].IsAdmin)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][second.Id])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][second.Id])
+	})
+
+	t.Run("first user without org roles becomes admin of its org", func(t *testing.T) {
+		loginService, store := setupBootstrap(true)
+		loginService.UserFactory = func(_ *models.ExternalUserInfo, cmd *models.CreateUserCommand) { cmd.OrgId = 1 }
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", Login: "alice"}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.True(t, store.users[cmd.Result.Id].IsAdmin)
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][cmd.Result.Id])
+	})
+
+	t.Run("first user isn't boosted when disabled", func(t *testing.T) {
+		loginService, store := setupBootstrap(false)
+
+		first := signup(loginService, "alice")
+		assert.False(t, store.users[first.Id].IsAdmin)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][first.Id])
+	})
+}
@@ -0,0 +1,125 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+const (
+	// lookupDependency names the user lookup in circuit breaker errors and events.
+	lookupDependency = "auth info lookup"
+	// defaultCircuitBreakerCooldown is used when CircuitBreakerThreshold is set
+	// without a CircuitBreakerCooldown.
+	defaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// circuitBreaker counts consecutive slow or failed calls. Once open, calls are
+// rejected until the cooldown passed, then a single trial call decides whether
+// it closes or opens again.
+type circuitBreaker struct {
+	mu         sync.Mutex
+	state      models.CircuitBreakerState
+	failures   int
+	retryAfter time.Time
+	trial      bool
+}
+
+// allow reports whether a call may go through, and if not until when calls
+// are rejected.
+func (b *circuitBreaker) allow(now time.Time) (bool, time.Time, models.CircuitBreakerState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case models.CircuitBreakerOpen:
+		if now.Before(b.retryAfter) {
+			return false, b.retryAfter, ""
+		}
+		b.state = models.CircuitBreakerHalfOpen
+		b.trial = true
+		return true, time.Time{}, models.CircuitBreakerHalfOpen
+	case models.CircuitBreakerHalfOpen:
+		if b.trial {
+			return false, b.retryAfter, ""
+		}
+		b.trial = true
+	}
+	return true, time.Time{}, ""
+}
+
+// record records the outcome of a call and returns the new state if it changed.
+func (b *circuitBreaker) record(failed bool, now time.Time, threshold int, cooldown time.Duration) (models.CircuitBreakerState, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if !failed {
+		b.failures = 0
+		if b.state == models.CircuitBreakerHalfOpen {
+			b.state = models.CircuitBreakerClosed
+			return models.CircuitBreakerClosed, 0
+		}
+		return "", 0
+	}
+
+	b.failures++
+	if b.state == models.CircuitBreakerHalfOpen || (b.state != models.CircuitBreakerOpen && b.failures >= threshold) {
+		b.state = models.CircuitBreakerOpen
+		b.retryAfter = now.Add(cooldown)
+		return models.CircuitBreakerOpen, b.failures
+	}
+	return "", 0
+}
+
+// guardedLookupUser looks up the user behind the circuit breaker, see
+// CircuitBreakerThreshold. Lookups not finding a user don't count as failed.
+func (ls *Implementation) guardedLookupUser(ctx context.Context, extUser *models.ExternalUserInfo, state *syncState) (*models.User, error) {
+	if ls.CircuitBreakerThreshold <= 0 {
+		return ls.lookupUser(ctx, extUser, state)
+	}
+
+	allowed, retryAfter, changed := ls.breaker.allow(ls.now())
+	if !allowed {
+		return nil, &login.ErrCircuitOpen{Dependency: lookupDependency, RetryAfter: retryAfter}
+	}
+	if changed != "" {
+		ls.publishBreakerState(ctx, changed, 0)
+	}
+
+	start := ls.now()
+	user, err := ls.lookupUser(ctx, extUser, state)
+	failed := err != nil && !errors.Is(err, models.ErrUserNotFound) && !isAmbiguousUser(err)
+	if ls.CircuitBreakerSlowCall > 0 && ls.now().Sub(start) >= ls.CircuitBreakerSlowCall {
+		failed = true
+	}
+
+	cooldown := ls.CircuitBreakerCooldown
+	if cooldown <= 0 {
+		cooldown = defaultCircuitBreakerCooldown
+	}
+	if changed, failures := ls.breaker.record(failed, ls.now(), ls.CircuitBreakerThreshold, cooldown); changed != "" {
+		ls.publishBreakerState(ctx, changed, failures)
+	}
+	return user, err
+}
+
+func (ls *Implementation) publishBreakerState(ctx context.Context, state models.CircuitBreakerState, failures int) {
+	if state == models.CircuitBreakerOpen {
+		logger.Warn("Circuit breaker opened", "dependency", lookupDependency, "consecutiveFailures", failures)
+	} else {
+		logger.Info("Circuit breaker changed state", "dependency", lookupDependency, "state", state)
+	}
+	if ls.Bus == nil {
+		return
+	}
+
+	event := &models.LoginCircuitBreakerStateChangedEvent{Dependency: lookupDependency, State: state, ConsecutiveFailures: failures}
+	if err := ls.Bus.Publish(ctx, event); err != nil {
+		logger.Error("Failed to publish circuit breaker state change", "state", state, "error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowAuthInfoService advances the clock by delay on every lookup.
+type slowAuthInfoService struct {
+	*logintest.AuthInfoServiceFake
+	clk     *clock.Mock
+	delay   time.Duration
+	lookups int
+}
+
+func (s *slowAuthInfoService) LookupAndUpdate(ctx context.Context, query *models.GetUserByAuthInfoQuery) (*models.User, error) {
+	s.lookups++
+	s.clk.Add(s.delay)
+	return s.AuthInfoServiceFake.LookupAndUpdate(ctx, query)
+}
+
+func Test_UpsertUser_circuitBreaker(t *testing.T) {
+	errUnavailable := errors.New("auth info backend unavailable")
+
+	setup := func() (*Implementation, *slowAuthInfoService, *[]models.CircuitBreakerState) {
+		user := &models.User{Id: 1, Login: "alice"}
+		clk := clock.NewMock()
+		var states []models.CircuitBreakerState
+		eventBus := bus.New()
+		eventBus.AddEventListener(func(ctx context.Context, e *models.LoginCircuitBreakerStateChangedEvent) error {
+			states = append(states, e.State)
+			return nil
+		})
+		authInfoService := &slowAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{ExpectedUser: user}, clk: clk}
+		return &Implementation{
+			Clock:                   clk,
+			Bus:                     eventBus,
+			SQLStore:                newFakeStore(user),
+			AuthInfoService:         authInfoService,
+			CircuitBreakerThreshold: 2,
+			CircuitBreakerSlowCall:  time.Second,
+			CircuitBreakerCooldown:  time.Minute,
+		}, authInfoService, &states
+	}
+	upsert := func(loginService *Implementation) error {
+		return loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_okta", AuthId: "alice", Login: "alice"}})
+	}
+
+	t.Run("failed lookups open the breaker until the cooldown passed", func(t *testing.T) {
+		loginService, authInfoService, states := setup()
+		authInfoService.ExpectedError = errUnavailable
+
+		require.ErrorIs(t, upsert(loginService), errUnavailable)
+		require.ErrorIs(t, upsert(loginService), errUnavailable)
+		assert.Equal(t, []models.CircuitBreakerState{models.CircuitBreakerOpen}, *states)
+
+		err := upsert(loginService)
+		var openErr *login.ErrCircuitOpen
+		require.ErrorAs(t, err, &openErr)
+		assert.ErrorIs(t, err, &login.ErrUpsertUser{Phase: login.UpsertPhaseLookup})
+		assert.Equal(t, 2, authInfoService.lookups)
+
+		// a failed trial opens it again
+		authInfoService.clk.Add(time.Minute)
+		require.ErrorIs(t, upsert(loginService), errUnavailable)
+		require.ErrorAs(t, upsert(loginService), &openErr)
+		assert.Equal(t, 3, authInfoService.lookups)
+
+		// a successful trial closes it
+		authInfoService.clk.Add(time.Minute)
+		authInfoService.ExpectedError = nil
+		require.NoError(t, upsert(loginService))
+		require.NoError(t, upsert(loginService))
+		assert.Equal(t, []models.CircuitBreakerState{
+			models.CircuitBreakerOpen,
+			models.CircuitBreakerHalfOpen,
+			models.CircuitBreakerOpen,
+			models.CircuitBreakerHalfOpen,
+			models.CircuitBreakerClosed,
+		}, *states)
+	})
+
+	t.Run("slow lookups open the breaker", func(t *testing.T) {
+		loginService, authInfoService, states := setup()
+		authInfoService.delay = time.Second
+
+		require.NoError(t, upsert(loginService))
+		require.NoError(t, upsert(loginService))
+		assert.Equal(t, []models.CircuitBreakerState{models.CircuitBreakerOpen}, *states)
+
+		var openErr *login.ErrCircuitOpen
+		require.ErrorAs(t, upsert(loginService), &openErr)
+	})
+
+	t.Run("a successful lookup resets the failure count", func(t *testing.T) {
+		loginService, authInfoService, states := setup()
+
+		authInfoService.ExpectedError = errUnavailable
+		require.Error(t, upsert(loginService))
+		authInfoService.ExpectedError = nil
+		require.NoError(t, upsert(loginService))
+		authInfoService.ExpectedError = errUnavailable
+		require.ErrorIs(t, upsert(loginService), errUnavailable)
+
+		assert.Empty(t, *states)
+	})
+
+	t.Run("open breaker falls back to degraded login", func(t *testing.T) {
+		loginService, authInfoService, _ := setup()
+		loginService.AllowDegradedLogin = true
+		require.NoError(t, upsert(loginService))
+
+		authInfoService.ExpectedError = errUnavailable
+		require.NoError(t, upsert(loginService))
+		require.NoError(t, upsert(loginService))
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_okta", AuthId: "alice", Login: "alice"}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		assert.True(t, cmd.SyncResult.Degraded)
+		assert.Equal(t, 3, authInfoService.lookups)
+	})
+}
@@ -0,0 +1,96 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/util"
+)
+
+// breakGlassPasswordLength is the length of the generated break-glass passwords.
+const breakGlassPasswordLength = 32
+
+// EnsureBreakGlassUser makes sure a local Grafana admin with the login exists
+// and is enabled, for when the identity providers can't be reached. The user is
+// created if it doesn't exist, and has the role in its current org. Every call
+// sets a new generated password, which is only ever returned here. Logins of
+// external users are refused with login.ErrBreakGlassExternal.
+func (ls *Implementation) EnsureBreakGlassUser(ctx context.Context, userLogin string, role models.RoleType) (*models.User, string, error) {
+	if userLogin == "" {
+		return nil, "", errors.New("break-glass user needs a login")
+	}
+	if role == "" {
+		role = models.ROLE_ADMIN
+	}
+	if !role.IsValid() {
+		return nil, "", &login.ErrInvalidOrgRole{Role: role}
+	}
+
+	password, err := util.GetRandomString(breakGlassPasswordLength)
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := &models.GetUserByLoginQuery{LoginOrEmail: userLogin}
+	err = ls.SQLStore.GetUserByLogin(ctx, query)
+	if errors.Is(err, models.ErrUserNotFound) {
+		user, err := ls.SQLStore.CreateUser(ctx, models.CreateUserCommand{Login: userLogin, Email: userLogin, Password: password, IsAdmin: true})
+		if err != nil {
+			return nil, "", err
+		}
+		if err := ls.setBreakGlassRole(ctx, user, role); err != nil {
+			return nil, "", err
+		}
+		logger.Warn("Created break-glass user", "id", user.Id, "login", user.Login, "role", role)
+		return user, password, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	user := query.Result
+
+	err = ls.AuthInfoService.GetAuthInfo(ctx, &models.GetAuthInfoQuery{UserId: user.Id})
+	if err == nil {
+		return nil, "", login.ErrBreakGlassExternal
+	}
+	if !errors.Is(err, models.ErrUserNotFound) {
+		return nil, "", err
+	}
+
+	encoded, err := util.EncodePassword(password, user.Salt)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := ls.SQLStore.ChangeUserPassword(ctx, &models.ChangeUserPasswordCommand{UserId: user.Id, NewPassword: encoded}); err != nil {
+		return nil, "", err
+	}
+	if user.IsDisabled {
+		if err := ls.SQLStore.DisableUser(ctx, &models.DisableUserCommand{UserId: user.Id, IsDisabled: false}); err != nil {
+			return nil, "", err
+		}
+		user.IsDisabled = false
+	}
+	if !user.IsAdmin {
+		if err := ls.SQLStore.UpdateUserPermissions(user.Id, true); err != nil {
+			return nil, "", err
+		}
+		user.IsAdmin = true
+	}
+	if err := ls.setBreakGlassRole(ctx, user, role); err != nil {
+		return nil, "", err
+	}
+
+	logger.Warn("Enabled break-glass user and reset its password", "id", user.Id, "login", user.Login, "role", role)
+	return user, password, nil
+}
+
+// setBreakGlassRole gives the break-glass user the role in its current org.
+func (ls *Implementation) setBreakGlassRole(ctx context.Context, user *models.User, role models.RoleType) error {
+	err := ls.SQLStore.UpdateOrgUser(ctx, &models.UpdateOrgUserCommand{OrgId: user.OrgId, UserId: user.Id, Role: role})
+	if errors.Is(err, models.ErrOrgUserNotFound) {
+		err = ls.SQLStore.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: user.OrgId, UserId: user.Id, Role: role})
+	}
+	return err
+}
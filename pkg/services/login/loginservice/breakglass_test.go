@@ -0,0 +1,87 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureBreakGlassUser(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*Implementation, *sqlstore.SQLStore) {
+		sqlStore := sqlstore.InitTestDB(t)
+		return &Implementation{
+			SQLStore:        sqlStore,
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound},
+		}, sqlStore
+	}
+	getUser := func(t *testing.T, sqlStore *sqlstore.SQLStore, id int64) *models.User {
+		query := &models.GetUserByIdQuery{Id: id}
+		require.NoError(t, sqlStore.GetUserById(ctx, query))
+		return query.Result
+	}
+	assertPassword := func(t *testing.T, user *models.User, password string) {
+		encoded, err := util.EncodePassword(password, user.Salt)
+		require.NoError(t, err)
+		assert.Equal(t, encoded, user.Password)
+	}
+
+	t.Run("creates a local admin", func(t *testing.T) {
+		loginService, sqlStore := setup(t)
+
+		user, password, err := loginService.EnsureBreakGlassUser(ctx, "breakglass", models.ROLE_ADMIN)
+		require.NoError(t, err)
+		assert.Len(t, password, breakGlassPasswordLength)
+
+		stored := getUser(t, sqlStore, user.Id)
+		assert.Equal(t, "breakglass", stored.Login)
+		assert.True(t, stored.IsAdmin)
+		assert.False(t, stored.IsDisabled)
+		assertPassword(t, stored, password)
+
+		orgs := &models.GetUserOrgListQuery{UserId: user.Id}
+		require.NoError(t, sqlStore.GetUserOrgList(ctx, orgs))
+		require.Len(t, orgs.Result, 1)
+		assert.Equal(t, models.ROLE_ADMIN, orgs.Result[0].Role)
+	})
+
+	t.Run("ensuring again enables the same user with a new password", func(t *testing.T) {
+		loginService, sqlStore := setup(t)
+
+		user, first, err := loginService.EnsureBreakGlassUser(ctx, "breakglass", models.ROLE_ADMIN)
+		require.NoError(t, err)
+		require.NoError(t, sqlStore.DisableUser(ctx, &models.DisableUserCommand{UserId: user.Id, IsDisabled: true}))
+
+		again, second, err := loginService.EnsureBreakGlassUser(ctx, "breakglass", models.ROLE_ADMIN)
+		require.NoError(t, err)
+		assert.Equal(t, user.Id, again.Id)
+		assert.NotEqual(t, first, second)
+
+		stored := getUser(t, sqlStore, user.Id)
+		assert.False(t, stored.IsDisabled)
+		assert.True(t, stored.IsAdmin)
+		assertPassword(t, stored, second)
+
+		users := &models.SearchUsersQuery{Query: "breakglass", Page: 1, Limit: 10}
+		require.NoError(t, sqlStore.SearchUsers(ctx, users))
+		assert.Equal(t, int64(1), users.Result.TotalCount)
+	})
+
+	t.Run("external users are refused", func(t *testing.T) {
+		loginService, sqlStore := setup(t)
+		_, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org"})
+		require.NoError(t, err)
+		loginService.AuthInfoService = &logintest.AuthInfoServiceFake{}
+
+		_, _, err = loginService.EnsureBreakGlassUser(ctx, "alice", models.ROLE_ADMIN)
+		require.ErrorIs(t, err, login.ErrBreakGlassExternal)
+	})
+}
@@ -0,0 +1,26 @@
+package loginservice
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// applyRoleCeilings lowers the external org roles exceeding the ceiling of
+// their org, see PerOrgRoleCeiling.
+func (ls *Implementation) applyRoleCeilings(extUser *models.ExternalUserInfo, state *syncState) {
+	for orgID := range extUser.OrgRoles {
+		ceiling, ok := ls.PerOrgRoleCeiling[orgID]
+		if !ok {
+			continue
+		}
+		role := ls.externalOrgRole(extUser, orgID)
+		if !exceedsRole(role, ceiling) {
+			continue
+		}
+
+		logger.Warn("Lowering organization role to the ceiling of the organization", "orgId", orgID, "role", role, "ceiling", ceiling)
+		state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("lowered role %q in organization %d to %q", role, orgID, ceiling))
+		extUser.OrgRoles[orgID] = ceiling
+	}
+}
@@ -0,0 +1,40 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_perOrgRoleCeiling(t *testing.T) {
+	user := &models.User{Id: 1, Login: "alice"}
+	store := newFakeStore(user)
+	store.addOrgUser(2, 1, models.ROLE_ADMIN)
+	store.addOrg(1)
+	store.addOrg(3)
+	loginService := &Implementation{
+		SQLStore:          store,
+		AuthInfoService:   &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		PerOrgRoleCeiling: map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: models.ROLE_EDITOR, 3: models.ROLE_EDITOR},
+		DefaultRolePerOrg: map[int64]models.RoleType{3: models.ROLE_ADMIN},
+	}
+
+	cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", OrgRoles: map[int64]models.RoleType{
+		1: models.ROLE_VIEWER,
+		2: "Administrator",
+		3: "",
+	}}}
+	require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+	assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1], "roles below the ceiling are kept")
+	assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][1])
+	assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[3][1], "default roles are lowered too")
+	assert.ElementsMatch(t, []string{
+		`lowered role "Admin" in organization 2 to "Editor"`,
+		`lowered role "Admin" in organization 3 to "Editor"`,
+	}, cmd.SyncResult.Warnings)
+}
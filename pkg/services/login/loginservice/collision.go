@@ -0,0 +1,79 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// CollisionWithDisabledPolicy controls what happens when an external user
+// without a linked account matches a disabled user by email or login.
+type CollisionWithDisabledPolicy int
+
+const (
+	// CollisionWithDisabledAttach links the external user to the disabled
+	// user, which stays disabled (default).
+	CollisionWithDisabledAttach CollisionWithDisabledPolicy = iota
+	// CollisionWithDisabledAttachAndEnable links the external user to the
+	// disabled user and enables it.
+	CollisionWithDisabledAttachAndEnable
+	// CollisionWithDisabledCreateNew creates a new user. Logins and emails are
+	// unique, so the login and email of the disabled user are prefixed with
+	// "disabled-<id>-" to release them.
+	CollisionWithDisabledCreateNew
+)
+
+// attachAndEnable links a disabled user to the external user and enables it.
+func (ls *Implementation) attachAndEnable(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) (*models.User, error) {
+	if extUser.AuthModule != "" {
+		cmd := &models.SetAuthInfoCommand{UserId: user.Id, AuthModule: extUser.AuthModule, AuthId: extUser.AuthId}
+		if err := ls.AuthInfoService.SetAuthInfo(ctx, cmd); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("Enabling disabled user matched by external user", "id", user.Id, "login", user.Login, "authmodule", extUser.AuthModule)
+	if err := ls.SQLStore.DisableUser(ctx, &models.DisableUserCommand{UserId: user.Id, IsDisabled: false}); err != nil {
+		return nil, err
+	}
+	user.IsDisabled = false
+
+	if ls.DisableSourceStore == nil {
+		return user, nil
+	}
+	return user, ls.DisableSourceStore.DeleteDisableSource(ctx, user.Id)
+}
+
+// createReplacingDisabled creates a new user with the login and email of a
+// disabled user, see CollisionWithDisabledCreateNew. Both are released and the
+// user is created in one transaction, so the disabled user keeps them when the
+// creation fails.
+func (ls *Implementation) createReplacingDisabled(ctx context.Context, disabled *models.User, extUser *models.ExternalUserInfo) (*models.User, error) {
+	var user *models.User
+	err := ls.SQLStore.InTransaction(ctx, func(ctx context.Context) error {
+		if err := ls.releaseDisabledIdentity(ctx, disabled); err != nil {
+			return err
+		}
+		var err error
+		user, err = ls.createUserWithOutbox(ctx, extUser)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// releaseDisabledIdentity renames the login and email of a disabled user so
+// that a new user can be created with them, see CollisionWithDisabledCreateNew.
+func (ls *Implementation) releaseDisabledIdentity(ctx context.Context, user *models.User) error {
+	prefix := fmt.Sprintf("disabled-%d-", user.Id)
+	cmd := &models.UpdateUserCommand{UserId: user.Id, Login: prefix + user.Login}
+	if user.Email != "" {
+		cmd.Email = prefix + user.Email
+	}
+
+	logger.Info("Renaming disabled user to create a new user", "id", user.Id, "login", user.Login, "newLogin", cmd.Login)
+	return ls.SQLStore.UpdateUser(ctx, cmd)
+}
@@ -0,0 +1,131 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	"github.com/grafana/grafana/pkg/services/quota"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_collisionWithDisabled(t *testing.T) {
+	const authModule = "oauth_generic_oauth"
+	ctx := context.Background()
+
+	setup := func(t *testing.T, policy CollisionWithDisabledPolicy) (*Implementation, *sqlstore.SQLStore, *models.User) {
+		sqlStore := sqlstore.InitTestDB(t)
+		secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+		authInfoService := authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService))
+
+		local, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org", IsDisabled: true})
+		require.NoError(t, err)
+
+		return &Implementation{
+			SQLStore:                sqlStore,
+			QuotaService:            &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService:         authInfoService,
+			OnCollisionWithDisabled: policy,
+		}, sqlStore, local
+	}
+	upsert := func(t *testing.T, loginService *Implementation, local *models.User) *models.User {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule: authModule,
+			AuthId:     "alice-sub",
+			Login:      "alice",
+			Email:      "alice@example.org",
+			// the org created for the local user, who is its admin. New users
+			// would get an org named after their email otherwise.
+			OrgRoles: map[int64]models.RoleType{local.OrgId: models.ROLE_ADMIN},
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		return cmd.Result
+	}
+	getUser := func(t *testing.T, sqlStore *sqlstore.SQLStore, id int64) *models.User {
+		query := &models.GetUserByIdQuery{Id: id}
+		require.NoError(t, sqlStore.GetUserById(ctx, query))
+		return query.Result
+	}
+	linkedUserId := func(t *testing.T, loginService *Implementation) int64 {
+		query := &models.GetAuthInfoQuery{AuthModule: authModule, AuthId: "alice-sub"}
+		require.NoError(t, loginService.AuthInfoService.GetAuthInfo(ctx, query))
+		return query.Result.UserId
+	}
+
+	t.Run("attach links the disabled user", func(t *testing.T) {
+		loginService, sqlStore, local := setup(t, CollisionWithDisabledAttach)
+
+		user := upsert(t, loginService, local)
+		assert.Equal(t, local.Id, user.Id)
+		assert.True(t, getUser(t, sqlStore, local.Id).IsDisabled)
+		assert.Equal(t, local.Id, linkedUserId(t, loginService))
+	})
+
+	t.Run("attach and enable links and enables the disabled user", func(t *testing.T) {
+		loginService, sqlStore, local := setup(t, CollisionWithDisabledAttachAndEnable)
+
+		user := upsert(t, loginService, local)
+		assert.Equal(t, local.Id, user.Id)
+		assert.False(t, user.IsDisabled)
+		assert.False(t, getUser(t, sqlStore, local.Id).IsDisabled)
+		assert.Equal(t, local.Id, linkedUserId(t, loginService))
+	})
+
+	t.Run("create new releases the identity of the disabled user", func(t *testing.T) {
+		loginService, sqlStore, local := setup(t, CollisionWithDisabledCreateNew)
+
+		user := upsert(t, loginService, local)
+		assert.NotEqual(t, local.Id, user.Id)
+		assert.Equal(t, "alice", user.Login)
+		assert.Equal(t, "alice@example.org", user.Email)
+		assert.False(t, user.IsDisabled)
+		assert.Equal(t, user.Id, linkedUserId(t, loginService))
+
+		old := getUser(t, sqlStore, local.Id)
+		assert.True(t, old.IsDisabled)
+		assert.Equal(t, fmt.Sprintf("disabled-%d-alice", local.Id), old.Login)
+		assert.Equal(t, fmt.Sprintf("disabled-%d-alice@example.org", local.Id), old.Email)
+
+		// the next login finds the new user by its auth info
+		assert.Equal(t, user.Id, upsert(t, loginService, local).Id)
+	})
+
+	t.Run("the disabled user keeps its identity when the creation fails", func(t *testing.T) {
+		loginService, sqlStore, local := setup(t, CollisionWithDisabledCreateNew)
+		loginService.AllowInitialPassword = true
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule:      authModule,
+			AuthId:          "alice-sub",
+			Login:           "alice",
+			Email:           "alice@example.org",
+			InitialPassword: "abc",
+			OrgRoles:        map[int64]models.RoleType{local.OrgId: models.ROLE_ADMIN},
+		}}
+		require.ErrorIs(t, loginService.UpsertUser(ctx, cmd), login.ErrWeakPassword)
+
+		old := getUser(t, sqlStore, local.Id)
+		assert.Equal(t, "alice", old.Login)
+		assert.Equal(t, "alice@example.org", old.Email)
+	})
+
+	t.Run("linked disabled users are found with any policy", func(t *testing.T) {
+		loginService, sqlStore, local := setup(t, CollisionWithDisabledAttach)
+		upsert(t, loginService, local)
+
+		loginService.OnCollisionWithDisabled = CollisionWithDisabledCreateNew
+		user := upsert(t, loginService, local)
+		assert.Equal(t, local.Id, user.Id)
+		assert.True(t, getUser(t, sqlStore, local.Id).IsDisabled)
+	})
+}
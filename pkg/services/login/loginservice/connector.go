@@ -0,0 +1,38 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// managedByOtherConnector reports whether the sync of an existing membership
+// should be skipped with ConnectorScopedOrgSync. Memberships are only removed
+// by the auth module that granted them, memberships without provenance are
+// never removed. Roles granted by another auth module aren't changed.
+func (ls *Implementation) managedByOtherConnector(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, orgID int64, extRole models.RoleType, state *syncState) (bool, error) {
+	if !ls.ConnectorScopedOrgSync || ls.RoleProvenanceStore == nil {
+		return false, nil
+	}
+
+	provenance, err := ls.RoleProvenanceStore.GetOrgRoleProvenance(ctx, user.Id, orgID)
+	if err != nil {
+		return false, err
+	}
+
+	if extRole == "" {
+		if provenance == nil || provenance.AuthModule != extUser.AuthModule {
+			logger.Debug("Not removing organization membership granted by another auth module", "userId", user.Id, "orgId", orgID, "authmodule", extUser.AuthModule)
+			return true, nil
+		}
+		return false, nil
+	}
+
+	if provenance != nil && provenance.AuthModule != extUser.AuthModule {
+		logger.Warn("Not changing organization role granted by another auth module", "userId", user.Id, "orgId", orgID, "authmodule", extUser.AuthModule, "grantedBy", provenance.AuthModule)
+		state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("kept role in organization %d granted by %s", orgID, provenance.AuthModule))
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,82 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_connectorScopedOrgSync(t *testing.T) {
+	setup := func(scoped bool) (*Implementation, *fakeStore) {
+		user := &models.User{Id: 1, Login: "alice"}
+		store := newFakeStore(user)
+		store.addOrg(1)
+		store.addOrg(2)
+		store.addOrg(3)
+		return &Implementation{
+			SQLStore:               store,
+			AuthInfoService:        &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			RoleProvenanceStore:    &fakeRoleProvenanceStore{provenance: map[[2]int64]*login.OrgRoleProvenance{}},
+			ConnectorScopedOrgSync: scoped,
+		}, store
+	}
+	signIn := func(loginService *Implementation, authModule string, orgRoles map[int64]models.RoleType) *models.UpsertUserCommand {
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: authModule, Login: "alice", OrgRoles: orgRoles}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		return cmd
+	}
+
+	t.Run("connectors don't remove each other's roles", func(t *testing.T) {
+		loginService, store := setup(true)
+
+		signIn(loginService, "oauth_okta", map[int64]models.RoleType{1: models.ROLE_EDITOR})
+		signIn(loginService, "oauth_azuread", map[int64]models.RoleType{2: models.ROLE_VIEWER})
+		signIn(loginService, "oauth_okta", map[int64]models.RoleType{1: models.ROLE_EDITOR})
+
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[2][1])
+	})
+
+	t.Run("connectors remove their own roles", func(t *testing.T) {
+		loginService, store := setup(true)
+
+		signIn(loginService, "oauth_okta", map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: models.ROLE_VIEWER})
+		signIn(loginService, "oauth_okta", map[int64]models.RoleType{1: models.ROLE_EDITOR})
+
+		assert.NotContains(t, store.orgUsers[2], int64(1))
+	})
+
+	t.Run("memberships without provenance are kept", func(t *testing.T) {
+		loginService, store := setup(true)
+		store.addOrgUser(3, 1, models.ROLE_VIEWER)
+
+		signIn(loginService, "oauth_okta", map[int64]models.RoleType{1: models.ROLE_EDITOR})
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[3][1])
+	})
+
+	t.Run("roles granted by another connector aren't changed", func(t *testing.T) {
+		loginService, store := setup(true)
+
+		signIn(loginService, "oauth_okta", map[int64]models.RoleType{1: models.ROLE_VIEWER})
+		cmd := signIn(loginService, "oauth_azuread", map[int64]models.RoleType{1: models.ROLE_ADMIN})
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+		assert.Equal(t, []string{"kept role in organization 1 granted by oauth_okta"}, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("without scoping the last connector wins", func(t *testing.T) {
+		loginService, store := setup(false)
+
+		signIn(loginService, "oauth_okta", map[int64]models.RoleType{1: models.ROLE_EDITOR})
+		signIn(loginService, "oauth_azuread", map[int64]models.RoleType{2: models.ROLE_VIEWER})
+
+		assert.NotContains(t, store.orgUsers[1], int64(1))
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[2][1])
+	})
+}
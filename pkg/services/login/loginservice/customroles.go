@@ -0,0 +1,52 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// syncCustomRoles grants and revokes custom roles so that, for every org listed in
+// extUser.CustomRoles, the user has exactly the listed roles. Orgs that aren't
+// listed are left untouched, so an empty list is needed to revoke all roles in an org.
+func (ls *Implementation) syncCustomRoles(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	if ls.CustomRoleService == nil || len(extUser.CustomRoles) == 0 {
+		return nil
+	}
+
+	logger.Debug("Syncing custom roles", "id", user.Id, "extCustomRoles", extUser.CustomRoles)
+
+	for orgID, roleUIDs := range extUser.CustomRoles {
+		current, err := ls.CustomRoleService.GetUserCustomRoles(ctx, orgID, user.Id)
+		if err != nil {
+			return err
+		}
+
+		desired := make(map[string]bool, len(roleUIDs))
+		for _, uid := range roleUIDs {
+			desired[uid] = true
+		}
+
+		granted := make(map[string]bool, len(current))
+		for _, uid := range current {
+			granted[uid] = true
+			if desired[uid] {
+				continue
+			}
+			if err := ls.CustomRoleService.RemoveUserCustomRole(ctx, orgID, user.Id, uid); err != nil {
+				return err
+			}
+		}
+
+		for _, uid := range roleUIDs {
+			if granted[uid] {
+				continue
+			}
+			if err := ls.CustomRoleService.AddUserCustomRole(ctx, orgID, user.Id, uid); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
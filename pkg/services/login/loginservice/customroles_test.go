@@ -0,0 +1,73 @@
+package loginservice
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_syncCustomRoles(t *testing.T) {
+	user := &models.User{Id: 1}
+	roles := &fakeCustomRoleService{roles: map[int64]map[int64][]string{
+		1: {1: {"dashboards-reader", "alerts-writer"}},
+		2: {1: {"reports-reader"}},
+	}}
+	login := Implementation{CustomRoleService: roles}
+
+	extUser := &models.ExternalUserInfo{CustomRoles: map[int64][]string{
+		1: {"dashboards-reader", "datasources-writer"},
+	}}
+	require.NoError(t, login.syncCustomRoles(context.Background(), user, extUser))
+
+	assert.Equal(t, []string{"dashboards-reader", "datasources-writer"}, roles.userRoles(1, 1))
+	assert.Equal(t, []string{"reports-reader"}, roles.userRoles(2, 1), "unlisted orgs must be left untouched")
+
+	t.Run("an empty list revokes all roles in the org", func(t *testing.T) {
+		extUser := &models.ExternalUserInfo{CustomRoles: map[int64][]string{2: {}}}
+		require.NoError(t, login.syncCustomRoles(context.Background(), user, extUser))
+		assert.Empty(t, roles.userRoles(2, 1))
+	})
+
+	t.Run("nothing happens without a custom role service", func(t *testing.T) {
+		login := Implementation{}
+		require.NoError(t, login.syncCustomRoles(context.Background(), user, extUser))
+	})
+}
+
+type fakeCustomRoleService struct {
+	// org id -> user id -> role uids
+	roles map[int64]map[int64][]string
+}
+
+func (f *fakeCustomRoleService) userRoles(orgID, userID int64) []string {
+	uids := append([]string{}, f.roles[orgID][userID]...)
+	sort.Strings(uids)
+	return uids
+}
+
+func (f *fakeCustomRoleService) GetUserCustomRoles(ctx context.Context, orgID, userID int64) ([]string, error) {
+	return f.roles[orgID][userID], nil
+}
+
+func (f *fakeCustomRoleService) AddUserCustomRole(ctx context.Context, orgID, userID int64, roleUID string) error {
+	if f.roles[orgID] == nil {
+		f.roles[orgID] = map[int64][]string{}
+	}
+	f.roles[orgID][userID] = append(f.roles[orgID][userID], roleUID)
+	return nil
+}
+
+func (f *fakeCustomRoleService) RemoveUserCustomRole(ctx context.Context, orgID, userID int64, roleUID string) error {
+	var kept []string
+	for _, uid := range f.roles[orgID][userID] {
+		if uid != roleUID {
+			kept = append(kept, uid)
+		}
+	}
+	f.roles[orgID][userID] = kept
+	return nil
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// DisableSourceStore stores why users were disabled in the
+// user_disable_source table.
+type DisableSourceStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideDisableSourceStore(sqlStore sqlstore.Store) *DisableSourceStore {
+	return &DisableSourceStore{sqlStore: sqlStore}
+}
+
+type userDisableSource struct {
+	UserId int64
+	Source login.DisableSource
+}
+
+// GetDisableSource returns why a user was disabled, empty if it wasn't recorded.
+func (s *DisableSourceStore) GetDisableSource(ctx context.Context, userID int64) (login.DisableSource, error) {
+	var row userDisableSource
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Table("user_disable_source").Where("user_id = ?", userID).Get(&row)
+		return err
+	})
+	return row.Source, err
+}
+
+// SetDisableSource records why a user was disabled, replacing the source
+// recorded before.
+func (s *DisableSourceStore) SetDisableSource(ctx context.Context, userID int64, source login.DisableSource) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		if _, err := sess.Exec("DELETE FROM user_disable_source WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		_, err := sess.Table("user_disable_source").Insert(&userDisableSource{UserId: userID, Source: source})
+		return err
+	})
+}
+
+func (s *DisableSourceStore) DeleteDisableSource(ctx context.Context, userID int64) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("DELETE FROM user_disable_source WHERE user_id = ?", userID)
+		return err
+	})
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// DisplayNameStore checks whether display names are taken.
+type DisplayNameStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideDisplayNameStore(sqlStore sqlstore.Store) *DisplayNameStore {
+	return &DisplayNameStore{sqlStore: sqlStore}
+}
+
+// IsDisplayNameTaken reports whether a user other than exceptUserID has the
+// name, service accounts aside.
+func (s *DisplayNameStore) IsDisplayNameTaken(ctx context.Context, name string, exceptUserID int64) (bool, error) {
+	var taken bool
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		var err error
+		taken, err = sess.Table("user").
+			Where("name = ? AND id != ? AND is_service_account = ?", name, exceptUserID, false).
+			Exist()
+		return err
+	})
+	return taken, err
+}
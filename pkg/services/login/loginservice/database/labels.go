@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// UserLabelStore stores the labels of users in the user_label table.
+type UserLabelStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideUserLabelStore(sqlStore sqlstore.Store) *UserLabelStore {
+	return &UserLabelStore{sqlStore: sqlStore}
+}
+
+type userLabel struct {
+	Id      int64
+	UserId  int64
+	Label   string
+	Created time.Time
+}
+
+// AddUserLabels adds labels to a user, within the transaction of ctx if there's
+// one. Labels the user already has are kept.
+func (s *UserLabelStore) AddUserLabels(ctx context.Context, userID int64, labels []string) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		for _, label := range labels {
+			has, err := sess.Table("user_label").Where("user_id = ? AND label = ?", userID, label).Exist()
+			if err != nil {
+				return err
+			}
+			if has {
+				continue
+			}
+			if _, err := sess.Table("user_label").Insert(&userLabel{UserId: userID, Label: label, Created: time.Now()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *UserLabelStore) GetUserIdsByLabel(ctx context.Context, label string) ([]int64, error) {
+	userIDs := []int64{}
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		return sess.Table("user_label").Where("label = ?", label).Asc("user_id").Cols("user_id").Find(&userIDs)
+	})
+	return userIDs, err
+}
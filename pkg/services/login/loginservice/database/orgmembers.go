@@ -0,0 +1,33 @@
+package database
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// OrgMemberCountStore counts the members of the orgs.
+type OrgMemberCountStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideOrgMemberCountStore(sqlStore sqlstore.Store) *OrgMemberCountStore {
+	return &OrgMemberCountStore{sqlStore: sqlStore}
+}
+
+// GetOrgMemberCounts returns the number of members of each org by role,
+// service accounts aside.
+func (s *OrgMemberCountStore) GetOrgMemberCounts(ctx context.Context) ([]*login.OrgMemberCount, error) {
+	counts := []*login.OrgMemberCount{}
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		return sess.Table("org_user").
+			Join("INNER", []string{"user", "u"}, "u.id = org_user.user_id").
+			Where("u.is_service_account = ?", false).
+			Select("org_user.org_id, org_user.role, COUNT(*) AS count").
+			GroupBy("org_user.org_id, org_user.role").
+			Asc("org_user.org_id", "org_user.role").
+			Find(&counts)
+	})
+	return counts, err
+}
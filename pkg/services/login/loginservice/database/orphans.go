@@ -0,0 +1,40 @@
+package database
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// OrphanedAuthInfoStore finds and removes the user_auth rows whose user
+// doesn't exist.
+type OrphanedAuthInfoStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideOrphanedAuthInfoStore(sqlStore sqlstore.Store) *OrphanedAuthInfoStore {
+	return &OrphanedAuthInfoStore{sqlStore: sqlStore}
+}
+
+// GetOrphanedAuthInfo returns the auth info rows whose user doesn't exist,
+// without their tokens.
+func (s *OrphanedAuthInfoStore) GetOrphanedAuthInfo(ctx context.Context) ([]*models.UserAuth, error) {
+	orphans := []*models.UserAuth{}
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		return sess.Table("user_auth").
+			Join("LEFT", []string{"user", "u"}, "u.id = user_auth.user_id").
+			Where("u.id IS NULL").
+			Select("user_auth.id, user_auth.user_id, user_auth.auth_module, user_auth.auth_id, user_auth.created").
+			Asc("user_auth.id").
+			Find(&orphans)
+	})
+	return orphans, err
+}
+
+func (s *OrphanedAuthInfoStore) DeleteAuthInfo(ctx context.Context, cmd *models.DeleteAuthInfoCommand) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Delete(cmd.UserAuth)
+		return err
+	})
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// OutboxStore stores the login events to deliver in the login_outbox table.
+type OutboxStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideOutboxStore(sqlStore sqlstore.Store) *OutboxStore {
+	return &OutboxStore{sqlStore: sqlStore}
+}
+
+// AddOutboxEvent stores an event in the login outbox, within the transaction
+// of ctx if there's one.
+func (s *OutboxStore) AddOutboxEvent(ctx context.Context, event *login.OutboxEvent) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Table("login_outbox").Insert(event)
+		return err
+	})
+}
+
+func (s *OutboxStore) GetOutboxEvents(ctx context.Context, limit int) ([]*login.OutboxEvent, error) {
+	events := []*login.OutboxEvent{}
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		return sess.Table("login_outbox").Asc("id").Limit(limit).Find(&events)
+	})
+	return events, err
+}
+
+func (s *OutboxStore) DeleteOutboxEvent(ctx context.Context, id int64) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("DELETE FROM login_outbox WHERE id = ?", id)
+		return err
+	})
+}
+
+func (s *OutboxStore) IncrementOutboxAttempts(ctx context.Context, id int64) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("UPDATE login_outbox SET attempts = attempts + 1 WHERE id = ?", id)
+		return err
+	})
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// PendingRoleStore stores the org roles of orgs that don't exist yet in the
+// pending_org_role table.
+type PendingRoleStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvidePendingRoleStore(sqlStore sqlstore.Store) *PendingRoleStore {
+	return &PendingRoleStore{sqlStore: sqlStore}
+}
+
+// SavePendingOrgRole stores a role for an org that doesn't exist yet, replacing
+// the pending role of the user in that org if there's one.
+func (s *PendingRoleStore) SavePendingOrgRole(ctx context.Context, role *login.PendingOrgRole) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		if _, err := sess.Exec("DELETE FROM pending_org_role WHERE org_id = ? AND user_id = ?", role.OrgId, role.UserId); err != nil {
+			return err
+		}
+		_, err := sess.Table("pending_org_role").Insert(role)
+		return err
+	})
+}
+
+func (s *PendingRoleStore) GetPendingOrgRoles(ctx context.Context, orgID int64) ([]*login.PendingOrgRole, error) {
+	roles := make([]*login.PendingOrgRole, 0)
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		return sess.Table("pending_org_role").Where("org_id = ?", orgID).Asc("id").Find(&roles)
+	})
+	return roles, err
+}
+
+func (s *PendingRoleStore) DeletePendingOrgRole(ctx context.Context, orgID, userID int64) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("DELETE FROM pending_org_role WHERE org_id = ? AND user_id = ?", orgID, userID)
+		return err
+	})
+}
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// UserPreferencesStore stores the preferences synced for users in the
+// user_synced_preferences table.
+type UserPreferencesStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideUserPreferencesStore(sqlStore sqlstore.Store) *UserPreferencesStore {
+	return &UserPreferencesStore{sqlStore: sqlStore}
+}
+
+type userSyncedPreferences struct {
+	UserId   int64
+	Locale   string
+	Timezone string
+}
+
+// GetUserPreferences returns the preferences synced for a user, nil if there
+// are none.
+func (s *UserPreferencesStore) GetUserPreferences(ctx context.Context, userID int64) (*login.UserPreferences, error) {
+	var row userSyncedPreferences
+	var has bool
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		var err error
+		has, err = sess.Table("user_synced_preferences").Where("user_id = ?", userID).Get(&row)
+		return err
+	})
+	if err != nil || !has {
+		return nil, err
+	}
+	return &login.UserPreferences{Locale: row.Locale, Timezone: row.Timezone}, nil
+}
+
+// SetUserPreferences replaces the preferences synced for a user.
+func (s *UserPreferencesStore) SetUserPreferences(ctx context.Context, userID int64, prefs *login.UserPreferences) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		if _, err := sess.Exec("DELETE FROM user_synced_preferences WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		row := &userSyncedPreferences{UserId: userID, Locale: prefs.Locale, Timezone: prefs.Timezone}
+		_, err := sess.Table("user_synced_preferences").Insert(row)
+		return err
+	})
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// RoleProvenanceStore stores the provenance of the org roles set by external
+// sync in the org_role_provenance table.
+type RoleProvenanceStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideRoleProvenanceStore(sqlStore sqlstore.Store) *RoleProvenanceStore {
+	return &RoleProvenanceStore{sqlStore: sqlStore}
+}
+
+// SaveOrgRoleProvenance records the org role set by external sync, replacing
+// the provenance recorded before for the user and org.
+func (s *RoleProvenanceStore) SaveOrgRoleProvenance(ctx context.Context, provenance *login.OrgRoleProvenance) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		if _, err := sess.Exec("DELETE FROM org_role_provenance WHERE user_id = ? AND org_id = ?", provenance.UserId, provenance.OrgId); err != nil {
+			return err
+		}
+		_, err := sess.Table("org_role_provenance").Insert(provenance)
+		return err
+	})
+}
+
+// GetOrgRoleProvenance returns the provenance of the org role of a user, nil if
+// it was never set by external sync.
+func (s *RoleProvenanceStore) GetOrgRoleProvenance(ctx context.Context, userID, orgID int64) (*login.OrgRoleProvenance, error) {
+	var provenance login.OrgRoleProvenance
+	var has bool
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		var err error
+		has, err = sess.Table("org_role_provenance").Where("user_id = ? AND org_id = ?", userID, orgID).Get(&provenance)
+		return err
+	})
+	if err != nil || !has {
+		return nil, err
+	}
+	return &provenance, nil
+}
+
+func (s *RoleProvenanceStore) DeleteOrgRoleProvenance(ctx context.Context, userID, orgID int64) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("DELETE FROM org_role_provenance WHERE user_id = ? AND org_id = ?", userID, orgID)
+		return err
+	})
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// SoftDeleteStore stores the soft deleted users in the user_soft_delete
+// table.
+type SoftDeleteStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideSoftDeleteStore(sqlStore sqlstore.Store) *SoftDeleteStore {
+	return &SoftDeleteStore{sqlStore: sqlStore}
+}
+
+// GetSoftDeletedUser returns the soft deletion of a user, nil if it isn't soft
+// deleted.
+func (s *SoftDeleteStore) GetSoftDeletedUser(ctx context.Context, userID int64) (*login.SoftDeletedUser, error) {
+	var user login.SoftDeletedUser
+	var has bool
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		var err error
+		has, err = sess.Table("user_soft_delete").Where("user_id = ?", userID).Get(&user)
+		return err
+	})
+	if err != nil || !has {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SetSoftDeletedUser marks a user as soft deleted, replacing its current soft
+// deletion if there's one.
+func (s *SoftDeleteStore) SetSoftDeletedUser(ctx context.Context, user *login.SoftDeletedUser) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		if _, err := sess.Exec("DELETE FROM user_soft_delete WHERE user_id = ?", user.UserId); err != nil {
+			return err
+		}
+		_, err := sess.Table("user_soft_delete").Insert(user)
+		return err
+	})
+}
+
+func (s *SoftDeleteStore) DeleteSoftDeletedUser(ctx context.Context, userID int64) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("DELETE FROM user_soft_delete WHERE user_id = ?", userID)
+		return err
+	})
+}
+
+// GetSoftDeletedUsersBefore returns the users soft deleted before t.
+func (s *SoftDeleteStore) GetSoftDeletedUsersBefore(ctx context.Context, t time.Time) ([]*login.SoftDeletedUser, error) {
+	var users []*login.SoftDeletedUser
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		return sess.Table("user_soft_delete").Where("deleted < ?", t).Asc("user_id").Find(&users)
+	})
+	return users, err
+}
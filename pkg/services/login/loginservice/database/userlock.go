@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// UserLockStore stores the user locks in the user_lock table.
+type UserLockStore struct {
+	sqlStore sqlstore.Store
+}
+
+func ProvideUserLockStore(sqlStore sqlstore.Store) *UserLockStore {
+	return &UserLockStore{sqlStore: sqlStore}
+}
+
+// GetUserLock returns the lock of a user, nil if it isn't locked.
+func (s *UserLockStore) GetUserLock(ctx context.Context, userID int64) (*login.UserLock, error) {
+	var lock login.UserLock
+	var has bool
+	err := s.sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		var err error
+		has, err = sess.Table("user_lock").Where("user_id = ?", userID).Get(&lock)
+		return err
+	})
+	if err != nil || !has {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// SetUserLock locks a user, replacing its current lock if there's one.
+func (s *UserLockStore) SetUserLock(ctx context.Context, lock *login.UserLock) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		if _, err := sess.Exec("DELETE FROM user_lock WHERE user_id = ?", lock.UserId); err != nil {
+			return err
+		}
+		_, err := sess.Table("user_lock").Insert(lock)
+		return err
+	})
+}
+
+func (s *UserLockStore) DeleteUserLock(ctx context.Context, userID int64) error {
+	return s.sqlStore.WithTransactionalDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		_, err := sess.Exec("DELETE FROM user_lock WHERE user_id = ?", userID)
+		return err
+	})
+}
@@ -0,0 +1,53 @@
+package loginservice
+
+import (
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// EmptyDefaultOrgRolePolicy controls what an empty role for the current org of
+// the user means when DefaultRolePerOrg has no role for that org.
+type EmptyDefaultOrgRolePolicy int
+
+const (
+	// EmptyDefaultOrgRoleRemove removes the membership like for any other org,
+	// and moves the user to another org it has a role in (default).
+	EmptyDefaultOrgRoleRemove EmptyDefaultOrgRolePolicy = iota
+	// EmptyDefaultOrgRoleKeep keeps the membership with its current role.
+	EmptyDefaultOrgRoleKeep
+	// EmptyDefaultOrgRoleDefault sets the role to the auto assigned org role.
+	EmptyDefaultOrgRoleDefault
+)
+
+// resolveEmptyDefaultOrgRole replaces an empty role for the current org of the
+// user according to EmptyDefaultOrgRole. Empty roles left in place remove the
+// membership, see externalOrgRole.
+func (ls *Implementation) resolveEmptyDefaultOrgRole(user *models.User, extUser *models.ExternalUserInfo, current []*models.UserOrgDTO) {
+	role, ok := extUser.OrgRoles[user.OrgId]
+	if !ok || role != "" || ls.externalOrgRole(extUser, user.OrgId) != "" {
+		return
+	}
+
+	switch ls.EmptyDefaultOrgRole {
+	case EmptyDefaultOrgRoleKeep:
+		for _, org := range current {
+			if org.OrgId == user.OrgId {
+				extUser.OrgRoles[user.OrgId] = org.Role
+			}
+		}
+	case EmptyDefaultOrgRoleDefault:
+		extUser.OrgRoles[user.OrgId] = models.RoleType(setting.AutoAssignOrgRole)
+	}
+}
+
+// syncedOrgRoles returns the org roles the user keeps a membership for, i.e.
+// those that don't resolve to an empty role.
+func (ls *Implementation) syncedOrgRoles(extUser *models.ExternalUserInfo) map[int64]models.RoleType {
+	synced := make(map[int64]models.RoleType, len(extUser.OrgRoles))
+	for orgID := range extUser.OrgRoles {
+		if role := ls.externalOrgRole(extUser, orgID); role != "" {
+			synced[orgID] = role
+		}
+	}
+	return synced
+}
@@ -0,0 +1,77 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_emptyDefaultOrgRole(t *testing.T) {
+	autoAssignOrgRole := setting.AutoAssignOrgRole
+	t.Cleanup(func() { setting.AutoAssignOrgRole = autoAssignOrgRole })
+	setting.AutoAssignOrgRole = string(models.ROLE_VIEWER)
+
+	setup := func(policy EmptyDefaultOrgRolePolicy) (*Implementation, *fakeStore, *models.User) {
+		user := &models.User{Id: 1, Login: "alice", OrgId: 1}
+		store := newFakeStore(user)
+		store.addOrg(1)
+		store.addOrg(2)
+		store.addOrgUser(1, 1, models.ROLE_EDITOR)
+		return &Implementation{
+			SQLStore:            store,
+			AuthInfoService:     &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			EmptyDefaultOrgRole: policy,
+		}, store, user
+	}
+	upsert := func(t *testing.T, loginService *Implementation) {
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			OrgRoles: map[int64]models.RoleType{1: "", 2: models.ROLE_EDITOR},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+	}
+
+	t.Run("remove drops the membership and moves the user to another org", func(t *testing.T) {
+		loginService, store, user := setup(EmptyDefaultOrgRoleRemove)
+
+		upsert(t, loginService)
+
+		assert.Empty(t, store.orgUsers[1])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][1])
+		assert.Equal(t, int64(2), user.OrgId)
+	})
+
+	t.Run("keep leaves the membership and the current org alone", func(t *testing.T) {
+		loginService, store, user := setup(EmptyDefaultOrgRoleKeep)
+
+		upsert(t, loginService)
+
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][1])
+		assert.Equal(t, int64(1), user.OrgId)
+	})
+
+	t.Run("default sets the auto assigned org role", func(t *testing.T) {
+		loginService, store, user := setup(EmptyDefaultOrgRoleDefault)
+
+		upsert(t, loginService)
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+		assert.Equal(t, int64(1), user.OrgId)
+	})
+
+	t.Run("the default role of the org takes precedence", func(t *testing.T) {
+		loginService, store, user := setup(EmptyDefaultOrgRoleKeep)
+		loginService.DefaultRolePerOrg = map[int64]models.RoleType{1: models.ROLE_ADMIN}
+
+		upsert(t, loginService)
+
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][1])
+		assert.Equal(t, int64(1), user.OrgId)
+	})
+}
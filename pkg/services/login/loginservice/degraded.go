@@ -0,0 +1,198 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// defaultDegradedLoginTTL is used when AllowDegradedLogin is set without a DegradedLoginTTL.
+const defaultDegradedLoginTTL = 5 * time.Minute
+
+type lastKnownGoodKey struct {
+	authModule string
+	id         string
+}
+
+type lastKnownGoodEntry struct {
+	user    models.User
+	expires time.Time
+}
+
+// lastKnownGoodCache keeps the users of recent successful logins, keyed by
+// their external identity.
+type lastKnownGoodCache struct {
+	mu      sync.Mutex
+	entries map[lastKnownGoodKey]lastKnownGoodEntry
+}
+
+func (c *lastKnownGoodCache) evict(key lastKnownGoodKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *lastKnownGoodCache) get(key lastKnownGoodKey, now time.Time) (*models.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	user := entry.user
+	return &user, true
+}
+
+// set caches user until now+ttl, evicting expired entries.
+func (c *lastKnownGoodCache) set(key lastKnownGoodKey, user *models.User, now time.Time, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[lastKnownGoodKey]lastKnownGoodEntry{}
+	}
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = lastKnownGoodEntry{user: *user, expires: now.Add(ttl)}
+}
+
+// lastKnownGoodKeyOf identifies extUser by its auth id, or by its login for
+// identity providers without ids.
+func lastKnownGoodKeyOf(extUser *models.ExternalUserInfo) (lastKnownGoodKey, bool) {
+	if extUser.AuthId != "" {
+		return lastKnownGoodKey{authModule: extUser.AuthModule, id: extUser.AuthId}, true
+	}
+	if extUser.Login != "" {
+		return lastKnownGoodKey{authModule: extUser.AuthModule, id: "login:" + extUser.Login}, true
+	}
+	return lastKnownGoodKey{}, false
+}
+
+func (ls *Implementation) degradedLoginTTL() time.Duration {
+	if ls.DegradedLoginTTL > 0 {
+		return ls.DegradedLoginTTL
+	}
+	return defaultDegradedLoginTTL
+}
+
+// rememberLastKnownGood caches the user of a successful login for degraded logins.
+func (ls *Implementation) rememberLastKnownGood(extUser *models.ExternalUserInfo, user *models.User) {
+	if !ls.AllowDegradedLogin {
+		return
+	}
+	key, ok := lastKnownGoodKeyOf(extUser)
+	if !ok {
+		return
+	}
+	ls.lastKnownGood.set(key, user, ls.now(), ls.degradedLoginTTL())
+}
+
+// degradedLogin returns the last known good user of extUser when the lookup
+// failed with lookupErr, or nil if there is none or degraded logins aren't allowed.
+// The user is read again and goes through the lock, soft delete and disabled
+// checks, so that a cached user can't log in once it can't anymore.
+func (ls *Implementation) degradedLogin(ctx context.Context, extUser *models.ExternalUserInfo, state *syncState, lookupErr error) (*models.User, error) {
+	if !ls.AllowDegradedLogin {
+		return nil, nil
+	}
+	key, ok := lastKnownGoodKeyOf(extUser)
+	if !ok {
+		return nil, nil
+	}
+	cached, ok := ls.lastKnownGood.get(key, ls.now())
+	if !ok {
+		return nil, nil
+	}
+	user, err := ls.checkLastKnownGood(ctx, cached)
+	if err != nil {
+		if !errors.Is(err, errLastKnownGoodUnavailable) {
+			ls.lastKnownGood.evict(key)
+		}
+		logger.Warn("Refusing degraded login", "id", cached.Id, "authmodule", extUser.AuthModule, "error", err)
+		return nil, err
+	}
+
+	logger.Warn("Auth info lookup failed, logging in user from last known good state without sync", "id", user.Id, "authmodule", extUser.AuthModule, "error", lookupErr)
+	metrics.MApiLoginDegraded.Inc()
+	state.result.Degraded = true
+	state.result.Warnings = append(state.result.Warnings, "user was not synced, the auth info lookup failed: "+lookupErr.Error())
+	return user, nil
+}
+
+// errLastKnownGoodUnavailable wraps the errors of checkLastKnownGood that don't
+// tell whether the user can still log in.
+var errLastKnownGoodUnavailable = errors.New("last known good user couldn't be checked")
+
+// checkLastKnownGood reads the cached user again and checks that it isn't
+// locked, soft deleted or disabled. Soft deleted users aren't restored, that
+// takes a full login.
+func (ls *Implementation) checkLastKnownGood(ctx context.Context, cached *models.User) (*models.User, error) {
+	query := &models.GetUserByIdQuery{Id: cached.Id}
+	if err := ls.SQLStore.GetUserById(ctx, query); err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil, err
+		}
+		return nil, upsertErr(login.UpsertPhaseLookup, fmt.Errorf("%w: %v", errLastKnownGoodUnavailable, err))
+	}
+	user := query.Result
+
+	if err := ls.checkUserLock(ctx, user.Id); err != nil {
+		var lockedErr *login.ErrUserLocked
+		if errors.As(err, &lockedErr) {
+			return nil, err
+		}
+		return nil, upsertErr(login.UpsertPhaseLookup, fmt.Errorf("%w: %v", errLastKnownGoodUnavailable, err))
+	}
+	if ls.SoftDeleteStore != nil {
+		deleted, err := ls.SoftDeleteStore.GetSoftDeletedUser(ctx, user.Id)
+		if err != nil {
+			return nil, upsertErr(login.UpsertPhaseLookup, fmt.Errorf("%w: %v", errLastKnownGoodUnavailable, err))
+		}
+		if deleted != nil {
+			return nil, login.ErrUserSoftDeleted
+		}
+	}
+	if user.IsDisabled {
+		return nil, login.ErrUserDisabled
+	}
+	return user, nil
+}
+
+// syncDegraded syncs the org roles and server admin flag of a degraded login
+// with SyncDegradedLogins. It fails safe since the user may be stale: org role
+// removals and downgrades and server admin revocations are applied, while org
+// role additions and upgrades are deferred and so are server admin grants.
+// Sync failures are reported as warnings, the degraded login succeeds anyway.
+func (ls *Implementation) syncDegraded(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, state *syncState) {
+	if !ls.SyncDegradedLogins {
+		return
+	}
+
+	state.degraded = true
+	if err := ls.syncOrgRoles(ctx, user, extUser, state); err != nil {
+		logger.Warn("Failed to sync organization roles of degraded login", "userId", user.Id, "error", err)
+		state.result.Warnings = append(state.result.Warnings, "failed to sync organization roles of degraded login: "+err.Error())
+	}
+	if err := ls.syncGrafanaAdmin(ctx, user, extUser, state); err != nil {
+		logger.Warn("Failed to sync server admin flag of degraded login", "userId", user.Id, "error", err)
+		state.result.Warnings = append(state.result.Warnings, "failed to sync server admin flag of degraded login: "+err.Error())
+	}
+	if len(state.result.DeferredOrgIds) > 0 {
+		logger.Warn("Deferring organization role upgrades of degraded login", "userId", user.Id, "deferredOrgIds", state.result.DeferredOrgIds)
+	}
+}
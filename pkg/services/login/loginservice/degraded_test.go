@@ -0,0 +1,241 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errAuthInfoUnavailable = errors.New("connection refused")
+
+func setupDegraded(allow bool) (*Implementation, *fakeStore, *logintest.AuthInfoServiceFake, *clock.Mock) {
+	store := newFakeStore(&models.User{Id: 1, Login: "alice", Email: "alice@example.com"})
+	store.addOrgUser(1, 1, models.ROLE_VIEWER)
+	authInfo := &logintest.AuthInfoServiceFake{ExpectedUser: store.users[1]}
+	clk := clock.NewMock()
+	return &Implementation{
+		Clock:              clk,
+		SQLStore:           store,
+		QuotaService:       &quota.QuotaService{Cfg: setting.NewCfg()},
+		AuthInfoService:    authInfo,
+		AllowDegradedLogin: allow,
+		DegradedLoginTTL:   time.Minute,
+	}, store, authInfo, clk
+}
+
+func degradedLoginCmd(role models.RoleType) *models.UpsertUserCommand {
+	return &models.UpsertUserCommand{
+		ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic_oauth",
+			AuthId:     "alice-id",
+			Login:      "alice",
+			Email:      "alice@example.com",
+			OrgRoles:   map[int64]models.RoleType{1: role},
+		},
+	}
+}
+
+func Test_UpsertUser_degradedLogin(t *testing.T) {
+	t.Run("outage with a cached login logs in without sync", func(t *testing.T) {
+		loginService, store, authInfo, _ := setupDegraded(true)
+		require.NoError(t, loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER)))
+
+		authInfo.ExpectedUser = nil
+		authInfo.ExpectedError = errAuthInfoUnavailable
+		before := testutil.ToFloat64(metrics.MApiLoginDegraded)
+
+		cmd := degradedLoginCmd(models.ROLE_EDITOR)
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, int64(1), cmd.Result.Id)
+		assert.True(t, cmd.SyncResult.Degraded)
+		assert.Len(t, cmd.SyncResult.Warnings, 1)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1], "org roles should not be synced")
+		assert.Equal(t, before+1, testutil.ToFloat64(metrics.MApiLoginDegraded))
+	})
+
+	t.Run("outage without a cached login fails", func(t *testing.T) {
+		loginService, _, authInfo, _ := setupDegraded(true)
+		authInfo.ExpectedUser = nil
+		authInfo.ExpectedError = errAuthInfoUnavailable
+
+		cmd := degradedLoginCmd(models.ROLE_VIEWER)
+		err := loginService.UpsertUser(context.Background(), cmd)
+		require.ErrorIs(t, err, errAuthInfoUnavailable)
+		assert.ErrorIs(t, err, &login.ErrUpsertUser{Phase: login.UpsertPhaseLookup})
+		assert.False(t, cmd.SyncResult.Degraded)
+	})
+
+	t.Run("cached login expires after the TTL", func(t *testing.T) {
+		loginService, _, authInfo, clk := setupDegraded(true)
+		require.NoError(t, loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER)))
+
+		clk.Add(2 * time.Minute)
+		authInfo.ExpectedUser = nil
+		authInfo.ExpectedError = errAuthInfoUnavailable
+
+		err := loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER))
+		require.ErrorIs(t, err, errAuthInfoUnavailable)
+	})
+
+	t.Run("outage fails when degraded login isn't allowed", func(t *testing.T) {
+		loginService, _, authInfo, _ := setupDegraded(false)
+		require.NoError(t, loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER)))
+
+		authInfo.ExpectedUser = nil
+		authInfo.ExpectedError = errAuthInfoUnavailable
+
+		err := loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER))
+		require.ErrorIs(t, err, errAuthInfoUnavailable)
+	})
+
+	t.Run("user not found isn't served from the cache", func(t *testing.T) {
+		loginService, _, authInfo, _ := setupDegraded(true)
+		require.NoError(t, loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER)))
+
+		authInfo.ExpectedUser = nil
+		authInfo.ExpectedError = models.ErrUserNotFound
+
+		cmd := degradedLoginCmd(models.ROLE_VIEWER)
+		cmd.ReqContext = &models.ReqContext{Logger: logger}
+		require.ErrorIs(t, loginService.UpsertUser(context.Background(), cmd), login.ErrSignupNotAllowed)
+	})
+
+	outage := func(t *testing.T) (*Implementation, *fakeStore) {
+		loginService, store, authInfo, _ := setupDegraded(true)
+		require.NoError(t, loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER)))
+		authInfo.ExpectedUser = nil
+		authInfo.ExpectedError = errAuthInfoUnavailable
+		return loginService, store
+	}
+
+	t.Run("locked users are refused and evicted", func(t *testing.T) {
+		loginService, _ := outage(t)
+		locks := &fakeUserLockStore{locks: map[int64]*login.UserLock{}}
+		loginService.UserLockStore = locks
+		require.NoError(t, loginService.LockUser(context.Background(), 1, "compromised"))
+
+		var lockedErr *login.ErrUserLocked
+		require.True(t, errors.As(loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER)), &lockedErr))
+
+		require.NoError(t, loginService.UnlockUser(context.Background(), 1))
+		err := loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER))
+		require.ErrorIs(t, err, errAuthInfoUnavailable, "the cached login should be evicted")
+	})
+
+	t.Run("soft deleted users are refused", func(t *testing.T) {
+		loginService, _ := outage(t)
+		loginService.SoftDeleteStore = &fakeSoftDeleteStore{users: map[int64]*login.SoftDeletedUser{1: {UserId: 1}}}
+		loginService.OnSoftDeletedLogin = SoftDeletedLoginRestore
+
+		err := loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER))
+		require.ErrorIs(t, err, login.ErrUserSoftDeleted, "degraded logins don't restore users")
+	})
+
+	t.Run("disabled users are refused", func(t *testing.T) {
+		loginService, store := outage(t)
+		store.users[1].IsDisabled = true
+
+		err := loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER))
+		require.ErrorIs(t, err, login.ErrUserDisabled)
+	})
+
+	t.Run("deleted users are refused", func(t *testing.T) {
+		loginService, store := outage(t)
+		delete(store.users, 1)
+
+		err := loginService.UpsertUser(context.Background(), degradedLoginCmd(models.ROLE_VIEWER))
+		require.ErrorIs(t, err, models.ErrUserNotFound)
+	})
+
+	t.Run("the cached user is refreshed", func(t *testing.T) {
+		loginService, store := outage(t)
+		store.users[1].Email = "alice@example.org"
+
+		cmd := degradedLoginCmd(models.ROLE_VIEWER)
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		assert.Equal(t, "alice@example.org", cmd.Result.Email)
+	})
+}
+
+func Test_UpsertUser_syncDegradedLogins(t *testing.T) {
+	setup := func(t *testing.T, orgRoles map[int64]models.RoleType, isAdmin bool) (*Implementation, *fakeStore, *logintest.AuthInfoServiceFake) {
+		loginService, store, authInfo, _ := setupDegraded(true)
+		loginService.SyncDegradedLogins = true
+		for orgID := int64(1); orgID <= 4; orgID++ {
+			store.addOrg(orgID)
+		}
+
+		cmd := degradedLoginCmd(models.ROLE_VIEWER)
+		cmd.ExternalUser.OrgRoles = orgRoles
+		cmd.ExternalUser.IsGrafanaAdmin = &isAdmin
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		authInfo.ExpectedUser = nil
+		authInfo.ExpectedError = errAuthInfoUnavailable
+		return loginService, store, authInfo
+	}
+	degradedLogin := func(t *testing.T, loginService *Implementation, orgRoles map[int64]models.RoleType, isAdmin bool) *models.UpsertUserCommand {
+		cmd := degradedLoginCmd(models.ROLE_VIEWER)
+		cmd.ExternalUser.OrgRoles = orgRoles
+		cmd.ExternalUser.IsGrafanaAdmin = &isAdmin
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		require.True(t, cmd.SyncResult.Degraded)
+		return cmd
+	}
+
+	t.Run("org role upgrades are deferred, downgrades and removals applied", func(t *testing.T) {
+		loginService, store, _ := setup(t, map[int64]models.RoleType{1: models.ROLE_VIEWER, 2: models.ROLE_EDITOR, 4: models.ROLE_VIEWER}, false)
+
+		cmd := degradedLogin(t, loginService, map[int64]models.RoleType{1: models.ROLE_ADMIN, 2: models.ROLE_VIEWER, 3: models.ROLE_EDITOR}, false)
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1], "upgrade should be deferred")
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[2][1], "downgrade should be applied")
+		assert.Empty(t, store.orgUsers[3], "addition should be deferred")
+		assert.Empty(t, store.orgUsers[4], "removal should be applied")
+		assert.ElementsMatch(t, []int64{1, 3}, cmd.SyncResult.DeferredOrgIds)
+	})
+
+	t.Run("server admin grant is deferred", func(t *testing.T) {
+		loginService, store, _ := setup(t, map[int64]models.RoleType{1: models.ROLE_VIEWER}, false)
+
+		cmd := degradedLogin(t, loginService, map[int64]models.RoleType{1: models.ROLE_VIEWER}, true)
+
+		assert.False(t, store.users[1].IsAdmin)
+		assert.True(t, cmd.SyncResult.AdminGrantDeferred)
+	})
+
+	t.Run("server admin revocation is applied", func(t *testing.T) {
+		loginService, store, _ := setup(t, map[int64]models.RoleType{1: models.ROLE_VIEWER}, true)
+		require.True(t, store.users[1].IsAdmin)
+
+		cmd := degradedLogin(t, loginService, map[int64]models.RoleType{1: models.ROLE_VIEWER}, false)
+
+		assert.False(t, store.users[1].IsAdmin)
+		assert.False(t, cmd.SyncResult.AdminGrantDeferred)
+	})
+
+	t.Run("upgrades aren't deferred outside degraded logins", func(t *testing.T) {
+		loginService, store, authInfo := setup(t, map[int64]models.RoleType{1: models.ROLE_VIEWER}, false)
+		authInfo.ExpectedUser = store.users[1]
+		authInfo.ExpectedError = nil
+
+		cmd := degradedLoginCmd(models.ROLE_ADMIN)
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][1])
+		assert.Empty(t, cmd.SyncResult.DeferredOrgIds)
+	})
+}
@@ -0,0 +1,122 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// LastAdminOnDeletePolicy controls how DeleteExternalUser handles orgs the user
+// is the last admin of.
+type LastAdminOnDeletePolicy int
+
+const (
+	// LastAdminOnDeleteRefuse refuses the deletion with models.ErrLastOrgAdmin
+	// before anything is deleted (default).
+	LastAdminOnDeleteRefuse LastAdminOnDeletePolicy = iota
+	// LastAdminOnDeleteReassign makes the member with the highest role, ties
+	// going to the lowest user id, admin of the org before the user is removed.
+	// Orgs without other members are left empty.
+	LastAdminOnDeleteReassign
+	// LastAdminOnDeleteSkip deletes the user regardless, leaving the org without
+	// an admin.
+	LastAdminOnDeleteSkip
+)
+
+// DeleteExternalUser deletes a user in a single transaction, in an order that
+// never leaves rows referencing a deleted row behind: first the org
+// memberships, applying LastAdminOnDelete, then the user with its auth info
+// and the rest of its data.
+func (ls *Implementation) DeleteExternalUser(ctx context.Context, userID int64) error {
+	orgsQuery := &models.GetUserOrgListQuery{UserId: userID}
+	if err := ls.SQLStore.GetUserOrgList(ctx, orgsQuery); err != nil {
+		return err
+	}
+
+	// last admin protection is settled before anything is deleted, a refused
+	// removal in the transaction would already have deleted the membership
+	reassign := map[int64]*models.OrgUserDTO{}
+	skip := map[int64]bool{}
+	for _, org := range orgsQuery.Result {
+		if org.Role != models.ROLE_ADMIN {
+			continue
+		}
+		successor, lastAdmin, err := ls.orgSuccessor(ctx, userID, org.OrgId)
+		if err != nil {
+			return err
+		}
+		if !lastAdmin {
+			continue
+		}
+
+		switch {
+		case ls.LastAdminOnDelete == LastAdminOnDeleteRefuse:
+			return fmt.Errorf("%w: organization %d", models.ErrLastOrgAdmin, org.OrgId)
+		case ls.LastAdminOnDelete == LastAdminOnDeleteReassign && successor != nil:
+			reassign[org.OrgId] = successor
+		default:
+			skip[org.OrgId] = true
+		}
+	}
+
+	return ls.SQLStore.InTransaction(ctx, func(ctx context.Context) error {
+		for _, org := range orgsQuery.Result {
+			if successor, ok := reassign[org.OrgId]; ok {
+				logger.Info("Reassigning organization admin of deleted user", "userId", userID, "orgId", org.OrgId, "successorId", successor.UserId)
+				cmd := &models.UpdateOrgUserCommand{OrgId: org.OrgId, UserId: successor.UserId, Role: models.ROLE_ADMIN}
+				if err := ls.SQLStore.UpdateOrgUser(ctx, cmd); err != nil {
+					return err
+				}
+			}
+			// the membership is deleted with the user
+			if skip[org.OrgId] {
+				logger.Warn("Deleting the last organization admin", "userId", userID, "orgId", org.OrgId)
+				continue
+			}
+
+			cmd := &models.RemoveOrgUserCommand{OrgId: org.OrgId, UserId: userID}
+			if err := ls.withOrgRoleSynced(ctx, cmd.UserId, cmd.OrgId, "", func(ctx context.Context) error {
+				return ls.SQLStore.RemoveOrgUser(ctx, cmd)
+			}); err != nil {
+				return err
+			}
+			if err := ls.deleteOrgRoleProvenance(ctx, userID, org.OrgId); err != nil {
+				return err
+			}
+		}
+
+		logger.Info("Deleting external user", "userId", userID)
+		if err := ls.SQLStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: userID}); err != nil {
+			return err
+		}
+		ls.fingerprints.forget(userID)
+		ls.quotaCache.invalidate()
+		return nil
+	})
+}
+
+// orgSuccessor reports whether the user is the last admin of the org and
+// returns the member that would replace it, nil if there is none.
+func (ls *Implementation) orgSuccessor(ctx context.Context, userID, orgID int64) (*models.OrgUserDTO, bool, error) {
+	query := &models.GetOrgUsersQuery{OrgId: orgID}
+	if err := ls.SQLStore.GetOrgUsers(ctx, query); err != nil {
+		return nil, false, err
+	}
+
+	var successor *models.OrgUserDTO
+	for _, member := range query.Result {
+		if member.UserId == userID {
+			continue
+		}
+		role := models.RoleType(member.Role)
+		if role == models.ROLE_ADMIN {
+			return nil, false, nil
+		}
+		if successor == nil || exceedsRole(role, models.RoleType(successor.Role)) ||
+			(role == models.RoleType(successor.Role) && member.UserId < successor.UserId) {
+			successor = member
+		}
+	}
+	return successor, true, nil
+}
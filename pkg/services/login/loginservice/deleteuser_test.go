@@ -0,0 +1,98 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteExternalUser(t *testing.T) {
+	ctx := context.Background()
+
+	type fixture struct {
+		loginService  *Implementation
+		sqlStore      *sqlstore.SQLStore
+		authInfoStore *database.AuthInfoStore
+		alice, bob    *models.User
+	}
+	// alice is the only admin of her org, bob is an editor in it
+	setup := func(t *testing.T, policy LastAdminOnDeletePolicy) *fixture {
+		sqlStore := sqlstore.InitTestDB(t)
+		secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+		authInfoStore := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)
+
+		alice, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org"})
+		require.NoError(t, err)
+		bob, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "bob", Email: "bob@example.org"})
+		require.NoError(t, err)
+		require.NoError(t, sqlStore.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: alice.OrgId, UserId: bob.Id, Role: models.ROLE_EDITOR}))
+		require.NoError(t, authInfoStore.SetAuthInfo(ctx, &models.SetAuthInfoCommand{UserId: alice.Id, AuthModule: "oauth_generic", AuthId: "alice-id"}))
+
+		return &fixture{
+			loginService:  &Implementation{SQLStore: sqlStore, LastAdminOnDelete: policy},
+			sqlStore:      sqlStore,
+			authInfoStore: authInfoStore,
+			alice:         alice,
+			bob:           bob,
+		}
+	}
+	orgRoles := func(t *testing.T, f *fixture, orgID int64) map[int64]models.RoleType {
+		query := &models.GetOrgUsersQuery{OrgId: orgID}
+		require.NoError(t, f.sqlStore.GetOrgUsers(ctx, query))
+		roles := map[int64]models.RoleType{}
+		for _, member := range query.Result {
+			roles[member.UserId] = models.RoleType(member.Role)
+		}
+		return roles
+	}
+	requireDeleted := func(t *testing.T, f *fixture) {
+		require.ErrorIs(t, f.sqlStore.GetUserById(ctx, &models.GetUserByIdQuery{Id: f.alice.Id}), models.ErrUserNotFound)
+		query := &models.GetAuthInfoQuery{AuthModule: "oauth_generic", AuthId: "alice-id"}
+		require.ErrorIs(t, f.authInfoStore.GetAuthInfo(ctx, query), models.ErrUserNotFound)
+	}
+
+	t.Run("the last admin isn't deleted by default", func(t *testing.T) {
+		f := setup(t, LastAdminOnDeleteRefuse)
+
+		require.ErrorIs(t, f.loginService.DeleteExternalUser(ctx, f.alice.Id), models.ErrLastOrgAdmin)
+
+		require.NoError(t, f.sqlStore.GetUserById(ctx, &models.GetUserByIdQuery{Id: f.alice.Id}))
+		assert.Equal(t, map[int64]models.RoleType{f.alice.Id: models.ROLE_ADMIN, f.bob.Id: models.ROLE_EDITOR}, orgRoles(t, f, f.alice.OrgId))
+	})
+
+	t.Run("reassign makes another member admin", func(t *testing.T) {
+		f := setup(t, LastAdminOnDeleteReassign)
+
+		require.NoError(t, f.loginService.DeleteExternalUser(ctx, f.alice.Id))
+
+		requireDeleted(t, f)
+		assert.Equal(t, map[int64]models.RoleType{f.bob.Id: models.ROLE_ADMIN}, orgRoles(t, f, f.alice.OrgId))
+	})
+
+	t.Run("skip deletes the user without an admin to take over", func(t *testing.T) {
+		f := setup(t, LastAdminOnDeleteSkip)
+
+		require.NoError(t, f.loginService.DeleteExternalUser(ctx, f.alice.Id))
+
+		requireDeleted(t, f)
+		assert.Equal(t, map[int64]models.RoleType{f.bob.Id: models.ROLE_EDITOR}, orgRoles(t, f, f.alice.OrgId))
+	})
+
+	t.Run("orgs with another admin don't need a policy", func(t *testing.T) {
+		f := setup(t, LastAdminOnDeleteRefuse)
+		require.NoError(t, f.sqlStore.UpdateOrgUser(ctx, &models.UpdateOrgUserCommand{OrgId: f.alice.OrgId, UserId: f.bob.Id, Role: models.ROLE_ADMIN}))
+
+		require.NoError(t, f.loginService.DeleteExternalUser(ctx, f.alice.Id))
+
+		requireDeleted(t, f)
+		assert.Equal(t, map[int64]models.RoleType{f.bob.Id: models.ROLE_ADMIN}, orgRoles(t, f, f.alice.OrgId))
+	})
+}
@@ -0,0 +1,75 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// DisableImpact describes what an external user would lose if disabled.
+type DisableImpact struct {
+	UserId          int64
+	Login           string
+	AlreadyDisabled bool
+	Orgs            []*OrgMembershipImpact
+}
+
+// OrgMembershipImpact is a single org membership affected by disabling a user.
+// SoleAdmin is set when the user is the only admin of the org, meaning the org
+// would be left without an active admin.
+type OrgMembershipImpact struct {
+	OrgId     int64
+	Name      string
+	Role      models.RoleType
+	SoleAdmin bool
+}
+
+// PreviewDisableExternalUser reports the org memberships of an external user and flags
+// the orgs where they are the sole admin. It does not perform any writes.
+func (ls *Implementation) PreviewDisableExternalUser(ctx context.Context, username string) (*DisableImpact, error) {
+	userQuery := &models.GetExternalUserInfoByLoginQuery{
+		LoginOrEmail: username,
+	}
+	if err := ls.AuthInfoService.GetExternalUserInfoByLogin(ctx, userQuery); err != nil {
+		return nil, err
+	}
+
+	impact := &DisableImpact{
+		UserId:          userQuery.Result.UserId,
+		Login:           userQuery.Result.Login,
+		AlreadyDisabled: userQuery.Result.IsDisabled,
+		Orgs:            []*OrgMembershipImpact{},
+	}
+
+	orgsQuery := &models.GetUserOrgListQuery{UserId: impact.UserId}
+	if err := ls.readStore(ctx, false).GetUserOrgList(ctx, orgsQuery); err != nil {
+		return nil, err
+	}
+
+	for _, org := range orgsQuery.Result {
+		orgImpact := &OrgMembershipImpact{OrgId: org.OrgId, Name: org.Name, Role: org.Role}
+		if org.Role == models.ROLE_ADMIN {
+			soleAdmin, err := ls.isSoleOrgAdmin(ctx, org.OrgId, impact.UserId, nil)
+			if err != nil {
+				return nil, err
+			}
+			orgImpact.SoleAdmin = soleAdmin
+		}
+		impact.Orgs = append(impact.Orgs, orgImpact)
+	}
+
+	return impact, nil
+}
+
+// isSoleOrgAdmin reports whether no other enabled user is an admin of the org.
+// Admins in excluded don't count either.
+func (ls *Implementation) isSoleOrgAdmin(ctx context.Context, orgID, userID int64, excluded map[int64]bool) (bool, error) {
+	query := &models.CountOrgAdminsQuery{OrgId: orgID, ExcludeUserIds: []int64{userID}}
+	for excludedID := range excluded {
+		query.ExcludeUserIds = append(query.ExcludeUserIds, excludedID)
+	}
+	if err := ls.readStore(ctx, false).CountOrgAdmins(ctx, query); err != nil {
+		return false, err
+	}
+	return query.Result == 0, nil
+}
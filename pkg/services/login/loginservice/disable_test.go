@@ -0,0 +1,41 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PreviewDisableExternalUser(t *testing.T) {
+	store := newFakeStore(
+		&models.User{Id: 1, Login: "alice"},
+		&models.User{Id: 2, Login: "bob"},
+	)
+	store.addOrgUser(1, 1, models.ROLE_ADMIN)
+	store.addOrgUser(1, 2, models.ROLE_ADMIN)
+	store.addOrgUser(2, 1, models.ROLE_ADMIN)
+	store.addOrgUser(3, 1, models.ROLE_VIEWER)
+
+	login := Implementation{
+		SQLStore: store,
+		AuthInfoService: &logintest.AuthInfoServiceFake{
+			ExpectedExternalUser: &models.ExternalUserInfo{UserId: 1, Login: "alice"},
+		},
+	}
+
+	impact, err := login.PreviewDisableExternalUser(context.Background(), "alice")
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), impact.UserId)
+	assert.False(t, impact.AlreadyDisabled)
+	require.Len(t, impact.Orgs, 3)
+	assert.False(t, impact.Orgs[0].SoleAdmin, "org 1 has another admin")
+	assert.True(t, impact.Orgs[1].SoleAdmin, "user is the only admin of org 2")
+	assert.False(t, impact.Orgs[2].SoleAdmin, "user is a viewer in org 3")
+
+	assert.Zero(t, store.LatestUserId, "preview must not disable the user")
+}
@@ -0,0 +1,55 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// DisableUserWithSource disables a user and records why, so that LDAP login
+// doesn't re-enable users that were disabled for another reason.
+func (ls *Implementation) DisableUserWithSource(ctx context.Context, userID int64, source login.DisableSource) error {
+	if err := ls.SQLStore.DisableUser(ctx, &models.DisableUserCommand{UserId: userID, IsDisabled: true}); err != nil {
+		return err
+	}
+	return ls.setDisableSource(ctx, userID, source)
+}
+
+func (ls *Implementation) setDisableSource(ctx context.Context, userID int64, source login.DisableSource) error {
+	if ls.DisableSourceStore == nil {
+		return nil
+	}
+	return ls.DisableSourceStore.SetDisableSource(ctx, userID, source)
+}
+
+// reenableLDAPUser re-enables a disabled user found in LDAP. When a DisableSourceStore
+// is configured, only users disabled because they were missing from LDAP are re-enabled.
+func (ls *Implementation) reenableLDAPUser(ctx context.Context, user *models.User) error {
+	return ls.reenableUser(ctx, user, login.DisableSourceLDAPAbsence)
+}
+
+// reenableUser re-enables a disabled user. When a DisableSourceStore is configured,
+// the user is only re-enabled if it was disabled for the given reason.
+func (ls *Implementation) reenableUser(ctx context.Context, user *models.User, source login.DisableSource) error {
+	if ls.DisableSourceStore != nil {
+		recorded, err := ls.DisableSourceStore.GetDisableSource(ctx, user.Id)
+		if err != nil {
+			return err
+		}
+		if recorded != source {
+			logger.Debug("Not re-enabling user, it was disabled for another reason", "userId", user.Id, "source", recorded, "reenableSource", source)
+			return nil
+		}
+	}
+
+	if err := ls.SQLStore.DisableUser(ctx, &models.DisableUserCommand{UserId: user.Id, IsDisabled: false}); err != nil {
+		return err
+	}
+	user.IsDisabled = false
+
+	if ls.DisableSourceStore == nil {
+		return nil
+	}
+	return ls.DisableSourceStore.DeleteDisableSource(ctx, user.Id)
+}
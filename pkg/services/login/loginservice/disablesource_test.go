@@ -0,0 +1,123 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDisableSourceStore struct {
+	sources map[int64]login.DisableSource
+}
+
+func (f *fakeDisableSourceStore) GetDisableSource(ctx context.Context, userID int64) (login.DisableSource, error) {
+	return f.sources[userID], nil
+}
+
+func (f *fakeDisableSourceStore) SetDisableSource(ctx context.Context, userID int64, source login.DisableSource) error {
+	f.sources[userID] = source
+	return nil
+}
+
+func (f *fakeDisableSourceStore) DeleteDisableSource(ctx context.Context, userID int64) error {
+	delete(f.sources, userID)
+	return nil
+}
+
+func TestDisableSource_sqlStore(t *testing.T) {
+	ctx := context.Background()
+	loginService, sqlStore := newSQLLoginService(t)
+	disableSourceStore := logindatabase.ProvideDisableSourceStore(sqlStore)
+	loginService.DisableSourceStore = disableSourceStore
+	upsert := func() *models.User {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule: models.AuthModuleLDAP,
+			AuthId:     "cn=alice",
+			Login:      "alice",
+			Email:      "alice@example.org",
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		return cmd.Result
+	}
+	isDisabled := func(userID int64) bool {
+		query := &models.GetUserByIdQuery{Id: userID}
+		require.NoError(t, sqlStore.GetUserById(ctx, query))
+		return query.Result.IsDisabled
+	}
+	user := upsert()
+
+	require.NoError(t, loginService.DisableUserWithSource(ctx, user.Id, login.DisableSourceSecurity))
+	upsert()
+	assert.True(t, isDisabled(user.Id), "a user disabled for security reasons should stay disabled")
+
+	require.NoError(t, loginService.DisableUserWithSource(ctx, user.Id, login.DisableSourceLDAPAbsence))
+	upsert()
+	assert.False(t, isDisabled(user.Id), "a user disabled because it was missing from LDAP should be re-enabled")
+	source, err := disableSourceStore.GetDisableSource(ctx, user.Id)
+	require.NoError(t, err)
+	assert.Empty(t, source)
+
+	require.NoError(t, loginService.DisableUserWithSource(ctx, user.Id, login.DisableSourceSecurity))
+	require.NoError(t, sqlStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: user.Id}))
+	source, err = disableSourceStore.GetDisableSource(ctx, user.Id)
+	require.NoError(t, err)
+	assert.Empty(t, source)
+}
+
+func Test_UpsertUser_reenablesOnlyUsersDisabledByLDAP(t *testing.T) {
+	setup := func() (*Implementation, *fakeStore, *fakeDisableSourceStore, *models.User) {
+		user := &models.User{Id: 1, Login: "alice"}
+		store := newFakeStore(user)
+		sources := &fakeDisableSourceStore{sources: map[int64]login.DisableSource{}}
+		loginService := &Implementation{
+			SQLStore: store,
+			AuthInfoService: &logintest.AuthInfoServiceFake{
+				ExpectedUser:         user,
+				ExpectedExternalUser: &models.ExternalUserInfo{UserId: user.Id, Login: user.Login},
+			},
+			DisableSourceStore: sources,
+		}
+		return loginService, store, sources, user
+	}
+	upsert := func(loginService *Implementation) {
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: models.AuthModuleLDAP, Login: "alice"}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+	}
+
+	t.Run("user disabled because it was missing from LDAP is re-enabled", func(t *testing.T) {
+		loginService, store, sources, user := setup()
+		require.NoError(t, loginService.DisableExternalUser(context.Background(), user.Login))
+		require.True(t, store.users[user.Id].IsDisabled)
+		require.Equal(t, login.DisableSourceLDAPAbsence, sources.sources[user.Id])
+
+		upsert(loginService)
+
+		assert.False(t, store.users[user.Id].IsDisabled)
+		assert.NotContains(t, sources.sources, user.Id)
+	})
+
+	t.Run("user disabled for security reasons stays disabled", func(t *testing.T) {
+		loginService, store, sources, user := setup()
+		require.NoError(t, loginService.DisableUserWithSource(context.Background(), user.Id, login.DisableSourceSecurity))
+
+		upsert(loginService)
+
+		assert.True(t, store.users[user.Id].IsDisabled)
+		assert.Equal(t, login.DisableSourceSecurity, sources.sources[user.Id])
+	})
+
+	t.Run("user disabled without a recorded source stays disabled", func(t *testing.T) {
+		loginService, store, _, user := setup()
+		store.users[user.Id].IsDisabled = true
+
+		upsert(loginService)
+
+		assert.True(t, store.users[user.Id].IsDisabled)
+	})
+}
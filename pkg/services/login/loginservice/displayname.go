@@ -0,0 +1,80 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// DisplayNamePolicy controls what happens when the display name of an external
+// user is taken by another user.
+type DisplayNamePolicy int
+
+const (
+	// DisplayNameAllowDuplicates keeps the name (default).
+	DisplayNameAllowDuplicates DisplayNamePolicy = iota
+	// DisplayNameAppendEmail appends the email, e.g. "Alice (alice@example.org)".
+	DisplayNameAppendEmail
+	// DisplayNameAppendOrg appends the name of the default org among the org
+	// roles of the user, e.g. "Alice (Engineering)", or the email if it has none.
+	DisplayNameAppendOrg
+	// DisplayNameReject fails the login with login.ErrDisplayNameTaken.
+	DisplayNameReject
+)
+
+// uniqueDisplayName returns the display name to give the user with userID,
+// zero for a new user, according to UniqueDisplayName. A name that is still
+// taken once disambiguated is rejected.
+func (ls *Implementation) uniqueDisplayName(ctx context.Context, extUser *models.ExternalUserInfo, userID int64) (string, error) {
+	name := extUser.Name
+	if ls.UniqueDisplayName == DisplayNameAllowDuplicates || ls.DisplayNameStore == nil || name == "" {
+		return name, nil
+	}
+
+	taken, err := ls.DisplayNameStore.IsDisplayNameTaken(ctx, name, userID)
+	if err != nil || !taken {
+		return name, err
+	}
+
+	var suffix string
+	switch ls.UniqueDisplayName {
+	case DisplayNameAppendOrg:
+		if suffix, err = ls.defaultOrgName(ctx, extUser); err != nil {
+			return "", err
+		}
+		if suffix == "" {
+			suffix = extUser.Email
+		}
+	case DisplayNameAppendEmail:
+		suffix = extUser.Email
+	}
+	if suffix == "" {
+		return "", &login.ErrDisplayNameTaken{Name: name}
+	}
+
+	disambiguated := fmt.Sprintf("%s (%s)", name, suffix)
+	if taken, err := ls.DisplayNameStore.IsDisplayNameTaken(ctx, disambiguated, userID); err != nil || taken {
+		if err != nil {
+			return "", err
+		}
+		return "", &login.ErrDisplayNameTaken{Name: disambiguated}
+	}
+	logger.Debug("Disambiguated taken display name", "userId", userID, "displayName", disambiguated)
+	return disambiguated, nil
+}
+
+// defaultOrgName returns the name of the default org among the org roles of
+// extUser, empty if it has none.
+func (ls *Implementation) defaultOrgName(ctx context.Context, extUser *models.ExternalUserInfo) (string, error) {
+	orgID := ls.selectDefaultOrg(extUser.OrgRoles)
+	if orgID == 0 {
+		return "", nil
+	}
+	query := &models.GetOrgByIdQuery{Id: orgID}
+	if err := ls.readStore(ctx, false).GetOrgById(ctx, query); err != nil {
+		return "", err
+	}
+	return query.Result.Name, nil
+}
@@ -0,0 +1,102 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/grafana/grafana/pkg/services/quota"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_uniqueDisplayName(t *testing.T) {
+	ctx := context.Background()
+
+	// an existing user is already called Alice
+	setup := func(t *testing.T, policy DisplayNamePolicy) (*Implementation, *sqlstore.SQLStore, models.Org) {
+		sqlStore := sqlstore.InitTestDB(t)
+		secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+		authInfoStore := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)
+		other, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "asmith", Email: "asmith@example.org", Name: "Alice"})
+		require.NoError(t, err)
+		org, err := sqlStore.CreateOrgWithMember("Engineering", other.Id)
+		require.NoError(t, err)
+		return &Implementation{
+			SQLStore:          sqlStore,
+			QuotaService:      &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService:   authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, authInfoStore),
+			UniqueDisplayName: policy,
+			DisplayNameStore:  logindatabase.ProvideDisplayNameStore(sqlStore),
+		}, sqlStore, org
+	}
+	upsert := func(loginService *Implementation, extUser *models.ExternalUserInfo) (*models.User, error) {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: extUser}
+		err := loginService.UpsertUser(ctx, cmd)
+		return cmd.Result, err
+	}
+	alice := func(orgRoles map[int64]models.RoleType) *models.ExternalUserInfo {
+		return &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org", Name: "Alice", OrgRoles: orgRoles}
+	}
+
+	t.Run("duplicate names are allowed by default", func(t *testing.T) {
+		loginService, _, _ := setup(t, DisplayNameAllowDuplicates)
+
+		user, err := upsert(loginService, alice(nil))
+		require.NoError(t, err)
+		assert.Equal(t, "Alice", user.Name)
+	})
+
+	t.Run("the email is appended to a taken name", func(t *testing.T) {
+		loginService, _, _ := setup(t, DisplayNameAppendEmail)
+
+		user, err := upsert(loginService, alice(nil))
+		require.NoError(t, err)
+		assert.Equal(t, "Alice (alice@example.org)", user.Name)
+	})
+
+	t.Run("the org is appended to a taken name", func(t *testing.T) {
+		loginService, _, org := setup(t, DisplayNameAppendOrg)
+
+		user, err := upsert(loginService, alice(map[int64]models.RoleType{org.Id: models.ROLE_VIEWER}))
+		require.NoError(t, err)
+		assert.Equal(t, "Alice (Engineering)", user.Name)
+	})
+
+	t.Run("taken names are rejected", func(t *testing.T) {
+		loginService, _, _ := setup(t, DisplayNameReject)
+
+		_, err := upsert(loginService, alice(nil))
+		var takenErr *login.ErrDisplayNameTaken
+		require.True(t, errors.As(err, &takenErr))
+		assert.Equal(t, "Alice", takenErr.Name)
+	})
+
+	t.Run("updated names are disambiguated once", func(t *testing.T) {
+		loginService, sqlStore, _ := setup(t, DisplayNameAppendEmail)
+		user, err := upsert(loginService, &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org", Name: "Alice Liddell"})
+		require.NoError(t, err)
+		require.Equal(t, "Alice Liddell", user.Name)
+
+		user, err = upsert(loginService, alice(nil))
+		require.NoError(t, err)
+		assert.Equal(t, "Alice (alice@example.org)", user.Name)
+
+		// the next login keeps the disambiguated name
+		user, err = upsert(loginService, alice(nil))
+		require.NoError(t, err)
+		query := &models.GetUserByIdQuery{Id: user.Id}
+		require.NoError(t, sqlStore.GetUserById(ctx, query))
+		assert.Equal(t, "Alice (alice@example.org)", query.Result.Name)
+	})
+}
@@ -0,0 +1,53 @@
+package loginservice
+
+import (
+	"context"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// GetEffectiveRole returns the effective role of the user in the org, the
+// highest of its basic org role and the roles its custom roles are equivalent
+// to according to CustomRoleLevels. Without a CustomRoleService only the basic
+// role counts.
+func (ls *Implementation) GetEffectiveRole(ctx context.Context, userID, orgID int64) (models.EffectiveRole, error) {
+	effective := models.EffectiveRole{OrgId: orgID, CustomRoles: []string{}, ElevatedBy: []string{}}
+
+	store := ls.readStore(ctx, false)
+	orgQuery := &models.GetUserOrgListQuery{UserId: userID}
+	if err := store.GetUserOrgList(ctx, orgQuery); err != nil {
+		return effective, err
+	}
+	for _, org := range orgQuery.Result {
+		if org.OrgId == orgID {
+			effective.BasicRole = org.Role
+		}
+	}
+	effective.Role = effective.BasicRole
+
+	if ls.CustomRoleService == nil {
+		return effective, nil
+	}
+	customRoles, err := ls.CustomRoleService.GetUserCustomRoles(ctx, orgID, userID)
+	if err != nil {
+		return effective, err
+	}
+	effective.CustomRoles = append(effective.CustomRoles, customRoles...)
+	sort.Strings(effective.CustomRoles)
+
+	for _, uid := range effective.CustomRoles {
+		if role, ok := ls.CustomRoleLevels[uid]; ok && (effective.Role == "" || exceedsRole(role, effective.Role)) {
+			effective.Role = role
+		}
+	}
+	if effective.Role == effective.BasicRole {
+		return effective, nil
+	}
+	for _, uid := range effective.CustomRoles {
+		if ls.CustomRoleLevels[uid] == effective.Role {
+			effective.ElevatedBy = append(effective.ElevatedBy, uid)
+		}
+	}
+	return effective, nil
+}
@@ -0,0 +1,85 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetEffectiveRole(t *testing.T) {
+	setup := func(customRoles ...string) *Implementation {
+		store := newFakeStore(&models.User{Id: 1, Login: "alice"})
+		store.addOrg(1)
+		store.addOrgUser(1, 1, models.ROLE_VIEWER)
+		return &Implementation{
+			SQLStore:          store,
+			CustomRoleService: &fakeCustomRoleService{roles: map[int64]map[int64][]string{1: {1: customRoles}}},
+			CustomRoleLevels: map[string]models.RoleType{
+				"dashboards-writer": models.ROLE_EDITOR,
+				"org-manager":       models.ROLE_ADMIN,
+				"reports-reader":    models.ROLE_VIEWER,
+			},
+		}
+	}
+
+	t.Run("a custom role elevates a basic viewer", func(t *testing.T) {
+		loginService := setup("reports-reader", "dashboards-writer", "unmapped")
+
+		effective, err := loginService.GetEffectiveRole(context.Background(), 1, 1)
+		require.NoError(t, err)
+
+		assert.Equal(t, models.EffectiveRole{
+			OrgId:       1,
+			BasicRole:   models.ROLE_VIEWER,
+			CustomRoles: []string{"dashboards-writer", "reports-reader", "unmapped"},
+			Role:        models.ROLE_EDITOR,
+			ElevatedBy:  []string{"dashboards-writer"},
+		}, effective)
+	})
+
+	t.Run("the highest custom role wins", func(t *testing.T) {
+		loginService := setup("dashboards-writer", "org-manager")
+
+		effective, err := loginService.GetEffectiveRole(context.Background(), 1, 1)
+		require.NoError(t, err)
+
+		assert.Equal(t, models.ROLE_ADMIN, effective.Role)
+		assert.Equal(t, []string{"org-manager"}, effective.ElevatedBy)
+	})
+
+	t.Run("custom roles that don't elevate keep the basic role", func(t *testing.T) {
+		loginService := setup("reports-reader")
+
+		effective, err := loginService.GetEffectiveRole(context.Background(), 1, 1)
+		require.NoError(t, err)
+
+		assert.Equal(t, models.ROLE_VIEWER, effective.Role)
+		assert.Empty(t, effective.ElevatedBy)
+	})
+
+	t.Run("only the basic role counts without a custom role service", func(t *testing.T) {
+		loginService := setup("org-manager")
+		loginService.CustomRoleService = nil
+
+		effective, err := loginService.GetEffectiveRole(context.Background(), 1, 1)
+		require.NoError(t, err)
+
+		assert.Equal(t, models.ROLE_VIEWER, effective.Role)
+		assert.Empty(t, effective.CustomRoles)
+	})
+
+	t.Run("custom roles count for non-members", func(t *testing.T) {
+		loginService := setup()
+		loginService.CustomRoleService = &fakeCustomRoleService{roles: map[int64]map[int64][]string{2: {1: {"dashboards-writer"}}}}
+
+		effective, err := loginService.GetEffectiveRole(context.Background(), 1, 2)
+		require.NoError(t, err)
+
+		assert.Empty(t, effective.BasicRole)
+		assert.Equal(t, models.ROLE_EDITOR, effective.Role)
+		assert.Equal(t, []string{"dashboards-writer"}, effective.ElevatedBy)
+	})
+}
@@ -0,0 +1,56 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// lookupUserColumns are the user columns UpsertUser uses, see ProjectUserLookup.
+var lookupUserColumns = []string{"login", "email", "name", "is_admin", "is_disabled", "org_id", "last_sync_status", "last_sync_error"}
+
+// lookupUser finds the existing user of extUser. If no user matches the primary
+// email, each of the EmailAliases is tried before giving up. A user found by an
+// alias keeps being synced with the primary email, which is the canonical one.
+func (ls *Implementation) lookupUser(ctx context.Context, extUser *models.ExternalUserInfo, state *syncState) (*models.User, error) {
+	query := ls.identityQuery(extUser)
+	if ls.ProjectUserLookup {
+		query.UserColumns = lookupUserColumns
+	}
+	query.SkipDisabledMatch = ls.OnCollisionWithDisabled != CollisionWithDisabledAttach
+	// users found by their details aren't linked while provisioning is paused
+	if ls.MaintenanceMode {
+		query.AutoLink = models.AutoLinkNever
+	}
+	user, err := ls.AuthInfoService.LookupAndUpdate(ctx, query)
+	state.disabledMatch = query.SkippedDisabledUser
+
+	for _, alias := range extUser.EmailAliases {
+		if !errors.Is(err, models.ErrUserNotFound) || !ls.matchesByEmail() {
+			break
+		}
+		if alias == "" || alias == extUser.Email {
+			continue
+		}
+
+		aliasQuery := *query
+		aliasQuery.Email = alias
+		// aliases are verified emails
+		aliasQuery.EmailVerified = true
+		aliasQuery.SkippedDisabledUser = nil
+		user, err = ls.AuthInfoService.LookupAndUpdate(ctx, &aliasQuery)
+		if state.disabledMatch == nil {
+			state.disabledMatch = aliasQuery.SkippedDisabledUser
+		}
+		if err == nil {
+			logger.Debug("Found user by email alias", "id", user.Id, "alias", alias, "email", extUser.Email)
+			state.result.MatchedEmailAlias = alias
+		}
+	}
+
+	if errors.Is(err, models.ErrUserNotFound) && state.disabledMatch != nil && ls.OnCollisionWithDisabled == CollisionWithDisabledAttachAndEnable && !ls.MaintenanceMode {
+		return ls.attachAndEnable(ctx, state.disabledMatch, extUser)
+	}
+	return user, err
+}
@@ -0,0 +1,125 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// emailAuthInfoService finds users of a fakeStore by email and records the emails looked up.
+type emailAuthInfoService struct {
+	*logintest.AuthInfoServiceFake
+	store  *fakeStore
+	emails []string
+}
+
+func (s *emailAuthInfoService) LookupAndUpdate(ctx context.Context, query *models.GetUserByAuthInfoQuery) (*models.User, error) {
+	s.emails = append(s.emails, query.Email)
+	for _, u := range s.store.users {
+		if u.Email == query.Email {
+			return u, nil
+		}
+	}
+	return nil, models.ErrUserNotFound
+}
+
+func setupEmailAlias(users ...*models.User) (*Implementation, *fakeStore, *emailAuthInfoService) {
+	store := newFakeStore(users...)
+	authInfo := &emailAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}, store: store}
+	return &Implementation{
+		SQLStore:        store,
+		QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+		AuthInfoService: authInfo,
+	}, store, authInfo
+}
+
+func Test_UpsertUser_emailAliases(t *testing.T) {
+	t.Run("login with an alias resolves to the existing user", func(t *testing.T) {
+		loginService, store, authInfo := setupEmailAlias(&models.User{Id: 1, Login: "alice", Email: "alice@old.example.com"})
+
+		cmd := &models.UpsertUserCommand{
+			SignupAllowed: true,
+			ExternalUser: &models.ExternalUserInfo{
+				Login:        "alice",
+				Email:        "alice@example.com",
+				EmailAliases: []string{"alice@other.example.com", "alice@old.example.com"},
+			},
+		}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, int64(1), cmd.Result.Id)
+		assert.Len(t, store.users, 1, "no duplicate user should be created")
+		assert.Equal(t, "alice@example.com", store.users[1].Email, "primary email should be recorded")
+		assert.Equal(t, "alice@old.example.com", cmd.SyncResult.MatchedEmailAlias)
+		assert.Equal(t, []string{"alice@example.com", "alice@other.example.com", "alice@old.example.com"}, authInfo.emails)
+	})
+
+	t.Run("aliases aren't tried when the primary email matches", func(t *testing.T) {
+		loginService, _, authInfo := setupEmailAlias(&models.User{Id: 1, Login: "alice", Email: "alice@example.com"})
+
+		cmd := &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{
+				Login:        "alice",
+				Email:        "alice@example.com",
+				EmailAliases: []string{"alice@old.example.com"},
+			},
+		}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, int64(1), cmd.Result.Id)
+		assert.Empty(t, cmd.SyncResult.MatchedEmailAlias)
+		assert.Equal(t, []string{"alice@example.com"}, authInfo.emails)
+	})
+
+	t.Run("user is created when no alias matches", func(t *testing.T) {
+		loginService, store, _ := setupEmailAlias(&models.User{Id: 1, Login: "bob", Email: "bob@example.com"})
+
+		cmd := &models.UpsertUserCommand{
+			SignupAllowed: true,
+			ExternalUser: &models.ExternalUserInfo{
+				Login:        "alice",
+				Email:        "alice@example.com",
+				EmailAliases: []string{"alice@old.example.com"},
+			},
+		}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, int64(2), cmd.Result.Id)
+		assert.Len(t, store.users, 2)
+		assert.Empty(t, cmd.SyncResult.MatchedEmailAlias)
+	})
+}
+
+// queryRecorder records the lookup queries.
+type queryRecorder struct {
+	*logintest.AuthInfoServiceFake
+	queries []*models.GetUserByAuthInfoQuery
+}
+
+func (r *queryRecorder) LookupAndUpdate(ctx context.Context, query *models.GetUserByAuthInfoQuery) (*models.User, error) {
+	r.queries = append(r.queries, query)
+	return r.AuthInfoServiceFake.LookupAndUpdate(ctx, query)
+}
+
+func Test_UpsertUser_projectUserLookup(t *testing.T) {
+	for _, project := range []bool{false, true} {
+		user := &models.User{Id: 1, Login: "alice"}
+		authInfo := &queryRecorder{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{ExpectedUser: user}}
+		loginService := &Implementation{SQLStore: newFakeStore(user), AuthInfoService: authInfo, ProjectUserLookup: project}
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice"}}))
+
+		require.Len(t, authInfo.queries, 1)
+		if project {
+			assert.Equal(t, lookupUserColumns, authInfo.queries[0].UserColumns)
+		} else {
+			assert.Empty(t, authInfo.queries[0].UserColumns)
+		}
+	}
+}
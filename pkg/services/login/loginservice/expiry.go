@@ -0,0 +1,52 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// orgRoleExpiry returns the expiry of the membership in an org as a unix
+// timestamp, or nil if it doesn't expire.
+func orgRoleExpiry(extUser *models.ExternalUserInfo, orgID int64) *int64 {
+	expiry, ok := extUser.OrgRoleExpiry[orgID]
+	if !ok || expiry.IsZero() {
+		return nil
+	}
+	expires := expiry.Unix()
+	return &expires
+}
+
+func expiryEqual(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// ExpireStaleRoles removes the org memberships that have expired and returns how
+// many were removed. Memberships of the last admin of an org are kept.
+func (ls *Implementation) ExpireStaleRoles(ctx context.Context) (int, error) {
+	query := &models.GetExpiredOrgUsersQuery{Now: ls.now().Unix()}
+	if err := ls.SQLStore.GetExpiredOrgUsers(ctx, query); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, orgUser := range query.Result {
+		logger.Debug("Removing expired organization membership", "userId", orgUser.UserId, "orgId", orgUser.OrgId)
+
+		cmd := &models.RemoveOrgUserCommand{OrgId: orgUser.OrgId, UserId: orgUser.UserId}
+		if err := ls.SQLStore.RemoveOrgUser(ctx, cmd); err != nil {
+			if errors.Is(err, models.ErrLastOrgAdmin) {
+				logger.Error(err.Error(), "userId", cmd.UserId, "orgId", cmd.OrgId)
+				continue
+			}
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,53 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_OrgRoleExpiry(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewMock()
+	clk.Set(now)
+
+	user := &models.User{Id: 1, OrgId: 1}
+	store := newFakeStore(user, &models.User{Id: 2})
+	store.addOrgUser(1, 1, models.ROLE_VIEWER)
+	store.addOrgUser(2, 2, models.ROLE_ADMIN)
+	loginService := Implementation{SQLStore: store, Clock: clk}
+
+	expiry := now.Add(24 * time.Hour)
+	extUser := &models.ExternalUserInfo{
+		OrgRoles:      map[int64]models.RoleType{1: models.ROLE_VIEWER, 2: models.ROLE_EDITOR},
+		OrgRoleExpiry: map[int64]time.Time{1: expiry, 2: expiry},
+	}
+	require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState()))
+
+	require.NotNil(t, store.orgUserExpires(1, 1), "expiry should be set on an unchanged role")
+	assert.Equal(t, expiry.Unix(), *store.orgUserExpires(1, 1))
+	require.NotNil(t, store.orgUserExpires(2, 1), "expiry should be set on a new role")
+	assert.Equal(t, expiry.Unix(), *store.orgUserExpires(2, 1))
+
+	t.Run("sweeper keeps memberships that haven't expired", func(t *testing.T) {
+		removed, err := loginService.ExpireStaleRoles(context.Background())
+		require.NoError(t, err)
+		assert.Zero(t, removed)
+	})
+
+	t.Run("sweeper removes expired memberships", func(t *testing.T) {
+		clk.Set(expiry.Add(time.Minute))
+
+		removed, err := loginService.ExpireStaleRoles(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 2, removed)
+		assert.NotContains(t, store.orgUsers[1], int64(1))
+		assert.NotContains(t, store.orgUsers[2], int64(1))
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[2][2])
+	})
+}
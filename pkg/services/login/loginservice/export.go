@@ -0,0 +1,155 @@
+package loginservice
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// UserProvisioningState is everything provisioning knows about a user, see
+// ExportUserProvisioningState.
+type UserProvisioningState struct {
+	User     ExportedUser       `json:"user"`
+	AuthInfo []ExportedAuthInfo `json:"authInfo"`
+	Orgs     []ExportedOrgRole  `json:"orgs"`
+	Teams    []ExportedTeam     `json:"teams"`
+}
+
+type ExportedUser struct {
+	Id             int64     `json:"id"`
+	Login          string    `json:"login"`
+	Email          string    `json:"email"`
+	Name           string    `json:"name"`
+	IsGrafanaAdmin bool      `json:"isGrafanaAdmin"`
+	IsDisabled     bool      `json:"isDisabled"`
+	Created        time.Time `json:"created"`
+	Updated        time.Time `json:"updated"`
+}
+
+// ExportedAuthInfo is a linked external identity. Tokens are redacted unless
+// ExportIncludeTokens is set.
+type ExportedAuthInfo struct {
+	AuthModule        string    `json:"authModule"`
+	AuthId            string    `json:"authId"`
+	ProviderLabel     string    `json:"providerLabel,omitempty"`
+	Created           time.Time `json:"created"`
+	OAuthAccessToken  string    `json:"oauthAccessToken,omitempty"`
+	OAuthRefreshToken string    `json:"oauthRefreshToken,omitempty"`
+	OAuthIdToken      string    `json:"oauthIdToken,omitempty"`
+	OAuthTokenType    string    `json:"oauthTokenType,omitempty"`
+	OAuthExpiry       time.Time `json:"oauthExpiry"`
+}
+
+type ExportedOrgRole struct {
+	OrgId int64           `json:"orgId"`
+	Name  string          `json:"name"`
+	Role  models.RoleType `json:"role"`
+}
+
+type ExportedTeam struct {
+	OrgId  int64  `json:"orgId"`
+	TeamId int64  `json:"teamId"`
+	Name   string `json:"name"`
+}
+
+// ExportUserProvisioningState returns the profile, linked external identities,
+// org memberships and team memberships of a user as JSON, e.g. for data export
+// requests. Lists are sorted so that the document is stable.
+func (ls *Implementation) ExportUserProvisioningState(ctx context.Context, userID int64) ([]byte, error) {
+	userQuery := &models.GetUserByIdQuery{Id: userID}
+	if err := ls.SQLStore.GetUserById(ctx, userQuery); err != nil {
+		return nil, err
+	}
+	user := userQuery.Result
+
+	state := UserProvisioningState{
+		User: ExportedUser{
+			Id:             user.Id,
+			Login:          user.Login,
+			Email:          user.Email,
+			Name:           user.Name,
+			IsGrafanaAdmin: user.IsAdmin,
+			IsDisabled:     user.IsDisabled,
+			Created:        user.Created,
+			Updated:        user.Updated,
+		},
+		AuthInfo: []ExportedAuthInfo{},
+		Orgs:     []ExportedOrgRole{},
+		Teams:    []ExportedTeam{},
+	}
+
+	authModules, err := ls.userAuthModules(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, authModule := range authModules {
+		query := &models.GetAuthInfoQuery{UserId: userID, AuthModule: authModule}
+		if err := ls.AuthInfoService.GetAuthInfo(ctx, query); err != nil {
+			return nil, err
+		}
+		state.AuthInfo = append(state.AuthInfo, ls.exportAuthInfo(query.Result))
+	}
+
+	orgQuery := &models.GetUserOrgListQuery{UserId: userID}
+	if err := ls.SQLStore.GetUserOrgList(ctx, orgQuery); err != nil {
+		return nil, err
+	}
+	sort.Slice(orgQuery.Result, func(i, j int) bool { return orgQuery.Result[i].OrgId < orgQuery.Result[j].OrgId })
+	for _, org := range orgQuery.Result {
+		state.Orgs = append(state.Orgs, ExportedOrgRole{OrgId: org.OrgId, Name: org.Name, Role: org.Role})
+
+		teamQuery := &models.GetTeamsByUserQuery{OrgId: org.OrgId, UserId: userID}
+		if err := ls.SQLStore.GetTeamsByUser(ctx, teamQuery); err != nil {
+			return nil, err
+		}
+		sort.Slice(teamQuery.Result, func(i, j int) bool { return teamQuery.Result[i].Id < teamQuery.Result[j].Id })
+		for _, team := range teamQuery.Result {
+			state.Teams = append(state.Teams, ExportedTeam{OrgId: team.OrgId, TeamId: team.Id, Name: team.Name})
+		}
+	}
+
+	return json.MarshalIndent(state, "", "  ")
+}
+
+// userAuthModules returns the auth modules the user is linked to, sorted.
+func (ls *Implementation) userAuthModules(ctx context.Context, userID int64) ([]string, error) {
+	var authModules []string
+	err := ls.SQLStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+		return sess.Table("user_auth").Where("user_id = ?", userID).Distinct("auth_module").Asc("auth_module").Find(&authModules)
+	})
+	return authModules, err
+}
+
+func (ls *Implementation) exportAuthInfo(authInfo *models.UserAuth) ExportedAuthInfo {
+	exported := ExportedAuthInfo{
+		AuthModule:        authInfo.AuthModule,
+		AuthId:            authInfo.AuthId,
+		ProviderLabel:     authInfo.ProviderLabel,
+		Created:           authInfo.Created,
+		OAuthAccessToken:  authInfo.OAuthAccessToken,
+		OAuthRefreshToken: authInfo.OAuthRefreshToken,
+		OAuthIdToken:      authInfo.OAuthIdToken,
+		OAuthTokenType:    authInfo.OAuthTokenType,
+		OAuthExpiry:       authInfo.OAuthExpiry,
+	}
+	if !ls.ExportIncludeTokens {
+		exported.OAuthAccessToken = redactToken(exported.OAuthAccessToken)
+		exported.OAuthRefreshToken = redactToken(exported.OAuthRefreshToken)
+		exported.OAuthIdToken = redactToken(exported.OAuthIdToken)
+	}
+	return exported
+}
+
+// redactToken redacts a token, keeping empty tokens empty so that it's visible
+// whether a token is stored.
+func redactToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	return setting.RedactedPassword
+}
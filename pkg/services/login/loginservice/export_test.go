@@ -0,0 +1,93 @@
+package loginservice
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func Test_ExportUserProvisioningState(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+	authInfoService := authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService))
+	ctx := context.Background()
+
+	_, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "admin", OrgId: 1})
+	require.NoError(t, err)
+	user, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org", Name: "Alice", SkipOrgSetup: true})
+	require.NoError(t, err)
+	require.NoError(t, sqlStore.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: 1, UserId: user.Id, Role: models.ROLE_EDITOR}))
+	team, err := sqlStore.CreateTeam("backend", "", 1)
+	require.NoError(t, err)
+	require.NoError(t, sqlStore.AddTeamMember(user.Id, 1, team.Id, true, 0))
+	require.NoError(t, authInfoService.SetAuthInfo(ctx, &models.SetAuthInfoCommand{
+		UserId:     user.Id,
+		AuthModule: "oauth_generic_oauth",
+		AuthId:     "alice-id",
+		OAuthToken: &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer"},
+	}))
+	require.NoError(t, authInfoService.SetAuthInfo(ctx, &models.SetAuthInfoCommand{UserId: user.Id, AuthModule: models.AuthModuleLDAP, AuthId: "cn=alice"}))
+
+	export := func(includeTokens bool) UserProvisioningState {
+		loginService := &Implementation{SQLStore: sqlStore, AuthInfoService: authInfoService, ExportIncludeTokens: includeTokens}
+		data, err := loginService.ExportUserProvisioningState(ctx, user.Id)
+		require.NoError(t, err)
+
+		var fields map[string]json.RawMessage
+		require.NoError(t, json.Unmarshal(data, &fields))
+		assert.Len(t, fields, 4)
+		assert.Contains(t, fields, "user")
+		assert.Contains(t, fields, "authInfo")
+		assert.Contains(t, fields, "orgs")
+		assert.Contains(t, fields, "teams")
+
+		var state UserProvisioningState
+		require.NoError(t, json.Unmarshal(data, &state))
+		return state
+	}
+
+	t.Run("exports the user with redacted tokens", func(t *testing.T) {
+		state := export(false)
+
+		assert.Equal(t, user.Id, state.User.Id)
+		assert.Equal(t, "alice", state.User.Login)
+		assert.Equal(t, "alice@example.org", state.User.Email)
+		assert.Equal(t, []ExportedOrgRole{{OrgId: 1, Name: "admin", Role: models.ROLE_EDITOR}}, state.Orgs)
+		assert.Equal(t, []ExportedTeam{{OrgId: 1, TeamId: team.Id, Name: "backend"}}, state.Teams)
+
+		require.Len(t, state.AuthInfo, 2)
+		assert.Equal(t, models.AuthModuleLDAP, state.AuthInfo[0].AuthModule)
+		assert.Equal(t, "cn=alice", state.AuthInfo[0].AuthId)
+		assert.Empty(t, state.AuthInfo[0].OAuthAccessToken)
+		assert.Equal(t, "oauth_generic_oauth", state.AuthInfo[1].AuthModule)
+		assert.Equal(t, setting.RedactedPassword, state.AuthInfo[1].OAuthAccessToken)
+		assert.Equal(t, setting.RedactedPassword, state.AuthInfo[1].OAuthRefreshToken)
+		assert.Equal(t, "Bearer", state.AuthInfo[1].OAuthTokenType)
+	})
+
+	t.Run("includes tokens when enabled", func(t *testing.T) {
+		state := export(true)
+
+		require.Len(t, state.AuthInfo, 2)
+		assert.Equal(t, "access", state.AuthInfo[1].OAuthAccessToken)
+		assert.Equal(t, "refresh", state.AuthInfo[1].OAuthRefreshToken)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		loginService := &Implementation{SQLStore: sqlStore, AuthInfoService: authInfoService}
+		_, err := loginService.ExportUserProvisioningState(ctx, 999)
+		assert.ErrorIs(t, err, models.ErrUserNotFound)
+	})
+}
@@ -0,0 +1,374 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	"github.com/grafana/grafana/pkg/services/quota"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/sqlstore/mockstore"
+	"github.com/grafana/grafana/pkg/setting"
+)
+
+// newSQLLoginService returns a login service on a test database. The optional
+// login stores are left to the tests.
+func newSQLLoginService(t *testing.T) (*Implementation, *sqlstore.SQLStore) {
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+	authInfoStore := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)
+	return &Implementation{
+		SQLStore:        sqlStore,
+		QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+		AuthInfoService: authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, authInfoStore),
+	}, sqlStore
+}
+
+// fakeStore is an in-memory store for the user and org membership operations
+// used by the login service. Anything else falls through to SQLStoreMock.
+type fakeStore struct {
+	*mockstore.SQLStoreMock
+
+	users    map[int64]*models.User
+	nextID   int64
+	orgs     map[int64]string
+	orgUsers map[int64]map[int64]models.RoleType
+	// org id -> user id -> unix expiry
+	orgUserExpiry map[int64]map[int64]int64
+
+	createUserCmds []models.CreateUserCommand
+	updateUserCmds []*models.UpdateUserCommand
+	syncStatusCmds []*models.UpdateUserSyncStatusCommand
+	// calls records the org membership writes in the order they happened.
+	calls []string
+}
+
+func newFakeStore(users ...*models.User) *fakeStore {
+	s := &fakeStore{
+		SQLStoreMock: mockstore.NewSQLStoreMock(),
+		users:        map[int64]*models.User{},
+		orgs:         map[int64]string{},
+		orgUsers:     map[int64]map[int64]models.RoleType{},
+
+		orgUserExpiry: map[int64]map[int64]int64{},
+	}
+	for _, u := range users {
+		s.insertUser(u)
+	}
+	return s
+}
+
+func (s *fakeStore) insertUser(u *models.User) *models.User {
+	if u.Id == 0 {
+		s.nextID++
+		u.Id = s.nextID
+	} else if u.Id > s.nextID {
+		s.nextID = u.Id
+	}
+	s.users[u.Id] = u
+	return u
+}
+
+func (s *fakeStore) CreateUser(ctx context.Context, cmd models.CreateUserCommand) (*models.User, error) {
+	s.createUserCmds = append(s.createUserCmds, cmd)
+	if cmd.Email == "" {
+		cmd.Email = cmd.Login
+	}
+	for _, u := range s.users {
+		if u.Login == cmd.Login || u.Email == cmd.Email {
+			return nil, models.ErrUserAlreadyExists
+		}
+	}
+	return s.insertUser(&models.User{
+		Login:         cmd.Login,
+		Email:         cmd.Email,
+		Name:          cmd.Name,
+		Company:       cmd.Company,
+		EmailVerified: cmd.EmailVerified,
+		IsAdmin:       cmd.IsAdmin,
+		IsDisabled:    cmd.IsDisabled,
+		OrgId:         cmd.OrgId,
+	}), nil
+}
+
+func (s *fakeStore) GetUserByEmail(ctx context.Context, query *models.GetUserByEmailQuery) error {
+	for _, u := range s.users {
+		if u.Email == query.Email {
+			query.Result = u
+			return nil
+		}
+	}
+	return models.ErrUserNotFound
+}
+
+func (s *fakeStore) addOrg(orgID int64) {
+	if _, ok := s.orgs[orgID]; !ok {
+		s.orgs[orgID] = fmt.Sprintf("org-%d", orgID)
+	}
+}
+
+func (s *fakeStore) addOrgUser(orgID, userID int64, role models.RoleType) {
+	s.addOrg(orgID)
+	if s.orgUsers[orgID] == nil {
+		s.orgUsers[orgID] = map[int64]models.RoleType{}
+	}
+	s.orgUsers[orgID][userID] = role
+}
+
+func (s *fakeStore) setOrgUserExpiry(orgID, userID int64, expires *int64) {
+	if expires == nil {
+		delete(s.orgUserExpiry[orgID], userID)
+		return
+	}
+	if s.orgUserExpiry[orgID] == nil {
+		s.orgUserExpiry[orgID] = map[int64]int64{}
+	}
+	s.orgUserExpiry[orgID][userID] = *expires
+}
+
+func (s *fakeStore) orgUserExpires(orgID, userID int64) *int64 {
+	if expires, ok := s.orgUserExpiry[orgID][userID]; ok {
+		return &expires
+	}
+	return nil
+}
+
+func (s *fakeStore) GetUserOrgList(ctx context.Context, query *models.GetUserOrgListQuery) error {
+	query.Result = []*models.UserOrgDTO{}
+	for orgID, members := range s.orgUsers {
+		if role, ok := members[query.UserId]; ok {
+			query.Result = append(query.Result, &models.UserOrgDTO{OrgId: orgID, Name: s.orgs[orgID], Role: role, Expires: s.orgUserExpires(orgID, query.UserId)})
+		}
+	}
+	sort.Slice(query.Result, func(i, j int) bool { return query.Result[i].OrgId < query.Result[j].OrgId })
+	return nil
+}
+
+func (s *fakeStore) GetOrgByNameHandler(ctx context.Context, query *models.GetOrgByNameQuery) error {
+	for id, name := range s.orgs {
+		if name == query.Name {
+			query.Result = &models.Org{Id: id, Name: name}
+			return nil
+		}
+	}
+	return models.ErrOrgNotFound
+}
+
+func (s *fakeStore) GetOrgById(ctx context.Context, query *models.GetOrgByIdQuery) error {
+	name, ok := s.orgs[query.Id]
+	if !ok {
+		return models.ErrOrgNotFound
+	}
+	query.Result = &models.Org{Id: query.Id, Name: name}
+	return nil
+}
+
+func (s *fakeStore) SearchOrgs(ctx context.Context, query *models.SearchOrgsQuery) error {
+	s.calls = append(s.calls, "SearchOrgs")
+	query.Result = []*models.OrgDTO{}
+	for _, id := range query.Ids {
+		if name, ok := s.orgs[id]; ok {
+			query.Result = append(query.Result, &models.OrgDTO{Id: id, Name: name})
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) GetOrgUsers(ctx context.Context, query *models.GetOrgUsersQuery) error {
+	query.Result = []*models.OrgUserDTO{}
+	for userID, role := range s.orgUsers[query.OrgId] {
+		u := s.users[userID]
+		query.Result = append(query.Result, &models.OrgUserDTO{OrgId: query.OrgId, UserId: userID, Login: u.Login, Email: u.Email, Role: string(role)})
+	}
+	sort.Slice(query.Result, func(i, j int) bool { return query.Result[i].UserId < query.Result[j].UserId })
+	return nil
+}
+
+func (s *fakeStore) CountOrgAdmins(ctx context.Context, query *models.CountOrgAdminsQuery) error {
+	excluded := map[int64]bool{}
+	for _, userID := range query.ExcludeUserIds {
+		excluded[userID] = true
+	}
+	query.Result = 0
+	for userID, role := range s.orgUsers[query.OrgId] {
+		u := s.users[userID]
+		if role == models.ROLE_ADMIN && !u.IsDisabled && !u.IsServiceAccount && !excluded[userID] {
+			query.Result++
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) UpdateUser(ctx context.Context, cmd *models.UpdateUserCommand) error {
+	s.updateUserCmds = append(s.updateUserCmds, cmd)
+	u, ok := s.users[cmd.UserId]
+	if !ok {
+		return models.ErrUserNotFound
+	}
+	if cmd.Login != "" {
+		u.Login = cmd.Login
+	}
+	if cmd.Email != "" {
+		u.Email = cmd.Email
+	}
+	if cmd.Name != "" {
+		u.Name = cmd.Name
+	}
+	return nil
+}
+
+func (s *fakeStore) UpdateUserSyncStatus(ctx context.Context, cmd *models.UpdateUserSyncStatusCommand) error {
+	s.syncStatusCmds = append(s.syncStatusCmds, cmd)
+	u, ok := s.users[cmd.UserId]
+	if !ok {
+		return models.ErrUserNotFound
+	}
+	u.LastSyncStatus, u.LastSyncError = cmd.Status, cmd.Error
+	return nil
+}
+
+func (s *fakeStore) DeleteUser(ctx context.Context, cmd *models.DeleteUserCommand) error {
+	s.LatestUserId = cmd.UserId
+	if _, ok := s.users[cmd.UserId]; !ok {
+		return models.ErrUserNotFound
+	}
+	delete(s.users, cmd.UserId)
+	for _, members := range s.orgUsers {
+		delete(members, cmd.UserId)
+	}
+	return nil
+}
+
+func (s *fakeStore) DisableUser(ctx context.Context, cmd *models.DisableUserCommand) error {
+	s.LatestUserId = cmd.UserId
+	u, ok := s.users[cmd.UserId]
+	if !ok {
+		return models.ErrUserNotFound
+	}
+	u.IsDisabled = cmd.IsDisabled
+	return nil
+}
+
+func (s *fakeStore) GetUserById(ctx context.Context, query *models.GetUserByIdQuery) error {
+	u, ok := s.users[query.Id]
+	if !ok {
+		return models.ErrUserNotFound
+	}
+	query.Result = u
+	return nil
+}
+
+func (s *fakeStore) SearchUsers(ctx context.Context, query *models.SearchUsersQuery) error {
+	query.Result = models.SearchUserQueryResult{TotalCount: int64(len(s.users))}
+	return nil
+}
+
+func (s *fakeStore) UpdateUserPermissions(userID int64, isAdmin bool) error {
+	u, ok := s.users[userID]
+	if !ok {
+		return models.ErrUserNotFound
+	}
+	u.IsAdmin = isAdmin
+	return nil
+}
+
+func (s *fakeStore) AddOrgUser(ctx context.Context, cmd *models.AddOrgUserCommand) error {
+	s.calls = append(s.calls, fmt.Sprintf("AddOrgUser:%d", cmd.OrgId))
+	if _, ok := s.orgs[cmd.OrgId]; !ok {
+		return models.ErrOrgNotFound
+	}
+	if _, ok := s.orgUsers[cmd.OrgId][cmd.UserId]; ok {
+		return models.ErrOrgUserAlreadyAdded
+	}
+	s.addOrgUser(cmd.OrgId, cmd.UserId, cmd.Role)
+	s.setOrgUserExpiry(cmd.OrgId, cmd.UserId, cmd.Expires)
+	return nil
+}
+
+func (s *fakeStore) UpdateOrgUser(ctx context.Context, cmd *models.UpdateOrgUserCommand) error {
+	s.calls = append(s.calls, fmt.Sprintf("UpdateOrgUser:%d", cmd.OrgId))
+	if _, ok := s.orgUsers[cmd.OrgId][cmd.UserId]; !ok {
+		return models.ErrOrgUserNotFound
+	}
+	s.orgUsers[cmd.OrgId][cmd.UserId] = cmd.Role
+	if cmd.UpdateExpires {
+		s.setOrgUserExpiry(cmd.OrgId, cmd.UserId, cmd.Expires)
+	}
+	return nil
+}
+
+func (s *fakeStore) RemoveOrgUser(ctx context.Context, cmd *models.RemoveOrgUserCommand) error {
+	s.calls = append(s.calls, fmt.Sprintf("RemoveOrgUser:%d", cmd.OrgId))
+	role, ok := s.orgUsers[cmd.OrgId][cmd.UserId]
+	if !ok {
+		return models.ErrOrgUserNotFound
+	}
+	if role == models.ROLE_ADMIN {
+		admins := 0
+		for _, r := range s.orgUsers[cmd.OrgId] {
+			if r == models.ROLE_ADMIN {
+				admins++
+			}
+		}
+		if admins == 1 {
+			return models.ErrLastOrgAdmin
+		}
+	}
+	delete(s.orgUsers[cmd.OrgId], cmd.UserId)
+	s.setOrgUserExpiry(cmd.OrgId, cmd.UserId, nil)
+	return nil
+}
+
+func (s *fakeStore) GetExpiredOrgUsers(ctx context.Context, query *models.GetExpiredOrgUsersQuery) error {
+	query.Result = []*models.OrgUser{}
+	for orgID, members := range s.orgUserExpiry {
+		for userID, expires := range members {
+			if expires <= query.Now {
+				expires := expires
+				query.Result = append(query.Result, &models.OrgUser{OrgId: orgID, UserId: userID, Role: s.orgUsers[orgID][userID], Expires: &expires})
+			}
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) SetUsingOrg(ctx context.Context, cmd *models.SetUsingOrgCommand) error {
+	if s.ExpectedSetUsingOrgError != nil {
+		return s.ExpectedSetUsingOrgError
+	}
+	if u, ok := s.users[cmd.UserId]; ok {
+		u.OrgId = cmd.OrgId
+	}
+	return nil
+}
+
+// slowStore advances a mock clock by delay on every org membership write.
+type slowStore struct {
+	*fakeStore
+	delay time.Duration
+	clock *clock.Mock
+}
+
+func (s *slowStore) AddOrgUser(ctx context.Context, cmd *models.AddOrgUserCommand) error {
+	s.clock.Add(s.delay)
+	return s.fakeStore.AddOrgUser(ctx, cmd)
+}
+
+func (s *slowStore) UpdateOrgUser(ctx context.Context, cmd *models.UpdateOrgUserCommand) error {
+	s.clock.Add(s.delay)
+	return s.fakeStore.UpdateOrgUser(ctx, cmd)
+}
+
+func (s *slowStore) RemoveOrgUser(ctx context.Context, cmd *models.RemoveOrgUserCommand) error {
+	s.clock.Add(s.delay)
+	return s.fakeStore.RemoveOrgUser(ctx, cmd)
+}
@@ -0,0 +1,64 @@
+package loginservice
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// fingerprintCache keeps the fingerprint of the external user info each user
+// was last fully synced with, see SkipUnchangedSync.
+type fingerprintCache struct {
+	mu     sync.Mutex
+	byUser map[int64]string
+}
+
+func (c *fingerprintCache) matches(userID int64, fingerprint string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.byUser[userID] == fingerprint
+}
+
+func (c *fingerprintCache) set(userID int64, fingerprint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.byUser == nil {
+		c.byUser = map[int64]string{}
+	}
+	c.byUser[userID] = fingerprint
+}
+
+func (c *fingerprintCache) forget(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.byUser, userID)
+}
+
+func (c *fingerprintCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.byUser = nil
+}
+
+// ForceResyncAll makes the next UpsertUser of every user sync it even if it's
+// unchanged, by forgetting the fingerprints of SkipUnchangedSync. Unlike
+// ForceFullSync, orgs may still be deferred by SyncTopNOrgs or SoftDeadline.
+func (ls *Implementation) ForceResyncAll(ctx context.Context) {
+	ls.fingerprints.reset()
+	logger.Info("Forgot the fingerprints of synced users, the next login of every user runs the full sync")
+}
+
+// fingerprint returns the fingerprint of the external user of cmd, empty if
+// unchanged users aren't skipped. Calls with overrides are always synced, and
+// so are all calls with BeforeUpsert hooks since they may change the user.
+func (ls *Implementation) fingerprint(cmd *models.UpsertUserCommand) string {
+	if !ls.SkipUnchangedSync || cmd.Overrides != nil || len(ls.BeforeUpsert) > 0 {
+		return ""
+	}
+	return cmd.ExternalUser.Fingerprint()
+}
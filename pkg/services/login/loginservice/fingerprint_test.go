@@ -0,0 +1,111 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func Test_UpsertUser_skipUnchangedSync(t *testing.T) {
+	user := &models.User{Id: 1, Login: "alice", Name: "Alice", OrgId: 1}
+	store := newFakeStore(user)
+	store.addOrg(1)
+	authInfoService := &logintest.AuthInfoServiceFake{ExpectedUser: user}
+	loginService := &Implementation{
+		SQLStore:          store,
+		AuthInfoService:   authInfoService,
+		SkipUnchangedSync: true,
+	}
+	upsert := func(name string, overrides *models.UpsertUserOverrides) {
+		cmd := &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{
+				AuthModule: "oauth_okta",
+				AuthId:     "sub-alice",
+				Login:      "alice",
+				Name:       name,
+				OAuthToken: &oauth2.Token{AccessToken: "token"},
+				OrgRoles:   map[int64]models.RoleType{1: models.ROLE_EDITOR},
+			},
+			Overrides: overrides,
+		}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+	}
+
+	upsert("Alice", nil)
+	require.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+
+	t.Run("unchanged users aren't synced", func(t *testing.T) {
+		store.orgUsers[1][1] = models.ROLE_VIEWER
+		store.calls = nil
+		authInfoService.LatestUpdateAuthInfoCmd = nil
+
+		upsert("Alice", nil)
+		assert.Empty(t, store.calls)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+		require.NotNil(t, authInfoService.LatestUpdateAuthInfoCmd, "the token should still be stored")
+	})
+
+	t.Run("calls with overrides are synced", func(t *testing.T) {
+		upsert("Alice", &models.UpsertUserOverrides{})
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+	})
+
+	t.Run("changed users are synced", func(t *testing.T) {
+		store.orgUsers[1][1] = models.ROLE_VIEWER
+
+		upsert("Alice Liddell", nil)
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+		assert.Equal(t, "Alice Liddell", store.users[1].Name)
+	})
+}
+
+func Test_UpsertUser_forceFullSync(t *testing.T) {
+	user := &models.User{Id: 1, Login: "alice", Name: "Alice", OrgId: 1}
+	store := newFakeStore(user)
+	store.addOrg(1)
+	loginService := &Implementation{
+		SQLStore:          store,
+		AuthInfoService:   &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		SkipUnchangedSync: true,
+	}
+	upsert := func(force bool) {
+		cmd := &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{
+				Login:    "alice",
+				Name:     "Alice",
+				OrgRoles: map[int64]models.RoleType{1: models.ROLE_EDITOR},
+			},
+			ForceFullSync: force,
+		}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+	}
+
+	upsert(false)
+	require.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+
+	t.Run("forced calls sync unchanged users", func(t *testing.T) {
+		store.orgUsers[1][1] = models.ROLE_VIEWER
+
+		upsert(true)
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+	})
+
+	t.Run("unchanged users are synced once after ForceResyncAll", func(t *testing.T) {
+		store.orgUsers[1][1] = models.ROLE_VIEWER
+		upsert(false)
+		require.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1], "the user is unchanged")
+
+		loginService.ForceResyncAll(context.Background())
+		upsert(false)
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+
+		store.orgUsers[1][1] = models.ROLE_VIEWER
+		upsert(false)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+	})
+}
@@ -0,0 +1,33 @@
+package loginservice
+
+import (
+	"strings"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// checkGroupAccess returns login.ErrLoginDeniedByGroup if extUser is in one of
+// the DenyGroups, or if AllowGroups are set and extUser is in none of them.
+// Groups are compared case insensitively.
+func (ls *Implementation) checkGroupAccess(extUser *models.ExternalUserInfo) error {
+	if group := matchGroup(ls.DenyGroups, extUser.Groups); group != "" {
+		return &login.ErrLoginDeniedByGroup{Login: extUser.Login, Group: group}
+	}
+	if len(ls.AllowGroups) > 0 && matchGroup(ls.AllowGroups, extUser.Groups) == "" {
+		return &login.ErrLoginDeniedByGroup{Login: extUser.Login}
+	}
+	return nil
+}
+
+// matchGroup returns the first of groups the user is in, empty if none.
+func matchGroup(groups, userGroups []string) string {
+	for _, group := range groups {
+		for _, userGroup := range userGroups {
+			if strings.EqualFold(group, userGroup) {
+				return group
+			}
+		}
+	}
+	return ""
+}
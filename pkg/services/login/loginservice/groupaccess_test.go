@@ -0,0 +1,77 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupGroupAccess(allow, deny []string) (*Implementation, *fakeStore) {
+	user := &models.User{Id: 1, Login: "alice"}
+	store := newFakeStore(user)
+	store.addOrg(1)
+	return &Implementation{
+		SQLStore:        store,
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		AllowGroups:     allow,
+		DenyGroups:      deny,
+	}, store
+}
+
+func Test_UpsertUser_groupAccess(t *testing.T) {
+	upsert := func(loginService *Implementation, groups ...string) error {
+		return loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			Groups:   groups,
+			OrgRoles: map[int64]models.RoleType{1: models.ROLE_VIEWER},
+		}})
+	}
+
+	t.Run("users in an allowed group can log in", func(t *testing.T) {
+		loginService, store := setupGroupAccess([]string{"engineering", "support"}, nil)
+
+		require.NoError(t, upsert(loginService, "Support"))
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_VIEWER}, store.orgUsers[1])
+	})
+
+	t.Run("users in a denied group are rejected", func(t *testing.T) {
+		loginService, store := setupGroupAccess(nil, []string{"contractors"})
+
+		err := upsert(loginService, "engineering", "contractors")
+		var deniedErr *login.ErrLoginDeniedByGroup
+		require.True(t, errors.As(err, &deniedErr))
+		assert.Equal(t, "contractors", deniedErr.Group)
+		assert.Empty(t, store.calls)
+	})
+
+	t.Run("deny takes precedence over allow", func(t *testing.T) {
+		loginService, _ := setupGroupAccess([]string{"engineering"}, []string{"contractors"})
+
+		err := upsert(loginService, "engineering", "contractors")
+		var deniedErr *login.ErrLoginDeniedByGroup
+		require.True(t, errors.As(err, &deniedErr))
+		assert.Equal(t, "contractors", deniedErr.Group)
+	})
+
+	t.Run("users in neither list are rejected when groups are allowed", func(t *testing.T) {
+		loginService, store := setupGroupAccess([]string{"engineering"}, []string{"contractors"})
+
+		err := upsert(loginService, "marketing")
+		var deniedErr *login.ErrLoginDeniedByGroup
+		require.True(t, errors.As(err, &deniedErr))
+		assert.Empty(t, deniedErr.Group)
+		assert.Empty(t, store.calls)
+	})
+
+	t.Run("users in neither list can log in when no groups are allowed", func(t *testing.T) {
+		loginService, _ := setupGroupAccess(nil, []string{"contractors"})
+
+		require.NoError(t, upsert(loginService, "marketing"))
+	})
+}
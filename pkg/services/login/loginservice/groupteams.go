@@ -0,0 +1,107 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// GroupTeamMapping maps the members of an external group to a team.
+type GroupTeamMapping struct {
+	Group  string
+	OrgId  int64
+	TeamId int64
+}
+
+// GroupTeamMapper syncs team memberships from the groups of external users,
+// as a built-in alternative to a TeamSyncFunc.
+type GroupTeamMapper struct {
+	Teams    login.TeamMembershipService
+	Mappings []GroupTeamMapping
+	// Additive only adds memberships. Otherwise external memberships of mapped
+	// teams that none of the user's groups map to are removed, like org sync
+	// removes org roles. Memberships that weren't added by sync are kept.
+	Additive bool
+}
+
+type teamKey struct {
+	orgID  int64
+	teamID int64
+}
+
+// syncTeams adds the user to the teams its groups map to and, unless Additive,
+// removes it from the other mapped teams.
+func (m *GroupTeamMapper) syncTeams(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	groups := make(map[string]bool, len(extUser.Groups))
+	for _, group := range extUser.Groups {
+		groups[group] = true
+	}
+
+	mapped := map[teamKey]bool{}
+	desired := map[teamKey]bool{}
+	mappedOrgs := map[int64]bool{}
+	orgIDs := []int64{}
+	for _, mapping := range m.Mappings {
+		if !mappedOrgs[mapping.OrgId] {
+			mappedOrgs[mapping.OrgId] = true
+			orgIDs = append(orgIDs, mapping.OrgId)
+		}
+
+		key := teamKey{orgID: mapping.OrgId, teamID: mapping.TeamId}
+		mapped[key] = true
+		if groups[mapping.Group] {
+			desired[key] = true
+		}
+	}
+	sort.Slice(orgIDs, func(i, j int) bool { return orgIDs[i] < orgIDs[j] })
+
+	logger.Debug("Syncing team memberships from groups", "id", user.Id, "groups", extUser.Groups)
+
+	current := map[teamKey]bool{}
+	if !m.Additive {
+		for _, orgID := range orgIDs {
+			memberships, err := m.Teams.GetUserTeamMemberships(ctx, orgID, user.Id, true)
+			if err != nil {
+				return err
+			}
+			for _, membership := range memberships {
+				key := teamKey{orgID: membership.OrgId, teamID: membership.TeamId}
+				current[key] = true
+				if !mapped[key] || desired[key] {
+					continue
+				}
+				logger.Debug("Removing user from team", "id", user.Id, "orgId", key.orgID, "teamId", key.teamID)
+				cmd := &models.RemoveTeamMemberCommand{OrgId: key.orgID, UserId: user.Id, TeamId: key.teamID}
+				if err := m.Teams.RemoveTeamMember(ctx, cmd); err != nil && !errors.Is(err, models.ErrTeamMemberNotFound) {
+					return err
+				}
+			}
+		}
+	}
+
+	added := make([]teamKey, 0, len(desired))
+	for key := range desired {
+		if !current[key] {
+			added = append(added, key)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		if added[i].orgID != added[j].orgID {
+			return added[i].orgID < added[j].orgID
+		}
+		return added[i].teamID < added[j].teamID
+	})
+	for _, key := range added {
+		logger.Debug("Adding user to team", "id", user.Id, "orgId", key.orgID, "teamId", key.teamID)
+		err := m.Teams.AddTeamMember(user.Id, key.orgID, key.teamID, true, 0)
+		// users that are already members, e.g. added by hand, are fine
+		if err != nil && !errors.Is(err, models.ErrTeamMemberAlreadyAdded) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTeamMembershipService keeps team memberships in memory, keyed by org
+// and team. The value is whether the membership is external.
+type fakeTeamMembershipService struct {
+	members map[teamKey]map[int64]bool
+}
+
+func (s *fakeTeamMembershipService) GetUserTeamMemberships(ctx context.Context, orgID, userID int64, external bool) ([]*models.TeamMemberDTO, error) {
+	result := []*models.TeamMemberDTO{}
+	for key, members := range s.members {
+		if isExternal, ok := members[userID]; ok && key.orgID == orgID && (!external || isExternal) {
+			result = append(result, &models.TeamMemberDTO{OrgId: orgID, TeamId: key.teamID, UserId: userID, External: isExternal})
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeTeamMembershipService) AddTeamMember(userID, orgID, teamID int64, isExternal bool, permission models.PermissionType) error {
+	key := teamKey{orgID: orgID, teamID: teamID}
+	if _, ok := s.members[key][userID]; ok {
+		return models.ErrTeamMemberAlreadyAdded
+	}
+	if s.members[key] == nil {
+		s.members[key] = map[int64]bool{}
+	}
+	s.members[key][userID] = isExternal
+	return nil
+}
+
+func (s *fakeTeamMembershipService) RemoveTeamMember(ctx context.Context, cmd *models.RemoveTeamMemberCommand) error {
+	key := teamKey{orgID: cmd.OrgId, teamID: cmd.TeamId}
+	if _, ok := s.members[key][cmd.UserId]; !ok {
+		return models.ErrTeamMemberNotFound
+	}
+	delete(s.members[key], cmd.UserId)
+	return nil
+}
+
+func (s *fakeTeamMembershipService) isMember(orgID, teamID, userID int64) bool {
+	_, ok := s.members[teamKey{orgID: orgID, teamID: teamID}][userID]
+	return ok
+}
+
+func Test_UpsertUser_groupTeamMapper(t *testing.T) {
+	mappings := []GroupTeamMapping{
+		{Group: "devs", OrgId: 1, TeamId: 10},
+		{Group: "ops", OrgId: 1, TeamId: 11},
+		{Group: "ops", OrgId: 2, TeamId: 20},
+	}
+
+	setup := func(additive bool) (*Implementation, *fakeTeamMembershipService, *bool) {
+		user := &models.User{Id: 1, Login: "alice"}
+		teams := &fakeTeamMembershipService{members: map[teamKey]map[int64]bool{}}
+		teamSyncCalled := false
+		return &Implementation{
+			SQLStore:        newFakeStore(user),
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			GroupTeamMapper: &GroupTeamMapper{Teams: teams, Mappings: mappings, Additive: additive},
+			TeamSync: func(user *models.User, externalUser *models.ExternalUserInfo) error {
+				teamSyncCalled = true
+				return nil
+			},
+		}, teams, &teamSyncCalled
+	}
+	login := func(loginService *Implementation, groups ...string) {
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", Groups: groups}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+	}
+
+	t.Run("groups are mapped to teams", func(t *testing.T) {
+		loginService, teams, teamSyncCalled := setup(false)
+
+		login(loginService, "ops", "unmapped")
+
+		assert.False(t, teams.isMember(1, 10, 1))
+		assert.True(t, teams.isMember(1, 11, 1))
+		assert.True(t, teams.isMember(2, 20, 1))
+		assert.True(t, *teamSyncCalled)
+	})
+
+	t.Run("memberships of groups the user left are removed", func(t *testing.T) {
+		loginService, teams, _ := setup(false)
+
+		login(loginService, "devs", "ops")
+		login(loginService, "devs")
+
+		assert.True(t, teams.isMember(1, 10, 1))
+		assert.False(t, teams.isMember(1, 11, 1))
+		assert.False(t, teams.isMember(2, 20, 1))
+	})
+
+	t.Run("memberships that weren't added by sync are kept", func(t *testing.T) {
+		loginService, teams, _ := setup(false)
+		require.NoError(t, teams.AddTeamMember(1, 1, 11, false, 0))
+		require.NoError(t, teams.AddTeamMember(1, 1, 99, true, 0))
+
+		login(loginService, "devs")
+
+		assert.True(t, teams.isMember(1, 10, 1))
+		assert.True(t, teams.isMember(1, 11, 1))
+		assert.True(t, teams.isMember(1, 99, 1))
+	})
+
+	t.Run("additive mapping doesn't remove memberships", func(t *testing.T) {
+		loginService, teams, _ := setup(true)
+
+		login(loginService, "devs", "ops")
+		login(loginService, "devs")
+
+		assert.True(t, teams.isMember(1, 10, 1))
+		assert.True(t, teams.isMember(1, 11, 1))
+		assert.True(t, teams.isMember(2, 20, 1))
+	})
+}
@@ -0,0 +1,27 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// BeforeUpsertHook runs in UpsertUser after the user is looked up, and before
+// the user quota is checked and the user is created or updated. user is nil if
+// the user doesn't exist yet. The hook may change extUser, e.g. to add
+// attributes fetched from elsewhere, and the changes are synced like the ones
+// of the identity provider. Org roles must be added by id, names are resolved
+// before the lookup. An error fails UpsertUser, as is.
+//
+// The order of UpsertUser is: lookup, BeforeUpsert hooks, quota check, create
+// or update, org and team sync.
+type BeforeUpsertHook func(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error
+
+func (ls *Implementation) runBeforeUpsert(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	for _, hook := range ls.BeforeUpsert {
+		if err := hook(ctx, user, extUser); err != nil {
+			return err
+		}
+	}
+	return nil
+}
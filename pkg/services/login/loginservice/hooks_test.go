@@ -0,0 +1,140 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orderRecorder records the steps of UpsertUser in the order they happen.
+type orderRecorder struct {
+	steps []string
+}
+
+type recordingAuthInfoService struct {
+	*logintest.AuthInfoServiceFake
+	recorder *orderRecorder
+}
+
+func (s *recordingAuthInfoService) LookupAndUpdate(ctx context.Context, query *models.GetUserByAuthInfoQuery) (*models.User, error) {
+	s.recorder.steps = append(s.recorder.steps, "lookup")
+	return s.AuthInfoServiceFake.LookupAndUpdate(ctx, query)
+}
+
+type recordingQuotaService struct {
+	recorder *orderRecorder
+}
+
+func (s *recordingQuotaService) QuotaReached(c *models.ReqContext, target string) (bool, error) {
+	s.recorder.steps = append(s.recorder.steps, "quota")
+	return false, nil
+}
+
+func (s *recordingQuotaService) CheckQuotaReached(ctx context.Context, target string, scopeParams *quota.ScopeParameters) (bool, error) {
+	s.recorder.steps = append(s.recorder.steps, "quota")
+	return false, nil
+}
+
+type recordingStore struct {
+	*fakeStore
+	recorder *orderRecorder
+}
+
+func (s *recordingStore) CreateUser(ctx context.Context, cmd models.CreateUserCommand) (*models.User, error) {
+	s.recorder.steps = append(s.recorder.steps, "create")
+	return s.fakeStore.CreateUser(ctx, cmd)
+}
+
+func setupBeforeUpsert(users ...*models.User) (*Implementation, *fakeStore, *orderRecorder) {
+	recorder := &orderRecorder{}
+	store := newFakeStore(users...)
+	store.addOrg(1)
+	store.addOrg(2)
+
+	authInfo := &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound}
+	if len(users) > 0 {
+		authInfo = &logintest.AuthInfoServiceFake{ExpectedUser: users[0]}
+	}
+	return &Implementation{
+		SQLStore:        &recordingStore{fakeStore: store, recorder: recorder},
+		AuthInfoService: &recordingAuthInfoService{AuthInfoServiceFake: authInfo, recorder: recorder},
+		QuotaService:    &recordingQuotaService{recorder: recorder},
+	}, store, recorder
+}
+
+func Test_UpsertUser_beforeUpsert(t *testing.T) {
+	t.Run("hooks run after the lookup and before the quota check and create", func(t *testing.T) {
+		loginService, _, recorder := setupBeforeUpsert()
+		loginService.BeforeUpsert = []BeforeUpsertHook{
+			func(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+				assert.Nil(t, user)
+				recorder.steps = append(recorder.steps, "hook")
+				return nil
+			},
+		}
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org"}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, []string{"lookup", "hook", "quota", "create"}, recorder.steps)
+	})
+
+	t.Run("org roles added by a hook are synced for a new user", func(t *testing.T) {
+		loginService, store, _ := setupBeforeUpsert()
+		loginService.BeforeUpsert = []BeforeUpsertHook{
+			func(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+				extUser.Name = "Alice"
+				extUser.OrgRoles = map[int64]models.RoleType{2: models.ROLE_EDITOR}
+				return nil
+			},
+		}
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org"}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		require.Len(t, store.createUserCmds, 1)
+		assert.Equal(t, "Alice", store.createUserCmds[0].Name)
+		assert.True(t, store.createUserCmds[0].SkipOrgSetup)
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][cmd.Result.Id])
+	})
+
+	t.Run("hooks get the existing user", func(t *testing.T) {
+		existing := &models.User{Id: 1, Login: "alice", Email: "alice@example.org"}
+		loginService, store, _ := setupBeforeUpsert(existing)
+		loginService.BeforeUpsert = []BeforeUpsertHook{
+			func(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+				require.NotNil(t, user)
+				assert.Equal(t, int64(1), user.Id)
+				extUser.OrgRoles = map[int64]models.RoleType{1: models.ROLE_ADMIN}
+				return nil
+			},
+		}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org"}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][1])
+	})
+
+	t.Run("a hook error fails the upsert before anything is written", func(t *testing.T) {
+		loginService, store, recorder := setupBeforeUpsert()
+		hookErr := errors.New("attributes unavailable")
+		loginService.BeforeUpsert = []BeforeUpsertHook{
+			func(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+				return hookErr
+			},
+		}
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org"}}
+		require.ErrorIs(t, loginService.UpsertUser(context.Background(), cmd), hookErr)
+
+		assert.Equal(t, []string{"lookup"}, recorder.steps)
+		assert.Empty(t, store.createUserCmds)
+	})
+}
@@ -0,0 +1,81 @@
+package loginservice
+
+import (
+	"errors"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// IdentityKey is the field of external users that identifies them, it decides
+// which fields are used to find an existing user.
+type IdentityKey int
+
+const (
+	// IdentityKeyDefault finds users by auth id, then by email and then by
+	// login (default).
+	IdentityKeyDefault IdentityKey = iota
+	// IdentityKeyEmail finds users by auth id or email, never by login.
+	IdentityKeyEmail
+	// IdentityKeyLogin finds users by auth id or login, never by email.
+	IdentityKeyLogin
+	// IdentityKeyAuthSubject finds users only by the auth id (the subject) of
+	// their identity provider. Users with the same email or login aren't
+	// matched, creating a user with a taken email or login fails.
+	IdentityKeyAuthSubject
+)
+
+// checkIdentityKey returns an error if extUser lacks its IdentityKey field.
+func (ls *Implementation) checkIdentityKey(extUser *models.ExternalUserInfo) error {
+	switch ls.IdentityKey {
+	case IdentityKeyEmail:
+		if extUser.Email == "" {
+			return login.ErrMissingEmail
+		}
+	case IdentityKeyLogin:
+		if extUser.Login == "" {
+			return login.ErrMissingLogin
+		}
+	case IdentityKeyAuthSubject:
+		if extUser.AuthId == "" {
+			return login.ErrMissingAuthId
+		}
+	}
+	return nil
+}
+
+// identityQuery builds the user lookup of extUser according to the IdentityKey.
+func (ls *Implementation) identityQuery(extUser *models.ExternalUserInfo) *models.GetUserByAuthInfoQuery {
+	query := &models.GetUserByAuthInfoQuery{
+		AuthModule:    extUser.AuthModule,
+		AuthId:        extUser.AuthId,
+		UserId:        extUser.UserId,
+		AutoLink:      ls.AutoLink,
+		EmailVerified: extUser.EmailVerified,
+		MultiMatch:    ls.MultiMatch,
+	}
+	switch ls.IdentityKey {
+	case IdentityKeyEmail:
+		query.Email = extUser.Email
+	case IdentityKeyLogin:
+		query.Login = extUser.Login
+	case IdentityKeyAuthSubject:
+	default:
+		query.Email = extUser.Email
+		query.Login = extUser.Login
+	}
+	return query
+}
+
+// matchesByEmail reports whether users may be found by email, and thus by
+// email aliases.
+func (ls *Implementation) matchesByEmail() bool {
+	return ls.IdentityKey == IdentityKeyDefault || ls.IdentityKey == IdentityKeyEmail
+}
+
+// isAmbiguousUser reports whether the lookup failed because the external user
+// matched several users, which is a rejection rather than a lookup failure.
+func isAmbiguousUser(err error) bool {
+	var ambiguousErr *login.ErrAmbiguousUser
+	return errors.As(err, &ambiguousErr)
+}
@@ -0,0 +1,168 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	"github.com/grafana/grafana/pkg/services/quota"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_identityKey(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T, key IdentityKey) *Implementation {
+		sqlStore := sqlstore.InitTestDB(t)
+		secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+		return &Implementation{
+			SQLStore:        sqlStore,
+			QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService: authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)),
+			IdentityKey:     key,
+		}
+	}
+	upsert := func(loginService *Implementation, extUser *models.ExternalUserInfo) (*models.User, error) {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: extUser}
+		err := loginService.UpsertUser(ctx, cmd)
+		return cmd.Result, err
+	}
+	alice := func() *models.ExternalUserInfo {
+		return &models.ExternalUserInfo{AuthModule: "oauth_okta", AuthId: "sub-alice", Login: "alice", Email: "alice@example.org"}
+	}
+
+	t.Run("default key finds users by email and login", func(t *testing.T) {
+		loginService := setup(t, IdentityKeyDefault)
+		user, err := upsert(loginService, alice())
+		require.NoError(t, err)
+
+		byLogin, err := upsert(loginService, &models.ExternalUserInfo{AuthModule: "ldap", Login: "alice", Email: "a.smith@example.org"})
+		require.NoError(t, err)
+		assert.Equal(t, user.Id, byLogin.Id)
+
+		// the email was synced by the previous login
+		byEmail, err := upsert(loginService, &models.ExternalUserInfo{AuthModule: "oauth_azuread", AuthId: "a1", Login: "asmith", Email: "a.smith@example.org"})
+		require.NoError(t, err)
+		assert.Equal(t, user.Id, byEmail.Id)
+	})
+
+	t.Run("email key finds users by email only", func(t *testing.T) {
+		loginService := setup(t, IdentityKeyEmail)
+		user, err := upsert(loginService, alice())
+		require.NoError(t, err)
+
+		byEmail, err := upsert(loginService, &models.ExternalUserInfo{AuthModule: "oauth_azuread", AuthId: "a1", Login: "asmith", Email: "alice@example.org"})
+		require.NoError(t, err)
+		assert.Equal(t, user.Id, byEmail.Id)
+
+		// the login was synced to alice by the previous login, she isn't matched by it
+		_, err = upsert(loginService, &models.ExternalUserInfo{AuthModule: "ldap", Login: "asmith", Email: "a.smith@example.org"})
+		require.ErrorIs(t, err, models.ErrUserAlreadyExists)
+
+		_, err = upsert(loginService, &models.ExternalUserInfo{AuthModule: "ldap", Login: "alice"})
+		require.ErrorIs(t, err, login.ErrMissingEmail)
+	})
+
+	t.Run("login key finds users by login only", func(t *testing.T) {
+		loginService := setup(t, IdentityKeyLogin)
+		user, err := upsert(loginService, alice())
+		require.NoError(t, err)
+
+		byLogin, err := upsert(loginService, &models.ExternalUserInfo{AuthModule: "ldap", Login: "alice", Email: "a.smith@example.org"})
+		require.NoError(t, err)
+		assert.Equal(t, user.Id, byLogin.Id)
+
+		_, err = upsert(loginService, &models.ExternalUserInfo{AuthModule: "oauth_azuread", AuthId: "a1", Login: "asmith", Email: "a.smith@example.org"})
+		require.ErrorIs(t, err, models.ErrUserAlreadyExists)
+
+		_, err = upsert(loginService, &models.ExternalUserInfo{AuthModule: "ldap", Email: "alice@example.org"})
+		require.ErrorIs(t, err, login.ErrMissingLogin)
+	})
+
+	t.Run("auth subject key finds users by auth id only", func(t *testing.T) {
+		loginService := setup(t, IdentityKeyAuthSubject)
+		user, err := upsert(loginService, alice())
+		require.NoError(t, err)
+
+		renamed, err := upsert(loginService, &models.ExternalUserInfo{AuthModule: "oauth_okta", AuthId: "sub-alice", Login: "asmith", Email: "a.smith@example.org"})
+		require.NoError(t, err)
+		assert.Equal(t, user.Id, renamed.Id)
+		assert.Equal(t, "asmith", renamed.Login)
+
+		_, err = upsert(loginService, &models.ExternalUserInfo{AuthModule: "oauth_okta", AuthId: "sub-other", Login: "asmith", Email: "a.smith@example.org"})
+		require.ErrorIs(t, err, models.ErrUserAlreadyExists)
+
+		_, err = upsert(loginService, &models.ExternalUserInfo{AuthModule: "oauth_okta", Login: "asmith"})
+		require.ErrorIs(t, err, login.ErrMissingAuthId)
+	})
+}
+
+func Test_UpsertUser_autoLink(t *testing.T) {
+	ctx := context.Background()
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+	authInfoService := authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService))
+	loginService := &Implementation{
+		SQLStore:        sqlStore,
+		QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+		AuthInfoService: authInfoService,
+		AutoLink:        models.AutoLinkIfEmailVerified,
+	}
+
+	local, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org"})
+	require.NoError(t, err)
+	upsert := func(emailVerified bool) {
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			AuthModule:    "oauth_okta",
+			AuthId:        "sub-alice",
+			Login:         "alice",
+			Email:         "alice@example.org",
+			EmailVerified: emailVerified,
+			OrgRoles:      map[int64]models.RoleType{local.OrgId: models.ROLE_ADMIN},
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		require.Equal(t, local.Id, cmd.Result.Id)
+	}
+	linked := func() bool {
+		err := authInfoService.GetAuthInfo(ctx, &models.GetAuthInfoQuery{UserId: local.Id, AuthModule: "oauth_okta"})
+		return err == nil
+	}
+
+	upsert(false)
+	assert.False(t, linked())
+
+	upsert(true)
+	assert.True(t, linked())
+}
+
+func Test_UpsertUser_multiMatch(t *testing.T) {
+	ctx := context.Background()
+	sqlStore := sqlstore.InitTestDB(t)
+	secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+	loginService := &Implementation{
+		SQLStore:        sqlStore,
+		AuthInfoService: authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)),
+		MultiMatch:      models.MultiMatchReject,
+	}
+	_, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "bob", Email: "alice@example.org"})
+	require.NoError(t, err)
+	_, err = sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "carol@example.org"})
+	require.NoError(t, err)
+
+	cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "ldap", Login: "alice", Email: "alice@example.org"}}
+	err = loginService.UpsertUser(ctx, cmd)
+
+	var ambiguousErr *login.ErrAmbiguousUser
+	require.True(t, errors.As(err, &ambiguousErr))
+	assert.False(t, errors.Is(err, &login.ErrUpsertUser{}), "ambiguous users are rejected as is")
+}
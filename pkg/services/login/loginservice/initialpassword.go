@@ -0,0 +1,25 @@
+package loginservice
+
+import (
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// setInitialPassword sets the InitialPassword of the external user as the
+// password of the user to create, if AllowInitialPassword. CreateUser stores
+// it hashed. Weak passwords are refused with login.ErrWeakPassword.
+func (ls *Implementation) setInitialPassword(extUser *models.ExternalUserInfo, cmd *models.CreateUserCommand) error {
+	if extUser.InitialPassword == "" {
+		return nil
+	}
+	if !ls.AllowInitialPassword {
+		logger.Warn("Ignoring the initial password of the external user since initial passwords aren't allowed", "login", extUser.Login)
+		return nil
+	}
+	if models.Password(extUser.InitialPassword).IsWeak() {
+		return login.ErrWeakPassword
+	}
+
+	cmd.Password = extUser.InitialPassword
+	return nil
+}
@@ -0,0 +1,93 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	"github.com/grafana/grafana/pkg/services/quota"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/grafana/grafana/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitialPassword(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T, allow bool) (*Implementation, *sqlstore.SQLStore) {
+		sqlStore := sqlstore.InitTestDB(t)
+		secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+		authInfoStore := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)
+		return &Implementation{
+			SQLStore:             sqlStore,
+			QuotaService:         &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService:      authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, authInfoStore),
+			AllowInitialPassword: allow,
+		}, sqlStore
+	}
+	upsertCmd := func(password string) *models.UpsertUserCommand {
+		return &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule:      "oauth_generic",
+			AuthId:          "alice-id",
+			Login:           "alice",
+			Email:           "alice@example.org",
+			InitialPassword: password,
+		}}
+	}
+	storedUser := func(t *testing.T, sqlStore *sqlstore.SQLStore) *models.User {
+		query := &models.GetUserByLoginQuery{LoginOrEmail: "alice"}
+		require.NoError(t, sqlStore.GetUserByLogin(ctx, query))
+		return query.Result
+	}
+
+	t.Run("the password is stored hashed next to the external linkage", func(t *testing.T) {
+		loginService, sqlStore := setup(t, true)
+
+		require.NoError(t, loginService.UpsertUser(ctx, upsertCmd("break-glass")))
+
+		user := storedUser(t, sqlStore)
+		assert.NotEqual(t, "break-glass", user.Password)
+		hashed, err := util.EncodePassword("break-glass", user.Salt)
+		require.NoError(t, err)
+		assert.Equal(t, hashed, user.Password)
+
+		query := &models.GetAuthInfoQuery{AuthModule: "oauth_generic", AuthId: "alice-id"}
+		require.NoError(t, loginService.AuthInfoService.GetAuthInfo(ctx, query))
+		assert.Equal(t, user.Id, query.Result.UserId)
+	})
+
+	t.Run("the password is ignored when not allowed", func(t *testing.T) {
+		loginService, sqlStore := setup(t, false)
+
+		require.NoError(t, loginService.UpsertUser(ctx, upsertCmd("break-glass")))
+
+		assert.Empty(t, storedUser(t, sqlStore).Password)
+	})
+
+	t.Run("weak passwords are refused", func(t *testing.T) {
+		loginService, sqlStore := setup(t, true)
+
+		err := loginService.UpsertUser(ctx, upsertCmd("abc"))
+		require.ErrorIs(t, err, login.ErrWeakPassword)
+
+		query := &models.GetUserByLoginQuery{LoginOrEmail: "alice"}
+		require.ErrorIs(t, sqlStore.GetUserByLogin(ctx, query), models.ErrUserNotFound)
+	})
+
+	t.Run("existing users don't get the password", func(t *testing.T) {
+		loginService, sqlStore := setup(t, true)
+		require.NoError(t, loginService.UpsertUser(ctx, upsertCmd("")))
+
+		require.NoError(t, loginService.UpsertUser(ctx, upsertCmd("break-glass")))
+
+		assert.Empty(t, storedUser(t, sqlStore).Password)
+	})
+}
@@ -0,0 +1,58 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// invariantViolated fails with the violation under StrictInvariants, otherwise
+// it's logged and tolerated.
+func (ls *Implementation) invariantViolated(userID int64, invariant string) error {
+	if ls.StrictInvariants {
+		return &login.ErrInvariantViolation{UserId: userID, Invariant: invariant}
+	}
+	logger.Warn("Tolerating invariant violation", "userId", userID, "invariant", invariant)
+	return nil
+}
+
+// checkAuthInfoInvariant checks that a user with auth info for the auth module
+// of the external user has auth info for its auth id, i.e. that the user could
+// be looked up by it. It's only checked with StrictInvariants, since it takes
+// extra queries and the user is synced regardless otherwise.
+func (ls *Implementation) checkAuthInfoInvariant(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	if !ls.StrictInvariants || extUser.AuthModule == "" || extUser.AuthId == "" {
+		return nil
+	}
+
+	moduleQuery := &models.GetAuthInfoQuery{UserId: user.Id, AuthModule: extUser.AuthModule}
+	if err := ls.AuthInfoService.GetAuthInfo(ctx, moduleQuery); err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+	if moduleQuery.Result == nil || moduleQuery.Result.AuthId == extUser.AuthId {
+		return nil
+	}
+
+	idQuery := &models.GetAuthInfoQuery{UserId: user.Id, AuthModule: extUser.AuthModule, AuthId: extUser.AuthId}
+	if err := ls.AuthInfoService.GetAuthInfo(ctx, idQuery); err == nil {
+		return nil
+	} else if !errors.Is(err, models.ErrUserNotFound) {
+		return err
+	}
+	return ls.invariantViolated(user.Id, fmt.Sprintf("has auth info for auth module %s that doesn't match auth id %q", extUser.AuthModule, extUser.AuthId))
+}
+
+// checkOrgRoleInvariant checks that a stored org membership of the user has a
+// valid role.
+func (ls *Implementation) checkOrgRoleInvariant(user *models.User, org *models.UserOrgDTO) error {
+	if org.Role.IsValid() {
+		return nil
+	}
+	return ls.invariantViolated(user.Id, fmt.Sprintf("has invalid role %q in organization %d", org.Role, org.OrgId))
+}
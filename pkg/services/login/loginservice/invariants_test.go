@@ -0,0 +1,113 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_strictInvariants(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("org membership with an invalid role", func(t *testing.T) {
+		setup := func(strict bool) (*Implementation, *fakeStore) {
+			user := &models.User{Id: 1, Login: "alice"}
+			store := newFakeStore(user)
+			store.addOrgUser(1, 1, "Superuser")
+			return &Implementation{
+				SQLStore:         store,
+				AuthInfoService:  &logintest.AuthInfoServiceFake{ExpectedUser: user},
+				StrictInvariants: strict,
+			}, store
+		}
+		extUser := func() *models.ExternalUserInfo {
+			return &models.ExternalUserInfo{Login: "alice", OrgRoles: map[int64]models.RoleType{1: models.ROLE_EDITOR}}
+		}
+
+		t.Run("is tolerated by default", func(t *testing.T) {
+			loginService, store := setup(false)
+
+			require.NoError(t, loginService.UpsertUser(ctx, &models.UpsertUserCommand{ExternalUser: extUser()}))
+			assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+		})
+
+		t.Run("fails the sync in strict mode", func(t *testing.T) {
+			loginService, store := setup(true)
+
+			err := loginService.UpsertUser(ctx, &models.UpsertUserCommand{ExternalUser: extUser()})
+			var violation *login.ErrInvariantViolation
+			require.True(t, errors.As(err, &violation), err)
+			assert.Equal(t, int64(1), violation.UserId)
+			assert.Contains(t, violation.Invariant, `invalid role "Superuser"`)
+			assert.Equal(t, models.RoleType("Superuser"), store.orgUsers[1][1])
+		})
+	})
+
+	t.Run("auth info that doesn't match the lookup", func(t *testing.T) {
+		// alice is linked to oauth_github with auth id 1 and logs in with auth id
+		// 2, she's only found by email since auth ids aren't linked automatically
+		setup := func(t *testing.T, strict bool) (*Implementation, *database.AuthInfoStore) {
+			sqlStore := sqlstore.InitTestDB(t)
+			secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+			authInfoStore := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)
+			loginService := &Implementation{
+				SQLStore:        sqlStore,
+				QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+				AuthInfoService: authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, authInfoStore),
+				AutoLink:        models.AutoLinkNever,
+			}
+			require.NoError(t, loginService.UpsertUser(ctx, &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: githubUser("1")}))
+			loginService.StrictInvariants = strict
+			return loginService, authInfoStore
+		}
+
+		t.Run("is tolerated by default", func(t *testing.T) {
+			loginService, authInfoStore := setup(t, false)
+
+			cmd := &models.UpsertUserCommand{ExternalUser: githubUser("2")}
+			require.NoError(t, loginService.UpsertUser(ctx, cmd))
+
+			query := &models.GetAuthInfoQuery{UserId: cmd.Result.Id, AuthModule: "oauth_github"}
+			require.NoError(t, authInfoStore.GetAuthInfo(ctx, query))
+			assert.Equal(t, "1", query.Result.AuthId)
+		})
+
+		t.Run("fails the sync in strict mode", func(t *testing.T) {
+			loginService, authInfoStore := setup(t, true)
+
+			err := loginService.UpsertUser(ctx, &models.UpsertUserCommand{ExternalUser: githubUser("2")})
+			var violation *login.ErrInvariantViolation
+			require.True(t, errors.As(err, &violation), err)
+			assert.Contains(t, violation.Invariant, `doesn't match auth id "2"`)
+			assert.ErrorIs(t, err, &login.ErrUpsertUser{Phase: login.UpsertPhaseAuthInfo})
+
+			query := &models.GetAuthInfoQuery{AuthModule: "oauth_github", AuthId: "1"}
+			require.NoError(t, authInfoStore.GetAuthInfo(ctx, query))
+			assert.Equal(t, violation.UserId, query.Result.UserId)
+		})
+
+		t.Run("matching auth info passes in strict mode", func(t *testing.T) {
+			loginService, _ := setup(t, true)
+
+			require.NoError(t, loginService.UpsertUser(ctx, &models.UpsertUserCommand{ExternalUser: githubUser("1")}))
+		})
+	})
+}
+
+func githubUser(authID string) *models.ExternalUserInfo {
+	return &models.ExternalUserInfo{AuthModule: "oauth_github", AuthId: authID, Login: "alice", Email: "alice@example.org"}
+}
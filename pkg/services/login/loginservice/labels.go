@@ -0,0 +1,78 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// addUserLabels persists the labels of the external user. Labels are only
+// ever added, a label the identity provider stops sending is kept.
+func (ls *Implementation) addUserLabels(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	if ls.UserLabelStore == nil || len(extUser.Labels) == 0 {
+		return nil
+	}
+	return ls.UserLabelStore.AddUserLabels(ctx, user.Id, extUser.Labels)
+}
+
+// ForEachUserWithLabel calls fn for each user with the label, in ascending
+// order of user id. Labeled users that no longer exist are skipped. It stops
+// at the first error, returned as is.
+func (ls *Implementation) ForEachUserWithLabel(ctx context.Context, label string, fn func(ctx context.Context, user *models.User) error) error {
+	if ls.UserLabelStore == nil {
+		return login.ErrUserLabelsDisabled
+	}
+
+	userIDs, err := ls.UserLabelStore.GetUserIdsByLabel(ctx, label)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		userQuery := &models.GetUserByIdQuery{Id: userID}
+		if err := ls.readStore(ctx, false).GetUserById(ctx, userQuery); err != nil {
+			if errors.Is(err, models.ErrUserNotFound) {
+				continue
+			}
+			return err
+		}
+		if err := fn(ctx, userQuery.Result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisableUsersWithLabel disables the enabled users with the label, e.g. all
+// users of a contractor, and returns how many were disabled.
+func (ls *Implementation) DisableUsersWithLabel(ctx context.Context, label string) (int, error) {
+	disabled := 0
+	err := ls.ForEachUserWithLabel(ctx, label, func(ctx context.Context, user *models.User) error {
+		if user.IsDisabled {
+			return nil
+		}
+		if err := ls.DisableUserWithSource(ctx, user.Id, login.DisableSourceManual); err != nil {
+			return err
+		}
+		disabled++
+		return nil
+	})
+	if disabled > 0 {
+		logger.Info("Disabled users with label", "label", label, "count", disabled)
+	}
+	return disabled, err
+}
+
+// ForceResyncWithLabel makes the next UpsertUser of the users with the label
+// sync them even if they're unchanged, like ForceResyncAll, and returns how
+// many users it applied to.
+func (ls *Implementation) ForceResyncWithLabel(ctx context.Context, label string) (int, error) {
+	count := 0
+	err := ls.ForEachUserWithLabel(ctx, label, func(ctx context.Context, user *models.User) error {
+		ls.fingerprints.forget(user.Id)
+		count++
+		return nil
+	})
+	return count, err
+}
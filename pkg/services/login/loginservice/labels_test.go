@@ -0,0 +1,147 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/grafana/grafana/pkg/services/quota"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserLabels(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*Implementation, *sqlstore.SQLStore) {
+		sqlStore := sqlstore.InitTestDB(t)
+		secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+		authInfoStore := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)
+		return &Implementation{
+			SQLStore:        sqlStore,
+			QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService: authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, authInfoStore),
+			UserLabelStore:  logindatabase.ProvideUserLabelStore(sqlStore),
+		}, sqlStore
+	}
+	upsert := func(t *testing.T, loginService *Implementation, login string, labels ...string) *models.User {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic",
+			AuthId:     login,
+			Login:      login,
+			Email:      login + "@example.org",
+			Labels:     labels,
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		return cmd.Result
+	}
+	loginsWithLabel := func(t *testing.T, loginService *Implementation, label string) []string {
+		logins := []string{}
+		require.NoError(t, loginService.ForEachUserWithLabel(ctx, label, func(ctx context.Context, user *models.User) error {
+			logins = append(logins, user.Login)
+			return nil
+		}))
+		return logins
+	}
+
+	t.Run("users are iterated by label", func(t *testing.T) {
+		loginService, _ := setup(t)
+		upsert(t, loginService, "alice", "contractor", "emea")
+		upsert(t, loginService, "bob", "emea")
+		upsert(t, loginService, "carol")
+
+		assert.Equal(t, []string{"alice", "bob"}, loginsWithLabel(t, loginService, "emea"))
+		assert.Equal(t, []string{"alice"}, loginsWithLabel(t, loginService, "contractor"))
+		assert.Empty(t, loginsWithLabel(t, loginService, "apac"))
+	})
+
+	t.Run("labels are added at later logins and kept", func(t *testing.T) {
+		loginService, _ := setup(t)
+		upsert(t, loginService, "alice", "emea")
+		upsert(t, loginService, "alice", "contractor")
+		upsert(t, loginService, "alice", "contractor")
+
+		assert.Equal(t, []string{"alice"}, loginsWithLabel(t, loginService, "emea"))
+		assert.Equal(t, []string{"alice"}, loginsWithLabel(t, loginService, "contractor"))
+	})
+
+	t.Run("deleted users are skipped", func(t *testing.T) {
+		loginService, sqlStore := setup(t)
+		alice := upsert(t, loginService, "alice", "emea")
+		upsert(t, loginService, "bob", "emea")
+		require.NoError(t, sqlStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: alice.Id}))
+
+		assert.Equal(t, []string{"bob"}, loginsWithLabel(t, loginService, "emea"))
+		userIDs, err := loginService.UserLabelStore.GetUserIdsByLabel(ctx, "emea")
+		require.NoError(t, err)
+		assert.NotContains(t, userIDs, alice.Id, "the labels of deleted users should be deleted with them")
+	})
+
+	t.Run("iteration stops at the first error", func(t *testing.T) {
+		loginService, _ := setup(t)
+		upsert(t, loginService, "alice", "emea")
+		upsert(t, loginService, "bob", "emea")
+		errStop := errors.New("stop")
+
+		calls := 0
+		err := loginService.ForEachUserWithLabel(ctx, "emea", func(ctx context.Context, user *models.User) error {
+			calls++
+			return errStop
+		})
+		require.ErrorIs(t, err, errStop)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("users with a label are disabled", func(t *testing.T) {
+		loginService, sqlStore := setup(t)
+		alice := upsert(t, loginService, "alice", "contractor")
+		bob := upsert(t, loginService, "bob")
+		carol := upsert(t, loginService, "carol", "contractor")
+		require.NoError(t, sqlStore.DisableUser(ctx, &models.DisableUserCommand{UserId: carol.Id, IsDisabled: true}))
+
+		disabled, err := loginService.DisableUsersWithLabel(ctx, "contractor")
+		require.NoError(t, err)
+		assert.Equal(t, 1, disabled)
+
+		for userID, isDisabled := range map[int64]bool{alice.Id: true, bob.Id: false, carol.Id: true} {
+			query := &models.GetUserByIdQuery{Id: userID}
+			require.NoError(t, sqlStore.GetUserById(ctx, query))
+			assert.Equal(t, isDisabled, query.Result.IsDisabled, query.Result.Login)
+		}
+	})
+
+	t.Run("users with a label are resynced", func(t *testing.T) {
+		loginService, _ := setup(t)
+		loginService.SkipUnchangedSync = true
+		alice := upsert(t, loginService, "alice", "emea")
+		bob := upsert(t, loginService, "bob")
+		for _, user := range []*models.User{alice, bob} {
+			loginService.fingerprints.set(user.Id, "fingerprint")
+		}
+
+		count, err := loginService.ForceResyncWithLabel(ctx, "emea")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.False(t, loginService.fingerprints.matches(alice.Id, "fingerprint"))
+		assert.True(t, loginService.fingerprints.matches(bob.Id, "fingerprint"))
+	})
+
+	t.Run("bulk operations fail without a label store", func(t *testing.T) {
+		loginService := &Implementation{}
+
+		_, err := loginService.DisableUsersWithLabel(ctx, "emea")
+		assert.ErrorIs(t, err, login.ErrUserLabelsDisabled)
+		_, err = loginService.ForceResyncWithLabel(ctx, "emea")
+		assert.ErrorIs(t, err, login.ErrUserLabelsDisabled)
+	})
+}
@@ -0,0 +1,48 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// LockUser locks a user so that subsequent logins are rejected with
+// login.ErrUserLocked, even if authentication with the identity provider succeeded.
+func (ls *Implementation) LockUser(ctx context.Context, userID int64, reason string) error {
+	if ls.UserLockStore == nil {
+		return login.ErrUserLockingDisabled
+	}
+
+	logger.Info("Locking user", "id", userID, "reason", reason)
+	return ls.UserLockStore.SetUserLock(ctx, &login.UserLock{
+		UserId:  userID,
+		Reason:  reason,
+		Created: ls.now(),
+	})
+}
+
+// UnlockUser removes the lock placed on a user by LockUser.
+func (ls *Implementation) UnlockUser(ctx context.Context, userID int64) error {
+	if ls.UserLockStore == nil {
+		return login.ErrUserLockingDisabled
+	}
+
+	logger.Info("Unlocking user", "id", userID)
+	return ls.UserLockStore.DeleteUserLock(ctx, userID)
+}
+
+// checkUserLock returns login.ErrUserLocked if the user has been locked.
+func (ls *Implementation) checkUserLock(ctx context.Context, userID int64) error {
+	if ls.UserLockStore == nil {
+		return nil
+	}
+
+	lock, err := ls.UserLockStore.GetUserLock(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if lock != nil {
+		return &login.ErrUserLocked{Reason: lock.Reason}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LockUser(t *testing.T) {
+	user := &models.User{Id: 1, Login: "alice"}
+	lockStore := &fakeUserLockStore{locks: map[int64]*login.UserLock{}}
+	loginService := Implementation{
+		SQLStore:        newFakeStore(user),
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		UserLockStore:   lockStore,
+	}
+	upsertCmd := func() *models.UpsertUserCommand {
+		return &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice"}}
+	}
+
+	require.NoError(t, loginService.LockUser(context.Background(), 1, "under investigation"))
+	assert.Equal(t, "under investigation", lockStore.locks[1].Reason)
+
+	err := loginService.UpsertUser(context.Background(), upsertCmd())
+	var lockedErr *login.ErrUserLocked
+	require.True(t, errors.As(err, &lockedErr))
+	assert.Equal(t, "Your account is locked: under investigation", err.Error())
+
+	require.NoError(t, loginService.UnlockUser(context.Background(), 1))
+	cmd := upsertCmd()
+	require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+	assert.Equal(t, user, cmd.Result)
+}
+
+func TestUserLock_sqlStore(t *testing.T) {
+	ctx := context.Background()
+	loginService, sqlStore := newSQLLoginService(t)
+	lockStore := logindatabase.ProvideUserLockStore(sqlStore)
+	loginService.UserLockStore = lockStore
+	upsertCmd := func() *models.UpsertUserCommand {
+		return &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic",
+			AuthId:     "alice-id",
+			Login:      "alice",
+			Email:      "alice@example.org",
+		}}
+	}
+	cmd := upsertCmd()
+	require.NoError(t, loginService.UpsertUser(ctx, cmd))
+	userID := cmd.Result.Id
+
+	require.NoError(t, loginService.LockUser(ctx, userID, "under investigation"))
+	require.NoError(t, loginService.LockUser(ctx, userID, "offboarding"))
+	var lockedErr *login.ErrUserLocked
+	require.True(t, errors.As(loginService.UpsertUser(ctx, upsertCmd()), &lockedErr))
+	assert.Equal(t, "offboarding", lockedErr.Reason)
+
+	require.NoError(t, loginService.UnlockUser(ctx, userID))
+	require.NoError(t, loginService.UpsertUser(ctx, upsertCmd()))
+
+	require.NoError(t, loginService.LockUser(ctx, userID, "offboarding"))
+	require.NoError(t, sqlStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: userID}))
+	lock, err := lockStore.GetUserLock(ctx, userID)
+	require.NoError(t, err)
+	assert.Nil(t, lock)
+}
+
+func Test_LockUser_withoutStore(t *testing.T) {
+	loginService := Implementation{}
+
+	require.ErrorIs(t, loginService.LockUser(context.Background(), 1, "reason"), login.ErrUserLockingDisabled)
+	require.ErrorIs(t, loginService.UnlockUser(context.Background(), 1), login.ErrUserLockingDisabled)
+}
+
+type fakeUserLockStore struct {
+	locks map[int64]*login.UserLock
+}
+
+func (f *fakeUserLockStore) GetUserLock(ctx context.Context, userID int64) (*login.UserLock, error) {
+	return f.locks[userID], nil
+}
+
+func (f *fakeUserLockStore) SetUserLock(ctx context.Context, lock *login.UserLock) error {
+	f.locks[lock.UserId] = lock
+	return nil
+}
+
+func (f *fakeUserLockStore) DeleteUserLock(ctx context.Context, userID int64) error {
+	delete(f.locks, userID)
+	return nil
+}
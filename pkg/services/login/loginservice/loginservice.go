@@ -3,35 +3,388 @@ package loginservice
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/infra/log"
+	"github.com/grafana/grafana/pkg/infra/tracing"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/services/login"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
 	"github.com/grafana/grafana/pkg/services/quota"
 	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"golang.org/x/oauth2"
 )
 
 var (
-	logger = log.New("login.ext_user")
+	logger = &redactingLogger{ConcreteLogger: log.New("login.ext_user")}
 )
 
-func ProvideService(sqlStore sqlstore.Store, bus bus.Bus, quotaService *quota.QuotaService, authInfoService login.AuthInfoService) *Implementation {
+// maxLoginSuffixAttempts bounds how many numeric suffixes are tried when
+// LoginCollisionSuffix is used.
+const maxLoginSuffixAttempts = 10
+
+// UserFields is a set of user fields that can be synced from the identity provider.
+type UserFields uint8
+
+const (
+	UserFieldLogin UserFields = 1 << iota
+	UserFieldEmail
+	UserFieldName
+
+	AllUserFields = UserFieldLogin | UserFieldEmail | UserFieldName
+)
+
+// MissingEmailPolicy controls how users without an email are created.
+type MissingEmailPolicy int
+
+const (
+	// MissingEmailAllow creates the user without an email (default).
+	MissingEmailAllow MissingEmailPolicy = iota
+	// MissingEmailReject rejects the creation with login.ErrMissingEmail.
+	MissingEmailReject
+	// MissingEmailPlaceholder creates the user with a placeholder email derived from the login.
+	MissingEmailPlaceholder
+)
+
+// placeholderEmailDomain is used for placeholder emails, .invalid is reserved by RFC 2606.
+const placeholderEmailDomain = "noreply.invalid"
+
+// LoginCollisionStrategy controls how createUser handles a login that is
+// already taken by a different user.
+type LoginCollisionStrategy int
+
+const (
+	// LoginCollisionFail returns models.ErrUserAlreadyExists (default).
+	LoginCollisionFail LoginCollisionStrategy = iota
+	// LoginCollisionSuffix appends a numeric suffix ("login-1", "login-2", ...) to the login.
+	LoginCollisionSuffix
+	// LoginCollisionUseEmail falls back to using the email address as login.
+	LoginCollisionUseEmail
+)
+
+func ProvideService(cfg *setting.Cfg, sqlStore sqlstore.Store, bus bus.Bus, quotaService *quota.QuotaService, authInfoService login.AuthInfoService) *Implementation {
 	s := &Implementation{
-		SQLStore:        sqlStore,
-		Bus:             bus,
-		QuotaService:    quotaService,
-		AuthInfoService: authInfoService,
+		SQLStore:                sqlStore,
+		Bus:                     bus,
+		QuotaService:            quotaService,
+		QuotaUsage:              quotaService,
+		AuthInfoService:         authInfoService,
+		Clock:                   clock.New(),
+		UserLockStore:           logindatabase.ProvideUserLockStore(sqlStore),
+		PendingRoleStore:        logindatabase.ProvidePendingRoleStore(sqlStore),
+		RoleProvenanceStore:     logindatabase.ProvideRoleProvenanceStore(sqlStore),
+		DisableSourceStore:      logindatabase.ProvideDisableSourceStore(sqlStore),
+		PreferencesStore:        logindatabase.ProvideUserPreferencesStore(sqlStore),
+		SoftDeleteStore:         logindatabase.ProvideSoftDeleteStore(sqlStore),
+		UserLabelStore:          logindatabase.ProvideUserLabelStore(sqlStore),
+		DisplayNameStore:        logindatabase.ProvideDisplayNameStore(sqlStore),
+		OrphanedAuthInfoStore:   logindatabase.ProvideOrphanedAuthInfoStore(sqlStore),
+		OrgMemberCountStore:     logindatabase.ProvideOrgMemberCountStore(sqlStore),
+		OrgMemberSampleInterval: time.Minute,
 	}
+	if cfg.LoginOutboxEnabled {
+		s.Outbox = logindatabase.ProvideOutboxStore(sqlStore)
+		s.OutboxRelayInterval = cfg.LoginOutboxRelayInterval
+	}
+	bus.AddEventListener(s.handleOrgCreated)
 	return s
 }
 
 type Implementation struct {
-	SQLStore        sqlstore.Store
+	SQLStore sqlstore.Store
+	// ReadStore is an optional read replica of SQLStore used for reads that
+	// tolerate replication lag. Writes always go to SQLStore.
+	ReadStore       sqlstore.Store
 	Bus             bus.Bus
 	AuthInfoService login.AuthInfoService
-	QuotaService    *quota.QuotaService
+	QuotaService    quota.Service
 	TeamSync        login.TeamSyncFunc
+	// GroupTeamMapper syncs team memberships from groups, before TeamSync runs.
+	GroupTeamMapper *GroupTeamMapper
+	// ShadowTeamSync runs a team sync in shadow mode after the live one, to
+	// validate it before switching. The changes it would make on top of the
+	// live team sync are logged and recorded in the sync result, never applied.
+	ShadowTeamSync ShadowTeamSyncFunc
+	// ShadowTeams is where ShadowTeamSync reads the live team memberships from.
+	ShadowTeams login.TeamMembershipService
+	// UserFactory can set additional fields on users created by UpsertUser.
+	UserFactory login.UserFactoryFunc
+	// AllowInitialPassword sets the InitialPassword of external users as the
+	// local password of the users created from them. It's ignored otherwise.
+	AllowInitialPassword bool
+	// TokenTransformer rewrites OAuth tokens before they're persisted, e.g. to
+	// strip the id_token. Tokens are persisted as is when it's nil.
+	TokenTransformer func(*oauth2.Token) *oauth2.Token
+	// Clock is used for everything time related. Real time is used when it's nil.
+	Clock clock.Clock
+	// Tracer enables spans for the phases of UpsertUser.
+	Tracer tracing.Tracer
+	// RecordPhaseDurations records the durations of the phases of UpsertUser in
+	// the sync result and logs them at debug level.
+	RecordPhaseDurations bool
+
+	LoginCollisionStrategy LoginCollisionStrategy
+	UserLockStore          login.UserLockStore
+	// LoginTemplate derives the login of users created without one from their
+	// external user info, e.g. "ext-{{.AuthId}}", see renderLoginTemplate.
+	LoginTemplate string
+	// UniqueDisplayName controls what happens when the display name of a
+	// created or updated user is taken by another user. It requires
+	// DisplayNameStore.
+	UniqueDisplayName DisplayNamePolicy
+	DisplayNameStore  login.DisplayNameStore
+	// UserLabelStore persists the labels of external users, see
+	// ForEachUserWithLabel. Labels aren't persisted without it.
+	UserLabelStore login.UserLabelStore
+	// SyncableUserFields restricts which fields updateUser syncs from the
+	// identity provider. The zero value syncs all fields.
+	SyncableUserFields UserFields
+	// TeamSyncOrgID is the org that users without any org roles are added to
+	// before team sync runs, so that they can be made members of its teams.
+	TeamSyncOrgID   int64
+	TeamSyncOrgRole models.RoleType
+	// QuotaCacheTTL enables caching a reached user quota for new signups.
+	QuotaCacheTTL time.Duration
+	// CustomRoleService enables syncing custom roles from ExternalUserInfo.CustomRoles.
+	CustomRoleService login.CustomRoleService
+	// CustomRoleLevels maps the UIDs of custom roles to the basic role they're
+	// equivalent to, for GetEffectiveRole. Unmapped custom roles don't count.
+	CustomRoleLevels map[string]models.RoleType
+	// PendingRoleStore keeps roles for orgs that don't exist yet, see ApplyPendingRoles.
+	PendingRoleStore login.PendingRoleStore
+	// SoftDeadline is the time budget of UpsertUser. Org role changes that would
+	// happen after it's exceeded are deferred and reported in the sync result.
+	SoftDeadline time.Duration
+	// UserQuotaSoftLimit is the fraction of the global user quota, e.g. 0.9,
+	// from which on creating a user adds a warning to the sync result and
+	// publishes a models.UserQuotaNearLimitEvent. The user is still created.
+	// Zero disables it, it requires QuotaUsage.
+	UserQuotaSoftLimit float64
+	QuotaUsage         login.QuotaUsageService
+	// SyncTopNOrgs limits the org role additions and updates of UpsertUser to
+	// the ones with the N highest roles. The others are deferred and reported in
+	// the sync result. Zero syncs all of them.
+	SyncTopNOrgs int
+	// BeforeUpsert are run in order by UpsertUser after the user lookup, see
+	// BeforeUpsertHook.
+	BeforeUpsert []BeforeUpsertHook
+	// AnnotateSyncErrors records on the user whether its last sync failed or
+	// had warnings, see models.User.LastSyncStatus.
+	AnnotateSyncErrors bool
+	// VerifyAfterSync reads back the org memberships and server admin flag the
+	// sync wrote and fails it with login.ErrSyncVerificationFailed when they
+	// don't match.
+	VerifyAfterSync bool
+	// ClearTokenOnNil removes the stored OAuth token of an existing user that
+	// logs in without one, e.g. after revoking consent. It's kept otherwise.
+	ClearTokenOnNil bool
+	// RawClaimsMaxSize bounds the JSON snapshot of ExternalUserInfo.RawClaims
+	// stored with the auth info, defaultRawClaimsMaxSize when zero.
+	RawClaimsMaxSize int
+	// RawClaimsRedactedKeys are claim keys redacted from the snapshot in
+	// addition to the default ones, see redactClaims.
+	RawClaimsRedactedKeys []string
+	// RoleProvenanceStore records where synced org roles came from, see ExplainUserOrgRole.
+	RoleProvenanceStore login.RoleProvenanceStore
+	MissingEmailPolicy  MissingEmailPolicy
+	// ConnectorScopedOrgSync limits each auth module to managing the org roles it
+	// granted itself, based on the RoleProvenanceStore, so that users synced by
+	// several identity providers don't flip between their roles.
+	ConnectorScopedOrgSync bool
+	// MaxRemovalRatio is the largest fraction of a user's orgs that a single sync
+	// may remove. Larger removals are skipped and a models.SuspiciousSyncDetectedEvent
+	// is published instead. Zero disables the check.
+	MaxRemovalRatio float64
+	// OnOrgless is applied when org sync leaves a user without any org.
+	OnOrgless OrglessPolicy
+	// OrglessOrgID and OrglessOrgRole are used by OrglessAssignDefault.
+	OrglessOrgID   int64
+	OrglessOrgRole models.RoleType
+	// GroupOrgRoles grants org roles to the members of external groups, they're
+	// merged into the org roles sent by the identity provider.
+	GroupOrgRoles []GroupOrgRoleMapping
+	// OrgRoleMerge resolves conflicting roles for the same org from OrgRoles,
+	// OrgRolesByName and GroupOrgRoles, in that order.
+	OrgRoleMerge OrgRoleMergeStrategy
+	// DefaultRolePerOrg is the role used for org roles the identity provider sends
+	// without a role. Without a default for the org, such entries remove the membership.
+	DefaultRolePerOrg map[int64]models.RoleType
+	// PerOrgRoleCeiling is the highest role external sync grants in each org,
+	// higher roles are lowered to it with a warning.
+	PerOrgRoleCeiling map[int64]models.RoleType
+	// EmptyDefaultOrgRole decides what an empty role for the current org of the
+	// user means when DefaultRolePerOrg has none for it.
+	EmptyDefaultOrgRole EmptyDefaultOrgRolePolicy
+	// RolePolicy is checked before every org role that org sync adds or
+	// updates, denied roles are skipped with a warning.
+	RolePolicy RolePolicy
+	// AdminGrantAllowlist restricts which users external sync may make server
+	// admins. Nil allows everyone.
+	AdminGrantAllowlist *AdminAllowlist
+	// AdminFlagStableLogins is the number of consecutive logins a server admin
+	// grant must be claimed on before it's applied. Zero or one applies grants
+	// immediately. Revocations are never delayed.
+	AdminFlagStableLogins int
+	// OnAdminSyncFailure is applied when updating the server admin flag fails.
+	OnAdminSyncFailure AdminSyncFailurePolicy
+	// OnTokenPersistFailure is applied when storing the OAuth token of a user fails.
+	OnTokenPersistFailure TokenPersistFailurePolicy
+	// RoleAliases maps role names sent by the identity provider to roles, in
+	// addition to and overriding the default aliases. Names are case insensitive.
+	RoleAliases map[string]models.RoleType
+	// StrictRoleValidation fails the sync on unknown org roles instead of
+	// skipping them with a warning.
+	StrictRoleValidation bool
+	// StrictInvariants fails the sync on stored state that breaks an invariant,
+	// see checkAuthInfoInvariant and checkOrgRoleInvariant, instead of tolerating
+	// it. It's meant for test and staging environments.
+	StrictInvariants bool
+	// OrgIdByName resolves the org names of ExternalUserInfo.OrgRolesByName. It
+	// must return models.ErrOrgNotFound for unknown names. Orgs are looked up in
+	// the store when it's nil.
+	OrgIdByName func(ctx context.Context, name string) (int64, error)
+	// VerifyOrgIds checks that the orgs of the external org roles exist before
+	// syncing. Unknown orgs are reported as warnings, or fail the sync with
+	// StrictRoleValidation.
+	VerifyOrgIds bool
+	// DisableSourceStore records why users were disabled, see reenableLDAPUser.
+	DisableSourceStore login.DisableSourceStore
+	// OrphanedAuthInfoStore enables RepairOrphanedAuthInfo.
+	OrphanedAuthInfoStore login.OrphanedAuthInfoStore
+	// SoftDeleteStore enables SoftDeleteExternalUser.
+	SoftDeleteStore login.SoftDeleteStore
+	// OrgMemberCountStore enables OrgMemberCounts and RunOrgMemberSampler.
+	OrgMemberCountStore login.OrgMemberCountStore
+	// OrgMemberSampleInterval is how often Run samples the org member counts.
+	// Zero doesn't sample them.
+	OrgMemberSampleInterval time.Duration
+	// OnSoftDeletedLogin is applied when a soft deleted user logs in.
+	OnSoftDeletedLogin SoftDeletedLoginPolicy
+	// LastAdminOnDelete is applied by DeleteExternalUser to the orgs the user
+	// is the last admin of.
+	LastAdminOnDelete LastAdminOnDeletePolicy
+	// Outbox stores the UserCreated and OrgRoleSynced events of UpsertUser in
+	// the transaction of their change, to be delivered by RelayOutbox.
+	Outbox login.OutboxStore
+	// OutboxRelayInterval is how often Run relays the Outbox. Zero doesn't
+	// relay it.
+	OutboxRelayInterval time.Duration
+	// PreferencesStore enables syncing the locale and timezone of users.
+	PreferencesStore login.UserPreferencesStore
+	// AuthoritativePreferences resets preferences to the default when the
+	// identity provider stops sending them.
+	AuthoritativePreferences bool
+	// DefaultOrgSelector picks the new default org when the current one isn't among
+	// the synced org roles. The org with the lowest id is used when it's nil.
+	DefaultOrgSelector func(map[int64]models.RoleType) int64
+	// ObserveUntil enables observe only mode until the given time. Org role and
+	// server admin changes are recorded in the sync result instead of applied,
+	// user info and tokens are still updated.
+	ObserveUntil time.Time
+	// MaintenanceMode freezes provisioning, e.g. during a migration. Existing
+	// users still log in but only their OAuth token is refreshed, no users are
+	// created or linked and the BeforeUpsert hooks don't run, see
+	// maintenanceLogin.
+	MaintenanceMode bool
+	// MinimumAutoCreateRole is the highest role users get when they're created,
+	// in the auto assigned org if the identity provider sends no org roles and in
+	// the synced orgs otherwise. Later logins sync roles as usual.
+	MinimumAutoCreateRole models.RoleType
+	// AllowAutoCreateAboveMinimum lets org roles from the identity provider
+	// exceed MinimumAutoCreateRole on creation.
+	AllowAutoCreateAboveMinimum bool
+	// OnCollisionWithDisabled is applied when an external user without a linked
+	// account matches a disabled user by email or login.
+	OnCollisionWithDisabled CollisionWithDisabledPolicy
+	// BootstrapFirstUser makes the first user created in an empty user table a
+	// server admin and an admin of its orgs, whatever the identity provider sends.
+	BootstrapFirstUser bool
+	// ProjectUserLookup only fetches the user columns UpsertUser needs when an
+	// existing user is found by its auth info. The other fields of the resulting
+	// user aren't set then.
+	ProjectUserLookup bool
+	// IdentityKey decides which fields are used to find the existing user of an
+	// external user.
+	IdentityKey IdentityKey
+	// LoginWindows restrict when external users may log in. Users must be
+	// within every window that applies to them, see LoginWindow.
+	LoginWindows []LoginWindow
+	// DenyGroups and AllowGroups restrict logins by the groups of external
+	// users, before anything is provisioned. Users in any of the DenyGroups are
+	// rejected. When AllowGroups are set, users must be in one of them too.
+	DenyGroups  []string
+	AllowGroups []string
+	// AutoLink controls whether the auth module of an external user is linked to
+	// an existing user found by email or login.
+	AutoLink models.AutoLinkPolicy
+	// MultiMatch controls which user an external user is matched with when its
+	// email and login match different existing users.
+	MultiMatch models.MultiMatchPolicy
+	// AsyncOrgSync makes UpsertUser return before org roles, custom roles and
+	// teams are synced. They're synced by a background worker instead, a newer
+	// login of a user supersedes its queued sync. See FlushOrgSync.
+	AsyncOrgSync bool
+	// BatchConcurrency is the number of users batch operations such as
+	// DisableStaleExternalUsers process in parallel. Zero or one runs serially.
+	BatchConcurrency int
+	// ExportIncludeTokens includes OAuth tokens in ExportUserProvisioningState,
+	// they're redacted otherwise.
+	ExportIncludeTokens bool
+	// AllowDegradedLogin lets users log in without being synced while the auth
+	// info backend is unavailable, if they logged in successfully within the
+	// last DegradedLoginTTL.
+	AllowDegradedLogin bool
+	DegradedLoginTTL   time.Duration
+	// SyncDegradedLogins syncs the org roles and server admin flag of degraded
+	// logins fail safe, see syncDegraded.
+	SyncDegradedLogins bool
+	// CircuitBreakerThreshold is the number of consecutive slow or failed user
+	// lookups after which lookups fail fast with login.ErrCircuitOpen, and are
+	// served by degraded logins if allowed. Zero disables the breaker.
+	CircuitBreakerThreshold int
+	// CircuitBreakerSlowCall is the duration after which a lookup counts as
+	// slow. Only failed lookups count when it's zero.
+	CircuitBreakerSlowCall time.Duration
+	// CircuitBreakerCooldown is how long the breaker stays open before a trial
+	// lookup is let through.
+	CircuitBreakerCooldown time.Duration
+	// ThrottleLimit is the number of UpsertUser calls a remote address may make
+	// within ThrottleWindow, more calls are rejected with login.ErrLoginThrottled.
+	// Calls without a request aren't throttled. Zero disables throttling.
+	ThrottleLimit int
+	// ThrottleWindow is one minute when it's zero.
+	ThrottleWindow time.Duration
+	// ThrottleExemptExisting doesn't count successful logins of existing users
+	// against the ThrottleLimit.
+	ThrottleExemptExisting bool
+	// SkipUnchangedSync skips syncing existing users whose external user info
+	// didn't change since their last synced login, see
+	// models.ExternalUserInfo.Fingerprint. Tokens and provider labels are still
+	// stored, changes made to such users in Grafana aren't reverted.
+	SkipUnchangedSync bool
+	// AuditSink receives the changes syncs make to users, as an entry per
+	// change or one per sync depending on AuditMode.
+	AuditSink login.AuditSink
+	AuditMode AuditMode
+
+	quotaCache    userQuotaCache
+	adminClaims   adminClaimTracker
+	lastKnownGood lastKnownGoodCache
+	orgSyncs      orgSyncQueue
+	breaker       circuitBreaker
+	throttler     loginThrottler
+	fingerprints  fingerprintCache
+}
+
+func (ls *Implementation) now() time.Time {
+	if ls.Clock == nil {
+		return time.Now()
+	}
+	return ls.Clock.Now()
 }
 
 // CreateUser creates inserts a new one.
@@ -40,95 +393,324 @@ func (ls *Implementation) CreateUser(cmd models.CreateUserCommand) (*models.User
 }
 
 // UpsertUser updates an existing user, or if it doesn't exist, inserts a new one.
-func (ls *Implementation) UpsertUser(ctx context.Context, cmd *models.UpsertUserCommand) error {
+func (ls *Implementation) UpsertUser(ctx context.Context, cmd *models.UpsertUserCommand) (err error) {
 	extUser := cmd.ExternalUser
+	state := ls.newSyncState()
+	state.applyOverrides(cmd.Overrides)
+	state.fullSync = cmd.ForceFullSync
+	cmd.SyncResult = state.result
+	defer ls.logPhaseDurations(extUser, state)
+	defer func() { ls.flushAudit(ctx, state, cmd.Result, extUser) }()
+
+	remoteAddr := throttleKey(cmd)
+	if err := ls.throttle(remoteAddr); err != nil {
+		return err
+	}
+	if ls.ThrottleExemptExisting {
+		defer func() {
+			if err == nil && !state.userCreated {
+				ls.throttler.refund(remoteAddr)
+			}
+		}()
+	}
+
+	if err := ls.checkGroupAccess(extUser); err != nil {
+		return err
+	}
+	// an auth module without an auth id is fine, not every identity provider has ids
+	if extUser.AuthModule == "" && extUser.AuthId != "" {
+		return &login.ErrMissingAuthModule{AuthId: extUser.AuthId}
+	}
+	if err := ls.checkIdentityKey(extUser); err != nil {
+		return err
+	}
+	if err := ls.checkLoginWindows(extUser); err != nil {
+		return err
+	}
+
+	// fingerprinted before the org roles are resolved and merged below
+	fingerprint := ls.fingerprint(cmd)
+
+	if err := ls.resolveOrgRolesByName(ctx, extUser, state); err != nil {
+		return err
+	}
+	ls.mergeGroupOrgRoles(extUser, state)
+	if err := ls.verifyOrgIds(ctx, extUser, state); err != nil {
+		return err
+	}
 
-	user, err := ls.AuthInfoService.LookupAndUpdate(ctx, &models.GetUserByAuthInfoQuery{
-		AuthModule: extUser.AuthModule,
-		AuthId:     extUser.AuthId,
-		UserId:     extUser.UserId,
-		Email:      extUser.Email,
-		Login:      extUser.Login,
-	})
+	lookupCtx, endLookup := ls.startSpan(ctx, state, spanLookup, extUser)
+	user, err := ls.guardedLookupUser(lookupCtx, extUser, state)
+	if errors.Is(err, models.ErrUserNotFound) {
+		endLookup(nil)
+	} else {
+		endLookup(err)
+	}
+	if errors.Is(err, models.ErrUserNotFound) && ls.MaintenanceMode {
+		return login.ErrProvisioningPaused
+	}
+	if (err == nil || errors.Is(err, models.ErrUserNotFound)) && !ls.MaintenanceMode {
+		if err := ls.runBeforeUpsert(ctx, user, extUser); err != nil {
+			return err
+		}
+	}
 	if err != nil {
 		if !errors.Is(err, models.ErrUserNotFound) {
-			return err
+			if isAmbiguousUser(err) {
+				return err
+			}
+			degraded, degradedErr := ls.degradedLogin(ctx, extUser, state, err)
+			if degradedErr != nil {
+				return degradedErr
+			}
+			if degraded != nil {
+				cmd.Result = degraded
+				ls.syncDegraded(ctx, degraded, extUser, state)
+				return nil
+			}
+			return upsertErr(login.UpsertPhaseLookup, err)
 		}
 		if !cmd.SignupAllowed {
-			cmd.ReqContext.Logger.Warn("Not allowing login, user not found in internal user database and allow signup = false", "authmode", extUser.AuthModule)
+			logger.Warn("Not allowing login, user not found in internal user database and allow signup = false", "authmode", extUser.AuthModule)
 			return login.ErrSignupNotAllowed
 		}
 
-		limitReached, err := ls.QuotaService.QuotaReached(cmd.ReqContext, "user")
+		if extUser.Email == "" {
+			if err := ls.handleMissingEmail(extUser); err != nil {
+				return err
+			}
+		}
+
+		limitReached, err := ls.userQuotaReached(ctx, cmd)
 		if err != nil {
-			cmd.ReqContext.Logger.Warn("Error getting user quota.", "error", err)
+			logger.Warn("Error getting user quota.", "error", err)
 			return login.ErrGettingUserQuota
 		}
 		if limitReached {
+			ls.publishSignupQuotaReached(ctx, cmd)
 			return login.ErrUsersQuotaReached
 		}
+		ls.checkUserQuotaSoftLimit(ctx, extUser, state)
+
+		ls.capAutoCreateOrgRoles(extUser, state)
 
-		cmd.Result, err = ls.createUser(extUser)
+		_, endCreate := ls.startSpan(ctx, state, spanCreate, extUser)
+		if state.disabledMatch != nil && ls.OnCollisionWithDisabled == CollisionWithDisabledCreateNew {
+			cmd.Result, err = ls.createReplacingDisabled(ctx, state.disabledMatch, extUser)
+		} else {
+			cmd.Result, err = ls.createUserWithOutbox(ctx, extUser)
+		}
+		endCreate(err)
 		if err != nil {
-			return err
+			return upsertErr(login.UpsertPhaseCreate, err)
+		}
+		ls.quotaCache.invalidate()
+		state.userCreated = true
+		ls.audit(ctx, state, cmd.Result, extUser, login.LoginAuditChange{Action: login.AuditUserCreated})
+
+		if cmd.Result.IsDisabled {
+			if err := ls.setDisableSource(ctx, cmd.Result.Id, login.DisableSourceIdPInactive); err != nil {
+				return upsertErr(login.UpsertPhaseCreate, err)
+			}
 		}
 
 		if extUser.AuthModule != "" {
 			cmd2 := &models.SetAuthInfoCommand{
-				UserId:     cmd.Result.Id,
-				AuthModule: extUser.AuthModule,
-				AuthId:     extUser.AuthId,
-				OAuthToken: extUser.OAuthToken,
+				UserId:        cmd.Result.Id,
+				AuthModule:    extUser.AuthModule,
+				AuthId:        extUser.AuthId,
+				OAuthToken:    ls.transformToken(extUser.OAuthToken),
+				ProviderLabel: extUser.ProviderLabel,
+				RawClaims:     ls.rawClaimsSnapshot(extUser),
 			}
-			if err := ls.AuthInfoService.SetAuthInfo(ctx, cmd2); err != nil {
-				return err
+			tokenCtx, endToken := ls.startSpan(ctx, state, spanToken, extUser)
+			err := ls.setAuthInfo(tokenCtx, cmd2, state)
+			endToken(err)
+			if err != nil {
+				return upsertErr(login.UpsertPhaseAuthInfo, ls.rollbackCreatedUser(ctx, cmd.Result, extUser, err))
 			}
 		}
-	} else {
-		cmd.Result = user
 
-		err = ls.updateUser(ctx, cmd.Result, extUser)
-		if err != nil {
+		if err := ls.bootstrapFirstUser(ctx, cmd.Result, extUser); err != nil {
+			return upsertErr(login.UpsertPhaseCreate, err)
+		}
+
+		if err := ls.addUserLabels(ctx, cmd.Result, extUser); err != nil {
+			return upsertErr(login.UpsertPhaseCreate, err)
+		}
+	} else {
+		if err := ls.checkUserLock(ctx, user.Id); err != nil {
 			return err
 		}
+		if err := ls.checkSoftDeleted(ctx, user); err != nil {
+			return err
+		}
+
+		cmd.Result = user
+		if ls.MaintenanceMode {
+			return ls.maintenanceLogin(ctx, user, extUser)
+		}
 
-		// Always persist the latest token at log-in
-		if extUser.AuthModule != "" && extUser.OAuthToken != nil {
-			err = ls.updateUserAuth(ctx, cmd.Result, extUser)
+		unchanged := fingerprint != "" && !cmd.ForceFullSync && ls.fingerprints.matches(user.Id, fingerprint)
+		if !unchanged {
+			before := *user
+			updateCtx, endUpdate := ls.startSpan(ctx, state, spanUpdate, extUser)
+			err = ls.updateUser(updateCtx, cmd.Result, extUser)
+			endUpdate(err)
 			if err != nil {
-				return err
+				return upsertErr(login.UpsertPhaseUpdate, err)
+			}
+			if user.Login != before.Login || user.Email != before.Email || user.Name != before.Name {
+				ls.audit(ctx, state, user, extUser, login.LoginAuditChange{Action: login.AuditUserUpdated})
+			}
+		}
+
+		if err := ls.checkAuthInfoInvariant(ctx, user, extUser); err != nil {
+			return upsertErr(login.UpsertPhaseAuthInfo, err)
+		}
+
+		// Always persist the latest token, provider label and claims at log-in
+		if extUser.AuthModule != "" && (extUser.OAuthToken != nil || extUser.ProviderLabel != "" || extUser.RawClaims != nil || ls.ClearTokenOnNil) {
+			tokenCtx, endToken := ls.startSpan(ctx, state, spanToken, extUser)
+			err = ls.updateUserAuth(tokenCtx, cmd.Result, extUser)
+			endToken(err)
+			if err != nil && (extUser.OAuthToken == nil || !ls.tokenPersistFailed(cmd.Result.Id, extUser.AuthModule, state, err)) {
+				return upsertErr(login.UpsertPhaseAuthInfo, err)
 			}
 		}
 
-		if extUser.AuthModule == models.AuthModuleLDAP && user.IsDisabled {
+		if err := ls.addUserLabels(ctx, cmd.Result, extUser); err != nil {
+			return upsertErr(login.UpsertPhaseUpdate, err)
+		}
+
+		if unchanged {
+			logger.Debug("Skipping sync of unchanged external user", "userId", user.Id)
+			ls.rememberLastKnownGood(extUser, cmd.Result)
+			ls.setAccessSummary(ctx, cmd)
+			return nil
+		}
+
+		if extUser.AuthModule == models.AuthModuleLDAP && user.IsDisabled && extUser.IsActive == nil {
 			// Re-enable user when it found in LDAP
-			if err := ls.SQLStore.DisableUser(ctx, &models.DisableUserCommand{UserId: cmd.Result.Id, IsDisabled: false}); err != nil {
-				return err
+			if err := ls.reenableLDAPUser(ctx, cmd.Result); err != nil {
+				return upsertErr(login.UpsertPhaseUpdate, err)
 			}
 		}
-	}
 
-	if err := ls.syncOrgRoles(ctx, cmd.Result, extUser); err != nil {
-		return err
+		if err := ls.syncActive(ctx, cmd.Result, extUser); err != nil {
+			return upsertErr(login.UpsertPhaseUpdate, err)
+		}
 	}
 
-	// Sync isGrafanaAdmin permission
-	if extUser.IsGrafanaAdmin != nil && *extUser.IsGrafanaAdmin != cmd.Result.IsAdmin {
-		if err := ls.SQLStore.UpdateUserPermissions(cmd.Result.Id, *extUser.IsGrafanaAdmin); err != nil {
+	async := state.asyncOrgSync && !state.observing
+	if !async {
+		if err := ls.syncOrgs(ctx, cmd.Result, extUser, state); err != nil {
+			ls.recordSyncStatus(ctx, cmd.Result, state, err)
 			return err
 		}
 	}
 
-	if ls.TeamSync != nil {
-		err := ls.TeamSync(cmd.Result, extUser)
-		if err != nil {
-			return err
-		}
+	// Sync isGrafanaAdmin permission
+	if err := ls.syncGrafanaAdmin(ctx, cmd.Result, extUser, state); err != nil {
+		return upsertErr(login.UpsertPhaseUpdate, err)
+	}
+
+	if err := ls.verifySync(ctx, cmd.Result, state); err != nil {
+		ls.recordSyncStatus(ctx, cmd.Result, state, err)
+		return upsertErr(login.UpsertPhaseUpdate, err)
 	}
 
+	if err := ls.syncPreferences(ctx, cmd.Result, extUser); err != nil {
+		return upsertErr(login.UpsertPhaseUpdate, err)
+	}
+
+	if async {
+		ls.orgSyncs.enqueue(ls, cmd.Result, extUser, state.userCreated, cmd.Overrides)
+	} else if err := ls.syncTeams(ctx, cmd.Result, extUser, state); err != nil {
+		ls.recordSyncStatus(ctx, cmd.Result, state, err)
+		return err
+	}
+
+	ls.rememberLastKnownGood(extUser, cmd.Result)
+	if fingerprint != "" && !async && !state.observing && len(state.result.DeferredOrgIds) == 0 {
+		ls.fingerprints.set(cmd.Result.Id, fingerprint)
+	}
+	if !async {
+		ls.recordSyncStatus(ctx, cmd.Result, state, nil)
+	}
+	ls.setAccessSummary(ctx, cmd)
+
+	return nil
+}
+
+// syncOrgs syncs the org roles and custom roles of the user.
+func (ls *Implementation) syncOrgs(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, state *syncState) error {
+	orgSyncCtx, endOrgSync := ls.startSpan(ctx, state, spanOrgSync, extUser)
+	err := ls.syncOrgRoles(orgSyncCtx, user, extUser, state)
+	if err == nil && !state.observing {
+		err = ls.syncCustomRoles(orgSyncCtx, user, extUser)
+	}
+	endOrgSync(err)
+	if err != nil {
+		return upsertErr(login.UpsertPhaseOrgSync, err)
+	}
+	return nil
+}
+
+// syncTeams runs team sync for the user, if configured.
+func (ls *Implementation) syncTeams(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, state *syncState) error {
+	if ls.TeamSync == nil && ls.GroupTeamMapper == nil {
+		return nil
+	}
+
+	teamSyncCtx, endTeamSync := ls.startSpan(ctx, state, spanTeamSync, extUser)
+	err := ls.ensureTeamSyncOrgMembership(teamSyncCtx, user, extUser)
+	if err == nil && ls.GroupTeamMapper != nil {
+		err = ls.GroupTeamMapper.syncTeams(teamSyncCtx, user, extUser)
+	}
+	if err == nil && ls.TeamSync != nil {
+		err = ls.TeamSync(user, extUser)
+	}
+	if err == nil {
+		ls.shadowSyncTeams(teamSyncCtx, user, extUser, state)
+	}
+	endTeamSync(err)
+	if err != nil {
+		return upsertErr(login.UpsertPhaseTeamSync, err)
+	}
 	return nil
 }
 
+// externalOrgRole returns the role of the external user in an org, falling back
+// to DefaultRolePerOrg when the identity provider sent the org without a role.
+func (ls *Implementation) externalOrgRole(extUser *models.ExternalUserInfo, orgID int64) models.RoleType {
+	role, ok := extUser.OrgRoles[orgID]
+	if ok && role == "" {
+		return ls.DefaultRolePerOrg[orgID]
+	}
+	return role
+}
+
+// selectDefaultOrg picks the default org of a user among its org roles, using
+// DefaultOrgSelector if set and the lowest org id otherwise.
+func (ls *Implementation) selectDefaultOrg(orgRoles map[int64]models.RoleType) int64 {
+	if ls.DefaultOrgSelector != nil {
+		return ls.DefaultOrgSelector(orgRoles)
+	}
+
+	var selected int64
+	for orgID := range orgRoles {
+		if selected == 0 || orgID < selected {
+			selected = orgID
+		}
+	}
+	return selected
+}
+
+func upsertErr(phase login.UpsertPhase, err error) error {
+	return &login.ErrUpsertUser{Phase: phase, Err: err}
+}
+
 func (ls *Implementation) DisableExternalUser(ctx context.Context, username string) error {
 	// Check if external user exist in Grafana
 	userQuery := &models.GetExternalUserInfoByLoginQuery{
@@ -166,7 +748,7 @@ func (ls *Implementation) DisableExternalUser(ctx context.Context, username stri
 		)
 		return err
 	}
-	return nil
+	return ls.setDisableSource(ctx, userInfo.UserId, login.DisableSourceLDAPAbsence)
 }
 
 // SetTeamSyncFunc sets the function received through args as the team sync function.
@@ -174,15 +756,109 @@ func (ls *Implementation) SetTeamSyncFunc(teamSyncFunc login.TeamSyncFunc) {
 	ls.TeamSync = teamSyncFunc
 }
 
-func (ls *Implementation) createUser(extUser *models.ExternalUserInfo) (*models.User, error) {
+func (ls *Implementation) createUser(ctx context.Context, extUser *models.ExternalUserInfo) (*models.User, error) {
+	name, err := ls.uniqueDisplayName(ctx, extUser, 0)
+	if err != nil {
+		return nil, err
+	}
 	cmd := models.CreateUserCommand{
 		Login:        extUser.Login,
 		Email:        extUser.Email,
-		Name:         extUser.Name,
+		Name:         name,
 		SkipOrgSetup: len(extUser.OrgRoles) > 0,
+		IsDisabled:   extUser.IsActive != nil && !*extUser.IsActive,
+	}
+	if cmd.Login == "" && ls.LoginTemplate != "" {
+		login, err := ls.renderLoginTemplate(extUser)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Login = login
+	}
+	if ls.UserFactory != nil {
+		ls.UserFactory(extUser, &cmd)
+	}
+	ls.capAutoCreateDefaultRole(&cmd)
+	if err := ls.setInitialPassword(extUser, &cmd); err != nil {
+		return nil, err
 	}
 
-	return ls.CreateUser(cmd)
+	user, err := ls.SQLStore.CreateUser(ctx, cmd)
+	if !errors.Is(err, models.ErrUserAlreadyExists) {
+		return user, err
+	}
+	// other logins can't resolve a collision on the email
+	if taken, lookupErr := ls.emailTaken(ctx, cmd.Email); lookupErr != nil {
+		return nil, lookupErr
+	} else if taken {
+		return nil, err
+	}
+
+	switch ls.LoginCollisionStrategy {
+	case LoginCollisionSuffix:
+		login := cmd.Login
+		for i := 1; i <= maxLoginSuffixAttempts && errors.Is(err, models.ErrUserAlreadyExists); i++ {
+			cmd.Login = fmt.Sprintf("%s-%d", login, i)
+			user, err = ls.SQLStore.CreateUser(ctx, cmd)
+		}
+	case LoginCollisionUseEmail:
+		if cmd.Email != "" && cmd.Email != cmd.Login {
+			cmd.Login = cmd.Email
+			user, err = ls.SQLStore.CreateUser(ctx, cmd)
+		}
+	}
+
+	if err == nil {
+		logger.Debug("Resolved login collision", "login", extUser.Login, "newLogin", user.Login)
+	}
+	return user, err
+}
+
+// handleMissingEmail applies the MissingEmailPolicy to a user about to be created without an email.
+func (ls *Implementation) handleMissingEmail(extUser *models.ExternalUserInfo) error {
+	switch ls.MissingEmailPolicy {
+	case MissingEmailReject:
+		return login.ErrMissingEmail
+	case MissingEmailPlaceholder:
+		name := extUser.Login
+		if name == "" {
+			name = extUser.AuthId
+		}
+		if name == "" {
+			return login.ErrMissingEmail
+		}
+		extUser.Email = fmt.Sprintf("%s@%s", name, placeholderEmailDomain)
+		logger.Debug("Using placeholder email for user without email", "login", extUser.Login, "email", extUser.Email)
+	}
+	return nil
+}
+
+// emailTaken reports whether a user with the email exists.
+func (ls *Implementation) emailTaken(ctx context.Context, email string) (bool, error) {
+	if email == "" {
+		return false, nil
+	}
+	query := &models.GetUserByEmailQuery{Email: email}
+	err := ls.SQLStore.GetUserByEmail(ctx, query)
+	if errors.Is(err, models.ErrUserNotFound) {
+		return false, nil
+	}
+	return err == nil && query.Result != nil, err
+}
+
+// rollbackCreatedUser removes a user whose auth info couldn't be set, so that the
+// next login doesn't find an account without linkage.
+func (ls *Implementation) rollbackCreatedUser(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, cause error) error {
+	linkErr := &login.ErrAuthInfoLinkFailed{UserId: user.Id, AuthModule: extUser.AuthModule, Err: cause}
+
+	if err := ls.SQLStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: user.Id}); err != nil {
+		logger.Error("Failed to roll back user without auth info", "id", user.Id, "authmodule", extUser.AuthModule, "error", err)
+		return linkErr
+	}
+	ls.quotaCache.invalidate()
+
+	linkErr.RolledBack = true
+	return linkErr
 }
 
 func (ls *Implementation) updateUser(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
@@ -191,23 +867,34 @@ func (ls *Implementation) updateUser(ctx context.Context, user *models.User, ext
 		UserId: user.Id,
 	}
 
+	fields := ls.SyncableUserFields
+	if fields == 0 {
+		fields = AllUserFields
+	}
+
 	needsUpdate := false
-	if extUser.Login != "" && extUser.Login != user.Login {
+	if fields&UserFieldLogin != 0 && extUser.Login != "" && extUser.Login != user.Login {
 		updateCmd.Login = extUser.Login
 		user.Login = extUser.Login
 		needsUpdate = true
 	}
 
-	if extUser.Email != "" && extUser.Email != user.Email {
+	if fields&UserFieldEmail != 0 && extUser.Email != "" && extUser.Email != user.Email {
 		updateCmd.Email = extUser.Email
 		user.Email = extUser.Email
 		needsUpdate = true
 	}
 
-	if extUser.Name != "" && extUser.Name != user.Name {
-		updateCmd.Name = extUser.Name
-		user.Name = extUser.Name
-		needsUpdate = true
+	if fields&UserFieldName != 0 && extUser.Name != "" && extUser.Name != user.Name {
+		name, err := ls.uniqueDisplayName(ctx, extUser, user.Id)
+		if err != nil {
+			return err
+		}
+		if name != user.Name {
+			updateCmd.Name = name
+			user.Name = name
+			needsUpdate = true
+		}
 	}
 
 	if !needsUpdate {
@@ -218,19 +905,50 @@ func (ls *Implementation) updateUser(ctx context.Context, user *models.User, ext
 	return ls.SQLStore.UpdateUser(ctx, updateCmd)
 }
 
+func (ls *Implementation) transformToken(token *oauth2.Token) *oauth2.Token {
+	if token == nil || ls.TokenTransformer == nil {
+		return token
+	}
+	return ls.TokenTransformer(token)
+}
+
 func (ls *Implementation) updateUserAuth(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
 	updateCmd := &models.UpdateAuthInfoCommand{
-		AuthModule: extUser.AuthModule,
-		AuthId:     extUser.AuthId,
-		UserId:     user.Id,
-		OAuthToken: extUser.OAuthToken,
+		AuthModule:    extUser.AuthModule,
+		AuthId:        extUser.AuthId,
+		UserId:        user.Id,
+		OAuthToken:    ls.transformToken(extUser.OAuthToken),
+		ProviderLabel: extUser.ProviderLabel,
+		RawClaims:     ls.rawClaimsSnapshot(extUser),
+		// a consent revoked since the last login leaves no token
+		ClearOAuthToken: ls.ClearTokenOnNil,
 	}
 
 	logger.Debug("Updating user_auth info", "user_id", user.Id)
 	return ls.AuthInfoService.UpdateAuthInfo(ctx, updateCmd)
 }
 
-func (ls *Implementation) syncOrgRoles(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+// ensureTeamSyncOrgMembership adds users that didn't get any org roles from the
+// identity provider to the team sync org, so that team sync can add them to teams.
+func (ls *Implementation) ensureTeamSyncOrgMembership(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	if ls.TeamSyncOrgID == 0 || len(extUser.OrgRoles) > 0 {
+		return nil
+	}
+
+	role := ls.TeamSyncOrgRole
+	if role == "" {
+		role = models.ROLE_VIEWER
+	}
+
+	logger.Debug("Adding user to team sync organization", "id", user.Id, "orgId", ls.TeamSyncOrgID, "role", role)
+	cmd := &models.AddOrgUserCommand{UserId: user.Id, OrgId: ls.TeamSyncOrgID, Role: role}
+	if err := ls.SQLStore.AddOrgUser(ctx, cmd); err != nil && !errors.Is(err, models.ErrOrgUserAlreadyAdded) {
+		return err
+	}
+	return nil
+}
+
+func (ls *Implementation) syncOrgRoles(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, state *syncState) error {
 	logger.Debug("Syncing organization roles", "id", user.Id, "extOrgRoles", extUser.OrgRoles)
 
 	// don't sync org roles if none is specified
@@ -239,65 +957,171 @@ func (ls *Implementation) syncOrgRoles(ctx context.Context, user *models.User, e
 		return nil
 	}
 
+	// memberships of a user created in this call were just written
 	orgsQuery := &models.GetUserOrgListQuery{UserId: user.Id}
-	if err := ls.SQLStore.GetUserOrgList(ctx, orgsQuery); err != nil {
+	if err := ls.readStore(ctx, state.userCreated).GetUserOrgList(ctx, orgsQuery); err != nil {
 		return err
 	}
 
+	return ls.syncOrgRolesWithCurrent(ctx, user, extUser, orgsQuery.Result, state)
+}
+
+// syncOrgRolesWithCurrent syncs org roles against the supplied current memberships
+// of the user instead of reading them, for callers that already fetched them.
+func (ls *Implementation) syncOrgRolesWithCurrent(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, current []*models.UserOrgDTO, state *syncState) error {
+	skippedOrgIds, err := ls.normalizeOrgRoles(extUser, state)
+	if err != nil {
+		return err
+	}
+	if len(extUser.OrgRoles) == 0 {
+		logger.Debug("Not syncing organization roles since external user doesn't have any valid ones")
+		return nil
+	}
+	ls.resolveEmptyDefaultOrgRole(user, extUser, current)
+	ls.applyRoleCeilings(extUser, state)
+
+	if state.observing {
+		ls.observeOrgRoles(user, extUser, current, state)
+		return nil
+	}
+
+	ls.deferBeyondTopN(user, extUser, current, state)
+
 	handledOrgIds := map[int64]bool{}
 	deleteOrgIds := []int64{}
 
 	// update existing org roles
-	for _, org := range orgsQuery.Result {
+	for _, org := range current {
 		handledOrgIds[org.OrgId] = true
+		if err := ls.checkOrgRoleInvariant(user, org); err != nil {
+			return err
+		}
+		if skippedOrgIds[org.OrgId] {
+			continue
+		}
 
-		extRole := extUser.OrgRoles[org.OrgId]
+		extRole := ls.externalOrgRole(extUser, org.OrgId)
+		if skip, err := ls.managedByOtherConnector(ctx, user, extUser, org.OrgId, extRole, state); err != nil {
+			return err
+		} else if skip {
+			continue
+		}
+
+		expires := orgRoleExpiry(extUser, org.OrgId)
 		if extRole == "" {
 			deleteOrgIds = append(deleteOrgIds, org.OrgId)
-		} else if extRole != org.Role {
+		} else if extRole != org.Role || !expiryEqual(expires, org.Expires) {
+			if state.deferOrg(org.OrgId) || state.deferUpgrade(org.OrgId, org.Role, extRole) {
+				continue
+			}
+			if !ls.rolePolicyAllows(ctx, user, extUser, org.OrgId, extRole, state) {
+				continue
+			}
+
 			// update role
-			cmd := &models.UpdateOrgUserCommand{OrgId: org.OrgId, UserId: user.Id, Role: extRole}
-			if err := ls.SQLStore.UpdateOrgUser(ctx, cmd); err != nil {
+			cmd := &models.UpdateOrgUserCommand{OrgId: org.OrgId, UserId: user.Id, Role: extRole, Expires: expires, UpdateExpires: true}
+			if err := ls.withOrgRoleSynced(ctx, cmd.UserId, cmd.OrgId, cmd.Role, func(ctx context.Context) error {
+				return ls.SQLStore.UpdateOrgUser(ctx, cmd)
+			}); err != nil {
+				return err
+			}
+			state.orgRoleWritten(org.OrgId, extRole)
+			ls.audit(ctx, state, user, extUser, login.LoginAuditChange{Action: login.AuditOrgRoleUpdated, OrgId: org.OrgId, Role: extRole})
+			if err := ls.recordOrgRoleProvenance(ctx, user, extUser, org.OrgId, extRole); err != nil {
 				return err
 			}
 		}
 	}
 
 	// add any new org roles
-	for orgId, orgRole := range extUser.OrgRoles {
+	for orgId := range extUser.OrgRoles {
 		if _, exists := handledOrgIds[orgId]; exists {
 			continue
 		}
+		orgRole := ls.externalOrgRole(extUser, orgId)
+		if orgRole == "" {
+			continue
+		}
+		if state.deferOrg(orgId) || state.deferUpgrade(orgId, "", orgRole) {
+			continue
+		}
+		if !ls.rolePolicyAllows(ctx, user, extUser, orgId, orgRole, state) {
+			continue
+		}
 
 		// add role
-		cmd := &models.AddOrgUserCommand{UserId: user.Id, Role: orgRole, OrgId: orgId}
-		err := ls.SQLStore.AddOrgUser(ctx, cmd)
-		if err != nil && !errors.Is(err, models.ErrOrgNotFound) {
+		cmd := &models.AddOrgUserCommand{UserId: user.Id, Role: orgRole, OrgId: orgId, Expires: orgRoleExpiry(extUser, orgId)}
+		err := ls.withOrgRoleSynced(ctx, cmd.UserId, cmd.OrgId, cmd.Role, func(ctx context.Context) error {
+			return ls.SQLStore.AddOrgUser(ctx, cmd)
+		})
+		if errors.Is(err, models.ErrOrgNotFound) {
+			err = ls.stashPendingOrgRole(ctx, user.Id, orgId, orgRole)
+		} else if err == nil {
+			state.result.OrgRolesAdded = append(state.result.OrgRolesAdded, models.OrgRoleAdded{OrgId: orgId, Role: orgRole})
+			state.orgRoleWritten(orgId, orgRole)
+			ls.audit(ctx, state, user, extUser, login.LoginAuditChange{Action: login.AuditOrgRoleAdded, OrgId: orgId, Role: orgRole})
+			err = ls.recordOrgRoleProvenance(ctx, user, extUser, orgId, orgRole)
+		}
+		if err != nil {
 			return err
 		}
 	}
 
+	if ls.isSuspiciousRemoval(ctx, user, extUser, current, deleteOrgIds) {
+		state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("skipped removal of %d of %d organizations, the organization roles look truncated", len(deleteOrgIds), len(current)))
+		deleteOrgIds = nil
+	}
+
 	// delete any removed org roles
 	for _, orgId := range deleteOrgIds {
+		if state.deferOrg(orgId) {
+			continue
+		}
 		logger.Debug("Removing user's organization membership as part of syncing with OAuth login",
 			"userId", user.Id, "orgId", orgId)
 		cmd := &models.RemoveOrgUserCommand{OrgId: orgId, UserId: user.Id}
-		if err := ls.SQLStore.RemoveOrgUser(ctx, cmd); err != nil {
-			if errors.Is(err, models.ErrLastOrgAdmin) {
+		if err := ls.withOrgRoleSynced(ctx, cmd.UserId, cmd.OrgId, "", func(ctx context.Context) error {
+			return ls.SQLStore.RemoveOrgUser(ctx, cmd)
+		}); err != nil {
+			// a refused removal is only undone by rolling back a transaction of
+			// the caller, so it fails the sync then
+			if errors.Is(err, models.ErrLastOrgAdmin) && !inTransaction(ctx) {
 				logger.Error(err.Error(), "userId", cmd.UserId, "orgId", cmd.OrgId)
 				continue
 			}
 
 			return err
 		}
-	}
+		state.orgRoleWritten(orgId, "")
+		ls.audit(ctx, state, user, extUser, login.LoginAuditChange{Action: login.AuditOrgRoleRemoved, OrgId: orgId})
 
-	// update user's default org if needed
-	if _, ok := extUser.OrgRoles[user.OrgId]; !ok {
-		for orgId := range extUser.OrgRoles {
-			user.OrgId = orgId
-			break
+		if err := ls.deleteOrgRoleProvenance(ctx, user.Id, orgId); err != nil {
+			return err
 		}
+	}
+
+	if state.deadlineDeferred > 0 {
+		logger.Warn("Soft deadline exceeded, deferring organization role sync", "userId", user.Id, "deferredOrgIds", state.result.DeferredOrgIds)
+		state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("soft deadline exceeded, deferred role sync for %d organizations", state.deadlineDeferred))
+	}
+
+	assigned, err := ls.handleOrgless(ctx, user, state)
+	if err != nil {
+		return err
+	}
+	if assigned {
+		user.OrgId = ls.OrglessOrgID
+		return ls.SQLStore.SetUsingOrg(ctx, &models.SetUsingOrgCommand{
+			UserId: user.Id,
+			OrgId:  user.OrgId,
+		})
+	}
+
+	// update user's default org if needed, an empty role for it is a removal
+	// unless resolved by resolveEmptyDefaultOrgRole
+	synced := ls.syncedOrgRoles(extUser)
+	if _, ok := synced[user.OrgId]; !ok && len(synced) > 0 {
+		user.OrgId = ls.selectDefaultOrg(synced)
 
 		return ls.SQLStore.SetUsingOrg(ctx, &models.SetUsingOrgCommand{
 			UserId: user.Id,
@@ -5,16 +5,21 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/go-kit/log"
 	"github.com/grafana/grafana/pkg/bus"
 	"github.com/grafana/grafana/pkg/infra/log/level"
 	"github.com/grafana/grafana/pkg/models"
+	loginpkg "github.com/grafana/grafana/pkg/services/login"
 	"github.com/grafana/grafana/pkg/services/login/logintest"
 	"github.com/grafana/grafana/pkg/services/quota"
 	"github.com/grafana/grafana/pkg/services/sqlstore/mockstore"
+	"github.com/grafana/grafana/pkg/setting"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
 )
 
 func Test_syncOrgRoles_doesNotBreakWhenTryingToRemoveLastOrgAdmin(t *testing.T) {
@@ -34,7 +39,7 @@ func Test_syncOrgRoles_doesNotBreakWhenTryingToRemoveLastOrgAdmin(t *testing.T)
 		SQLStore:        store,
 	}
 
-	err := login.syncOrgRoles(context.Background(), &user, &externalUser)
+	err := login.syncOrgRoles(context.Background(), &user, &externalUser, login.newSyncState())
 	require.NoError(t, err)
 }
 
@@ -59,7 +64,7 @@ func Test_syncOrgRoles_whenTryingToRemoveLastOrgLogsError(t *testing.T) {
 		SQLStore:        store,
 	}
 
-	err := login.syncOrgRoles(context.Background(), &user, &externalUser)
+	err := login.syncOrgRoles(context.Background(), &user, &externalUser, login.newSyncState())
 	require.NoError(t, err)
 	assert.Contains(t, buf.String(), models.ErrLastOrgAdmin.Error())
 }
@@ -114,6 +119,628 @@ func Test_teamSync(t *testing.T) {
 	})
 }
 
+func Test_createUser_loginCollision(t *testing.T) {
+	existing := &models.User{Login: "alice", Email: "alice@other.org"}
+	extUser := &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org", Name: "Alice"}
+
+	t.Run("Fail returns ErrUserAlreadyExists", func(t *testing.T) {
+		login := Implementation{SQLStore: newFakeStore(existing), LoginCollisionStrategy: LoginCollisionFail}
+
+		_, err := login.createUser(context.Background(), extUser)
+		require.ErrorIs(t, err, models.ErrUserAlreadyExists)
+	})
+
+	t.Run("Suffix appends a numeric suffix", func(t *testing.T) {
+		store := newFakeStore(existing, &models.User{Login: "alice-1", Email: "alice1@other.org"})
+		login := Implementation{SQLStore: store, LoginCollisionStrategy: LoginCollisionSuffix}
+
+		user, err := login.createUser(context.Background(), extUser)
+		require.NoError(t, err)
+		assert.Equal(t, "alice-2", user.Login)
+		assert.Equal(t, "alice@example.org", user.Email)
+	})
+
+	t.Run("email collisions aren't retried", func(t *testing.T) {
+		for _, strategy := range []LoginCollisionStrategy{LoginCollisionSuffix, LoginCollisionUseEmail} {
+			store := newFakeStore(&models.User{Login: "alice", Email: "alice@example.org"})
+			login := Implementation{SQLStore: store, LoginCollisionStrategy: strategy}
+
+			_, err := login.createUser(context.Background(), extUser)
+			require.ErrorIs(t, err, models.ErrUserAlreadyExists)
+			assert.Len(t, store.createUserCmds, 1)
+		}
+	})
+
+	t.Run("UseEmail falls back to the email as login", func(t *testing.T) {
+		login := Implementation{SQLStore: newFakeStore(existing), LoginCollisionStrategy: LoginCollisionUseEmail}
+
+		user, err := login.createUser(context.Background(), extUser)
+		require.NoError(t, err)
+		assert.Equal(t, "alice@example.org", user.Login)
+	})
+}
+
+func Test_createUser_userFactory(t *testing.T) {
+	externalUser := &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org", Name: "Alice"}
+
+	t.Run("defaults are kept without a factory", func(t *testing.T) {
+		login := Implementation{SQLStore: newFakeStore()}
+
+		user, err := login.createUser(context.Background(), externalUser)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", user.Login)
+		assert.Equal(t, "Alice", user.Name)
+		assert.Empty(t, user.Company)
+		assert.False(t, user.EmailVerified)
+	})
+
+	t.Run("factory sets additional fields", func(t *testing.T) {
+		login := Implementation{
+			SQLStore: newFakeStore(),
+			UserFactory: func(externalUser *models.ExternalUserInfo, cmd *models.CreateUserCommand) {
+				cmd.Company = "ACME"
+				cmd.EmailVerified = true
+			},
+		}
+
+		user, err := login.createUser(context.Background(), externalUser)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", user.Login)
+		assert.Equal(t, "ACME", user.Company)
+		assert.True(t, user.EmailVerified)
+	})
+}
+
+func Test_updateUser_syncableUserFields(t *testing.T) {
+	user := &models.User{Id: 1, Login: "alice", Email: "alice@old.org", Name: "Alice"}
+	store := newFakeStore(user)
+	login := Implementation{SQLStore: store, SyncableUserFields: UserFieldEmail | UserFieldName}
+
+	extUser := &models.ExternalUserInfo{Login: "alice.smith", Email: "alice@new.org", Name: "Alice Smith"}
+	err := login.updateUser(context.Background(), user, extUser)
+	require.NoError(t, err)
+
+	require.Len(t, store.updateUserCmds, 1)
+	assert.Empty(t, store.updateUserCmds[0].Login)
+	assert.Equal(t, "alice", store.users[1].Login)
+	assert.Equal(t, "alice@new.org", store.users[1].Email)
+	assert.Equal(t, "Alice Smith", store.users[1].Name)
+
+	t.Run("no update when only excluded fields changed", func(t *testing.T) {
+		err := login.updateUser(context.Background(), user, &models.ExternalUserInfo{Login: "alice.other"})
+		require.NoError(t, err)
+		assert.Len(t, store.updateUserCmds, 1)
+		assert.Equal(t, "alice", user.Login)
+	})
+}
+
+func Test_UpsertUser_rollsBackCreatedUserWhenSetAuthInfoFails(t *testing.T) {
+	store := newFakeStore()
+	authInfoMock := &logintest.AuthInfoServiceFake{
+		ExpectedError:            models.ErrUserNotFound,
+		ExpectedSetAuthInfoError: errors.New("set auth info failed"),
+	}
+	login := Implementation{
+		QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+		AuthInfoService: authInfoMock,
+		SQLStore:        store,
+	}
+
+	cmd := &models.UpsertUserCommand{
+		SignupAllowed: true,
+		ExternalUser:  &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", AuthId: "abc", Login: "alice", Email: "alice@example.org"},
+	}
+	err := login.UpsertUser(context.Background(), cmd)
+
+	var linkErr *loginpkg.ErrAuthInfoLinkFailed
+	require.True(t, errors.As(err, &linkErr))
+	assert.True(t, linkErr.RolledBack)
+	assert.Equal(t, "oauth_generic_oauth", linkErr.AuthModule)
+	assert.ErrorIs(t, err, authInfoMock.ExpectedSetAuthInfoError)
+
+	require.NotNil(t, authInfoMock.LatestSetAuthInfoCmd)
+	assert.Equal(t, linkErr.UserId, store.LatestUserId)
+	assert.Empty(t, store.users, "created user should have been removed")
+}
+
+func Test_UpsertUser_errorPhases(t *testing.T) {
+	cause := errors.New("boom")
+
+	tests := []struct {
+		desc  string
+		setup func(login *Implementation, store *fakeStore, authInfo *logintest.AuthInfoServiceFake, extUser *models.ExternalUserInfo)
+		phase loginpkg.UpsertPhase
+	}{
+		{
+			desc: "lookup",
+			setup: func(login *Implementation, store *fakeStore, authInfo *logintest.AuthInfoServiceFake, extUser *models.ExternalUserInfo) {
+				authInfo.ExpectedError = cause
+			},
+			phase: loginpkg.UpsertPhaseLookup,
+		},
+		{
+			desc: "create",
+			setup: func(login *Implementation, store *fakeStore, authInfo *logintest.AuthInfoServiceFake, extUser *models.ExternalUserInfo) {
+				authInfo.ExpectedError = models.ErrUserNotFound
+				store.insertUser(&models.User{Login: extUser.Login, Email: extUser.Email})
+			},
+			phase: loginpkg.UpsertPhaseCreate,
+		},
+		{
+			desc: "auth info",
+			setup: func(login *Implementation, store *fakeStore, authInfo *logintest.AuthInfoServiceFake, extUser *models.ExternalUserInfo) {
+				authInfo.ExpectedError = models.ErrUserNotFound
+				authInfo.ExpectedSetAuthInfoError = cause
+			},
+			phase: loginpkg.UpsertPhaseAuthInfo,
+		},
+		{
+			desc: "org sync",
+			setup: func(login *Implementation, store *fakeStore, authInfo *logintest.AuthInfoServiceFake, extUser *models.ExternalUserInfo) {
+				authInfo.ExpectedUser = store.insertUser(&models.User{Login: extUser.Login, Email: extUser.Email})
+				store.addOrg(1)
+				store.ExpectedSetUsingOrgError = cause
+				extUser.OrgRoles = map[int64]models.RoleType{1: models.ROLE_VIEWER}
+			},
+			phase: loginpkg.UpsertPhaseOrgSync,
+		},
+		{
+			desc: "team sync",
+			setup: func(login *Implementation, store *fakeStore, authInfo *logintest.AuthInfoServiceFake, extUser *models.ExternalUserInfo) {
+				authInfo.ExpectedUser = store.insertUser(&models.User{Login: extUser.Login, Email: extUser.Email})
+				login.TeamSync = func(user *models.User, externalUser *models.ExternalUserInfo) error {
+					return cause
+				}
+			},
+			phase: loginpkg.UpsertPhaseTeamSync,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			store := newFakeStore()
+			authInfo := &logintest.AuthInfoServiceFake{}
+			login := Implementation{
+				QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+				AuthInfoService: authInfo,
+				SQLStore:        store,
+			}
+			extUser := &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", AuthId: "abc", Login: "alice", Email: "alice@example.org"}
+			tt.setup(&login, store, authInfo, extUser)
+
+			err := login.UpsertUser(context.Background(), &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: extUser})
+
+			var upsertErr *loginpkg.ErrUpsertUser
+			require.True(t, errors.As(err, &upsertErr))
+			assert.Equal(t, tt.phase, upsertErr.Phase)
+			assert.ErrorIs(t, err, &loginpkg.ErrUpsertUser{Phase: tt.phase})
+			assert.ErrorIs(t, err, &loginpkg.ErrUpsertUser{})
+			if tt.phase != loginpkg.UpsertPhaseCreate {
+				assert.ErrorIs(t, err, cause)
+			}
+		})
+	}
+
+	t.Run("policy rejections are returned as is", func(t *testing.T) {
+		login := Implementation{
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound},
+			SQLStore:        newFakeStore(),
+		}
+		cmd := &models.UpsertUserCommand{
+			ReqContext:   &models.ReqContext{Logger: logger},
+			ExternalUser: &models.ExternalUserInfo{Login: "alice"},
+		}
+
+		err := login.UpsertUser(context.Background(), cmd)
+		assert.Equal(t, loginpkg.ErrSignupNotAllowed, err)
+		assert.False(t, errors.Is(err, &loginpkg.ErrUpsertUser{}))
+	})
+}
+
+func Test_UpsertUser_tokenTransformer(t *testing.T) {
+	stripIDToken := func(token *oauth2.Token) *oauth2.Token {
+		return &oauth2.Token{AccessToken: token.AccessToken, RefreshToken: token.RefreshToken, Expiry: token.Expiry, TokenType: token.TokenType}
+	}
+	newToken := func() *oauth2.Token {
+		token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh"}
+		return token.WithExtra(map[string]interface{}{"id_token": "id"})
+	}
+
+	t.Run("create", func(t *testing.T) {
+		authInfoMock := &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}}
+		login := Implementation{
+			QuotaService:     &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService:  authInfoMock,
+			SQLStore:         newFakeStore(),
+			TokenTransformer: stripIDToken,
+		}
+
+		cmd := &models.UpsertUserCommand{
+			SignupAllowed: true,
+			ExternalUser:  &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", AuthId: "abc", Login: "alice", OAuthToken: newToken()},
+		}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		token := authInfoMock.LatestSetAuthInfoCmd.OAuthToken
+		assert.Equal(t, "access", token.AccessToken)
+		assert.Nil(t, token.Extra("id_token"))
+	})
+
+	t.Run("update", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice"}
+		authInfoMock := &logintest.AuthInfoServiceFake{ExpectedUser: user}
+		login := Implementation{
+			AuthInfoService:  authInfoMock,
+			SQLStore:         newFakeStore(user),
+			TokenTransformer: stripIDToken,
+		}
+
+		cmd := &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", AuthId: "abc", Login: "alice", OAuthToken: newToken()},
+		}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		token := authInfoMock.LatestUpdateAuthInfoCmd.OAuthToken
+		assert.Equal(t, "refresh", token.RefreshToken)
+		assert.Nil(t, token.Extra("id_token"))
+	})
+
+	t.Run("tokens are persisted as is by default", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice"}
+		authInfoMock := &logintest.AuthInfoServiceFake{ExpectedUser: user}
+		login := Implementation{AuthInfoService: authInfoMock, SQLStore: newFakeStore(user)}
+
+		token := newToken()
+		cmd := &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", AuthId: "abc", Login: "alice", OAuthToken: token},
+		}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		assert.Same(t, token, authInfoMock.LatestUpdateAuthInfoCmd.OAuthToken)
+	})
+}
+
+func Test_UpsertUser_authModuleAndAuthId(t *testing.T) {
+	tests := []struct {
+		desc       string
+		authModule string
+		authId     string
+		expectErr  bool
+	}{
+		{desc: "auth id without auth module is rejected", authId: "abc", expectErr: true},
+		{desc: "auth module and auth id", authModule: "oauth_generic_oauth", authId: "abc"},
+		{desc: "auth module without auth id", authModule: "oauth_generic_oauth"},
+		{desc: "neither auth module nor auth id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			authInfoMock := &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}}
+			store := newFakeStore()
+			login := Implementation{
+				QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+				AuthInfoService: authInfoMock,
+				SQLStore:        store,
+			}
+
+			cmd := &models.UpsertUserCommand{
+				SignupAllowed: true,
+				ExternalUser:  &models.ExternalUserInfo{AuthModule: tt.authModule, AuthId: tt.authId, Login: "alice"},
+			}
+			err := login.UpsertUser(context.Background(), cmd)
+
+			if !tt.expectErr {
+				require.NoError(t, err)
+				return
+			}
+			var authErr *loginpkg.ErrMissingAuthModule
+			require.True(t, errors.As(err, &authErr))
+			assert.Equal(t, "abc", authErr.AuthId)
+			assert.Empty(t, store.users, "no user should be created")
+		})
+	}
+}
+
+func Test_teamSync_addsTeamsOnlyUserToTeamSyncOrg(t *testing.T) {
+	user := &models.User{Id: 1, Login: "alice"}
+	store := newFakeStore(user)
+	store.addOrg(5)
+
+	login := Implementation{
+		QuotaService:    &quota.QuotaService{},
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		SQLStore:        store,
+		TeamSyncOrgID:   5,
+		TeamSync: func(user *models.User, externalUser *models.ExternalUserInfo) error {
+			store.calls = append(store.calls, "TeamSync")
+			return nil
+		},
+	}
+
+	cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", Groups: []string{"devs"}}}
+	require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+	assert.Equal(t, []string{"AddOrgUser:5", "TeamSync"}, store.calls)
+	assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[5][1])
+
+	t.Run("existing membership is kept", func(t *testing.T) {
+		store.calls = nil
+		store.orgUsers[5][1] = models.ROLE_EDITOR
+
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+		assert.Equal(t, []string{"AddOrgUser:5", "TeamSync"}, store.calls)
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[5][1])
+	})
+}
+
+func Test_UpsertUser_softDeadlineDefersOrgSync(t *testing.T) {
+	clk := clock.NewMock()
+	user := &models.User{Id: 1, Login: "alice", OrgId: 1}
+	store := &slowStore{fakeStore: newFakeStore(user), delay: time.Second, clock: clk}
+	store.addOrgUser(1, 1, models.ROLE_VIEWER)
+	for orgID := int64(2); orgID <= 5; orgID++ {
+		store.addOrg(orgID)
+	}
+
+	login := Implementation{
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		SQLStore:        store,
+		SoftDeadline:    1500 * time.Millisecond,
+		Clock:           clk,
+	}
+
+	cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", OrgRoles: map[int64]models.RoleType{
+		1: models.ROLE_VIEWER,
+		2: models.ROLE_EDITOR,
+		3: models.ROLE_EDITOR,
+		4: models.ROLE_EDITOR,
+		5: models.ROLE_EDITOR,
+	}}}
+	require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+	assert.Len(t, store.calls, 2, "only the org additions within the deadline should happen")
+	assert.Len(t, cmd.SyncResult.DeferredOrgIds, 2)
+	assert.NotEmpty(t, cmd.SyncResult.Warnings)
+	for _, orgID := range cmd.SyncResult.DeferredOrgIds {
+		assert.NotContains(t, store.orgUsers[orgID], int64(1))
+	}
+}
+
+func Test_UpsertUser_missingEmail(t *testing.T) {
+	newCmd := func() *models.UpsertUserCommand {
+		return &models.UpsertUserCommand{
+			SignupAllowed: true,
+			ExternalUser:  &models.ExternalUserInfo{Login: "alice"},
+		}
+	}
+	newLogin := func(policy MissingEmailPolicy) (*Implementation, *fakeStore) {
+		store := newFakeStore()
+		return &Implementation{
+			QuotaService:       &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService:    &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound},
+			SQLStore:           store,
+			MissingEmailPolicy: policy,
+		}, store
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		login, store := newLogin(MissingEmailReject)
+
+		err := login.UpsertUser(context.Background(), newCmd())
+		require.ErrorIs(t, err, loginpkg.ErrMissingEmail)
+		assert.Empty(t, store.users)
+	})
+
+	t.Run("placeholder", func(t *testing.T) {
+		login, store := newLogin(MissingEmailPlaceholder)
+
+		cmd := newCmd()
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+		assert.Equal(t, "alice@noreply.invalid", cmd.Result.Email)
+		assert.Len(t, store.users, 1)
+	})
+}
+
+func Test_syncOrgRoles_defaultRolePerOrg(t *testing.T) {
+	user := &models.User{Id: 1, OrgId: 1}
+	store := newFakeStore(user)
+	store.addOrgUser(1, user.Id, models.ROLE_EDITOR)
+	store.addOrgUser(2, user.Id, models.ROLE_EDITOR)
+	store.addOrg(3)
+	store.addOrg(4)
+
+	login := Implementation{
+		SQLStore:          store,
+		DefaultRolePerOrg: map[int64]models.RoleType{1: models.ROLE_VIEWER, 3: models.ROLE_VIEWER},
+	}
+	externalUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{
+		1: "",
+		2: "",
+		3: "",
+		4: "",
+	}}
+
+	require.NoError(t, login.syncOrgRoles(context.Background(), user, externalUser, login.newSyncState()))
+
+	assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][user.Id], "existing membership should get the org default")
+	assert.NotContains(t, store.orgUsers[2], user.Id, "membership without a default should be removed")
+	assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[3][user.Id], "new membership should get the org default")
+	assert.NotContains(t, store.orgUsers[4], user.Id, "new membership without a default should be skipped")
+}
+
+func Test_syncOrgRoles_recordsAddedOrgRoles(t *testing.T) {
+	user := &models.User{Id: 1, OrgId: 1}
+	store := newFakeStore(user)
+	store.addOrgUser(1, user.Id, models.ROLE_VIEWER)
+	store.addOrgUser(2, user.Id, models.ROLE_VIEWER)
+	store.addOrg(3)
+	store.addOrg(4)
+
+	login := Implementation{SQLStore: store}
+	externalUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{
+		1: models.ROLE_VIEWER,
+		2: models.ROLE_EDITOR,
+		3: models.ROLE_ADMIN,
+		4: models.ROLE_VIEWER,
+		5: models.ROLE_VIEWER,
+	}}
+
+	state := login.newSyncState()
+	require.NoError(t, login.syncOrgRoles(context.Background(), user, externalUser, state))
+
+	// org 1 is unchanged, org 2 is updated and org 5 doesn't exist
+	assert.ElementsMatch(t, []models.OrgRoleAdded{
+		{OrgId: 3, Role: models.ROLE_ADMIN},
+		{OrgId: 4, Role: models.ROLE_VIEWER},
+	}, state.result.OrgRolesAdded)
+}
+
+func Test_syncOrgRolesWithCurrent_doesNotReadMemberships(t *testing.T) {
+	user := &models.User{Id: 1, OrgId: 1}
+	store := newFakeStore(user)
+	store.addOrgUser(1, user.Id, models.ROLE_VIEWER)
+	store.addOrg(2)
+	reads := &[]string{}
+
+	login := Implementation{SQLStore: &readRecorder{fakeStore: store, name: "primary", reads: reads}}
+	externalUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: models.ROLE_VIEWER}}
+	current := []*models.UserOrgDTO{{OrgId: 1, Role: models.ROLE_VIEWER}}
+
+	require.NoError(t, login.syncOrgRolesWithCurrent(context.Background(), user, externalUser, current, login.newSyncState()))
+
+	assert.Empty(t, *reads)
+	assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][user.Id])
+	assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[2][user.Id])
+}
+
+func Test_syncOrgRoles_defaultOrgSelection(t *testing.T) {
+	orgRoles := map[int64]models.RoleType{
+		2: models.ROLE_VIEWER,
+		3: models.ROLE_ADMIN,
+		4: models.ROLE_EDITOR,
+	}
+	setup := func() (*models.User, *fakeStore) {
+		user := &models.User{Id: 1, OrgId: 1}
+		store := newFakeStore(user)
+		store.addOrgUser(1, user.Id, models.ROLE_VIEWER)
+		for orgID := range orgRoles {
+			store.addOrg(orgID)
+		}
+		return user, store
+	}
+
+	t.Run("lowest org id by default", func(t *testing.T) {
+		user, store := setup()
+		login := Implementation{SQLStore: store}
+
+		require.NoError(t, login.syncOrgRoles(context.Background(), user, &models.ExternalUserInfo{OrgRoles: orgRoles}, login.newSyncState()))
+		assert.Equal(t, int64(2), store.users[user.Id].OrgId)
+	})
+
+	t.Run("selector preferring admin orgs", func(t *testing.T) {
+		user, store := setup()
+		login := Implementation{
+			SQLStore: store,
+			DefaultOrgSelector: func(orgRoles map[int64]models.RoleType) int64 {
+				var selected int64
+				for orgID, role := range orgRoles {
+					if role == models.ROLE_ADMIN && (selected == 0 || orgID < selected) {
+						selected = orgID
+					}
+				}
+				return selected
+			},
+		}
+
+		require.NoError(t, login.syncOrgRoles(context.Background(), user, &models.ExternalUserInfo{OrgRoles: orgRoles}, login.newSyncState()))
+		assert.Equal(t, int64(3), store.users[user.Id].OrgId)
+	})
+}
+
+func Test_UpsertUser_providerLabel(t *testing.T) {
+	t.Run("label is stored for new users", func(t *testing.T) {
+		authInfoService := &logintest.AuthInfoServiceFake{}
+		login := &Implementation{
+			SQLStore:        newFakeStore(),
+			QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService: &notFoundAuthInfoService{AuthInfoServiceFake: authInfoService},
+		}
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_okta", Login: "alice", ProviderLabel: "Okta"}}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		require.NotNil(t, authInfoService.LatestSetAuthInfoCmd)
+		assert.Equal(t, "Okta", authInfoService.LatestSetAuthInfoCmd.ProviderLabel)
+	})
+
+	t.Run("label is updated without a token", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice"}
+		authInfoService := &logintest.AuthInfoServiceFake{ExpectedUser: user}
+		login := &Implementation{SQLStore: newFakeStore(user), AuthInfoService: authInfoService}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_okta", Login: "alice", ProviderLabel: "Okta (EU)"}}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		require.NotNil(t, authInfoService.LatestUpdateAuthInfoCmd)
+		assert.Equal(t, "Okta (EU)", authInfoService.LatestUpdateAuthInfoCmd.ProviderLabel)
+		assert.Nil(t, authInfoService.LatestUpdateAuthInfoCmd.OAuthToken)
+	})
+}
+
+func Test_UpsertUser_clearTokenOnNil(t *testing.T) {
+	setup := func(clear bool) (*Implementation, *logintest.AuthInfoServiceFake) {
+		user := &models.User{Id: 1, Login: "alice"}
+		authInfoService := &logintest.AuthInfoServiceFake{ExpectedUser: user}
+		return &Implementation{SQLStore: newFakeStore(user), AuthInfoService: authInfoService, ClearTokenOnNil: clear}, authInfoService
+	}
+
+	t.Run("token is kept without a token by default", func(t *testing.T) {
+		login, authInfoService := setup(false)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_okta", Login: "alice"}}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		assert.Nil(t, authInfoService.LatestUpdateAuthInfoCmd)
+	})
+
+	t.Run("token is cleared without a token", func(t *testing.T) {
+		login, authInfoService := setup(true)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_okta", Login: "alice"}}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		require.NotNil(t, authInfoService.LatestUpdateAuthInfoCmd)
+		assert.Nil(t, authInfoService.LatestUpdateAuthInfoCmd.OAuthToken)
+		assert.True(t, authInfoService.LatestUpdateAuthInfoCmd.ClearOAuthToken)
+	})
+
+	t.Run("a new token is still stored", func(t *testing.T) {
+		login, authInfoService := setup(true)
+		token := &oauth2.Token{AccessToken: "access"}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_okta", Login: "alice", OAuthToken: token}}
+		require.NoError(t, login.UpsertUser(context.Background(), cmd))
+
+		require.NotNil(t, authInfoService.LatestUpdateAuthInfoCmd)
+		assert.Same(t, token, authInfoService.LatestUpdateAuthInfoCmd.OAuthToken)
+	})
+}
+
+func Test_now(t *testing.T) {
+	t.Run("uses the configured clock", func(t *testing.T) {
+		clk := clock.NewMock()
+		login := Implementation{Clock: clk}
+
+		clk.Add(time.Hour)
+		assert.Equal(t, clk.Now(), login.now())
+	})
+
+	t.Run("falls back to real time", func(t *testing.T) {
+		login := Implementation{}
+		assert.WithinDuration(t, time.Now(), login.now(), time.Minute)
+	})
+}
+
 func createSimpleUser() models.User {
 	user := models.User{
 		Id: 1,
@@ -0,0 +1,62 @@
+package loginservice
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// maxLoginLength is the length of the user login column.
+const maxLoginLength = 190
+
+// renderLoginTemplate renders LoginTemplate for extUser. The template can use
+// the AuthModule, AuthId, Email, Name and ProviderLabel fields. Empty fields
+// are missing, rendering fails if the template uses one so that users don't
+// get logins like "ext-". The rendered login is validated with validateLogin.
+func (ls *Implementation) renderLoginTemplate(extUser *models.ExternalUserInfo) (string, error) {
+	tmpl, err := template.New("login").Option("missingkey=error").Parse(ls.LoginTemplate)
+	if err != nil {
+		return "", fmt.Errorf("invalid login template: %w", err)
+	}
+
+	data := map[string]string{}
+	for field, value := range map[string]string{
+		"AuthModule":    extUser.AuthModule,
+		"AuthId":        extUser.AuthId,
+		"Email":         extUser.Email,
+		"Name":          extUser.Name,
+		"ProviderLabel": extUser.ProviderLabel,
+	} {
+		if value != "" {
+			data[field] = value
+		}
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render login template: %w", err)
+	}
+	if err := validateLogin(b.String()); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// validateLogin returns login.ErrInvalidLogin if userLogin is empty, too long,
+// or contains whitespace or control characters.
+func validateLogin(userLogin string) error {
+	if userLogin == "" {
+		return fmt.Errorf("%w: empty login", login.ErrInvalidLogin)
+	}
+	if len(userLogin) > maxLoginLength {
+		return fmt.Errorf("%w: %q is longer than %d characters", login.ErrInvalidLogin, userLogin, maxLoginLength)
+	}
+	if strings.IndexFunc(userLogin, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return fmt.Errorf("%w: %q contains whitespace or control characters", login.ErrInvalidLogin, userLogin)
+	}
+	return nil
+}
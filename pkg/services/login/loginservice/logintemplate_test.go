@@ -0,0 +1,62 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_loginTemplate(t *testing.T) {
+	setup := func(loginTemplate string) (*Implementation, *fakeStore) {
+		store := newFakeStore()
+		return &Implementation{
+			SQLStore:        store,
+			AuthInfoService: &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}},
+			QuotaService:    &fakeQuotaService{},
+			LoginTemplate:   loginTemplate,
+		}, store
+	}
+	upsert := func(loginService *Implementation, extUser *models.ExternalUserInfo) (*models.User, error) {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: extUser}
+		err := loginService.UpsertUser(context.Background(), cmd)
+		return cmd.Result, err
+	}
+
+	t.Run("users without a login get the rendered login", func(t *testing.T) {
+		loginService, _ := setup("ext-{{.AuthId}}")
+
+		user, err := upsert(loginService, &models.ExternalUserInfo{AuthModule: "oauth_okta", AuthId: "00u1a2b3", Email: "alice@example.org"})
+		require.NoError(t, err)
+		assert.Equal(t, "ext-00u1a2b3", user.Login)
+	})
+
+	t.Run("explicit logins are kept", func(t *testing.T) {
+		loginService, _ := setup("ext-{{.AuthId}}")
+
+		user, err := upsert(loginService, &models.ExternalUserInfo{AuthModule: "oauth_okta", AuthId: "00u1a2b3", Login: "alice"})
+		require.NoError(t, err)
+		assert.Equal(t, "alice", user.Login)
+	})
+
+	t.Run("templates using a missing field fail", func(t *testing.T) {
+		loginService, store := setup("{{.AuthModule}}-{{.AuthId}}")
+
+		_, err := upsert(loginService, &models.ExternalUserInfo{Email: "alice@example.org"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `map has no entry for key "AuthModule"`)
+		assert.Empty(t, store.createUserCmds)
+	})
+
+	t.Run("invalid rendered logins fail", func(t *testing.T) {
+		loginService, store := setup("{{.Name}}")
+
+		_, err := upsert(loginService, &models.ExternalUserInfo{Name: "Alice Liddell", Email: "alice@example.org"})
+		require.ErrorIs(t, err, login.ErrInvalidLogin)
+		assert.Empty(t, store.createUserCmds)
+	})
+}
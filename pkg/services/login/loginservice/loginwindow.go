@@ -0,0 +1,110 @@
+package loginservice
+
+import (
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// LoginWindow restricts the times at which the external users it applies to
+// may log in.
+type LoginWindow struct {
+	// AuthModules and Groups select the users the window applies to, users of
+	// one of the auth modules or in one of the groups. Groups are compared case
+	// insensitively. A window without either applies to all external users.
+	AuthModules []string
+	Groups      []string
+	// Days are the days logins are allowed on, every day when empty.
+	Days []time.Weekday
+	// Ranges are the times of day logins are allowed at, the whole day when
+	// empty.
+	Ranges []TimeRange
+	// Location is the timezone of Days and Ranges, UTC when nil.
+	Location *time.Location
+}
+
+// TimeRange is a range of the day as offsets from midnight, the start is
+// inclusive and the end exclusive. A range ending before it starts spans
+// midnight and belongs to the day it starts on.
+type TimeRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+func (w *LoginWindow) appliesTo(extUser *models.ExternalUserInfo) bool {
+	if len(w.AuthModules) == 0 && len(w.Groups) == 0 {
+		return true
+	}
+	for _, module := range w.AuthModules {
+		if module == extUser.AuthModule {
+			return true
+		}
+	}
+	for _, group := range w.Groups {
+		for _, userGroup := range extUser.Groups {
+			if strings.EqualFold(group, userGroup) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *LoginWindow) contains(t time.Time) bool {
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	sinceMidnight := t.Sub(midnight)
+	yesterday := midnight.AddDate(0, 0, -1).Weekday()
+
+	if len(w.Ranges) == 0 {
+		return w.allowsDay(t.Weekday())
+	}
+	for _, r := range w.Ranges {
+		switch {
+		case r.Start <= r.End:
+			if sinceMidnight >= r.Start && sinceMidnight < r.End && w.allowsDay(t.Weekday()) {
+				return true
+			}
+		case sinceMidnight >= r.Start:
+			if w.allowsDay(t.Weekday()) {
+				return true
+			}
+		case sinceMidnight < r.End:
+			if w.allowsDay(yesterday) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (w *LoginWindow) allowsDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLoginWindows returns login.ErrOutsideLoginWindow if extUser logs in
+// outside of a login window that applies to them.
+func (ls *Implementation) checkLoginWindows(extUser *models.ExternalUserInfo) error {
+	now := ls.now()
+	for i := range ls.LoginWindows {
+		w := &ls.LoginWindows[i]
+		if w.appliesTo(extUser) && !w.contains(now) {
+			return &login.ErrOutsideLoginWindow{Login: extUser.Login, AuthModule: extUser.AuthModule}
+		}
+	}
+	return nil
+}
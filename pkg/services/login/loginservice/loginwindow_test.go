@@ -0,0 +1,92 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_loginWindows(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	businessHours := LoginWindow{
+		Groups:   []string{"contractors"},
+		Days:     []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Ranges:   []TimeRange{{Start: 9 * time.Hour, End: 17 * time.Hour}},
+		Location: berlin,
+	}
+
+	upsert := func(at time.Time, window LoginWindow, groups ...string) error {
+		clk := clock.NewMock()
+		clk.Set(at)
+		user := &models.User{Id: 1, Login: "alice"}
+		loginService := &Implementation{
+			Clock:           clk,
+			SQLStore:        newFakeStore(user),
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			LoginWindows:    []LoginWindow{window},
+		}
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_okta", Login: "alice", Groups: groups}}
+		return loginService.UpsertUser(context.Background(), cmd)
+	}
+
+	// Wednesday
+	at := func(hour, min int) time.Time {
+		return time.Date(2022, 3, 2, hour, min, 0, 0, berlin)
+	}
+
+	t.Run("users inside the window log in", func(t *testing.T) {
+		require.NoError(t, upsert(at(9, 0), businessHours, "Contractors"))
+		require.NoError(t, upsert(at(16, 59), businessHours, "contractors"))
+	})
+
+	t.Run("users outside the window are rejected", func(t *testing.T) {
+		var windowErr *login.ErrOutsideLoginWindow
+		err := upsert(at(17, 0), businessHours, "contractors")
+		require.ErrorAs(t, err, &windowErr)
+		assert.Equal(t, "alice", windowErr.Login)
+		assert.Equal(t, "oauth_okta", windowErr.AuthModule)
+
+		require.ErrorAs(t, upsert(at(8, 59), businessHours, "contractors"), &windowErr)
+		// Saturday
+		require.ErrorAs(t, upsert(at(12, 0).AddDate(0, 0, 3), businessHours, "contractors"), &windowErr)
+	})
+
+	t.Run("the window uses its timezone", func(t *testing.T) {
+		// 10:00 in Berlin
+		require.NoError(t, upsert(time.Date(2022, 3, 2, 9, 0, 0, 0, time.UTC), businessHours, "contractors"))
+		// 18:00 in Berlin
+		require.Error(t, upsert(time.Date(2022, 3, 2, 17, 0, 0, 0, time.UTC), businessHours, "contractors"))
+	})
+
+	t.Run("users the window doesn't apply to aren't restricted", func(t *testing.T) {
+		require.NoError(t, upsert(at(22, 0), businessHours, "employees"))
+		require.NoError(t, upsert(at(22, 0), businessHours))
+
+		byModule := businessHours
+		byModule.Groups = nil
+		byModule.AuthModules = []string{"ldap"}
+		require.NoError(t, upsert(at(22, 0), byModule))
+		byModule.AuthModules = []string{"oauth_okta"}
+		require.Error(t, upsert(at(22, 0), byModule))
+	})
+
+	t.Run("ranges spanning midnight belong to the day they start on", func(t *testing.T) {
+		nightShift := LoginWindow{
+			Days:     []time.Weekday{time.Wednesday},
+			Ranges:   []TimeRange{{Start: 22 * time.Hour, End: 6 * time.Hour}},
+			Location: berlin,
+		}
+		require.NoError(t, upsert(at(23, 0), nightShift))
+		require.NoError(t, upsert(at(5, 0).AddDate(0, 0, 1), nightShift))
+		require.Error(t, upsert(at(5, 0), nightShift))
+		require.Error(t, upsert(at(23, 0).AddDate(0, 0, 1), nightShift))
+	})
+}
@@ -0,0 +1,123 @@
+package loginservice
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/grafana/grafana/pkg/infra/log"
+)
+
+// LogRedaction controls how the logins, emails and display names of users
+// appear in the logs of the service. User ids are always logged as is.
+type LogRedaction int32
+
+const (
+	// LogRedactionNone logs them as is (default).
+	LogRedactionNone LogRedaction = iota
+	// LogRedactionMask keeps only their first character, and the domain of
+	// emails, e.g. "a***@example.org".
+	LogRedactionMask
+	// LogRedactionHash replaces them with a short hash, so that the log lines
+	// of a user can still be correlated.
+	LogRedactionHash
+)
+
+var logRedaction int32
+
+// SetLogRedaction sets the redaction of the logs of the service. The logger is
+// shared, so it applies to all instances.
+func SetLogRedaction(redaction LogRedaction) {
+	atomic.StoreInt32(&logRedaction, int32(redaction))
+}
+
+func currentLogRedaction() LogRedaction {
+	return LogRedaction(atomic.LoadInt32(&logRedaction))
+}
+
+// redactedLogKeys are the keys whose values are redacted.
+var redactedLogKeys = map[string]bool{
+	"login":       true,
+	"newLogin":    true,
+	"email":       true,
+	"alias":       true,
+	"displayName": true,
+}
+
+// emailPattern finds the emails in the other values and in messages, e.g. in
+// errors.
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// redactingLogger redacts the values of redactedLogKeys, and emails anywhere,
+// according to SetLogRedaction.
+type redactingLogger struct {
+	*log.ConcreteLogger
+}
+
+func (l *redactingLogger) Debug(msg string, ctx ...interface{}) {
+	msg, ctx = redactLog(msg, ctx)
+	l.ConcreteLogger.Debug(msg, ctx...)
+}
+
+func (l *redactingLogger) Info(msg string, ctx ...interface{}) {
+	msg, ctx = redactLog(msg, ctx)
+	l.ConcreteLogger.Info(msg, ctx...)
+}
+
+func (l *redactingLogger) Warn(msg string, ctx ...interface{}) {
+	msg, ctx = redactLog(msg, ctx)
+	l.ConcreteLogger.Warn(msg, ctx...)
+}
+
+func (l *redactingLogger) Error(msg string, ctx ...interface{}) {
+	msg, ctx = redactLog(msg, ctx)
+	l.ConcreteLogger.Error(msg, ctx...)
+}
+
+func redactLog(msg string, ctx []interface{}) (string, []interface{}) {
+	redaction := currentLogRedaction()
+	if redaction == LogRedactionNone {
+		return msg, ctx
+	}
+
+	redacted := make([]interface{}, len(ctx))
+	copy(redacted, ctx)
+	for i := 1; i < len(redacted); i += 2 {
+		if key, ok := redacted[i-1].(string); ok && redactedLogKeys[key] {
+			redacted[i] = redactLogValue(redaction, fmt.Sprint(redacted[i]))
+			continue
+		}
+		switch v := redacted[i].(type) {
+		case string:
+			redacted[i] = redactEmails(redaction, v)
+		case error:
+			redacted[i] = redactEmails(redaction, v.Error())
+		}
+	}
+	return redactEmails(redaction, msg), redacted
+}
+
+func redactEmails(redaction LogRedaction, s string) string {
+	return emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		return redactLogValue(redaction, email)
+	})
+}
+
+func redactLogValue(redaction LogRedaction, value string) string {
+	if value == "" {
+		return value
+	}
+	if redaction == LogRedactionHash {
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:6])
+	}
+
+	local, domain := value, ""
+	if at := strings.LastIndex(value, "@"); at > 0 {
+		local, domain = value[:at], value[at:]
+	}
+	return string([]rune(local)[:1]) + "***" + domain
+}
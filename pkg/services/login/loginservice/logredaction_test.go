@@ -0,0 +1,71 @@
+package loginservice
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/grafana/pkg/infra/log/level"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogRedaction(t *testing.T) {
+	capture := func(t *testing.T, redaction LogRedaction) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		logger.Swap(level.NewFilter(log.NewLogfmtLogger(buf), level.AllowAll()))
+		SetLogRedaction(redaction)
+		t.Cleanup(func() { SetLogRedaction(LogRedactionNone) })
+		return buf
+	}
+
+	t.Run("logs are redacted when redaction is on", func(t *testing.T) {
+		buf := capture(t, LogRedactionMask)
+
+		logger.Warn("Lookup failed", "userId", 42, "login", "alice", "email", "alice@example.org",
+			"error", fmt.Errorf("email %q matches different users", "alice@example.org"))
+
+		out := buf.String()
+		assert.NotContains(t, out, "alice@example.org")
+		assert.NotContains(t, out, "login=alice")
+		assert.Contains(t, out, "userId=42")
+		assert.Contains(t, out, "login=a***")
+		assert.Contains(t, out, "email=a***@example.org")
+		assert.Contains(t, out, `matches different users`)
+	})
+
+	t.Run("emails of the service are masked", func(t *testing.T) {
+		buf := capture(t, LogRedactionMask)
+		loginService := &Implementation{MissingEmailPolicy: MissingEmailPlaceholder}
+
+		extUser := &models.ExternalUserInfo{Login: "alice"}
+		require.NoError(t, loginService.handleMissingEmail(extUser))
+
+		assert.Contains(t, buf.String(), "Using placeholder email")
+		assert.NotContains(t, buf.String(), extUser.Email)
+		assert.NotContains(t, buf.String(), "alice")
+	})
+
+	t.Run("hashes are stable", func(t *testing.T) {
+		buf := capture(t, LogRedactionHash)
+
+		logger.Info("first", "email", "alice@example.org")
+		logger.Info("second", "error", "no user with email alice@example.org")
+
+		out := buf.String()
+		hash := redactLogValue(LogRedactionHash, "alice@example.org")
+		assert.NotContains(t, out, "alice")
+		assert.Equal(t, 2, bytes.Count(buf.Bytes(), []byte(hash)))
+	})
+
+	t.Run("logs are kept as is by default", func(t *testing.T) {
+		buf := capture(t, LogRedactionNone)
+
+		logger.Info("Found user", "userId", 42, "login", "alice", "email", "alice@example.org")
+
+		assert.Contains(t, buf.String(), "login=alice")
+		assert.Contains(t, buf.String(), "email=alice@example.org")
+	})
+}
@@ -0,0 +1,29 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// maintenanceLogin logs in an existing user under MaintenanceMode. Only the
+// OAuth token of the user is refreshed, the user info, org and team
+// memberships and server admin flag are left as is.
+func (ls *Implementation) maintenanceLogin(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	logger.Debug("Provisioning is paused, not syncing user", "userId", user.Id)
+	if extUser.AuthModule == "" || extUser.OAuthToken == nil {
+		return nil
+	}
+
+	cmd := &models.UpdateAuthInfoCommand{
+		AuthModule: extUser.AuthModule,
+		AuthId:     extUser.AuthId,
+		UserId:     user.Id,
+		OAuthToken: ls.transformToken(extUser.OAuthToken),
+	}
+	if err := ls.AuthInfoService.UpdateAuthInfo(ctx, cmd); err != nil {
+		return upsertErr(login.UpsertPhaseAuthInfo, err)
+	}
+	return nil
+}
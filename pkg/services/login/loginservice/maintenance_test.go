@@ -0,0 +1,143 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func Test_UpsertUser_maintenanceMode(t *testing.T) {
+	isAdmin := true
+
+	t.Run("existing users log in without being synced", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice", Email: "alice@example.org", Name: "Alice"}
+		store := newFakeStore(user)
+		store.addOrgUser(1, 1, models.ROLE_VIEWER)
+		authInfoService := &logintest.AuthInfoServiceFake{ExpectedUser: user}
+		loginService := &Implementation{
+			SQLStore:        store,
+			AuthInfoService: authInfoService,
+			MaintenanceMode: true,
+		}
+		teamSynced := false
+		loginService.TeamSync = func(user *models.User, externalUser *models.ExternalUserInfo) error {
+			teamSynced = true
+			return nil
+		}
+
+		token := &oauth2.Token{AccessToken: "new-token"}
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			AuthModule:     "oauth_generic",
+			AuthId:         "1",
+			Login:          "alice",
+			Email:          "alice@example.org",
+			Name:           "Alice Smith",
+			OAuthToken:     token,
+			OrgRoles:       map[int64]models.RoleType{1: models.ROLE_ADMIN, 2: models.ROLE_EDITOR},
+			IsGrafanaAdmin: &isAdmin,
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, user, cmd.Result)
+		assert.Empty(t, store.updateUserCmds)
+		assert.Empty(t, store.calls)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+		assert.False(t, user.IsAdmin)
+		assert.False(t, teamSynced)
+
+		require.NotNil(t, authInfoService.LatestUpdateAuthInfoCmd)
+		assert.Equal(t, token, authInfoService.LatestUpdateAuthInfoCmd.OAuthToken)
+		assert.Empty(t, authInfoService.LatestUpdateAuthInfoCmd.ProviderLabel)
+	})
+
+	t.Run("auth info isn't written without a token", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice"}
+		authInfoService := &logintest.AuthInfoServiceFake{ExpectedUser: user}
+		loginService := &Implementation{
+			SQLStore:        newFakeStore(user),
+			AuthInfoService: authInfoService,
+			MaintenanceMode: true,
+		}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_generic", AuthId: "1", Login: "alice", ProviderLabel: "Okta"}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Nil(t, authInfoService.LatestUpdateAuthInfoCmd)
+	})
+
+	t.Run("new users are rejected", func(t *testing.T) {
+		store := newFakeStore()
+		loginService := &Implementation{
+			SQLStore:        store,
+			QuotaService:    &fakeQuotaService{},
+			AuthInfoService: &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}},
+			MaintenanceMode: true,
+		}
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "bob", Email: "bob@example.org"}}
+		err := loginService.UpsertUser(context.Background(), cmd)
+		require.ErrorIs(t, err, login.ErrProvisioningPaused)
+		assert.Empty(t, store.createUserCmds)
+	})
+
+	t.Run("hooks don't run", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			authInfoService login.AuthInfoService
+			err             error
+		}{
+			"existing user": {authInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: &models.User{Id: 1, Login: "alice"}}},
+			"new user":      {authInfoService: &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}}, err: login.ErrProvisioningPaused},
+		} {
+			hookCalled := false
+			loginService := &Implementation{
+				SQLStore:        newFakeStore(&models.User{Id: 1, Login: "alice"}),
+				AuthInfoService: tc.authInfoService,
+				MaintenanceMode: true,
+				BeforeUpsert: []BeforeUpsertHook{func(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+					hookCalled = true
+					return nil
+				}},
+			}
+
+			cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "alice"}}
+			err := loginService.UpsertUser(context.Background(), cmd)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err, name)
+			} else {
+				require.NoError(t, err, name)
+			}
+			assert.False(t, hookCalled, name)
+		}
+	})
+
+	t.Run("users found by their details aren't linked", func(t *testing.T) {
+		ctx := context.Background()
+		loginService, sqlStore := newSQLLoginService(t)
+		loginService.MaintenanceMode = true
+		local, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org"})
+		require.NoError(t, err)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic",
+			AuthId:     "alice-sub",
+			Login:      "alice",
+			Email:      "alice@example.org",
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		assert.Equal(t, local.Id, cmd.Result.Id)
+
+		query := &models.GetAuthInfoQuery{AuthModule: "oauth_generic", AuthId: "alice-sub"}
+		require.ErrorIs(t, loginService.AuthInfoService.GetAuthInfo(ctx, query), models.ErrUserNotFound)
+
+		loginService.MaintenanceMode = false
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		require.NoError(t, loginService.AuthInfoService.GetAuthInfo(ctx, query))
+		assert.Equal(t, local.Id, query.Result.UserId)
+	})
+}
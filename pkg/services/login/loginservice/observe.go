@@ -0,0 +1,39 @@
+package loginservice
+
+import (
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// observeOrgRoles records the org role changes syncOrgRoles would make, without
+// making them.
+func (ls *Implementation) observeOrgRoles(user *models.User, extUser *models.ExternalUserInfo, current []*models.UserOrgDTO, state *syncState) {
+	changes := []models.ObservedSyncChange{}
+	handledOrgIds := map[int64]bool{}
+
+	for _, org := range current {
+		handledOrgIds[org.OrgId] = true
+		extRole := ls.externalOrgRole(extUser, org.OrgId)
+		if extRole == "" {
+			changes = append(changes, models.ObservedSyncChange{Action: models.ObservedRemoveOrgUser, OrgId: org.OrgId, Role: org.Role})
+		} else if extRole != org.Role {
+			changes = append(changes, models.ObservedSyncChange{Action: models.ObservedUpdateOrgUser, OrgId: org.OrgId, Role: extRole})
+		}
+	}
+
+	for orgID := range extUser.OrgRoles {
+		if handledOrgIds[orgID] {
+			continue
+		}
+		if role := ls.externalOrgRole(extUser, orgID); role != "" {
+			changes = append(changes, models.ObservedSyncChange{Action: models.ObservedAddOrgUser, OrgId: orgID, Role: role})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].OrgId < changes[j].OrgId })
+	for _, change := range changes {
+		logger.Info("Observe only, not applying organization role change", "userId", user.Id, "action", change.Action, "orgId", change.OrgId, "role", change.Role, "observeUntil", ls.ObserveUntil)
+	}
+	state.result.ObservedChanges = append(state.result.ObservedChanges, changes...)
+}
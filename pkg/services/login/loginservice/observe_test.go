@@ -0,0 +1,73 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_observeUntil(t *testing.T) {
+	cutover := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewMock()
+	clk.Set(cutover.Add(-time.Hour))
+
+	user := &models.User{Id: 1, Login: "alice", Name: "Alice", OrgId: 1}
+	store := newFakeStore(user)
+	store.addOrgUser(1, user.Id, models.ROLE_VIEWER)
+	store.addOrgUser(2, user.Id, models.ROLE_VIEWER)
+	store.addOrg(3)
+
+	loginService := &Implementation{
+		SQLStore:        store,
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		Clock:           clk,
+		ObserveUntil:    cutover,
+	}
+
+	upsert := func() *models.UpsertUserCommand {
+		isAdmin := true
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:          "alice",
+			Name:           "Alice Liddell",
+			IsGrafanaAdmin: &isAdmin,
+			OrgRoles: map[int64]models.RoleType{
+				1: models.ROLE_EDITOR,
+				3: models.ROLE_VIEWER,
+			},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		return cmd
+	}
+
+	t.Run("before the cutover changes are only observed", func(t *testing.T) {
+		cmd := upsert()
+
+		assert.Equal(t, []models.ObservedSyncChange{
+			{Action: models.ObservedUpdateOrgUser, OrgId: 1, Role: models.ROLE_EDITOR},
+			{Action: models.ObservedRemoveOrgUser, OrgId: 2, Role: models.ROLE_VIEWER},
+			{Action: models.ObservedAddOrgUser, OrgId: 3, Role: models.ROLE_VIEWER},
+			{Action: models.ObservedUpdateAdmin, IsAdmin: true},
+		}, cmd.SyncResult.ObservedChanges)
+
+		assert.Empty(t, store.calls)
+		assert.False(t, store.users[user.Id].IsAdmin)
+		assert.Equal(t, "Alice Liddell", store.users[user.Id].Name, "user info should still be updated")
+	})
+
+	t.Run("after the cutover changes are enforced", func(t *testing.T) {
+		clk.Set(cutover)
+		cmd := upsert()
+
+		assert.Empty(t, cmd.SyncResult.ObservedChanges)
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][user.Id])
+		assert.NotContains(t, store.orgUsers[2], user.Id)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[3][user.Id])
+		assert.True(t, store.users[user.Id].IsAdmin)
+	})
+}
@@ -0,0 +1,59 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// verifyOrgIds checks that the orgs of the external org roles exist, with a
+// single query for all of them. Unknown orgs are kept so that their roles can
+// still be deferred, see PendingRoleStore.
+func (ls *Implementation) verifyOrgIds(ctx context.Context, extUser *models.ExternalUserInfo, state *syncState) error {
+	if !ls.VerifyOrgIds || len(extUser.OrgRoles) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(extUser.OrgRoles))
+	for orgID := range extUser.OrgRoles {
+		// invalid ids are handled by normalizeOrgRoles
+		if orgID > 0 {
+			ids = append(ids, orgID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := &models.SearchOrgsQuery{Ids: ids}
+	if err := ls.readStore(ctx, false).SearchOrgs(ctx, query); err != nil {
+		return upsertErr(login.UpsertPhaseOrgSync, err)
+	}
+	found := make(map[int64]bool, len(query.Result))
+	for _, org := range query.Result {
+		found[org.Id] = true
+	}
+
+	var unknown []int64
+	for _, id := range ids {
+		if !found[id] {
+			unknown = append(unknown, id)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i] < unknown[j] })
+
+	if state.strictRoles {
+		return fmt.Errorf("%w: %v", models.ErrOrgNotFound, unknown)
+	}
+	logger.Warn("External user has roles for unknown organizations", "authmodule", extUser.AuthModule, "login", extUser.Login, "orgIds", unknown)
+	for _, id := range unknown {
+		state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("role for unknown organization %d", id))
+	}
+	return nil
+}
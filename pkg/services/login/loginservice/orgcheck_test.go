@@ -0,0 +1,66 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_verifyOrgIds(t *testing.T) {
+	setup := func(strict bool) (*Implementation, *fakeStore, *models.UpsertUserCommand) {
+		user := &models.User{Id: 1, Login: "alice"}
+		store := newFakeStore(user)
+		store.addOrg(1)
+		store.addOrg(2)
+		return &Implementation{
+			SQLStore:             store,
+			AuthInfoService:      &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			VerifyOrgIds:         true,
+			StrictRoleValidation: strict,
+		}, store, &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login: "alice",
+			OrgRoles: map[int64]models.RoleType{
+				1: models.ROLE_VIEWER,
+				2: models.ROLE_EDITOR,
+				7: models.ROLE_VIEWER,
+				9: models.ROLE_ADMIN,
+			},
+		}}
+	}
+
+	t.Run("unknown orgs are reported as warnings", func(t *testing.T) {
+		loginService, store, cmd := setup(false)
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Contains(t, cmd.SyncResult.Warnings, "role for unknown organization 7")
+		assert.Contains(t, cmd.SyncResult.Warnings, "role for unknown organization 9")
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][1])
+		assert.Equal(t, "SearchOrgs", store.calls[0], "orgs should be checked with a single query before syncing")
+		assert.NotContains(t, store.calls[1:], "SearchOrgs")
+	})
+
+	t.Run("unknown orgs fail the sync in strict mode", func(t *testing.T) {
+		loginService, store, cmd := setup(true)
+
+		err := loginService.UpsertUser(context.Background(), cmd)
+		require.ErrorIs(t, err, models.ErrOrgNotFound)
+		assert.Contains(t, err.Error(), "[7 9]")
+		assert.Empty(t, store.orgUsers[1], "nothing should be synced")
+	})
+
+	t.Run("known orgs pass in strict mode", func(t *testing.T) {
+		loginService, store, cmd := setup(true)
+		delete(cmd.ExternalUser.OrgRoles, 7)
+		delete(cmd.ExternalUser.OrgRoles, 9)
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		assert.Empty(t, cmd.SyncResult.Warnings)
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][1])
+	})
+}
@@ -0,0 +1,73 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// OrgDeletionReport describes the users affected by deleting an org.
+type OrgDeletionReport struct {
+	OrgId int64
+	Name  string
+	Users []*OrgDeletionUserImpact
+}
+
+// OrgDeletionUserImpact is a member of an org that would be deleted.
+type OrgDeletionUserImpact struct {
+	UserId int64
+	Login  string
+	Role   models.RoleType
+	// DefaultOrg is set when the org is the current org of the user.
+	DefaultOrg bool
+	// OnlyMembership is set when the user isn't a member of any other org.
+	OnlyMembership bool
+	// SoleAdmin is set when the user is the only admin of the org.
+	SoleAdmin bool
+}
+
+// OrgDeletionImpact reports the members of an org and flags the users for whom
+// it's their current org or only membership. It does not perform any writes.
+func (ls *Implementation) OrgDeletionImpact(ctx context.Context, orgID int64) (*OrgDeletionReport, error) {
+	store := ls.readStore(ctx, false)
+
+	orgQuery := &models.GetOrgByIdQuery{Id: orgID}
+	if err := store.GetOrgById(ctx, orgQuery); err != nil {
+		return nil, err
+	}
+
+	usersQuery := &models.GetOrgUsersQuery{OrgId: orgID}
+	if err := store.GetOrgUsers(ctx, usersQuery); err != nil {
+		return nil, err
+	}
+
+	admins := 0
+	for _, orgUser := range usersQuery.Result {
+		if orgUser.Role == string(models.ROLE_ADMIN) {
+			admins++
+		}
+	}
+
+	report := &OrgDeletionReport{OrgId: orgID, Name: orgQuery.Result.Name, Users: []*OrgDeletionUserImpact{}}
+	for _, orgUser := range usersQuery.Result {
+		userQuery := &models.GetUserByIdQuery{Id: orgUser.UserId}
+		if err := store.GetUserById(ctx, userQuery); err != nil {
+			return nil, err
+		}
+		orgsQuery := &models.GetUserOrgListQuery{UserId: orgUser.UserId}
+		if err := store.GetUserOrgList(ctx, orgsQuery); err != nil {
+			return nil, err
+		}
+
+		role := models.RoleType(orgUser.Role)
+		report.Users = append(report.Users, &OrgDeletionUserImpact{
+			UserId:         orgUser.UserId,
+			Login:          orgUser.Login,
+			Role:           role,
+			DefaultOrg:     userQuery.Result.OrgId == orgID,
+			OnlyMembership: len(orgsQuery.Result) == 1,
+			SoleAdmin:      role == models.ROLE_ADMIN && admins == 1,
+		})
+	}
+	return report, nil
+}
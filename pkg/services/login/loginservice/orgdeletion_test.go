@@ -0,0 +1,52 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgDeletionImpact(t *testing.T) {
+	store := newFakeStore(
+		&models.User{Id: 1, Login: "alice", OrgId: 1},
+		&models.User{Id: 2, Login: "bob", OrgId: 2},
+		&models.User{Id: 3, Login: "carol", OrgId: 1},
+	)
+	// alice is only in org 1, bob and carol are in orgs 1 and 2
+	store.addOrgUser(1, 1, models.ROLE_ADMIN)
+	store.addOrgUser(1, 2, models.ROLE_VIEWER)
+	store.addOrgUser(2, 2, models.ROLE_ADMIN)
+	store.addOrgUser(1, 3, models.ROLE_EDITOR)
+	store.addOrgUser(2, 3, models.ROLE_ADMIN)
+	loginService := &Implementation{SQLStore: store}
+
+	t.Run("flags users in a single org and their default org", func(t *testing.T) {
+		report, err := loginService.OrgDeletionImpact(context.Background(), 1)
+		require.NoError(t, err)
+
+		assert.Equal(t, &OrgDeletionReport{OrgId: 1, Name: "org-1", Users: []*OrgDeletionUserImpact{
+			{UserId: 1, Login: "alice", Role: models.ROLE_ADMIN, DefaultOrg: true, OnlyMembership: true, SoleAdmin: true},
+			{UserId: 2, Login: "bob", Role: models.ROLE_VIEWER},
+			{UserId: 3, Login: "carol", Role: models.ROLE_EDITOR, DefaultOrg: true},
+		}}, report)
+	})
+
+	t.Run("users in multiple orgs aren't flagged", func(t *testing.T) {
+		report, err := loginService.OrgDeletionImpact(context.Background(), 2)
+		require.NoError(t, err)
+
+		assert.Equal(t, []*OrgDeletionUserImpact{
+			{UserId: 2, Login: "bob", Role: models.ROLE_ADMIN, DefaultOrg: true},
+			{UserId: 3, Login: "carol", Role: models.ROLE_ADMIN},
+		}, report.Users)
+		assert.Empty(t, store.calls)
+	})
+
+	t.Run("unknown orgs", func(t *testing.T) {
+		_, err := loginService.OrgDeletionImpact(context.Background(), 3)
+		require.ErrorIs(t, err, models.ErrOrgNotFound)
+	})
+}
@@ -0,0 +1,66 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// OrglessPolicy controls what happens to a user that org sync leaves without
+// any org memberships.
+type OrglessPolicy int
+
+const (
+	// OrglessAllow leaves the user without orgs (default).
+	OrglessAllow OrglessPolicy = iota
+	// OrglessDisable disables the user.
+	OrglessDisable
+	// OrglessAssignDefault adds the user to OrglessOrgID with OrglessOrgRole.
+	OrglessAssignDefault
+)
+
+// handleOrgless applies the OnOrgless policy if the user has no org memberships
+// left. It reports whether the user was assigned to the default org.
+func (ls *Implementation) handleOrgless(ctx context.Context, user *models.User, state *syncState) (bool, error) {
+	if ls.OnOrgless == OrglessAllow {
+		return false, nil
+	}
+
+	// memberships were just written by org sync, read them from the primary
+	query := &models.GetUserOrgListQuery{UserId: user.Id}
+	if err := ls.SQLStore.GetUserOrgList(ctx, query); err != nil {
+		return false, err
+	}
+	if len(query.Result) > 0 {
+		return false, nil
+	}
+
+	switch ls.OnOrgless {
+	case OrglessDisable:
+		logger.Warn("Disabling user left without organizations after sync", "userId", user.Id)
+		if err := ls.SQLStore.DisableUser(ctx, &models.DisableUserCommand{UserId: user.Id, IsDisabled: true}); err != nil {
+			return false, err
+		}
+		user.IsDisabled = true
+	case OrglessAssignDefault:
+		if ls.OrglessOrgID == 0 {
+			logger.Warn("User left without organizations after sync, but no default organization is configured", "userId", user.Id)
+			return false, nil
+		}
+
+		role := ls.OrglessOrgRole
+		if role == "" {
+			role = models.ROLE_VIEWER
+		}
+
+		logger.Debug("Adding user left without organizations to the default organization", "userId", user.Id, "orgId", ls.OrglessOrgID, "role", role)
+		cmd := &models.AddOrgUserCommand{UserId: user.Id, OrgId: ls.OrglessOrgID, Role: role}
+		if err := ls.SQLStore.AddOrgUser(ctx, cmd); err != nil {
+			return false, err
+		}
+		state.result.OrgRolesAdded = append(state.result.OrgRolesAdded, models.OrgRoleAdded{OrgId: ls.OrglessOrgID, Role: role})
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,62 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_syncOrgRoles_onOrgless(t *testing.T) {
+	setup := func(policy OrglessPolicy) (*Implementation, *fakeStore, *models.User, *models.ExternalUserInfo) {
+		user := &models.User{Id: 1, OrgId: 1}
+		store := newFakeStore(user)
+		store.addOrgUser(1, user.Id, models.ROLE_VIEWER)
+		store.addOrg(3)
+
+		loginService := &Implementation{SQLStore: store, OnOrgless: policy, OrglessOrgID: 3}
+		// org 2 doesn't exist, so the user ends up without any org
+		extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{2: models.ROLE_EDITOR}}
+		return loginService, store, user, extUser
+	}
+
+	t.Run("allow leaves the user without orgs", func(t *testing.T) {
+		loginService, store, user, extUser := setup(OrglessAllow)
+		require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState()))
+
+		assert.NotContains(t, store.orgUsers[1], user.Id)
+		assert.NotContains(t, store.orgUsers[3], user.Id)
+		assert.False(t, store.users[user.Id].IsDisabled)
+	})
+
+	t.Run("disable disables the user", func(t *testing.T) {
+		loginService, store, user, extUser := setup(OrglessDisable)
+		require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState()))
+
+		assert.NotContains(t, store.orgUsers[1], user.Id)
+		assert.True(t, store.users[user.Id].IsDisabled)
+	})
+
+	t.Run("assign default adds the user to the default org", func(t *testing.T) {
+		loginService, store, user, extUser := setup(OrglessAssignDefault)
+		state := loginService.newSyncState()
+		require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, state))
+
+		assert.NotContains(t, store.orgUsers[1], user.Id)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[3][user.Id])
+		assert.Equal(t, int64(3), store.users[user.Id].OrgId)
+		assert.False(t, store.users[user.Id].IsDisabled)
+		assert.Equal(t, []models.OrgRoleAdded{{OrgId: 3, Role: models.ROLE_VIEWER}}, state.result.OrgRolesAdded)
+	})
+
+	t.Run("policy is not applied to users that keep an org", func(t *testing.T) {
+		loginService, store, user, extUser := setup(OrglessDisable)
+		store.addOrg(2)
+		require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState()))
+
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][user.Id])
+		assert.False(t, store.users[user.Id].IsDisabled)
+	})
+}
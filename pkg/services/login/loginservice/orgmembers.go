@@ -0,0 +1,65 @@
+package loginservice
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// OrgMemberCounts returns the number of members of each org by role, ordered
+// by org id and role. Service accounts aren't counted.
+func (ls *Implementation) OrgMemberCounts(ctx context.Context) ([]*login.OrgMemberCount, error) {
+	if ls.OrgMemberCountStore == nil {
+		return nil, login.ErrMemberStatsDisabled
+	}
+	return ls.OrgMemberCountStore.GetOrgMemberCounts(ctx)
+}
+
+// SampleOrgMemberCounts sets the grafana_org_members gauges to the current
+// org member counts. Gauges of orgs and roles without members are removed.
+func (ls *Implementation) SampleOrgMemberCounts(ctx context.Context) error {
+	counts, err := ls.OrgMemberCounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	metrics.MStatOrgMembers.Reset()
+	for _, count := range counts {
+		metrics.MStatOrgMembers.WithLabelValues(strconv.FormatInt(count.OrgId, 10), string(count.Role)).Set(float64(count.Count))
+	}
+	return nil
+}
+
+// RunOrgMemberSampler runs SampleOrgMemberCounts right away and then every
+// interval until ctx is done.
+func (ls *Implementation) RunOrgMemberSampler(ctx context.Context, interval time.Duration) error {
+	if ls.OrgMemberCountStore == nil {
+		return login.ErrMemberStatsDisabled
+	}
+
+	c := ls.Clock
+	if c == nil {
+		c = clock.New()
+	}
+	ticker := c.Ticker(interval)
+	defer ticker.Stop()
+
+	sample := func() {
+		if err := ls.SampleOrgMemberCounts(ctx); err != nil {
+			logger.Error("Failed to sample org member counts", "error", err)
+		}
+	}
+	sample()
+	for {
+		select {
+		case <-ticker.C:
+			sample()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package loginservice
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrgMemberCounts(t *testing.T) {
+	ctx := context.Background()
+
+	// Engineering has an admin, two editors and a viewer, the service account
+	// is the only member of its org
+	setup := func(t *testing.T) (*Implementation, int64, int64) {
+		sqlStore := sqlstore.InitTestDB(t)
+
+		createUser := func(login string) *models.User {
+			user, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: login, Email: login + "@example.org"})
+			require.NoError(t, err)
+			return user
+		}
+		org, err := sqlStore.CreateOrgWithMember("Engineering", createUser("owner").Id)
+		require.NoError(t, err)
+		members := map[string]models.RoleType{"alice": models.ROLE_EDITOR, "bob": models.ROLE_EDITOR, "carol": models.ROLE_VIEWER}
+		for login, role := range members {
+			require.NoError(t, sqlStore.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: org.Id, UserId: createUser(login).Id, Role: role}))
+		}
+		serviceAccount, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "sa-ci", DefaultOrgRole: string(models.ROLE_EDITOR), IsServiceAccount: true})
+		require.NoError(t, err)
+
+		return &Implementation{SQLStore: sqlStore, OrgMemberCountStore: logindatabase.ProvideOrgMemberCountStore(sqlStore)}, org.Id, serviceAccount.OrgId
+	}
+	countsOf := func(counts []*login.OrgMemberCount, orgID int64) map[models.RoleType]int64 {
+		byRole := map[models.RoleType]int64{}
+		for _, count := range counts {
+			if count.OrgId == orgID {
+				byRole[count.Role] = count.Count
+			}
+		}
+		return byRole
+	}
+
+	t.Run("counts reflect the memberships", func(t *testing.T) {
+		loginService, orgID, serviceAccountOrgID := setup(t)
+
+		counts, err := loginService.OrgMemberCounts(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[models.RoleType]int64{models.ROLE_ADMIN: 1, models.ROLE_EDITOR: 2, models.ROLE_VIEWER: 1}, countsOf(counts, orgID))
+		assert.Empty(t, countsOf(counts, serviceAccountOrgID), "service accounts shouldn't be counted")
+	})
+
+	t.Run("gauges are set to the counts", func(t *testing.T) {
+		loginService, orgID, _ := setup(t)
+		metrics.MStatOrgMembers.WithLabelValues("999", string(models.ROLE_ADMIN)).Set(1)
+
+		require.NoError(t, loginService.SampleOrgMemberCounts(ctx))
+
+		org := strconv.FormatInt(orgID, 10)
+		assert.Equal(t, 1.0, testutil.ToFloat64(metrics.MStatOrgMembers.WithLabelValues(org, string(models.ROLE_ADMIN))))
+		assert.Equal(t, 2.0, testutil.ToFloat64(metrics.MStatOrgMembers.WithLabelValues(org, string(models.ROLE_EDITOR))))
+		assert.Equal(t, 1.0, testutil.ToFloat64(metrics.MStatOrgMembers.WithLabelValues(org, string(models.ROLE_VIEWER))))
+
+		counts, err := loginService.OrgMemberCounts(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, len(counts), testutil.CollectAndCount(metrics.MStatOrgMembers), "gauges of orgs without members should be removed")
+	})
+
+	t.Run("counts fail without a store", func(t *testing.T) {
+		loginService := &Implementation{}
+
+		_, err := loginService.OrgMemberCounts(ctx)
+		assert.ErrorIs(t, err, login.ErrMemberStatsDisabled)
+		assert.ErrorIs(t, loginService.RunOrgMemberSampler(ctx, time.Minute), login.ErrMemberStatsDisabled)
+	})
+}
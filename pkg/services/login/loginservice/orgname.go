@@ -0,0 +1,69 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+func (ls *Implementation) orgIdByName(ctx context.Context, name string) (int64, error) {
+	if ls.OrgIdByName != nil {
+		return ls.OrgIdByName(ctx, name)
+	}
+
+	query := &models.GetOrgByNameQuery{Name: name}
+	if err := ls.readStore(ctx, false).GetOrgByNameHandler(ctx, query); err != nil {
+		return 0, err
+	}
+	return query.Result.Id, nil
+}
+
+// resolveOrgRolesByName merges the org roles keyed by name into OrgRoles. With
+// StrictRoleValidation an unknown name fails the sync, otherwise it's skipped.
+// When a name resolves to an org that already has a role, the roles are merged
+// according to OrgRoleMerge.
+func (ls *Implementation) resolveOrgRolesByName(ctx context.Context, extUser *models.ExternalUserInfo, state *syncState) error {
+	if len(extUser.OrgRolesByName) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(extUser.OrgRolesByName))
+	for name := range extUser.OrgRolesByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if extUser.OrgRoles == nil {
+		extUser.OrgRoles = map[int64]models.RoleType{}
+	}
+	for _, name := range names {
+		role := extUser.OrgRolesByName[name]
+
+		orgID, err := ls.orgIdByName(ctx, name)
+		if errors.Is(err, models.ErrOrgNotFound) {
+			if state.strictRoles {
+				return fmt.Errorf("%w: %q", models.ErrOrgNotFound, name)
+			}
+			logger.Warn("Skipping organization role for unknown organization name", "name", name, "role", role)
+			state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("skipped role for unknown organization %q", name))
+			continue
+		}
+		if err != nil {
+			return upsertErr(login.UpsertPhaseOrgSync, err)
+		}
+
+		if existing, ok := extUser.OrgRoles[orgID]; ok && ls.mergeOrgRole(existing, role) == existing {
+			if existing != role {
+				logger.Warn("Ignoring organization role by name, organization already has a role", "name", name, "orgId", orgID, "role", role, "existingRole", existing)
+				state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("ignored role %q for organization %q, organization %d already has role %q", role, name, orgID, existing))
+			}
+			continue
+		}
+		extUser.OrgRoles[orgID] = role
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupOrgRolesByName(strict bool) (*Implementation, *fakeStore) {
+	user := &models.User{Id: 1, Login: "alice"}
+	store := newFakeStore(user)
+	store.addOrg(1)
+	store.addOrg(2)
+	store.addOrg(3)
+	return &Implementation{
+		SQLStore:             store,
+		AuthInfoService:      &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		StrictRoleValidation: strict,
+	}, store
+}
+
+func Test_UpsertUser_orgRolesByName(t *testing.T) {
+	t.Run("names are resolved to ids", func(t *testing.T) {
+		loginService, store := setupOrgRolesByName(false)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:          "alice",
+			OrgRolesByName: map[string]models.RoleType{"org-1": models.ROLE_VIEWER, "org-2": models.ROLE_EDITOR},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][1])
+		assert.Empty(t, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("roles by id take precedence over roles by name", func(t *testing.T) {
+		loginService, store := setupOrgRolesByName(false)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:          "alice",
+			OrgRoles:       map[int64]models.RoleType{1: models.ROLE_EDITOR},
+			OrgRolesByName: map[string]models.RoleType{"org-1": models.ROLE_ADMIN, "org-3": models.ROLE_VIEWER},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[3][1])
+		assert.Len(t, cmd.SyncResult.Warnings, 1)
+	})
+
+	t.Run("unknown names are skipped", func(t *testing.T) {
+		loginService, store := setupOrgRolesByName(false)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:          "alice",
+			OrgRolesByName: map[string]models.RoleType{"org-1": models.ROLE_VIEWER, "typo": models.ROLE_ADMIN},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+		assert.Equal(t, []string{`skipped role for unknown organization "typo"`}, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("unknown names fail the sync in strict mode", func(t *testing.T) {
+		loginService, store := setupOrgRolesByName(true)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:          "alice",
+			OrgRolesByName: map[string]models.RoleType{"org-1": models.ROLE_VIEWER, "typo": models.ROLE_ADMIN},
+		}}
+		require.ErrorIs(t, loginService.UpsertUser(context.Background(), cmd), models.ErrOrgNotFound)
+		assert.Empty(t, store.orgUsers[1])
+	})
+
+	t.Run("names are resolved with OrgIdByName when set", func(t *testing.T) {
+		loginService, store := setupOrgRolesByName(false)
+		var lookedUp []string
+		loginService.OrgIdByName = func(ctx context.Context, name string) (int64, error) {
+			lookedUp = append(lookedUp, name)
+			return 2, nil
+		}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:          "alice",
+			OrgRolesByName: map[string]models.RoleType{"Main Org.": models.ROLE_EDITOR},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, []string{"Main Org."}, lookedUp)
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][1])
+	})
+}
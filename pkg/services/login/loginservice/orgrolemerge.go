@@ -0,0 +1,82 @@
+package loginservice
+
+import (
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// OrgRoleMergeStrategy controls which role an org gets when several sources of
+// external org roles have a role for it. The sources are ExternalUserInfo.OrgRoles,
+// ExternalUserInfo.OrgRolesByName and GroupOrgRoles, in that order.
+type OrgRoleMergeStrategy int
+
+const (
+	// OrgRoleMergeFirstWins keeps the role of the first source (default).
+	OrgRoleMergeFirstWins OrgRoleMergeStrategy = iota
+	// OrgRoleMergeHighestWins keeps the highest role.
+	OrgRoleMergeHighestWins
+	// OrgRoleMergeLastWins keeps the role of the last source.
+	OrgRoleMergeLastWins
+)
+
+// GroupOrgRoleMapping grants the members of an external group a role in an org.
+type GroupOrgRoleMapping struct {
+	Group string
+	OrgId int64
+	Role  models.RoleType
+}
+
+// mergeOrgRole returns the role an org with the current role gets when another
+// source has role for it.
+func (ls *Implementation) mergeOrgRole(current, role models.RoleType) models.RoleType {
+	switch ls.OrgRoleMerge {
+	case OrgRoleMergeLastWins:
+		return role
+	case OrgRoleMergeHighestWins:
+		// aliases are compared by the role they map to, unknown roles never win
+		resolved, ok := ls.resolveRoleAlias(role)
+		if !ok {
+			return current
+		}
+		resolvedCurrent, ok := ls.resolveRoleAlias(current)
+		if !ok || (resolved != resolvedCurrent && resolved.Includes(resolvedCurrent)) {
+			return role
+		}
+		return current
+	default:
+		return current
+	}
+}
+
+// mergeGroupOrgRoles merges the roles of the GroupOrgRoles mappings matching the
+// groups of extUser into its org roles.
+func (ls *Implementation) mergeGroupOrgRoles(extUser *models.ExternalUserInfo, state *syncState) {
+	if len(ls.GroupOrgRoles) == 0 || len(extUser.Groups) == 0 {
+		return
+	}
+
+	groups := make(map[string]bool, len(extUser.Groups))
+	for _, group := range extUser.Groups {
+		groups[group] = true
+	}
+
+	for _, mapping := range ls.GroupOrgRoles {
+		if !groups[mapping.Group] {
+			continue
+		}
+
+		if existing, ok := extUser.OrgRoles[mapping.OrgId]; ok && ls.mergeOrgRole(existing, mapping.Role) == existing {
+			if existing != mapping.Role {
+				logger.Warn("Ignoring organization role of group, organization already has a role", "group", mapping.Group, "orgId", mapping.OrgId, "role", mapping.Role, "existingRole", existing)
+				state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("ignored role %q of group %q, organization %d already has role %q", mapping.Role, mapping.Group, mapping.OrgId, existing))
+			}
+			continue
+		}
+
+		if extUser.OrgRoles == nil {
+			extUser.OrgRoles = map[int64]models.RoleType{}
+		}
+		extUser.OrgRoles[mapping.OrgId] = mapping.Role
+	}
+}
@@ -0,0 +1,74 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_orgRoleMerge(t *testing.T) {
+	groupOrgRoles := []GroupOrgRoleMapping{
+		{Group: "admins", OrgId: 1, Role: models.ROLE_ADMIN},
+		{Group: "admins", OrgId: 2, Role: models.ROLE_VIEWER},
+		{Group: "viewers", OrgId: 3, Role: models.ROLE_VIEWER},
+		{Group: "other", OrgId: 3, Role: models.ROLE_ADMIN},
+	}
+	upsert := func(t *testing.T, strategy OrgRoleMergeStrategy) (*models.UpsertUserCommand, *fakeStore) {
+		loginService, store := setupOrgRolesByName(false)
+		loginService.GroupOrgRoles = groupOrgRoles
+		loginService.OrgRoleMerge = strategy
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:          "alice",
+			Groups:         []string{"admins", "viewers"},
+			OrgRoles:       map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: models.ROLE_EDITOR},
+			OrgRolesByName: map[string]models.RoleType{"org-2": "admin", "org-3": models.ROLE_EDITOR},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		return cmd, store
+	}
+
+	t.Run("the first source wins by default", func(t *testing.T) {
+		cmd, store := upsert(t, OrgRoleMergeFirstWins)
+
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][1])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[3][1])
+		assert.Len(t, cmd.SyncResult.Warnings, 4)
+	})
+
+	t.Run("the highest role wins", func(t *testing.T) {
+		cmd, store := upsert(t, OrgRoleMergeHighestWins)
+
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][1])
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[2][1])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[3][1])
+		assert.Equal(t, []string{
+			`ignored role "Viewer" of group "admins", organization 2 already has role "admin"`,
+			`ignored role "Viewer" of group "viewers", organization 3 already has role "Editor"`,
+		}, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("the last source wins", func(t *testing.T) {
+		cmd, store := upsert(t, OrgRoleMergeLastWins)
+
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][1])
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[2][1])
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[3][1])
+		assert.Empty(t, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("group roles are used without other org roles", func(t *testing.T) {
+		loginService, store := setupOrgRolesByName(false)
+		loginService.GroupOrgRoles = groupOrgRoles
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", Groups: []string{"viewers"}}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[3][1])
+		assert.Empty(t, store.orgUsers[1])
+	})
+}
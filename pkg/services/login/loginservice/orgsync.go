@@ -0,0 +1,22 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// SyncOrgRoles syncs the org roles of an existing user with the external user,
+// like UpsertUser does, e.g. while migrating orgs. When ctx carries a
+// transaction of the caller, see sqlstore.SQLStore.InTransaction, the org
+// operations join it and are committed or rolled back with the caller's other
+// changes. A removal refused for leaving an org without admins fails the sync
+// then, since the caller has to roll it back.
+func (ls *Implementation) SyncOrgRoles(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) (*models.ExternalUserSyncResult, error) {
+	state := ls.newSyncState()
+	defer ls.flushAudit(ctx, state, user, extUser)
+	if err := ls.syncOrgRoles(ctx, user, extUser, state); err != nil {
+		return nil, err
+	}
+	return state.result, nil
+}
@@ -0,0 +1,91 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/services/sqlstore/mockstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncOrgRoles_callerTransaction(t *testing.T) {
+	ctx := context.Background()
+	errMigration := errors.New("migration failed")
+
+	setup := func(t *testing.T) (*Implementation, *sqlstore.SQLStore, *models.User, *models.User) {
+		sqlStore := sqlstore.InitTestDB(t)
+		owner, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "owner", Email: "owner@example.org"})
+		require.NoError(t, err)
+		alice, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org"})
+		require.NoError(t, err)
+		return &Implementation{SQLStore: sqlStore}, sqlStore, owner, alice
+	}
+	userOrgRoles := func(t *testing.T, sqlStore *sqlstore.SQLStore, userID int64) map[int64]models.RoleType {
+		query := &models.GetUserOrgListQuery{UserId: userID}
+		require.NoError(t, sqlStore.GetUserOrgList(ctx, query))
+		roles := map[int64]models.RoleType{}
+		for _, org := range query.Result {
+			roles[org.OrgId] = org.Role
+		}
+		return roles
+	}
+
+	t.Run("org operations are committed with the transaction", func(t *testing.T) {
+		loginService, sqlStore, owner, alice := setup(t)
+
+		err := sqlStore.InTransaction(ctx, func(ctx context.Context) error {
+			extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{owner.OrgId: models.ROLE_EDITOR, alice.OrgId: models.ROLE_ADMIN}}
+			_, err := loginService.SyncOrgRoles(ctx, alice, extUser)
+			return err
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[int64]models.RoleType{owner.OrgId: models.ROLE_EDITOR, alice.OrgId: models.ROLE_ADMIN}, userOrgRoles(t, sqlStore, alice.Id))
+	})
+
+	t.Run("org operations are rolled back with the transaction", func(t *testing.T) {
+		loginService, sqlStore, owner, alice := setup(t)
+
+		err := sqlStore.InTransaction(ctx, func(ctx context.Context) error {
+			extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{owner.OrgId: models.ROLE_EDITOR, alice.OrgId: models.ROLE_ADMIN}}
+			if _, err := loginService.SyncOrgRoles(ctx, alice, extUser); err != nil {
+				return err
+			}
+			return errMigration
+		})
+		require.ErrorIs(t, err, errMigration)
+
+		assert.Equal(t, map[int64]models.RoleType{alice.OrgId: models.ROLE_ADMIN}, userOrgRoles(t, sqlStore, alice.Id))
+	})
+
+	t.Run("refused removal of the last admin fails the sync", func(t *testing.T) {
+		loginService, sqlStore, owner, alice := setup(t)
+
+		err := sqlStore.InTransaction(ctx, func(ctx context.Context) error {
+			extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{owner.OrgId: models.ROLE_EDITOR}}
+			_, err := loginService.SyncOrgRoles(ctx, alice, extUser)
+			return err
+		})
+		require.ErrorIs(t, err, models.ErrLastOrgAdmin)
+
+		assert.Equal(t, map[int64]models.RoleType{alice.OrgId: models.ROLE_ADMIN}, userOrgRoles(t, sqlStore, alice.Id))
+	})
+
+	t.Run("reads in the transaction don't use the read store", func(t *testing.T) {
+		loginService, sqlStore, owner, alice := setup(t)
+		loginService.ReadStore = &mockstore.SQLStoreMock{ExpectedError: errors.New("read store used")}
+
+		err := sqlStore.InTransaction(ctx, func(ctx context.Context) error {
+			extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{owner.OrgId: models.ROLE_VIEWER, alice.OrgId: models.ROLE_ADMIN}}
+			_, err := loginService.SyncOrgRoles(ctx, alice, extUser)
+			return err
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, models.ROLE_VIEWER, userOrgRoles(t, sqlStore, alice.Id)[owner.OrgId])
+	})
+}
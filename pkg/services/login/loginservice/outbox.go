@@ -0,0 +1,143 @@
+package loginservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+const (
+	outboxUserCreated   = "UserCreated"
+	outboxOrgRoleSynced = "OrgRoleSynced"
+
+	// outboxRelayBatchSize is the most events a single RelayOutbox delivers.
+	outboxRelayBatchSize = 100
+)
+
+// outboxEventTypes creates the events the outbox payloads are decoded into.
+var outboxEventTypes = map[string]func() interface{}{
+	outboxUserCreated:   func() interface{} { return &events.UserCreated{} },
+	outboxOrgRoleSynced: func() interface{} { return &models.OrgRoleSyncedEvent{} },
+}
+
+// withOutbox runs write and stores the event it returns in the Outbox, in one
+// transaction, so the event is stored if and only if the change is. Without an
+// Outbox write just runs.
+func (ls *Implementation) withOutbox(ctx context.Context, eventType string, write func(ctx context.Context) (interface{}, error)) error {
+	if ls.Outbox == nil {
+		_, err := write(ctx)
+		return err
+	}
+
+	return ls.SQLStore.InTransaction(ctx, func(ctx context.Context) error {
+		event, err := write(ctx)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return ls.Outbox.AddOutboxEvent(ctx, &login.OutboxEvent{Type: eventType, Payload: string(payload), Created: ls.now()})
+	})
+}
+
+func (ls *Implementation) createUserWithOutbox(ctx context.Context, extUser *models.ExternalUserInfo) (*models.User, error) {
+	var user *models.User
+	err := ls.withOutbox(ctx, outboxUserCreated, func(ctx context.Context) (interface{}, error) {
+		var err error
+		if user, err = ls.createUser(ctx, extUser); err != nil {
+			return nil, err
+		}
+		return &events.UserCreated{Timestamp: user.Created, Id: user.Id, Name: user.Name, Login: user.Login, Email: user.Email}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// withOrgRoleSynced runs the org membership write with its OrgRoleSynced event,
+// role is empty for removals.
+func (ls *Implementation) withOrgRoleSynced(ctx context.Context, userID, orgID int64, role models.RoleType, write func(ctx context.Context) error) error {
+	return ls.withOutbox(ctx, outboxOrgRoleSynced, func(ctx context.Context) (interface{}, error) {
+		if err := write(ctx); err != nil {
+			return nil, err
+		}
+		return &models.OrgRoleSyncedEvent{Timestamp: ls.now(), UserId: userID, OrgId: orgID, Role: role}, nil
+	})
+}
+
+// RelayOutbox publishes the events in the Outbox on the bus, oldest first, and
+// returns how many were delivered. Delivered events are deleted, the others are
+// retried by the next relay. Events are delivered at least once: an event whose
+// deletion fails is published again.
+func (ls *Implementation) RelayOutbox(ctx context.Context) (int, error) {
+	if ls.Outbox == nil || ls.Bus == nil {
+		return 0, login.ErrOutboxDisabled
+	}
+
+	pending, err := ls.Outbox.GetOutboxEvents(ctx, outboxRelayBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, event := range pending {
+		if err := ls.publishOutboxEvent(ctx, event); err != nil {
+			logger.Warn("Failed to relay outbox event", "id", event.Id, "type", event.Type, "attempts", event.Attempts+1, "error", err)
+			if err := ls.Outbox.IncrementOutboxAttempts(ctx, event.Id); err != nil {
+				return delivered, err
+			}
+			continue
+		}
+		if err := ls.Outbox.DeleteOutboxEvent(ctx, event.Id); err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+func (ls *Implementation) publishOutboxEvent(ctx context.Context, event *login.OutboxEvent) error {
+	newEvent, ok := outboxEventTypes[event.Type]
+	if !ok {
+		return fmt.Errorf("unknown outbox event type %q", event.Type)
+	}
+	msg := newEvent()
+	if err := json.Unmarshal([]byte(event.Payload), msg); err != nil {
+		return err
+	}
+	return ls.Bus.Publish(ctx, msg)
+}
+
+// RunOutboxRelay runs RelayOutbox every interval until ctx is done.
+func (ls *Implementation) RunOutboxRelay(ctx context.Context, interval time.Duration) error {
+	if ls.Outbox == nil || ls.Bus == nil {
+		return login.ErrOutboxDisabled
+	}
+
+	c := ls.Clock
+	if c == nil {
+		c = clock.New()
+	}
+	ticker := c.Ticker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := ls.RelayOutbox(ctx); err != nil {
+				logger.Error("Failed to relay the login outbox", "error", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
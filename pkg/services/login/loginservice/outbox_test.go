@@ -0,0 +1,182 @@
+package loginservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupOutbox(t *testing.T) (*Implementation, *sqlstore.SQLStore, *logindatabase.OutboxStore, *bus.InProcBus) {
+	t.Helper()
+
+	sqlStore := sqlstore.InitTestDB(t)
+	store := logindatabase.ProvideOutboxStore(sqlStore)
+	eventBus := bus.New()
+	return &Implementation{
+		SQLStore:        sqlStore,
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound},
+		QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+		Outbox:          store,
+		Bus:             eventBus,
+	}, sqlStore, store, eventBus
+}
+
+func Test_UpsertUser_outbox(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("events are stored with the user and org role changes", func(t *testing.T) {
+		loginService, sqlStore, store, _ := setupOutbox(t)
+		owner, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "owner", Email: "owner@example.org"})
+		require.NoError(t, err)
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			Email:    "alice@example.org",
+			OrgRoles: map[int64]models.RoleType{owner.OrgId: models.ROLE_EDITOR},
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+
+		pending, err := store.GetOutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, pending, 2)
+		assert.Equal(t, outboxUserCreated, pending[0].Type)
+		created := &events.UserCreated{}
+		require.NoError(t, json.Unmarshal([]byte(pending[0].Payload), created))
+		assert.Equal(t, cmd.Result.Id, created.Id)
+		assert.Equal(t, "alice", created.Login)
+
+		assert.Equal(t, outboxOrgRoleSynced, pending[1].Type)
+		synced := &models.OrgRoleSyncedEvent{}
+		require.NoError(t, json.Unmarshal([]byte(pending[1].Payload), synced))
+		assert.Equal(t, owner.OrgId, synced.OrgId)
+		assert.Equal(t, models.ROLE_EDITOR, synced.Role)
+	})
+
+	t.Run("no event is stored for a change that fails", func(t *testing.T) {
+		loginService, sqlStore, store, _ := setupOutbox(t)
+		alice, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org"})
+		require.NoError(t, err)
+		other, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "other", Email: "other@example.org"})
+		require.NoError(t, err)
+		loginService.AuthInfoService = &logintest.AuthInfoServiceFake{ExpectedUser: alice}
+
+		// alice is the last admin of her org, so her removal from it fails
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			Email:    "alice@example.org",
+			OrgRoles: map[int64]models.RoleType{other.OrgId: models.ROLE_VIEWER},
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+
+		pending, err := store.GetOutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		event := &models.OrgRoleSyncedEvent{}
+		require.NoError(t, json.Unmarshal([]byte(pending[0].Payload), event))
+		assert.Equal(t, other.OrgId, event.OrgId)
+		assert.Equal(t, models.ROLE_VIEWER, event.Role)
+	})
+
+	t.Run("no events are stored without an outbox", func(t *testing.T) {
+		loginService, _, store, _ := setupOutbox(t)
+		loginService.Outbox = nil
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org"}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+
+		pending, err := store.GetOutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+}
+
+func TestRelayOutbox(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("events are published and removed", func(t *testing.T) {
+		loginService, sqlStore, store, eventBus := setupOutbox(t)
+		owner, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "owner", Email: "owner@example.org"})
+		require.NoError(t, err)
+
+		var created []*events.UserCreated
+		var synced []*models.OrgRoleSyncedEvent
+		eventBus.AddEventListener(func(ctx context.Context, e *events.UserCreated) error {
+			created = append(created, e)
+			return nil
+		})
+		eventBus.AddEventListener(func(ctx context.Context, e *models.OrgRoleSyncedEvent) error {
+			synced = append(synced, e)
+			return nil
+		})
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			Email:    "alice@example.org",
+			OrgRoles: map[int64]models.RoleType{owner.OrgId: models.ROLE_EDITOR},
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+
+		delivered, err := loginService.RelayOutbox(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, delivered)
+		require.Len(t, created, 1)
+		assert.Equal(t, cmd.Result.Id, created[0].Id)
+		assert.Equal(t, "alice", created[0].Login)
+		require.Len(t, synced, 1)
+		assert.Equal(t, models.OrgRoleSyncedEvent{Timestamp: synced[0].Timestamp, UserId: cmd.Result.Id, OrgId: owner.OrgId, Role: models.ROLE_EDITOR}, *synced[0])
+
+		pending, err := store.GetOutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+
+	t.Run("failed deliveries are retried", func(t *testing.T) {
+		loginService, _, store, eventBus := setupOutbox(t)
+
+		failures := 1
+		var created []*events.UserCreated
+		eventBus.AddEventListener(func(ctx context.Context, e *events.UserCreated) error {
+			if failures > 0 {
+				failures--
+				return errors.New("subscriber down")
+			}
+			created = append(created, e)
+			return nil
+		})
+
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org"}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+
+		delivered, err := loginService.RelayOutbox(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, delivered)
+		pending, err := store.GetOutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Equal(t, 1, pending[0].Attempts)
+
+		delivered, err = loginService.RelayOutbox(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, delivered)
+		require.Len(t, created, 1)
+		assert.Equal(t, cmd.Result.Id, created[0].Id)
+	})
+
+	t.Run("relaying needs an outbox", func(t *testing.T) {
+		_, err := (&Implementation{Bus: bus.New()}).RelayOutbox(ctx)
+		require.ErrorIs(t, err, login.ErrOutboxDisabled)
+	})
+}
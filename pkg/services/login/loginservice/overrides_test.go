@@ -0,0 +1,66 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_overrides(t *testing.T) {
+	enabled, disabled := true, false
+	setup := func() (*Implementation, *fakeStore) {
+		user := &models.User{Id: 1, Login: "alice", OrgId: 1}
+		store := newFakeStore(user)
+		store.addOrgUser(1, user.Id, models.ROLE_VIEWER)
+		store.addOrg(2)
+		return &Implementation{
+			SQLStore:        store,
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		}, store
+	}
+	extUser := func() *models.ExternalUserInfo {
+		return &models.ExternalUserInfo{Login: "alice", OrgRoles: map[int64]models.RoleType{
+			1: models.ROLE_EDITOR,
+			2: "Superuser",
+		}}
+	}
+
+	t.Run("an override applies to its call only", func(t *testing.T) {
+		loginService, store := setup()
+
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser(), Overrides: &models.UpsertUserOverrides{StrictRoleValidation: &enabled}}
+		var roleErr *login.ErrInvalidOrgRole
+		require.ErrorAs(t, loginService.UpsertUser(context.Background(), cmd), &roleErr)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+
+		cmd = &models.UpsertUserCommand{ExternalUser: extUser()}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+		assert.Equal(t, []string{`skipped unknown role "Superuser" for organization 2`}, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("overrides can turn configured behavior off", func(t *testing.T) {
+		loginService, store := setup()
+		loginService.StrictRoleValidation = true
+
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser(), Overrides: &models.UpsertUserOverrides{StrictRoleValidation: &disabled}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[1][1])
+	})
+
+	t.Run("observe only records changes instead of applying them", func(t *testing.T) {
+		loginService, store := setup()
+
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser(), Overrides: &models.UpsertUserOverrides{ObserveOnly: &enabled}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		assert.Equal(t, []models.ObservedSyncChange{
+			{Action: models.ObservedUpdateOrgUser, OrgId: 1, Role: models.ROLE_EDITOR},
+		}, cmd.SyncResult.ObservedChanges)
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][1])
+	})
+}
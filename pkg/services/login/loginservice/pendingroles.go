@@ -0,0 +1,64 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// stashPendingOrgRole keeps a role for an org that doesn't exist yet, so that it
+// can be applied by ApplyPendingRoles once the org is created.
+func (ls *Implementation) stashPendingOrgRole(ctx context.Context, userID, orgID int64, role models.RoleType) error {
+	if ls.PendingRoleStore == nil {
+		return nil
+	}
+
+	logger.Debug("Stashing role for missing organization", "userId", userID, "orgId", orgID, "role", role)
+	return ls.PendingRoleStore.SavePendingOrgRole(ctx, &login.PendingOrgRole{
+		OrgId:   orgID,
+		UserId:  userID,
+		Role:    role,
+		Created: ls.now(),
+	})
+}
+
+// ApplyPendingRoles applies the roles stashed for an org while it didn't exist.
+// It's meant to be called when the org is created.
+func (ls *Implementation) ApplyPendingRoles(ctx context.Context, orgID int64) error {
+	if ls.PendingRoleStore == nil {
+		return nil
+	}
+
+	pending, err := ls.PendingRoleStore.GetPendingOrgRoles(ctx, orgID)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pending {
+		logger.Debug("Applying pending organization role", "userId", p.UserId, "orgId", p.OrgId, "role", p.Role)
+
+		err := ls.SQLStore.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: p.OrgId, UserId: p.UserId, Role: p.Role})
+		if errors.Is(err, models.ErrOrgUserAlreadyAdded) {
+			err = ls.SQLStore.UpdateOrgUser(ctx, &models.UpdateOrgUserCommand{OrgId: p.OrgId, UserId: p.UserId, Role: p.Role})
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := ls.PendingRoleStore.DeletePendingOrgRole(ctx, p.OrgId, p.UserId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ls *Implementation) handleOrgCreated(ctx context.Context, event *events.OrgCreated) error {
+	if err := ls.ApplyPendingRoles(ctx, event.Id); err != nil {
+		logger.Error("Failed to apply pending organization roles", "orgId", event.Id, "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/events"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PendingRoles(t *testing.T) {
+	user := &models.User{Id: 1, OrgId: 1}
+	store := newFakeStore(user)
+	store.addOrgUser(1, 1, models.ROLE_VIEWER)
+	pending := &fakePendingRoleStore{roles: map[int64]map[int64]*login.PendingOrgRole{}}
+	loginService := Implementation{SQLStore: store, PendingRoleStore: pending}
+
+	extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{
+		1: models.ROLE_VIEWER,
+		7: models.ROLE_EDITOR,
+	}}
+	require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState()))
+
+	require.Contains(t, pending.roles[7], int64(1), "role for missing org 7 should be stashed")
+	assert.Equal(t, models.ROLE_EDITOR, pending.roles[7][1].Role)
+
+	t.Run("pending roles are applied once the org is created", func(t *testing.T) {
+		store.addOrg(7)
+		require.NoError(t, loginService.ApplyPendingRoles(context.Background(), 7))
+
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[7][1])
+		assert.Empty(t, pending.roles[7])
+	})
+}
+
+func TestPendingRoles_sqlStore(t *testing.T) {
+	ctx := context.Background()
+	loginService, sqlStore := newSQLLoginService(t)
+	pendingStore := logindatabase.ProvidePendingRoleStore(sqlStore)
+	loginService.PendingRoleStore = pendingStore
+	bob, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "bob", Email: "bob@example.org"})
+	require.NoError(t, err)
+
+	upsert := func(role models.RoleType) *models.User {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic",
+			AuthId:     "alice-id",
+			Login:      "alice",
+			Email:      "alice@example.org",
+			OrgRoles:   map[int64]models.RoleType{bob.OrgId: models.ROLE_VIEWER, bob.OrgId + 1: role},
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		return cmd.Result
+	}
+	upsert(models.ROLE_VIEWER)
+	alice := upsert(models.ROLE_EDITOR)
+
+	pending, err := pendingStore.GetPendingOrgRoles(ctx, bob.OrgId+1)
+	require.NoError(t, err)
+	require.Len(t, pending, 1, "stashing again replaces the pending role")
+	assert.Equal(t, alice.Id, pending[0].UserId)
+	assert.Equal(t, models.ROLE_EDITOR, pending[0].Role)
+
+	org, err := sqlStore.CreateOrgWithMember("second", bob.Id)
+	require.NoError(t, err)
+	require.Equal(t, bob.OrgId+1, org.Id)
+	require.NoError(t, loginService.handleOrgCreated(ctx, &events.OrgCreated{Id: org.Id}))
+
+	query := &models.GetOrgUsersQuery{OrgId: org.Id}
+	require.NoError(t, sqlStore.GetOrgUsers(ctx, query))
+	roles := map[int64]string{}
+	for _, member := range query.Result {
+		roles[member.UserId] = member.Role
+	}
+	assert.Equal(t, map[int64]string{alice.Id: "Editor", bob.Id: "Admin"}, roles)
+	pending, err = pendingStore.GetPendingOrgRoles(ctx, org.Id)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+type fakePendingRoleStore struct {
+	roles map[int64]map[int64]*login.PendingOrgRole
+}
+
+func (f *fakePendingRoleStore) SavePendingOrgRole(ctx context.Context, role *login.PendingOrgRole) error {
+	if f.roles[role.OrgId] == nil {
+		f.roles[role.OrgId] = map[int64]*login.PendingOrgRole{}
+	}
+	f.roles[role.OrgId][role.UserId] = role
+	return nil
+}
+
+func (f *fakePendingRoleStore) GetPendingOrgRoles(ctx context.Context, orgID int64) ([]*login.PendingOrgRole, error) {
+	var result []*login.PendingOrgRole
+	for _, r := range f.roles[orgID] {
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+func (f *fakePendingRoleStore) DeletePendingOrgRole(ctx context.Context, orgID, userID int64) error {
+	delete(f.roles[orgID], userID)
+	return nil
+}
@@ -0,0 +1,40 @@
+package loginservice
+
+import (
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// startPhase starts timing a phase of UpsertUser for RecordPhaseDurations. The
+// returned function records its duration in the sync result under the span
+// name of the phase without the common prefix, e.g. "org_sync".
+func (ls *Implementation) startPhase(state *syncState, name string) func() {
+	if !ls.RecordPhaseDurations {
+		return func() {}
+	}
+
+	start := ls.now()
+	return func() {
+		if state.result.PhaseDurations == nil {
+			state.result.PhaseDurations = map[string]time.Duration{}
+		}
+		state.result.PhaseDurations[strings.TrimPrefix(name, "login.upsert_user.")] += ls.now().Sub(start)
+	}
+}
+
+// logPhaseDurations logs the phase durations recorded for RecordPhaseDurations.
+func (ls *Implementation) logPhaseDurations(extUser *models.ExternalUserInfo, state *syncState) {
+	if !ls.RecordPhaseDurations || len(state.result.PhaseDurations) == 0 {
+		return
+	}
+
+	args := []interface{}{"login", extUser.Login, "authModule", extUser.AuthModule}
+	for _, phase := range []string{"lookup", "create", "update", "token", "org_sync", "team_sync"} {
+		if d, ok := state.result.PhaseDurations[phase]; ok {
+			args = append(args, phase, d)
+		}
+	}
+	logger.Debug("Upsert user phase durations", args...)
+}
@@ -0,0 +1,103 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func Test_UpsertUser_recordPhaseDurations(t *testing.T) {
+	const teamSyncDelay = 5 * time.Millisecond
+	teamSync := func(user *models.User, externalUser *models.ExternalUserInfo) error {
+		time.Sleep(teamSyncDelay)
+		return nil
+	}
+	extUser := &models.ExternalUserInfo{
+		AuthModule: "oauth_generic",
+		AuthId:     "alice-id",
+		Login:      "alice",
+		Email:      "alice@example.org",
+		OAuthToken: &oauth2.Token{AccessToken: "token"},
+		OrgRoles:   map[int64]models.RoleType{1: models.ROLE_VIEWER},
+	}
+	upsert := func(t *testing.T, loginService *Implementation, extUser *models.ExternalUserInfo) *models.ExternalUserSyncResult {
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser, SignupAllowed: true}
+		start := time.Now()
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		elapsed := time.Since(start)
+
+		var total time.Duration
+		for phase, d := range cmd.SyncResult.PhaseDurations {
+			assert.GreaterOrEqual(t, d, time.Duration(0), phase)
+			total += d
+		}
+		assert.LessOrEqual(t, total, elapsed)
+		assert.GreaterOrEqual(t, cmd.SyncResult.PhaseDurations["team_sync"], teamSyncDelay)
+		return cmd.SyncResult
+	}
+
+	t.Run("phases of a created user are recorded", func(t *testing.T) {
+		store := newFakeStore()
+		store.addOrg(1)
+		loginService := &Implementation{
+			SQLStore:             store,
+			AuthInfoService:      &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound},
+			QuotaService:         &quota.QuotaService{Cfg: setting.NewCfg()},
+			TeamSync:             teamSync,
+			RecordPhaseDurations: true,
+		}
+
+		// without an auth module, the auth info isn't set for created users
+		result := upsert(t, loginService, &models.ExternalUserInfo{
+			Login:    "alice",
+			Email:    "alice@example.org",
+			OrgRoles: map[int64]models.RoleType{1: models.ROLE_VIEWER},
+		})
+		assert.ElementsMatch(t, []string{"lookup", "create", "org_sync", "team_sync"}, phases(result))
+	})
+
+	t.Run("phases of an updated user are recorded", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice", Email: "alice@example.org"}
+		store := newFakeStore(user)
+		store.addOrg(1)
+		loginService := &Implementation{
+			SQLStore:             store,
+			AuthInfoService:      &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			TeamSync:             teamSync,
+			RecordPhaseDurations: true,
+		}
+
+		result := upsert(t, loginService, extUser)
+		assert.ElementsMatch(t, []string{"lookup", "update", "token", "org_sync", "team_sync"}, phases(result))
+	})
+
+	t.Run("nothing is recorded when disabled", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice", Email: "alice@example.org"}
+		store := newFakeStore(user)
+		store.addOrg(1)
+		loginService := &Implementation{
+			SQLStore:        store,
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: extUser}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		assert.Nil(t, cmd.SyncResult.PhaseDurations)
+	})
+}
+
+func phases(result *models.ExternalUserSyncResult) []string {
+	phases := []string{}
+	for phase := range result.PhaseDurations {
+		phases = append(phases, phase)
+	}
+	return phases
+}
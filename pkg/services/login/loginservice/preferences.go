@@ -0,0 +1,43 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// syncPreferences syncs the locale and timezone of the user. A missing claim
+// leaves the preference as is, unless AuthoritativePreferences is set, in which
+// case it's reset to the default.
+func (ls *Implementation) syncPreferences(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo) error {
+	if ls.PreferencesStore == nil {
+		return nil
+	}
+
+	current, err := ls.PreferencesStore.GetUserPreferences(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		current = &login.UserPreferences{}
+	}
+
+	desired := login.UserPreferences{
+		Locale:   syncedPreference(current.Locale, extUser.Locale, ls.AuthoritativePreferences),
+		Timezone: syncedPreference(current.Timezone, extUser.Timezone, ls.AuthoritativePreferences),
+	}
+	if desired == *current {
+		return nil
+	}
+
+	logger.Debug("Syncing user preferences", "id", user.Id, "locale", desired.Locale, "timezone", desired.Timezone)
+	return ls.PreferencesStore.SetUserPreferences(ctx, user.Id, &desired)
+}
+
+func syncedPreference(current, claimed string, authoritative bool) string {
+	if claimed != "" || authoritative {
+		return claimed
+	}
+	return current
+}
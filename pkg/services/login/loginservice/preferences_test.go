@@ -0,0 +1,120 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePreferencesStore struct {
+	prefs  map[int64]login.UserPreferences
+	writes int
+}
+
+func (f *fakePreferencesStore) GetUserPreferences(ctx context.Context, userID int64) (*login.UserPreferences, error) {
+	prefs, ok := f.prefs[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &prefs, nil
+}
+
+func (f *fakePreferencesStore) SetUserPreferences(ctx context.Context, userID int64, prefs *login.UserPreferences) error {
+	f.writes++
+	f.prefs[userID] = *prefs
+	return nil
+}
+
+func TestSyncPreferences_sqlStore(t *testing.T) {
+	ctx := context.Background()
+	loginService, sqlStore := newSQLLoginService(t)
+	preferencesStore := logindatabase.ProvideUserPreferencesStore(sqlStore)
+	loginService.PreferencesStore = preferencesStore
+	upsert := func(locale, timezone string) *models.User {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic",
+			AuthId:     "alice-id",
+			Login:      "alice",
+			Email:      "alice@example.org",
+			Locale:     locale,
+			Timezone:   timezone,
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		return cmd.Result
+	}
+
+	user := upsert("fr-FR", "Europe/Paris")
+	prefs, err := preferencesStore.GetUserPreferences(ctx, user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, &login.UserPreferences{Locale: "fr-FR", Timezone: "Europe/Paris"}, prefs)
+
+	upsert("", "UTC")
+	prefs, err = preferencesStore.GetUserPreferences(ctx, user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, &login.UserPreferences{Locale: "fr-FR", Timezone: "UTC"}, prefs)
+
+	require.NoError(t, sqlStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: user.Id}))
+	prefs, err = preferencesStore.GetUserPreferences(ctx, user.Id)
+	require.NoError(t, err)
+	assert.Nil(t, prefs)
+}
+
+func Test_UpsertUser_syncPreferences(t *testing.T) {
+	setup := func(authoritative bool) (func(locale, timezone string), *fakePreferencesStore) {
+		user := &models.User{Id: 1, Login: "alice"}
+		prefs := &fakePreferencesStore{prefs: map[int64]login.UserPreferences{}}
+		loginService := &Implementation{
+			SQLStore:                 newFakeStore(user),
+			AuthInfoService:          &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			PreferencesStore:         prefs,
+			AuthoritativePreferences: authoritative,
+		}
+
+		upsert := func(locale, timezone string) {
+			cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", Locale: locale, Timezone: timezone}}
+			require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		}
+		return upsert, prefs
+	}
+
+	t.Run("set and update", func(t *testing.T) {
+		upsert, prefs := setup(false)
+
+		upsert("en-US", "Europe/Berlin")
+		assert.Equal(t, login.UserPreferences{Locale: "en-US", Timezone: "Europe/Berlin"}, prefs.prefs[1])
+		assert.Equal(t, 1, prefs.writes)
+
+		upsert("en-US", "Europe/Berlin")
+		assert.Equal(t, 1, prefs.writes, "unchanged preferences should not be written")
+
+		upsert("de-DE", "Europe/Berlin")
+		assert.Equal(t, login.UserPreferences{Locale: "de-DE", Timezone: "Europe/Berlin"}, prefs.prefs[1])
+		assert.Equal(t, 2, prefs.writes)
+	})
+
+	t.Run("missing claims keep existing preferences", func(t *testing.T) {
+		upsert, prefs := setup(false)
+
+		upsert("en-US", "Europe/Berlin")
+		upsert("", "")
+		assert.Equal(t, login.UserPreferences{Locale: "en-US", Timezone: "Europe/Berlin"}, prefs.prefs[1])
+		assert.Equal(t, 1, prefs.writes)
+	})
+
+	t.Run("missing claims reset preferences when authoritative", func(t *testing.T) {
+		upsert, prefs := setup(true)
+
+		upsert("en-US", "Europe/Berlin")
+		upsert("", "Europe/Berlin")
+		assert.Equal(t, login.UserPreferences{Timezone: "Europe/Berlin"}, prefs.prefs[1])
+		upsert("", "")
+		assert.Equal(t, login.UserPreferences{}, prefs.prefs[1])
+		assert.Equal(t, 3, prefs.writes)
+	})
+}
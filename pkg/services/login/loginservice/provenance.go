@@ -0,0 +1,82 @@
+package loginservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// RoleExplanation explains why a user has their role in an org.
+type RoleExplanation struct {
+	UserId int64
+	OrgId  int64
+	Role   models.RoleType
+	// ExternallySet is true when the current role is the one last set by external sync.
+	ExternallySet bool
+	AuthModule    string
+	// Source is the claim or group that produced the role, if known.
+	Source     string
+	LastSynced time.Time
+}
+
+// ExplainUserOrgRole reports the current role of a user in an org and, when
+// provenance is recorded, whether and how it was set by external sync.
+func (ls *Implementation) ExplainUserOrgRole(ctx context.Context, userID, orgID int64) (*RoleExplanation, error) {
+	orgsQuery := &models.GetUserOrgListQuery{UserId: userID}
+	if err := ls.readStore(ctx, false).GetUserOrgList(ctx, orgsQuery); err != nil {
+		return nil, err
+	}
+
+	var explanation *RoleExplanation
+	for _, org := range orgsQuery.Result {
+		if org.OrgId == orgID {
+			explanation = &RoleExplanation{UserId: userID, OrgId: orgID, Role: org.Role}
+			break
+		}
+	}
+	if explanation == nil {
+		return nil, models.ErrOrgUserNotFound
+	}
+
+	if ls.RoleProvenanceStore == nil {
+		return explanation, nil
+	}
+
+	provenance, err := ls.RoleProvenanceStore.GetOrgRoleProvenance(ctx, userID, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if provenance != nil {
+		explanation.ExternallySet = provenance.Role == explanation.Role
+		explanation.AuthModule = provenance.AuthModule
+		explanation.Source = provenance.Source
+		explanation.LastSynced = provenance.Synced
+	}
+
+	return explanation, nil
+}
+
+func (ls *Implementation) recordOrgRoleProvenance(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, orgID int64, role models.RoleType) error {
+	if ls.RoleProvenanceStore == nil {
+		return nil
+	}
+
+	return ls.RoleProvenanceStore.SaveOrgRoleProvenance(ctx, &login.OrgRoleProvenance{
+		UserId:     user.Id,
+		OrgId:      orgID,
+		Role:       role,
+		AuthModule: extUser.AuthModule,
+		Source:     extUser.OrgRoleSources[orgID],
+		Synced:     ls.now(),
+	})
+}
+
+func (ls *Implementation) deleteOrgRoleProvenance(ctx context.Context, userID, orgID int64) error {
+	if ls.RoleProvenanceStore == nil {
+		return nil
+	}
+
+	return ls.RoleProvenanceStore.DeleteOrgRoleProvenance(ctx, userID, orgID)
+}
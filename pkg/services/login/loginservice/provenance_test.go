@@ -0,0 +1,125 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExplainUserOrgRole(t *testing.T) {
+	syncTime := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewMock()
+	clk.Set(syncTime)
+
+	user := &models.User{Id: 1, OrgId: 1}
+	store := newFakeStore(user)
+	store.addOrgUser(1, 1, models.ROLE_VIEWER)
+	store.addOrg(5)
+	provenance := &fakeRoleProvenanceStore{provenance: map[[2]int64]*login.OrgRoleProvenance{}}
+	loginService := Implementation{SQLStore: store, RoleProvenanceStore: provenance, Clock: clk}
+
+	extUser := &models.ExternalUserInfo{
+		AuthModule:     "oauth_okta",
+		OrgRoles:       map[int64]models.RoleType{1: models.ROLE_VIEWER, 5: models.ROLE_ADMIN},
+		OrgRoleSources: map[int64]string{5: "groups:grafana-admins"},
+	}
+	require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState()))
+
+	explanation, err := loginService.ExplainUserOrgRole(context.Background(), 1, 5)
+	require.NoError(t, err)
+	assert.Equal(t, &RoleExplanation{
+		UserId:        1,
+		OrgId:         5,
+		Role:          models.ROLE_ADMIN,
+		ExternallySet: true,
+		AuthModule:    "oauth_okta",
+		Source:        "groups:grafana-admins",
+		LastSynced:    syncTime,
+	}, explanation)
+
+	t.Run("a role changed after sync isn't externally set", func(t *testing.T) {
+		store.orgUsers[5][1] = models.ROLE_EDITOR
+
+		explanation, err := loginService.ExplainUserOrgRole(context.Background(), 1, 5)
+		require.NoError(t, err)
+		assert.Equal(t, models.ROLE_EDITOR, explanation.Role)
+		assert.False(t, explanation.ExternallySet)
+	})
+
+	t.Run("only added and changed roles are recorded", func(t *testing.T) {
+		saves := provenance.saves
+		require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState()))
+
+		assert.Equal(t, saves+1, provenance.saves, "only the role changed in org 5 should be recorded")
+		assert.NotContains(t, provenance.provenance, [2]int64{1, 1}, "the unchanged role in org 1 should not be recorded")
+		assert.Equal(t, models.ROLE_ADMIN, provenance.provenance[[2]int64{1, 5}].Role)
+	})
+
+	t.Run("non members get ErrOrgUserNotFound", func(t *testing.T) {
+		_, err := loginService.ExplainUserOrgRole(context.Background(), 1, 9)
+		require.ErrorIs(t, err, models.ErrOrgUserNotFound)
+	})
+}
+
+func TestExplainUserOrgRole_sqlStore(t *testing.T) {
+	ctx := context.Background()
+	loginService, sqlStore := newSQLLoginService(t)
+	provenanceStore := logindatabase.ProvideRoleProvenanceStore(sqlStore)
+	loginService.RoleProvenanceStore = provenanceStore
+	bob, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "bob", Email: "bob@example.org"})
+	require.NoError(t, err)
+
+	upsert := func(role models.RoleType) *models.User {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule:     "oauth_okta",
+			AuthId:         "alice-id",
+			Login:          "alice",
+			Email:          "alice@example.org",
+			OrgRoles:       map[int64]models.RoleType{bob.OrgId: role},
+			OrgRoleSources: map[int64]string{bob.OrgId: "groups:grafana-" + string(role)},
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		return cmd.Result
+	}
+	upsert(models.ROLE_VIEWER)
+	alice := upsert(models.ROLE_EDITOR)
+
+	explanation, err := loginService.ExplainUserOrgRole(ctx, alice.Id, bob.OrgId)
+	require.NoError(t, err)
+	assert.True(t, explanation.ExternallySet)
+	assert.Equal(t, models.ROLE_EDITOR, explanation.Role)
+	assert.Equal(t, "oauth_okta", explanation.AuthModule)
+	assert.Equal(t, "groups:grafana-Editor", explanation.Source)
+
+	require.NoError(t, sqlStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: alice.Id}))
+	provenance, err := provenanceStore.GetOrgRoleProvenance(ctx, alice.Id, bob.OrgId)
+	require.NoError(t, err)
+	assert.Nil(t, provenance)
+}
+
+type fakeRoleProvenanceStore struct {
+	provenance map[[2]int64]*login.OrgRoleProvenance
+	saves      int
+}
+
+func (f *fakeRoleProvenanceStore) SaveOrgRoleProvenance(ctx context.Context, p *login.OrgRoleProvenance) error {
+	f.saves++
+	f.provenance[[2]int64{p.UserId, p.OrgId}] = p
+	return nil
+}
+
+func (f *fakeRoleProvenanceStore) GetOrgRoleProvenance(ctx context.Context, userID, orgID int64) (*login.OrgRoleProvenance, error) {
+	return f.provenance[[2]int64{userID, orgID}], nil
+}
+
+func (f *fakeRoleProvenanceStore) DeleteOrgRoleProvenance(ctx context.Context, userID, orgID int64) error {
+	delete(f.provenance, [2]int64{userID, orgID})
+	return nil
+}
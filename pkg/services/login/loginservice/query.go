@@ -0,0 +1,107 @@
+package loginservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+const defaultExternalUserPageSize = 100
+
+// ExternalUserQuery filters the users that have an external identity. Zero
+// values don't filter.
+type ExternalUserQuery struct {
+	// AuthModule restricts the result to users of an auth module, any external
+	// user is returned when empty.
+	AuthModule string
+	IsDisabled *bool
+	// LastSeenBefore restricts the result to users not seen since.
+	LastSeenBefore time.Time
+	OrgId          int64
+
+	// Page starts at 1.
+	Page    int
+	PerPage int
+}
+
+// ExternalUserPage is a page of users matching an ExternalUserQuery.
+type ExternalUserPage struct {
+	TotalCount int64
+	Page       int
+	PerPage    int
+	Users      []*models.UserSearchHitDTO
+}
+
+// QueryExternalUsers lists the external users matching the query.
+func (ls *Implementation) QueryExternalUsers(ctx context.Context, query ExternalUserQuery) (*ExternalUserPage, error) {
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	if query.PerPage <= 0 {
+		query.PerPage = defaultExternalUserPageSize
+	}
+
+	searchQuery := &models.SearchUsersQuery{
+		OrgId:      query.OrgId,
+		AuthModule: query.AuthModule,
+		IsDisabled: query.IsDisabled,
+		Page:       query.Page,
+		Limit:      query.PerPage,
+	}
+	if query.AuthModule == "" {
+		searchQuery.Filters = append(searchQuery.Filters, externalUserFilter{})
+	}
+	if !query.LastSeenBefore.IsZero() {
+		searchQuery.Filters = append(searchQuery.Filters, lastSeenBeforeFilter{before: query.LastSeenBefore})
+	}
+
+	if err := ls.readStore(ctx, false).SearchUsers(ctx, searchQuery); err != nil {
+		return nil, err
+	}
+
+	return &ExternalUserPage{
+		TotalCount: searchQuery.Result.TotalCount,
+		Page:       query.Page,
+		PerPage:    query.PerPage,
+		Users:      searchQuery.Result.Users,
+	}, nil
+}
+
+// externalUserFilter matches users with an auth module.
+type externalUserFilter struct{}
+
+func (externalUserFilter) WhereCondition() *models.WhereCondition {
+	return &models.WhereCondition{
+		Condition: "EXISTS (SELECT 1 FROM user_auth WHERE user_auth.user_id = u.id AND user_auth.auth_module != ?)",
+		Params:    "",
+	}
+}
+
+func (externalUserFilter) InCondition() *models.InCondition {
+	return nil
+}
+
+func (externalUserFilter) JoinCondition() *models.JoinCondition {
+	return nil
+}
+
+// lastSeenBeforeFilter matches users not seen since a point in time.
+type lastSeenBeforeFilter struct {
+	before time.Time
+}
+
+func (f lastSeenBeforeFilter) WhereCondition() *models.WhereCondition {
+	return &models.WhereCondition{
+		Condition: "u.last_seen_at < ?",
+		Params:    f.before,
+	}
+}
+
+func (lastSeenBeforeFilter) InCondition() *models.InCondition {
+	return nil
+}
+
+func (lastSeenBeforeFilter) JoinCondition() *models.JoinCondition {
+	return nil
+}
@@ -0,0 +1,92 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_QueryExternalUsers(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	ctx := context.Background()
+
+	createUser := func(login, authModule string, disabled, recentlySeen bool) {
+		user, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: login, Email: login + "@example.org"})
+		require.NoError(t, err)
+
+		if authModule != "" {
+			err = sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+				_, err := sess.Insert(&models.UserAuth{UserId: user.Id, AuthModule: authModule, AuthId: login, Created: time.Now()})
+				return err
+			})
+			require.NoError(t, err)
+		}
+		if disabled {
+			require.NoError(t, sqlStore.DisableUser(ctx, &models.DisableUserCommand{UserId: user.Id, IsDisabled: true}))
+		}
+		if recentlySeen {
+			require.NoError(t, sqlStore.UpdateUserLastSeenAt(ctx, &models.UpdateUserLastSeenAtCommand{UserId: user.Id}))
+		}
+	}
+
+	createUser("ldap-disabled-stale", models.AuthModuleLDAP, true, false)
+	createUser("ldap-disabled-recent", models.AuthModuleLDAP, true, true)
+	createUser("ldap-enabled-stale", models.AuthModuleLDAP, false, false)
+	createUser("oauth-disabled-stale", "oauth_generic_oauth", true, false)
+	createUser("local-disabled-stale", "", true, false)
+
+	loginService := &Implementation{SQLStore: sqlStore}
+	disabled := true
+	cutoff := time.Now().Add(-24 * time.Hour)
+
+	logins := func(page *ExternalUserPage) []string {
+		result := []string{}
+		for _, u := range page.Users {
+			result = append(result, u.Login)
+		}
+		return result
+	}
+
+	t.Run("disabled, module and cutoff", func(t *testing.T) {
+		page, err := loginService.QueryExternalUsers(ctx, ExternalUserQuery{
+			AuthModule:     models.AuthModuleLDAP,
+			IsDisabled:     &disabled,
+			LastSeenBefore: cutoff,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"ldap-disabled-stale"}, logins(page))
+		assert.Equal(t, int64(1), page.TotalCount)
+	})
+
+	t.Run("disabled and cutoff for any module", func(t *testing.T) {
+		page, err := loginService.QueryExternalUsers(ctx, ExternalUserQuery{
+			IsDisabled:     &disabled,
+			LastSeenBefore: cutoff,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"ldap-disabled-stale", "oauth-disabled-stale"}, logins(page))
+		assert.Equal(t, int64(2), page.TotalCount)
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		page, err := loginService.QueryExternalUsers(ctx, ExternalUserQuery{
+			IsDisabled:     &disabled,
+			LastSeenBefore: cutoff,
+			Page:           2,
+			PerPage:        1,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"oauth-disabled-stale"}, logins(page))
+		assert.Equal(t, int64(2), page.TotalCount)
+		assert.Equal(t, 2, page.Page)
+		assert.Equal(t, 1, page.PerPage)
+	})
+}
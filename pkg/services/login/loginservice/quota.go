@@ -0,0 +1,122 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/infra/metrics"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/quota"
+)
+
+// userQuotaCache caches a reached user quota for new signups. A "not reached"
+// result is never cached, since concurrent signups would all be admitted on it
+// and overshoot the quota.
+type userQuotaCache struct {
+	mu      sync.Mutex
+	expires time.Time
+}
+
+func (c *userQuotaCache) reached(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !now.After(c.expires)
+}
+
+func (c *userQuotaCache) setReached(expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expires = expires
+}
+
+// invalidate drops the cached result so that the next signup checks the quota
+// again. The login service calls it when it creates or deletes a user, changes
+// made elsewhere, e.g. a quota update, apply once the cached result expires.
+func (c *userQuotaCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expires = time.Time{}
+}
+
+// userQuotaReached checks the user quota, using a cached reached quota when
+// QuotaCacheTTL is set. Signed in requests and commands with a ProvisioningOrgID
+// are also subject to org scoped quotas and are never cached.
+func (ls *Implementation) userQuotaReached(ctx context.Context, cmd *models.UpsertUserCommand) (bool, error) {
+	if cmd.ProvisioningOrgID != 0 {
+		return ls.QuotaService.CheckQuotaReached(ctx, "user", &quota.ScopeParameters{OrgId: cmd.ProvisioningOrgID})
+	}
+
+	c := cmd.ReqContext
+	if ls.QuotaCacheTTL <= 0 || (c != nil && c.IsSignedIn) {
+		return ls.QuotaService.QuotaReached(c, "user")
+	}
+
+	if ls.quotaCache.reached(ls.now()) {
+		return true, nil
+	}
+
+	reached, err := ls.QuotaService.QuotaReached(c, "user")
+	if err != nil {
+		return false, err
+	}
+
+	if reached {
+		ls.quotaCache.setReached(ls.now().Add(ls.QuotaCacheTTL))
+	}
+	return reached, nil
+}
+
+// publishSignupQuotaReached notifies that an external user was rejected because
+// the user quota is reached.
+func (ls *Implementation) publishSignupQuotaReached(ctx context.Context, cmd *models.UpsertUserCommand) {
+	if ls.Bus == nil {
+		return
+	}
+
+	event := &models.SignupQuotaReachedEvent{
+		AuthModule: cmd.ExternalUser.AuthModule,
+		Login:      cmd.ExternalUser.Login,
+		OrgId:      cmd.ProvisioningOrgID,
+	}
+	if event.OrgId == 0 && cmd.ReqContext != nil && cmd.ReqContext.IsSignedIn {
+		event.OrgId = cmd.ReqContext.OrgId
+	}
+	if err := ls.Bus.Publish(ctx, event); err != nil {
+		logger.Error("Failed to publish signup quota reached event", "login", event.Login, "error", err)
+	}
+}
+
+// checkUserQuotaSoftLimit warns when a user is created while the global user
+// quota is used beyond UserQuotaSoftLimit. Failing to get the usage only
+// skips the check.
+func (ls *Implementation) checkUserQuotaSoftLimit(ctx context.Context, extUser *models.ExternalUserInfo, state *syncState) {
+	if ls.UserQuotaSoftLimit <= 0 || ls.QuotaUsage == nil {
+		return
+	}
+
+	used, limit, err := ls.QuotaUsage.GlobalQuotaUsage(ctx, "user")
+	if err != nil {
+		logger.Warn("Failed to get the user quota usage", "error", err)
+		return
+	}
+	if limit <= 0 || float64(used) < ls.UserQuotaSoftLimit*float64(limit) {
+		return
+	}
+
+	logger.Warn("User quota is nearly reached", "used", used, "limit", limit)
+	metrics.MApiLoginUserQuotaNearLimit.Inc()
+	state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("user quota is nearly reached, %d of %d users", used, limit))
+	if ls.Bus == nil {
+		return
+	}
+
+	event := &models.UserQuotaNearLimitEvent{AuthModule: extUser.AuthModule, Login: extUser.Login, Used: used, Limit: limit}
+	if err := ls.Bus.Publish(ctx, event); err != nil {
+		logger.Error("Failed to publish user quota near limit event", "login", event.Login, "error", err)
+	}
+}
@@ -0,0 +1,318 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_userQuotaReached_cache(t *testing.T) {
+	clk := clock.NewMock()
+	quotaService := &fakeQuotaService{reached: true}
+	loginService := Implementation{
+		Clock:           clk,
+		SQLStore:        newFakeStore(),
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound},
+		QuotaService:    quotaService,
+		QuotaCacheTTL:   time.Minute,
+	}
+	signup := func(login string) error {
+		return loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			ReqContext:    &models.ReqContext{},
+			SignupAllowed: true,
+			ExternalUser:  &models.ExternalUserInfo{Login: login},
+		})
+	}
+
+	t.Run("reached result is cached for a burst of signups", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			require.ErrorIs(t, signup("alice"), login.ErrUsersQuotaReached)
+		}
+		assert.Equal(t, 1, quotaService.calls)
+	})
+
+	t.Run("cache expires after the TTL", func(t *testing.T) {
+		clk.Add(2 * time.Minute)
+
+		quotaService.reached = false
+		require.NoError(t, signup("alice"))
+		assert.Equal(t, 2, quotaService.calls)
+	})
+
+	t.Run("not reached result isn't cached", func(t *testing.T) {
+		require.NoError(t, signup("bob"))
+		assert.Equal(t, 3, quotaService.calls)
+	})
+
+	t.Run("signed in requests are not cached", func(t *testing.T) {
+		loginService.quotaCache.setReached(clk.Now().Add(time.Hour))
+		err := loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			ReqContext:    &models.ReqContext{IsSignedIn: true},
+			SignupAllowed: true,
+			ExternalUser:  &models.ExternalUserInfo{Login: "carol"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 4, quotaService.calls)
+	})
+
+	t.Run("a create invalidates the cached result", func(t *testing.T) {
+		quotaService.reached = true
+		require.ErrorIs(t, signup("dave"), login.ErrUsersQuotaReached)
+		assert.Equal(t, 5, quotaService.calls)
+
+		// signed in requests check the quota and create the user anyway
+		quotaService.reached = false
+		err := loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			ReqContext:    &models.ReqContext{IsSignedIn: true},
+			SignupAllowed: true,
+			ExternalUser:  &models.ExternalUserInfo{Login: "erin"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 6, quotaService.calls)
+
+		require.NoError(t, signup("dave"))
+		assert.Equal(t, 7, quotaService.calls)
+	})
+}
+
+func Test_userQuotaReached_deleteInvalidatesCache(t *testing.T) {
+	ctx := context.Background()
+	loginService, sqlStore := newSQLLoginService(t)
+	quotaService := &fakeQuotaService{reached: true}
+	loginService.QuotaService = quotaService
+	loginService.QuotaCacheTTL = time.Minute
+
+	alice, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org", SkipOrgSetup: true})
+	require.NoError(t, err)
+	signup := func() error {
+		return loginService.UpsertUser(ctx, &models.UpsertUserCommand{
+			ReqContext:    &models.ReqContext{},
+			SignupAllowed: true,
+			ExternalUser:  &models.ExternalUserInfo{Login: "bob", Email: "bob@example.org"},
+		})
+	}
+
+	require.ErrorIs(t, signup(), login.ErrUsersQuotaReached)
+	require.ErrorIs(t, signup(), login.ErrUsersQuotaReached)
+	assert.Equal(t, 1, quotaService.calls)
+
+	require.NoError(t, loginService.DeleteExternalUser(ctx, alice.Id))
+	quotaService.reached = false
+	require.NoError(t, signup())
+	assert.Equal(t, 2, quotaService.calls)
+}
+
+func Test_userQuotaReached_concurrentSignups(t *testing.T) {
+	quotaService := &admittingQuotaService{limit: 3}
+	loginService := Implementation{
+		QuotaService:  quotaService,
+		QuotaCacheTTL: time.Minute,
+	}
+
+	var wg sync.WaitGroup
+	var admitted int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reached, err := loginService.userQuotaReached(context.Background(), &models.UpsertUserCommand{ReqContext: &models.ReqContext{}})
+			assert.NoError(t, err)
+			if !reached {
+				atomic.AddInt32(&admitted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(3), admitted)
+}
+
+func Test_userQuotaReached_provisioningOrgID(t *testing.T) {
+	quotaService := &fakeQuotaService{reached: true}
+	loginService := Implementation{
+		SQLStore:        newFakeStore(),
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound},
+		QuotaService:    quotaService,
+		QuotaCacheTTL:   time.Minute,
+	}
+
+	err := loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{
+		ReqContext:        &models.ReqContext{IsSignedIn: true, SignedInUser: &models.SignedInUser{OrgId: 1}},
+		SignupAllowed:     true,
+		ProvisioningOrgID: 3,
+		ExternalUser:      &models.ExternalUserInfo{Login: "alice"},
+	})
+	require.ErrorIs(t, err, login.ErrUsersQuotaReached)
+	assert.Equal(t, 0, quotaService.calls, "the request's org should not be used")
+	require.Len(t, quotaService.checkedScopes, 1)
+	assert.Equal(t, int64(3), quotaService.checkedScopes[0].OrgId)
+
+	t.Run("works without a request", func(t *testing.T) {
+		quotaService.reached = false
+		err := loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			SignupAllowed:     true,
+			ProvisioningOrgID: 3,
+			ExternalUser:      &models.ExternalUserInfo{Login: "alice"},
+		})
+		require.NoError(t, err)
+		assert.Len(t, quotaService.checkedScopes, 2)
+	})
+
+	t.Run("signup not allowed without a request", func(t *testing.T) {
+		err := loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			ProvisioningOrgID: 3,
+			ExternalUser:      &models.ExternalUserInfo{Login: "bob"},
+		})
+		require.ErrorIs(t, err, login.ErrSignupNotAllowed)
+	})
+}
+
+func Test_UpsertUser_signupQuotaReachedEvent(t *testing.T) {
+	var events []*models.SignupQuotaReachedEvent
+	eventBus := bus.New()
+	eventBus.AddEventListener(func(ctx context.Context, e *models.SignupQuotaReachedEvent) error {
+		events = append(events, e)
+		return nil
+	})
+
+	quotaService := &fakeQuotaService{reached: true}
+	loginService := Implementation{
+		SQLStore:        newFakeStore(),
+		Bus:             eventBus,
+		AuthInfoService: &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}},
+		QuotaService:    quotaService,
+	}
+	signup := func(provisioningOrgID int64) error {
+		return loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{
+			ReqContext:        &models.ReqContext{},
+			SignupAllowed:     true,
+			ProvisioningOrgID: provisioningOrgID,
+			ExternalUser:      &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", Login: "alice", Email: "alice@example.com", Name: "Alice"},
+		})
+	}
+
+	require.ErrorIs(t, signup(0), login.ErrUsersQuotaReached)
+	require.ErrorIs(t, signup(3), login.ErrUsersQuotaReached)
+	assert.Equal(t, []*models.SignupQuotaReachedEvent{
+		{AuthModule: "oauth_generic_oauth", Login: "alice"},
+		{AuthModule: "oauth_generic_oauth", Login: "alice", OrgId: 3},
+	}, events)
+
+	t.Run("no event when the quota isn't reached", func(t *testing.T) {
+		quotaService.reached = false
+		require.NoError(t, signup(0))
+		assert.Len(t, events, 2)
+	})
+}
+
+func Test_UpsertUser_userQuotaSoftLimit(t *testing.T) {
+	tests := []struct {
+		used    int64
+		wantErr error
+		warned  bool
+	}{
+		{used: 89},
+		{used: 95, warned: true},
+		{used: 100, wantErr: login.ErrUsersQuotaReached},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d%% of the quota used", tt.used), func(t *testing.T) {
+			var events []*models.UserQuotaNearLimitEvent
+			eventBus := bus.New()
+			eventBus.AddEventListener(func(ctx context.Context, e *models.UserQuotaNearLimitEvent) error {
+				events = append(events, e)
+				return nil
+			})
+			loginService := Implementation{
+				SQLStore:           newFakeStore(),
+				Bus:                eventBus,
+				AuthInfoService:    &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}},
+				QuotaService:       &fakeQuotaService{reached: tt.used >= 100},
+				QuotaUsage:         &fakeQuotaUsage{used: tt.used, limit: 100},
+				UserQuotaSoftLimit: 0.9,
+			}
+
+			cmd := &models.UpsertUserCommand{
+				ReqContext:    &models.ReqContext{},
+				SignupAllowed: true,
+				ExternalUser:  &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", Login: "alice"},
+			}
+			err := loginService.UpsertUser(context.Background(), cmd)
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				assert.Empty(t, events)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, cmd.Result, "the user is created")
+			if tt.warned {
+				assert.Equal(t, []string{"user quota is nearly reached, 95 of 100 users"}, cmd.SyncResult.Warnings)
+				assert.Equal(t, []*models.UserQuotaNearLimitEvent{{AuthModule: "oauth_generic_oauth", Login: "alice", Used: 95, Limit: 100}}, events)
+			} else {
+				assert.Empty(t, cmd.SyncResult.Warnings)
+				assert.Empty(t, events)
+			}
+		})
+	}
+}
+
+type fakeQuotaUsage struct {
+	used  int64
+	limit int64
+}
+
+func (f *fakeQuotaUsage) GlobalQuotaUsage(ctx context.Context, target string) (int64, int64, error) {
+	return f.used, f.limit, nil
+}
+
+// admittingQuotaService counts every signup it finds under the limit as a new
+// user, like a quota check and a user creation that can't interleave.
+type admittingQuotaService struct {
+	quota.Service
+	mu    sync.Mutex
+	limit int
+	users int
+}
+
+func (f *admittingQuotaService) QuotaReached(c *models.ReqContext, target string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.users >= f.limit {
+		return true, nil
+	}
+	f.users++
+	return false, nil
+}
+
+type fakeQuotaService struct {
+	quota.Service
+	reached bool
+	calls   int
+	// checkedScopes are the scopes of CheckQuotaReached calls
+	checkedScopes []*quota.ScopeParameters
+}
+
+func (f *fakeQuotaService) CheckQuotaReached(ctx context.Context, target string, scopeParams *quota.ScopeParameters) (bool, error) {
+	f.checkedScopes = append(f.checkedScopes, scopeParams)
+	return f.reached, nil
+}
+
+func (f *fakeQuotaService) QuotaReached(c *models.ReqContext, target string) (bool, error) {
+	f.calls++
+	return f.reached, nil
+}
@@ -0,0 +1,114 @@
+package loginservice
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// defaultRawClaimsMaxSize is the default of RawClaimsMaxSize, in bytes.
+const defaultRawClaimsMaxSize = 16 * 1024
+
+const redactedClaim = "[redacted]"
+
+// truncatedClaimsKey marks a snapshot that some claims were left out of.
+const truncatedClaimsKey = "_truncated"
+
+// sensitiveClaimKeyParts redact, by default, the claims whose key contains any
+// of them, ignoring case.
+var sensitiveClaimKeyParts = []string{"token", "secret", "password", "assertion", "credential", "signature"}
+
+// rawClaimsSnapshot returns the JSON snapshot of the raw claims of the external
+// user to store with its auth info, empty if it has none. Sensitive claims are
+// redacted, and claims are left out in key order once the snapshot would
+// exceed RawClaimsMaxSize.
+func (ls *Implementation) rawClaimsSnapshot(extUser *models.ExternalUserInfo) string {
+	if extUser.RawClaims == nil {
+		return ""
+	}
+
+	claims := ls.redactClaims(extUser.RawClaims)
+	snapshot, err := json.Marshal(claims)
+	if err != nil {
+		logger.Warn("Not storing raw claims that can't be encoded", "authmodule", extUser.AuthModule, "error", err)
+		return ""
+	}
+
+	maxSize := ls.RawClaimsMaxSize
+	if maxSize <= 0 {
+		maxSize = defaultRawClaimsMaxSize
+	}
+	if len(snapshot) <= maxSize {
+		return string(snapshot)
+	}
+
+	keys := make([]string, 0, len(claims))
+	for key := range claims {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bounded := map[string]interface{}{truncatedClaimsKey: true}
+	size := len(`{"` + truncatedClaimsKey + `":true}`)
+	for _, key := range keys {
+		// keys and values can be encoded since the whole snapshot could
+		encodedKey, _ := json.Marshal(key)
+		encodedValue, _ := json.Marshal(claims[key])
+		entrySize := len(encodedKey) + len(":") + len(encodedValue) + len(",")
+		if size+entrySize > maxSize {
+			continue
+		}
+		bounded[key] = claims[key]
+		size += entrySize
+	}
+	logger.Debug("Truncated raw claims snapshot", "authmodule", extUser.AuthModule, "claims", len(claims), "kept", len(bounded)-1)
+
+	snapshot, _ = json.Marshal(bounded)
+	return string(snapshot)
+}
+
+// redactClaims returns a copy of the claims with the values of sensitive keys
+// replaced, in nested objects too. Keys are sensitive if they contain any of
+// sensitiveClaimKeyParts or equal any of RawClaimsRedactedKeys, ignoring case.
+func (ls *Implementation) redactClaims(claims map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(claims))
+	for key, value := range claims {
+		if ls.isSensitiveClaim(key) {
+			redacted[key] = redactedClaim
+			continue
+		}
+		redacted[key] = ls.redactClaimValue(value)
+	}
+	return redacted
+}
+
+func (ls *Implementation) redactClaimValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return ls.redactClaims(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = ls.redactClaimValue(item)
+		}
+		return values
+	}
+	return value
+}
+
+func (ls *Implementation) isSensitiveClaim(key string) bool {
+	lower := strings.ToLower(key)
+	for _, part := range sensitiveClaimKeyParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	for _, redactedKey := range ls.RawClaimsRedactedKeys {
+		if strings.EqualFold(key, redactedKey) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package loginservice
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	"github.com/grafana/grafana/pkg/services/quota"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_rawClaims(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) *Implementation {
+		sqlStore := sqlstore.InitTestDB(t)
+		secretsService := secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore))
+		authInfoStore := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsService)
+		return &Implementation{
+			SQLStore:        sqlStore,
+			QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService: authinfoservice.ProvideAuthInfoService(&authinfoservice.OSSUserProtectionImpl{}, authInfoStore),
+		}
+	}
+	upsert := func(t *testing.T, loginService *Implementation, claims map[string]interface{}) {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic",
+			AuthId:     "1",
+			Login:      "alice",
+			Email:      "alice@example.org",
+			RawClaims:  claims,
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+	}
+	storedClaims := func(t *testing.T, loginService *Implementation) map[string]interface{} {
+		query := &models.GetExternalUserInfoByLoginQuery{LoginOrEmail: "alice"}
+		require.NoError(t, loginService.AuthInfoService.GetExternalUserInfoByLogin(ctx, query))
+		return query.Result.RawClaims
+	}
+
+	t.Run("claims are stored at creation and updated at login", func(t *testing.T) {
+		loginService := setup(t)
+
+		upsert(t, loginService, map[string]interface{}{"sub": "1", "groups": []interface{}{"admins"}})
+		assert.Equal(t, map[string]interface{}{"sub": "1", "groups": []interface{}{"admins"}}, storedClaims(t, loginService))
+
+		upsert(t, loginService, map[string]interface{}{"sub": "1", "groups": []interface{}{"editors"}})
+		assert.Equal(t, map[string]interface{}{"sub": "1", "groups": []interface{}{"editors"}}, storedClaims(t, loginService))
+	})
+
+	t.Run("stored claims are kept at a login without claims", func(t *testing.T) {
+		loginService := setup(t)
+
+		upsert(t, loginService, map[string]interface{}{"sub": "1"})
+		upsert(t, loginService, nil)
+		assert.Equal(t, map[string]interface{}{"sub": "1"}, storedClaims(t, loginService))
+	})
+
+	t.Run("sensitive claims are redacted", func(t *testing.T) {
+		loginService := setup(t)
+		loginService.RawClaimsRedactedKeys = []string{"SSN"}
+
+		upsert(t, loginService, map[string]interface{}{
+			"sub":          "1",
+			"access_token": "eyJhbGciOi",
+			"ssn":          "078-05-1120",
+			"upstream":     map[string]interface{}{"id_token": "eyJhbGciOi", "idp": "okta"},
+		})
+		assert.Equal(t, map[string]interface{}{
+			"sub":          "1",
+			"access_token": redactedClaim,
+			"ssn":          redactedClaim,
+			"upstream":     map[string]interface{}{"id_token": redactedClaim, "idp": "okta"},
+		}, storedClaims(t, loginService))
+	})
+}
+
+func TestRawClaimsSnapshot(t *testing.T) {
+	t.Run("snapshot is bounded", func(t *testing.T) {
+		loginService := &Implementation{RawClaimsMaxSize: 64}
+		extUser := &models.ExternalUserInfo{RawClaims: map[string]interface{}{
+			"a":      "short",
+			"b":      "a value that is much too long to fit into the snapshot",
+			"c":      "short",
+			"groups": []interface{}{"admins", "editors", "viewers"},
+		}}
+
+		snapshot := loginService.rawClaimsSnapshot(extUser)
+		assert.LessOrEqual(t, len(snapshot), 64)
+
+		claims := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(snapshot), &claims))
+		assert.Equal(t, map[string]interface{}{truncatedClaimsKey: true, "a": "short", "c": "short"}, claims)
+	})
+
+	t.Run("snapshot within the size is complete", func(t *testing.T) {
+		loginService := &Implementation{}
+		extUser := &models.ExternalUserInfo{RawClaims: map[string]interface{}{"sub": "1"}}
+
+		assert.Equal(t, `{"sub":"1"}`, loginService.rawClaimsSnapshot(extUser))
+	})
+
+	t.Run("no claims, no snapshot", func(t *testing.T) {
+		loginService := &Implementation{}
+
+		assert.Empty(t, loginService.rawClaimsSnapshot(&models.ExternalUserInfo{}))
+	})
+}
@@ -0,0 +1,42 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// isSuspiciousRemoval reports whether removing deleteOrgIds would exceed MaxRemovalRatio
+// of the user's current orgs. When it does, a models.SuspiciousSyncDetectedEvent is published.
+func (ls *Implementation) isSuspiciousRemoval(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, current []*models.UserOrgDTO, deleteOrgIds []int64) bool {
+	if ls.MaxRemovalRatio <= 0 || len(deleteOrgIds) == 0 {
+		return false
+	}
+
+	ratio := float64(len(deleteOrgIds)) / float64(len(current))
+	if ratio <= ls.MaxRemovalRatio {
+		return false
+	}
+
+	currentOrgIds := make([]int64, 0, len(current))
+	for _, org := range current {
+		currentOrgIds = append(currentOrgIds, org.OrgId)
+	}
+
+	logger.Warn("Skipping organization removals, the external organization roles look truncated",
+		"userId", user.Id, "authmodule", extUser.AuthModule, "currentOrgIds", currentOrgIds, "removedOrgIds", deleteOrgIds, "maxRemovalRatio", ls.MaxRemovalRatio)
+
+	if ls.Bus != nil {
+		event := &models.SuspiciousSyncDetectedEvent{
+			UserId:        user.Id,
+			AuthModule:    extUser.AuthModule,
+			CurrentOrgIds: currentOrgIds,
+			RemovedOrgIds: deleteOrgIds,
+		}
+		if err := ls.Bus.Publish(ctx, event); err != nil {
+			logger.Error("Failed to publish suspicious sync event", "userId", user.Id, "error", err)
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,67 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_syncOrgRoles_maxRemovalRatio(t *testing.T) {
+	tests := []struct {
+		desc          string
+		keptOrgs      []int64
+		expectRemoval bool
+	}{
+		{desc: "below the ratio removes orgs", keptOrgs: []int64{1, 2, 3}, expectRemoval: true},
+		{desc: "at the ratio removes orgs", keptOrgs: []int64{1, 2}, expectRemoval: true},
+		{desc: "above the ratio skips removals", keptOrgs: []int64{1}, expectRemoval: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			user := &models.User{Id: 1, OrgId: 1}
+			store := newFakeStore(user)
+			for orgID := int64(1); orgID <= 4; orgID++ {
+				store.addOrgUser(orgID, user.Id, models.ROLE_VIEWER)
+			}
+
+			var events []*models.SuspiciousSyncDetectedEvent
+			eventBus := bus.New()
+			eventBus.AddEventListener(func(ctx context.Context, e *models.SuspiciousSyncDetectedEvent) error {
+				events = append(events, e)
+				return nil
+			})
+
+			loginService := Implementation{SQLStore: store, Bus: eventBus, MaxRemovalRatio: 0.5}
+			extUser := &models.ExternalUserInfo{AuthModule: "oauth_generic", OrgRoles: map[int64]models.RoleType{}}
+			for _, orgID := range tt.keptOrgs {
+				extUser.OrgRoles[orgID] = models.ROLE_VIEWER
+			}
+
+			state := loginService.newSyncState()
+			require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, state))
+
+			if tt.expectRemoval {
+				for orgID := int64(len(tt.keptOrgs) + 1); orgID <= 4; orgID++ {
+					assert.NotContains(t, store.orgUsers[orgID], user.Id)
+				}
+				assert.Empty(t, events)
+				assert.Empty(t, state.result.Warnings)
+				return
+			}
+
+			for orgID := int64(1); orgID <= 4; orgID++ {
+				assert.Contains(t, store.orgUsers[orgID], user.Id)
+			}
+			require.Len(t, events, 1)
+			assert.Equal(t, user.Id, events[0].UserId)
+			assert.Equal(t, []int64{1, 2, 3, 4}, events[0].CurrentOrgIds)
+			assert.Equal(t, []int64{2, 3, 4}, events[0].RemovedOrgIds)
+			assert.Len(t, state.result.Warnings, 1)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// RepairReport describes the auth info rows removed by RepairOrphanedAuthInfo.
+type RepairReport struct {
+	// Found is the number of auth info rows without a user
+	Found int
+	// Removed is the number of those rows that were removed, RemovedByModule
+	// counts them by auth module
+	Removed         int
+	RemovedByModule map[string]int
+	// Failed is the number of rows that couldn't be removed
+	Failed int
+}
+
+// RepairOrphanedAuthInfo removes the auth info rows of users that don't exist.
+// Rows that can't be removed are logged and counted in the report.
+func (ls *Implementation) RepairOrphanedAuthInfo(ctx context.Context) (*RepairReport, error) {
+	if ls.OrphanedAuthInfoStore == nil {
+		return nil, login.ErrRepairDisabled
+	}
+
+	orphans, err := ls.OrphanedAuthInfoStore.GetOrphanedAuthInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RepairReport{Found: len(orphans), RemovedByModule: map[string]int{}}
+	for _, orphan := range orphans {
+		// rows are deleted by id only
+		cmd := &models.DeleteAuthInfoCommand{UserAuth: &models.UserAuth{Id: orphan.Id}}
+		if err := ls.OrphanedAuthInfoStore.DeleteAuthInfo(ctx, cmd); err != nil {
+			logger.Error("Failed to remove orphaned auth info", "id", orphan.Id, "userId", orphan.UserId, "authmodule", orphan.AuthModule, "error", err)
+			report.Failed++
+			continue
+		}
+		logger.Info("Removed orphaned auth info", "id", orphan.Id, "userId", orphan.UserId, "authmodule", orphan.AuthModule)
+		report.Removed++
+		report.RemovedByModule[orphan.AuthModule]++
+	}
+	return report, nil
+}
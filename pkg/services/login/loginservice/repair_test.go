@@ -0,0 +1,51 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/authinfoservice/database"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	secretstore "github.com/grafana/grafana/pkg/services/secrets/database"
+	secretsManager "github.com/grafana/grafana/pkg/services/secrets/manager"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairOrphanedAuthInfo(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("auth info without a user is removed", func(t *testing.T) {
+		sqlStore := sqlstore.InitTestDB(t)
+		store := database.ProvideAuthInfoStore(sqlStore, bus.New(), secretsManager.SetupTestService(t, secretstore.ProvideSecretsStore(sqlStore)))
+		loginService := &Implementation{SQLStore: sqlStore, OrphanedAuthInfoStore: logindatabase.ProvideOrphanedAuthInfoStore(sqlStore)}
+
+		user, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: "alice", Email: "alice@example.org"})
+		require.NoError(t, err)
+		require.NoError(t, store.SetAuthInfo(ctx, &models.SetAuthInfoCommand{UserId: user.Id, AuthModule: "oauth_okta", AuthId: "sub-alice"}))
+		require.NoError(t, store.SetAuthInfo(ctx, &models.SetAuthInfoCommand{UserId: user.Id + 100, AuthModule: "oauth_okta", AuthId: "sub-bob"}))
+		require.NoError(t, store.SetAuthInfo(ctx, &models.SetAuthInfoCommand{UserId: user.Id + 101, AuthModule: "ldap", AuthId: "cn=carol"}))
+
+		report, err := loginService.RepairOrphanedAuthInfo(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, &RepairReport{Found: 2, Removed: 2, RemovedByModule: map[string]int{"oauth_okta": 1, "ldap": 1}}, report)
+
+		query := &models.GetAuthInfoQuery{UserId: user.Id}
+		require.NoError(t, store.GetAuthInfo(ctx, query))
+		assert.Equal(t, "sub-alice", query.Result.AuthId)
+		require.ErrorIs(t, store.GetAuthInfo(ctx, &models.GetAuthInfoQuery{AuthModule: "oauth_okta", AuthId: "sub-bob"}), models.ErrUserNotFound)
+
+		report, err = loginService.RepairOrphanedAuthInfo(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, report.Found)
+	})
+
+	t.Run("repair needs a store", func(t *testing.T) {
+		_, err := (&Implementation{}).RepairOrphanedAuthInfo(ctx)
+		require.ErrorIs(t, err, login.ErrRepairDisabled)
+	})
+}
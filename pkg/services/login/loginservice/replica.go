@@ -0,0 +1,25 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+)
+
+// readStore returns the store to use for reads. Reads go to ReadStore when one is
+// configured, unless the value read was just written in the current call and
+// might not have been replicated yet, or ctx carries a transaction whose
+// uncommitted changes the read must see.
+func (ls *Implementation) readStore(ctx context.Context, justWritten bool) sqlstore.Store {
+	if ls.ReadStore == nil || justWritten || inTransaction(ctx) {
+		return ls.SQLStore
+	}
+	return ls.ReadStore
+}
+
+// inTransaction reports whether ctx carries a transaction of the caller, see
+// sqlstore.SQLStore.InTransaction. Store calls with ctx join it.
+func inTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(sqlstore.ContextSessionKey{}).(*sqlstore.DBSession)
+	return ok
+}
@@ -0,0 +1,78 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readRecorder records which store served GetUserOrgList.
+type readRecorder struct {
+	*fakeStore
+	name  string
+	reads *[]string
+}
+
+func (r *readRecorder) GetUserOrgList(ctx context.Context, query *models.GetUserOrgListQuery) error {
+	*r.reads = append(*r.reads, r.name)
+	return r.fakeStore.GetUserOrgList(ctx, query)
+}
+
+func setupReplica(users ...*models.User) (*Implementation, *fakeStore, *[]string) {
+	store := newFakeStore(users...)
+	store.addOrg(1)
+	reads := &[]string{}
+
+	loginService := &Implementation{
+		SQLStore:     &readRecorder{fakeStore: store, name: "primary", reads: reads},
+		ReadStore:    &readRecorder{fakeStore: store, name: "replica", reads: reads},
+		QuotaService: &quota.QuotaService{Cfg: setting.NewCfg()},
+		OnOrgless:    OrglessDisable,
+	}
+	return loginService, store, reads
+}
+
+func Test_UpsertUser_readReplica(t *testing.T) {
+	extUser := func() *models.ExternalUserInfo {
+		return &models.ExternalUserInfo{Login: "alice", Email: "alice@example.org", OrgRoles: map[int64]models.RoleType{1: models.ROLE_VIEWER}}
+	}
+
+	t.Run("reads of an existing user go to the replica", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice", Email: "alice@example.org", OrgId: 1}
+		loginService, store, reads := setupReplica(user)
+		store.addOrgUser(1, user.Id, models.ROLE_VIEWER)
+		loginService.AuthInfoService = &logintest.AuthInfoServiceFake{ExpectedUser: user}
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: extUser()}))
+
+		// the orgless check reads memberships that were just written
+		assert.Equal(t, []string{"replica", "primary"}, *reads)
+	})
+
+	t.Run("reads after creating the user go to the primary", func(t *testing.T) {
+		loginService, _, reads := setupReplica()
+		loginService.AuthInfoService = &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound}
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: extUser()}))
+
+		assert.Equal(t, []string{"primary", "primary"}, *reads)
+	})
+
+	t.Run("without a replica reads go to the primary", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice", Email: "alice@example.org", OrgId: 1}
+		loginService, store, reads := setupReplica(user)
+		loginService.ReadStore = nil
+		store.addOrgUser(1, user.Id, models.ROLE_VIEWER)
+
+		_, err := loginService.ExplainUserOrgRole(context.Background(), user.Id, 1)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"primary"}, *reads)
+	})
+}
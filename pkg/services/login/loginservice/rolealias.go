@@ -0,0 +1,69 @@
+package loginservice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// defaultRoleAliases maps lower case role names sent by identity providers to roles.
+var defaultRoleAliases = map[string]models.RoleType{
+	"viewer":        models.ROLE_VIEWER,
+	"editor":        models.ROLE_EDITOR,
+	"admin":         models.ROLE_ADMIN,
+	"administrator": models.ROLE_ADMIN,
+}
+
+func (ls *Implementation) resolveRoleAlias(role models.RoleType) (models.RoleType, bool) {
+	name := strings.ToLower(strings.TrimSpace(string(role)))
+	for alias, r := range ls.RoleAliases {
+		if strings.ToLower(alias) == name {
+			return r, true
+		}
+	}
+	r, ok := defaultRoleAliases[name]
+	return r, ok
+}
+
+// normalizeOrgRoles maps the external org roles to roles using the role aliases.
+// With StrictRoleValidation an unknown role or an org id that isn't positive fails
+// the sync. Otherwise they're skipped, and orgs with an unknown role are returned
+// so that their membership is left untouched.
+func (ls *Implementation) normalizeOrgRoles(extUser *models.ExternalUserInfo, state *syncState) (map[int64]bool, error) {
+	normalized := make(map[int64]models.RoleType, len(extUser.OrgRoles))
+	skipped := map[int64]bool{}
+
+	for orgID, role := range extUser.OrgRoles {
+		if orgID <= 0 {
+			if state.strictRoles {
+				return nil, fmt.Errorf("%w: %d", login.ErrInvalidOrgId, orgID)
+			}
+			logger.Warn("Skipping organization role with invalid organization id", "orgId", orgID, "role", role)
+			state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("skipped role for invalid organization id %d", orgID))
+			continue
+		}
+
+		// an empty role means the org default, see DefaultRolePerOrg
+		if role == "" {
+			normalized[orgID] = role
+			continue
+		}
+
+		resolved, ok := ls.resolveRoleAlias(role)
+		if !ok {
+			if state.strictRoles {
+				return nil, &login.ErrInvalidOrgRole{OrgId: orgID, Role: role}
+			}
+			logger.Warn("Skipping unknown organization role", "orgId", orgID, "role", role)
+			state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("skipped unknown role %q for organization %d", role, orgID))
+			skipped[orgID] = true
+			continue
+		}
+		normalized[orgID] = resolved
+	}
+
+	extUser.OrgRoles = normalized
+	return skipped, nil
+}
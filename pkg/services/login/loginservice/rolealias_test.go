@@ -0,0 +1,130 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_syncOrgRoles_roleAliases(t *testing.T) {
+	setup := func() (*models.User, *fakeStore) {
+		user := &models.User{Id: 1, OrgId: 1}
+		store := newFakeStore(user)
+		for orgID := int64(1); orgID <= 5; orgID++ {
+			store.addOrg(orgID)
+		}
+		store.addOrgUser(5, user.Id, models.ROLE_EDITOR)
+		return user, store
+	}
+
+	t.Run("default aliases are case insensitive", func(t *testing.T) {
+		user, store := setup()
+		loginService := &Implementation{SQLStore: store}
+		extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{
+			1: "admin",
+			2: "Administrator",
+			3: "ADMIN",
+			4: "viewer",
+			5: " EDITOR ",
+		}}
+
+		require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState()))
+
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][user.Id])
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[2][user.Id])
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[3][user.Id])
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[4][user.Id])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[5][user.Id])
+		assert.NotContains(t, store.calls, "UpdateOrgUser:5")
+	})
+
+	t.Run("configured aliases extend and override the defaults", func(t *testing.T) {
+		user, store := setup()
+		loginService := &Implementation{
+			SQLStore:    store,
+			RoleAliases: map[string]models.RoleType{"Owner": models.ROLE_ADMIN, "administrator": models.ROLE_EDITOR},
+		}
+		extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{
+			1: "owner",
+			2: "Administrator",
+		}}
+
+		require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState()))
+
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][user.Id])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[2][user.Id])
+	})
+
+	t.Run("unknown roles are skipped and warned about", func(t *testing.T) {
+		user, store := setup()
+		loginService := &Implementation{SQLStore: store}
+		extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{
+			1: "viewer",
+			5: "superuser",
+		}}
+
+		state := loginService.newSyncState()
+		require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser, state))
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[1][user.Id])
+		assert.Equal(t, models.ROLE_EDITOR, store.orgUsers[5][user.Id], "membership with an unknown role should be left untouched")
+		assert.Len(t, state.result.Warnings, 1)
+	})
+
+	t.Run("unknown roles fail the sync with strict validation", func(t *testing.T) {
+		user, store := setup()
+		loginService := &Implementation{SQLStore: store, StrictRoleValidation: true}
+		extUser := &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{
+			1: "viewer",
+			5: "superuser",
+		}}
+
+		err := loginService.syncOrgRoles(context.Background(), user, extUser, loginService.newSyncState())
+
+		var roleErr *login.ErrInvalidOrgRole
+		require.True(t, errors.As(err, &roleErr))
+		assert.Equal(t, int64(5), roleErr.OrgId)
+		assert.Empty(t, store.calls)
+	})
+}
+
+func Test_syncOrgRoles_invalidOrgIds(t *testing.T) {
+	extUser := func() *models.ExternalUserInfo {
+		return &models.ExternalUserInfo{OrgRoles: map[int64]models.RoleType{
+			0:  models.ROLE_ADMIN,
+			-1: models.ROLE_VIEWER,
+			1:  models.ROLE_VIEWER,
+		}}
+	}
+
+	t.Run("invalid org ids are skipped and warned about", func(t *testing.T) {
+		user := &models.User{Id: 1}
+		store := newFakeStore(user)
+		store.addOrg(1)
+		loginService := &Implementation{SQLStore: store}
+
+		state := loginService.newSyncState()
+		require.NoError(t, loginService.syncOrgRoles(context.Background(), user, extUser(), state))
+
+		assert.Equal(t, []string{"AddOrgUser:1"}, store.calls)
+		assert.Equal(t, int64(1), store.users[user.Id].OrgId)
+		assert.Len(t, state.result.Warnings, 2)
+	})
+
+	t.Run("invalid org ids fail the sync with strict validation", func(t *testing.T) {
+		user := &models.User{Id: 1}
+		store := newFakeStore(user)
+		store.addOrg(1)
+		loginService := &Implementation{SQLStore: store, StrictRoleValidation: true}
+
+		err := loginService.syncOrgRoles(context.Background(), user, extUser(), loginService.newSyncState())
+
+		assert.ErrorIs(t, err, login.ErrInvalidOrgId)
+		assert.Empty(t, store.calls)
+	})
+}
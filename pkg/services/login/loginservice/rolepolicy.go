@@ -0,0 +1,41 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// RolePolicy decides whether org sync may give a user a role in an org, e.g.
+// to keep external users from being admins of some orgs. It's checked before
+// every role org sync adds or updates, after PerOrgRoleCeiling was applied.
+// Removals aren't checked.
+type RolePolicy interface {
+	// Check returns whether the user may have the role in the org, and why
+	// not if it may not.
+	Check(ctx context.Context, userID, orgID int64, role models.RoleType, extUser *models.ExternalUserInfo) (allowed bool, reason string)
+}
+
+// RolePolicyFunc adapts a function to a RolePolicy.
+type RolePolicyFunc func(ctx context.Context, userID, orgID int64, role models.RoleType, extUser *models.ExternalUserInfo) (bool, string)
+
+func (f RolePolicyFunc) Check(ctx context.Context, userID, orgID int64, role models.RoleType, extUser *models.ExternalUserInfo) (bool, string) {
+	return f(ctx, userID, orgID, role, extUser)
+}
+
+// rolePolicyAllows checks the role against the RolePolicy, a denied role is
+// reported as a warning.
+func (ls *Implementation) rolePolicyAllows(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, orgID int64, role models.RoleType, state *syncState) bool {
+	if ls.RolePolicy == nil {
+		return true
+	}
+
+	allowed, reason := ls.RolePolicy.Check(ctx, user.Id, orgID, role, extUser)
+	if allowed {
+		return true
+	}
+	logger.Warn("Skipping organization role denied by the role policy", "userId", user.Id, "orgId", orgID, "role", role, "reason", reason)
+	state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("skipped role %q in organization %d denied by policy: %s", role, orgID, reason))
+	return false
+}
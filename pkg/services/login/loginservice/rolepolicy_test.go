@@ -0,0 +1,101 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_rolePolicy(t *testing.T) {
+	const prodOrgID = 2
+
+	// no external admins in the prod org, and contractors can't be editors
+	policy := RolePolicyFunc(func(ctx context.Context, userID, orgID int64, role models.RoleType, extUser *models.ExternalUserInfo) (bool, string) {
+		if orgID == prodOrgID && role == models.ROLE_ADMIN {
+			return false, "no external admins in prod"
+		}
+		for _, group := range extUser.Groups {
+			if group == "contractors" && role == models.ROLE_EDITOR {
+				return false, "contractors can't be editors"
+			}
+		}
+		return true, ""
+	})
+	setup := func() (*Implementation, *fakeStore) {
+		user := &models.User{Id: 1, Login: "alice"}
+		store := newFakeStore(user)
+		for orgID := int64(1); orgID <= 3; orgID++ {
+			store.addOrg(orgID)
+		}
+		return &Implementation{
+			SQLStore:        store,
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			RolePolicy:      policy,
+		}, store
+	}
+
+	t.Run("denied roles are skipped with a warning", func(t *testing.T) {
+		loginService, store := setup()
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			Groups:   []string{"contractors"},
+			OrgRoles: map[int64]models.RoleType{1: models.ROLE_ADMIN, prodOrgID: models.ROLE_ADMIN, 3: models.ROLE_EDITOR},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_ADMIN}, store.orgUsers[1])
+		assert.Empty(t, store.orgUsers[prodOrgID])
+		assert.Empty(t, store.orgUsers[3])
+		assert.ElementsMatch(t, []string{
+			`skipped role "Admin" in organization 2 denied by policy: no external admins in prod`,
+			`skipped role "Editor" in organization 3 denied by policy: contractors can't be editors`,
+		}, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("denied updates keep the current role", func(t *testing.T) {
+		loginService, store := setup()
+		store.addOrgUser(prodOrgID, 1, models.ROLE_VIEWER)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			OrgRoles: map[int64]models.RoleType{prodOrgID: models.ROLE_ADMIN},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[prodOrgID][1])
+		assert.Len(t, cmd.SyncResult.Warnings, 1)
+	})
+
+	t.Run("removals aren't checked", func(t *testing.T) {
+		loginService, store := setup()
+		store.addOrgUser(1, 1, models.ROLE_VIEWER)
+		store.addOrgUser(prodOrgID, 1, models.ROLE_EDITOR)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			OrgRoles: map[int64]models.RoleType{1: models.ROLE_VIEWER},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Empty(t, store.orgUsers[prodOrgID])
+		assert.Empty(t, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("the policy sees roles lowered to the ceiling", func(t *testing.T) {
+		loginService, store := setup()
+		loginService.PerOrgRoleCeiling = map[int64]models.RoleType{prodOrgID: models.ROLE_VIEWER}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			OrgRoles: map[int64]models.RoleType{prodOrgID: models.ROLE_ADMIN},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, models.ROLE_VIEWER, store.orgUsers[prodOrgID][1])
+	})
+}
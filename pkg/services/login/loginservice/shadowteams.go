@@ -0,0 +1,150 @@
+package loginservice
+
+import (
+	"context"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// ShadowTeamSyncFunc is a team sync run in shadow mode. It makes its changes
+// through teams, which reads the live memberships and records the changes
+// instead of applying them.
+type ShadowTeamSyncFunc func(ctx context.Context, teams login.TeamMembershipService, user *models.User, extUser *models.ExternalUserInfo) error
+
+// ShadowSync runs the mapper as a ShadowTeamSyncFunc, making its changes
+// through teams instead of Teams.
+func (m *GroupTeamMapper) ShadowSync(ctx context.Context, teams login.TeamMembershipService, user *models.User, extUser *models.ExternalUserInfo) error {
+	shadow := *m
+	shadow.Teams = teams
+	return shadow.syncTeams(ctx, user, extUser)
+}
+
+// shadowSyncTeams runs ShadowTeamSync after the live team sync and records the
+// changes it would have made. Since the live team sync already ran, any change
+// is a discrepancy between the two. Failures of the shadow are only logged.
+func (ls *Implementation) shadowSyncTeams(ctx context.Context, user *models.User, extUser *models.ExternalUserInfo, state *syncState) {
+	if ls.ShadowTeamSync == nil || ls.ShadowTeams == nil {
+		return
+	}
+
+	teams := &shadowTeams{ctx: ctx, live: ls.ShadowTeams, changes: map[teamKey]bool{}}
+	if err := ls.ShadowTeamSync(ctx, teams, user, extUser); err != nil {
+		logger.Warn("Shadow team sync failed", "userId", user.Id, "error", err)
+		return
+	}
+
+	diffs := teams.diffs()
+	if len(diffs) == 0 {
+		return
+	}
+	logger.Warn("Shadow team sync differs from the live team sync", "userId", user.Id, "diffs", diffs)
+	state.result.ShadowTeamSyncDiffs = append(state.result.ShadowTeamSyncDiffs, diffs...)
+}
+
+// shadowTeams is the login.TeamMembershipService of a shadow team sync. It
+// reads the live memberships and records changes on top of them.
+type shadowTeams struct {
+	ctx     context.Context
+	live    login.TeamMembershipService
+	changes map[teamKey]bool
+	// liveMember caches the live memberships by team
+	liveMember map[teamKey]bool
+	liveOrgs   map[int64]bool
+}
+
+func (s *shadowTeams) GetUserTeamMemberships(ctx context.Context, orgID, userID int64, external bool) ([]*models.TeamMemberDTO, error) {
+	memberships, err := s.live.GetUserTeamMemberships(ctx, orgID, userID, external)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.TeamMemberDTO, 0, len(memberships))
+	listed := map[teamKey]bool{}
+	for _, membership := range memberships {
+		key := teamKey{orgID: membership.OrgId, teamID: membership.TeamId}
+		listed[key] = true
+		if member, ok := s.changes[key]; ok && !member {
+			continue
+		}
+		result = append(result, membership)
+	}
+	for key, member := range s.changes {
+		if member && key.orgID == orgID && !listed[key] {
+			result = append(result, &models.TeamMemberDTO{OrgId: key.orgID, TeamId: key.teamID, UserId: userID, External: true})
+		}
+	}
+	return result, nil
+}
+
+func (s *shadowTeams) AddTeamMember(userID, orgID, teamID int64, isExternal bool, permission models.PermissionType) error {
+	key := teamKey{orgID: orgID, teamID: teamID}
+	member, err := s.isMember(userID, key)
+	if err != nil {
+		return err
+	}
+	if member {
+		return models.ErrTeamMemberAlreadyAdded
+	}
+	s.changes[key] = true
+	return nil
+}
+
+func (s *shadowTeams) RemoveTeamMember(ctx context.Context, cmd *models.RemoveTeamMemberCommand) error {
+	key := teamKey{orgID: cmd.OrgId, teamID: cmd.TeamId}
+	member, err := s.isMember(cmd.UserId, key)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return models.ErrTeamMemberNotFound
+	}
+	s.changes[key] = false
+	return nil
+}
+
+// isMember returns whether the user is a member of the team, with the recorded
+// changes applied.
+func (s *shadowTeams) isMember(userID int64, key teamKey) (bool, error) {
+	if member, ok := s.changes[key]; ok {
+		return member, nil
+	}
+	return s.isLiveMember(userID, key)
+}
+
+func (s *shadowTeams) isLiveMember(userID int64, key teamKey) (bool, error) {
+	if !s.liveOrgs[key.orgID] {
+		memberships, err := s.live.GetUserTeamMemberships(s.ctx, key.orgID, userID, false)
+		if err != nil {
+			return false, err
+		}
+		if s.liveOrgs == nil {
+			s.liveOrgs, s.liveMember = map[int64]bool{}, map[teamKey]bool{}
+		}
+		s.liveOrgs[key.orgID] = true
+		for _, membership := range memberships {
+			s.liveMember[teamKey{orgID: membership.OrgId, teamID: membership.TeamId}] = true
+		}
+	}
+	return s.liveMember[key], nil
+}
+
+// diffs returns the recorded changes that differ from the live memberships,
+// ordered by org and team.
+func (s *shadowTeams) diffs() []models.TeamSyncDiff {
+	diffs := []models.TeamSyncDiff{}
+	for key, member := range s.changes {
+		if s.liveMember[key] == member {
+			continue
+		}
+		diffs = append(diffs, models.TeamSyncDiff{OrgId: key.orgID, TeamId: key.teamID, Added: member})
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].OrgId != diffs[j].OrgId {
+			return diffs[i].OrgId < diffs[j].OrgId
+		}
+		return diffs[i].TeamId < diffs[j].TeamId
+	})
+	return diffs
+}
@@ -0,0 +1,82 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_shadowTeamSync(t *testing.T) {
+	live := []GroupTeamMapping{
+		{Group: "devs", OrgId: 1, TeamId: 10},
+		{Group: "ops", OrgId: 1, TeamId: 11},
+	}
+
+	setup := func(shadow []GroupTeamMapping) (*Implementation, *fakeTeamMembershipService) {
+		user := &models.User{Id: 1, Login: "alice"}
+		teams := &fakeTeamMembershipService{members: map[teamKey]map[int64]bool{}}
+		shadowMapper := &GroupTeamMapper{Mappings: shadow}
+		return &Implementation{
+			SQLStore:        newFakeStore(user),
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			GroupTeamMapper: &GroupTeamMapper{Teams: teams, Mappings: live},
+			ShadowTeamSync:  shadowMapper.ShadowSync,
+			ShadowTeams:     teams,
+		}, teams
+	}
+	upsert := func(t *testing.T, loginService *Implementation, groups ...string) *models.UpsertUserCommand {
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", Groups: groups}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		return cmd
+	}
+
+	t.Run("differences are reported but not applied", func(t *testing.T) {
+		loginService, teams := setup([]GroupTeamMapping{
+			{Group: "devs", OrgId: 1, TeamId: 10},
+			{Group: "ops", OrgId: 1, TeamId: 12},
+			{Group: "admins", OrgId: 1, TeamId: 11},
+		})
+
+		cmd := upsert(t, loginService, "devs", "ops")
+
+		assert.Equal(t, []models.TeamSyncDiff{
+			{OrgId: 1, TeamId: 11, Added: false},
+			{OrgId: 1, TeamId: 12, Added: true},
+		}, cmd.SyncResult.ShadowTeamSyncDiffs)
+		assert.True(t, teams.isMember(1, 10, 1))
+		assert.True(t, teams.isMember(1, 11, 1))
+		assert.False(t, teams.isMember(1, 12, 1))
+	})
+
+	t.Run("nothing is reported when both agree", func(t *testing.T) {
+		loginService, _ := setup(live)
+
+		cmd := upsert(t, loginService, "devs", "ops")
+		assert.Empty(t, cmd.SyncResult.ShadowTeamSyncDiffs)
+
+		cmd = upsert(t, loginService, "devs")
+		assert.Empty(t, cmd.SyncResult.ShadowTeamSyncDiffs)
+	})
+
+	t.Run("shadow failures don't fail the sync", func(t *testing.T) {
+		loginService, teams := setup(nil)
+		loginService.ShadowTeamSync = func(ctx context.Context, shadowTeams login.TeamMembershipService, user *models.User, extUser *models.ExternalUserInfo) error {
+			if err := shadowTeams.AddTeamMember(user.Id, 1, 12, true, 0); err != nil {
+				return err
+			}
+			return errors.New("shadow failed")
+		}
+
+		cmd := upsert(t, loginService, "devs")
+
+		assert.Empty(t, cmd.SyncResult.ShadowTeamSyncDiffs)
+		assert.True(t, teams.isMember(1, 10, 1))
+		assert.False(t, teams.isMember(1, 12, 1))
+	})
+}
@@ -0,0 +1,121 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// OrgRolesSnapshot is the org memberships of a user at a point in time, see
+// SnapshotUserOrgRoles.
+type OrgRolesSnapshot struct {
+	UserId  int64     `json:"userId"`
+	TakenAt time.Time `json:"takenAt"`
+	// OrgId is the current org of the user.
+	OrgId int64                `json:"orgId"`
+	Roles []OrgRoleSnapshotRow `json:"roles"`
+}
+
+// OrgRoleSnapshotRow is an org membership in an OrgRolesSnapshot.
+type OrgRoleSnapshotRow struct {
+	OrgId int64           `json:"orgId"`
+	Role  models.RoleType `json:"role"`
+	// Expires is the unix timestamp at which the membership expires, nil if it doesn't.
+	Expires *int64 `json:"expires,omitempty"`
+}
+
+// SnapshotUserOrgRoles returns the current org memberships of a user, to be
+// restored with RestoreUserOrgRoles.
+func (ls *Implementation) SnapshotUserOrgRoles(ctx context.Context, userID int64) (*OrgRolesSnapshot, error) {
+	userQuery := &models.GetUserByIdQuery{Id: userID}
+	if err := ls.SQLStore.GetUserById(ctx, userQuery); err != nil {
+		return nil, err
+	}
+
+	orgsQuery := &models.GetUserOrgListQuery{UserId: userID}
+	if err := ls.SQLStore.GetUserOrgList(ctx, orgsQuery); err != nil {
+		return nil, err
+	}
+
+	snapshot := &OrgRolesSnapshot{
+		UserId:  userID,
+		TakenAt: ls.now(),
+		OrgId:   userQuery.Result.OrgId,
+		Roles:   make([]OrgRoleSnapshotRow, 0, len(orgsQuery.Result)),
+	}
+	for _, org := range orgsQuery.Result {
+		snapshot.Roles = append(snapshot.Roles, OrgRoleSnapshotRow{OrgId: org.OrgId, Role: org.Role, Expires: org.Expires})
+	}
+	return snapshot, nil
+}
+
+// RestoreUserOrgRoles adds, updates and removes org memberships of a user so
+// that they match the snapshot, and switches the user back to the org it was
+// using. Like org sync, the last admin of an org is neither demoted nor removed.
+func (ls *Implementation) RestoreUserOrgRoles(ctx context.Context, userID int64, snapshot *OrgRolesSnapshot) error {
+	if snapshot.UserId != userID {
+		return fmt.Errorf("snapshot of user %d can't be restored for user %d", snapshot.UserId, userID)
+	}
+
+	orgsQuery := &models.GetUserOrgListQuery{UserId: userID}
+	if err := ls.SQLStore.GetUserOrgList(ctx, orgsQuery); err != nil {
+		return err
+	}
+	current := make(map[int64]*models.UserOrgDTO, len(orgsQuery.Result))
+	for _, org := range orgsQuery.Result {
+		current[org.OrgId] = org
+	}
+
+	restored := make(map[int64]bool, len(snapshot.Roles))
+	for _, row := range snapshot.Roles {
+		restored[row.OrgId] = true
+
+		org, ok := current[row.OrgId]
+		if !ok {
+			cmd := &models.AddOrgUserCommand{UserId: userID, OrgId: row.OrgId, Role: row.Role, Expires: row.Expires}
+			if err := ls.SQLStore.AddOrgUser(ctx, cmd); err != nil {
+				if errors.Is(err, models.ErrOrgNotFound) {
+					logger.Warn("Not restoring role in deleted organization", "userId", userID, "orgId", row.OrgId)
+					delete(restored, row.OrgId)
+					continue
+				}
+				return err
+			}
+			continue
+		}
+
+		if org.Role == row.Role && expiryEqual(org.Expires, row.Expires) {
+			continue
+		}
+		cmd := &models.UpdateOrgUserCommand{UserId: userID, OrgId: row.OrgId, Role: row.Role, Expires: row.Expires, UpdateExpires: true}
+		if err := ls.SQLStore.UpdateOrgUser(ctx, cmd); err != nil {
+			if errors.Is(err, models.ErrLastOrgAdmin) {
+				logger.Error(err.Error(), "userId", userID, "orgId", row.OrgId)
+				continue
+			}
+			return err
+		}
+	}
+
+	for _, org := range orgsQuery.Result {
+		if restored[org.OrgId] {
+			continue
+		}
+		cmd := &models.RemoveOrgUserCommand{OrgId: org.OrgId, UserId: userID}
+		if err := ls.SQLStore.RemoveOrgUser(ctx, cmd); err != nil {
+			if errors.Is(err, models.ErrLastOrgAdmin) {
+				logger.Error(err.Error(), "userId", userID, "orgId", org.OrgId)
+				continue
+			}
+			return err
+		}
+	}
+
+	if !restored[snapshot.OrgId] {
+		return nil
+	}
+	return ls.SQLStore.SetUsingOrg(ctx, &models.SetUsingOrgCommand{UserId: userID, OrgId: snapshot.OrgId})
+}
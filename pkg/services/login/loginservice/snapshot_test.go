@@ -0,0 +1,86 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotAndRestoreUserOrgRoles(t *testing.T) {
+	setup := func() (*Implementation, *fakeStore) {
+		user := &models.User{Id: 1, Login: "alice", OrgId: 1}
+		store := newFakeStore(user, &models.User{Id: 2, Login: "bob"})
+		for _, orgID := range []int64{1, 2, 3, 4} {
+			store.addOrg(orgID)
+		}
+		store.addOrgUser(1, 1, models.ROLE_EDITOR)
+		store.addOrgUser(2, 1, models.ROLE_VIEWER)
+		store.addOrgUser(2, 2, models.ROLE_ADMIN)
+		expires := int64(1700000000)
+		store.setOrgUserExpiry(2, 1, &expires)
+		store.addOrgUser(3, 1, models.ROLE_VIEWER)
+		return &Implementation{
+			SQLStore:        store,
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		}, store
+	}
+	membershipsOf := func(store *fakeStore, userID int64) map[int64]models.RoleType {
+		memberships := map[int64]models.RoleType{}
+		for orgID, members := range store.orgUsers {
+			if role, ok := members[userID]; ok {
+				memberships[orgID] = role
+			}
+		}
+		return memberships
+	}
+
+	t.Run("restoring undoes a sync", func(t *testing.T) {
+		loginService, store := setup()
+		original := membershipsOf(store, 1)
+
+		snapshot, err := loginService.SnapshotUserOrgRoles(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), snapshot.OrgId)
+		assert.Len(t, snapshot.Roles, 3)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice", OrgRoles: map[int64]models.RoleType{
+			2: models.ROLE_EDITOR,
+			4: models.ROLE_VIEWER,
+		}}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		require.NotEqual(t, original, membershipsOf(store, 1))
+		require.Equal(t, int64(2), store.users[1].OrgId)
+
+		require.NoError(t, loginService.RestoreUserOrgRoles(context.Background(), 1, snapshot))
+		assert.Equal(t, original, membershipsOf(store, 1))
+		assert.Equal(t, int64(1700000000), *store.orgUserExpires(2, 1))
+		assert.Nil(t, store.orgUserExpires(1, 1))
+		assert.Equal(t, int64(1), store.users[1].OrgId)
+		// other members are untouched
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[2][2])
+	})
+
+	t.Run("the last admin of an org isn't removed", func(t *testing.T) {
+		loginService, store := setup()
+
+		snapshot, err := loginService.SnapshotUserOrgRoles(context.Background(), 1)
+		require.NoError(t, err)
+
+		require.NoError(t, store.AddOrgUser(context.Background(), &models.AddOrgUserCommand{UserId: 1, OrgId: 4, Role: models.ROLE_ADMIN}))
+
+		require.NoError(t, loginService.RestoreUserOrgRoles(context.Background(), 1, snapshot))
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[4][1])
+	})
+
+	t.Run("snapshots are only restored for their user", func(t *testing.T) {
+		loginService, _ := setup()
+
+		snapshot, err := loginService.SnapshotUserOrgRoles(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Error(t, loginService.RestoreUserOrgRoles(context.Background(), 2, snapshot))
+	})
+}
@@ -0,0 +1,122 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// SoftDeletedLoginPolicy controls what happens when a soft deleted user logs in.
+type SoftDeletedLoginPolicy int
+
+const (
+	// SoftDeletedLoginReject rejects the login with login.ErrUserSoftDeleted (default).
+	SoftDeletedLoginReject SoftDeletedLoginPolicy = iota
+	// SoftDeletedLoginRestore restores the user and logs it in.
+	SoftDeletedLoginRestore
+)
+
+// SoftDeleteExternalUser marks an external user as deleted and disables it. The
+// user is kept until PurgeSoftDeleted removes it, or restored if it logs in with
+// SoftDeletedLoginRestore.
+func (ls *Implementation) SoftDeleteExternalUser(ctx context.Context, username string) error {
+	if ls.SoftDeleteStore == nil {
+		return login.ErrSoftDeleteDisabled
+	}
+
+	userQuery := &models.GetExternalUserInfoByLoginQuery{LoginOrEmail: username}
+	if err := ls.AuthInfoService.GetExternalUserInfoByLogin(ctx, userQuery); err != nil {
+		return err
+	}
+	userInfo := userQuery.Result
+
+	deleted, err := ls.SoftDeleteStore.GetSoftDeletedUser(ctx, userInfo.UserId)
+	if err != nil {
+		return err
+	}
+	if deleted != nil {
+		return nil
+	}
+
+	logger.Info("Soft deleting external user", "id", userInfo.UserId, "login", userInfo.Login)
+	// users disabled for another reason keep their disable source
+	if !userInfo.IsDisabled {
+		if err := ls.DisableUserWithSource(ctx, userInfo.UserId, login.DisableSourceSoftDelete); err != nil {
+			return err
+		}
+	}
+	return ls.SoftDeleteStore.SetSoftDeletedUser(ctx, &login.SoftDeletedUser{
+		UserId:      userInfo.UserId,
+		Deleted:     ls.now(),
+		WasDisabled: userInfo.IsDisabled,
+	})
+}
+
+// checkSoftDeleted applies the OnSoftDeletedLogin policy if the user is soft
+// deleted.
+func (ls *Implementation) checkSoftDeleted(ctx context.Context, user *models.User) error {
+	if ls.SoftDeleteStore == nil {
+		return nil
+	}
+
+	deleted, err := ls.SoftDeleteStore.GetSoftDeletedUser(ctx, user.Id)
+	if err != nil {
+		return upsertErr(login.UpsertPhaseLookup, err)
+	}
+	if deleted == nil {
+		return nil
+	}
+	if ls.OnSoftDeletedLogin != SoftDeletedLoginRestore {
+		return login.ErrUserSoftDeleted
+	}
+
+	logger.Info("Restoring soft deleted user on login", "id", user.Id, "deleted", deleted.Deleted)
+	if !deleted.WasDisabled {
+		if err := ls.reenableUser(ctx, user, login.DisableSourceSoftDelete); err != nil {
+			return upsertErr(login.UpsertPhaseUpdate, err)
+		}
+	}
+	if err := ls.SoftDeleteStore.DeleteSoftDeletedUser(ctx, user.Id); err != nil {
+		return upsertErr(login.UpsertPhaseUpdate, err)
+	}
+	return nil
+}
+
+// PurgeSoftDeleted deletes the users that were soft deleted longer than
+// olderThan ago and returns how many were deleted.
+func (ls *Implementation) PurgeSoftDeleted(ctx context.Context, olderThan time.Duration) (int, error) {
+	if ls.SoftDeleteStore == nil {
+		return 0, login.ErrSoftDeleteDisabled
+	}
+
+	deleted, err := ls.SoftDeleteStore.GetSoftDeletedUsersBefore(ctx, ls.now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range deleted {
+		err := ls.SQLStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: user.UserId})
+		if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+			return purged, err
+		}
+		if err == nil {
+			logger.Info("Purged soft deleted user", "id", user.UserId, "deleted", user.Deleted)
+			ls.quotaCache.invalidate()
+			purged++
+		}
+
+		if err := ls.SoftDeleteStore.DeleteSoftDeletedUser(ctx, user.UserId); err != nil {
+			return purged, err
+		}
+		if ls.DisableSourceStore != nil {
+			if err := ls.DisableSourceStore.DeleteDisableSource(ctx, user.UserId); err != nil {
+				return purged, err
+			}
+		}
+	}
+	return purged, nil
+}
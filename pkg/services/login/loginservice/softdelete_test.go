@@ -0,0 +1,176 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	logindatabase "github.com/grafana/grafana/pkg/services/login/loginservice/database"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSoftDeleteStore struct {
+	users map[int64]*login.SoftDeletedUser
+}
+
+func (f *fakeSoftDeleteStore) GetSoftDeletedUser(ctx context.Context, userID int64) (*login.SoftDeletedUser, error) {
+	return f.users[userID], nil
+}
+
+func (f *fakeSoftDeleteStore) SetSoftDeletedUser(ctx context.Context, user *login.SoftDeletedUser) error {
+	f.users[user.UserId] = user
+	return nil
+}
+
+func (f *fakeSoftDeleteStore) DeleteSoftDeletedUser(ctx context.Context, userID int64) error {
+	delete(f.users, userID)
+	return nil
+}
+
+func (f *fakeSoftDeleteStore) GetSoftDeletedUsersBefore(ctx context.Context, t time.Time) ([]*login.SoftDeletedUser, error) {
+	var users []*login.SoftDeletedUser
+	for _, user := range f.users {
+		if user.Deleted.Before(t) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func TestSoftDeleteExternalUser(t *testing.T) {
+	setup := func(policy SoftDeletedLoginPolicy) (*Implementation, *fakeStore, *fakeSoftDeleteStore, *clock.Mock) {
+		clk := clock.NewMock()
+		user := &models.User{Id: 1, Login: "alice"}
+		store := newFakeStore(user, &models.User{Id: 2, Login: "bob"})
+		softDeleted := &fakeSoftDeleteStore{users: map[int64]*login.SoftDeletedUser{}}
+		return &Implementation{
+			Clock:    clk,
+			SQLStore: store,
+			AuthInfoService: &logintest.AuthInfoServiceFake{
+				ExpectedUser:         user,
+				ExpectedExternalUser: &models.ExternalUserInfo{UserId: 1, Login: "alice"},
+			},
+			SoftDeleteStore:    softDeleted,
+			DisableSourceStore: &fakeDisableSourceStore{sources: map[int64]login.DisableSource{}},
+			OnSoftDeletedLogin: policy,
+		}, store, softDeleted, clk
+	}
+	upsert := func(loginService *Implementation) error {
+		return loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice"}})
+	}
+
+	t.Run("soft deleted users are disabled and rejected", func(t *testing.T) {
+		loginService, store, softDeleted, clk := setup(SoftDeletedLoginReject)
+
+		require.NoError(t, loginService.SoftDeleteExternalUser(context.Background(), "alice"))
+		assert.True(t, store.users[1].IsDisabled)
+		assert.Equal(t, &login.SoftDeletedUser{UserId: 1, Deleted: clk.Now()}, softDeleted.users[1])
+
+		require.ErrorIs(t, upsert(loginService), login.ErrUserSoftDeleted)
+		assert.True(t, store.users[1].IsDisabled)
+	})
+
+	t.Run("soft deleted users can be restored on login", func(t *testing.T) {
+		loginService, store, softDeleted, _ := setup(SoftDeletedLoginRestore)
+
+		require.NoError(t, loginService.SoftDeleteExternalUser(context.Background(), "alice"))
+		require.NoError(t, upsert(loginService))
+		assert.False(t, store.users[1].IsDisabled)
+		assert.Empty(t, softDeleted.users)
+	})
+
+	t.Run("restoring keeps users disabled for another reason disabled", func(t *testing.T) {
+		loginService, store, _, _ := setup(SoftDeletedLoginRestore)
+		require.NoError(t, loginService.DisableUserWithSource(context.Background(), 1, login.DisableSourceSecurity))
+		loginService.AuthInfoService.(*logintest.AuthInfoServiceFake).ExpectedExternalUser.IsDisabled = true
+
+		require.NoError(t, loginService.SoftDeleteExternalUser(context.Background(), "alice"))
+		require.NoError(t, upsert(loginService))
+		assert.True(t, store.users[1].IsDisabled)
+	})
+
+	t.Run("users are purged after the retention", func(t *testing.T) {
+		loginService, store, softDeleted, clk := setup(SoftDeletedLoginRestore)
+
+		require.NoError(t, loginService.SoftDeleteExternalUser(context.Background(), "alice"))
+		clk.Add(24 * time.Hour)
+
+		purged, err := loginService.PurgeSoftDeleted(context.Background(), 30*24*time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, 0, purged)
+		assert.Contains(t, store.users, int64(1))
+
+		clk.Add(30 * 24 * time.Hour)
+		purged, err = loginService.PurgeSoftDeleted(context.Background(), 30*24*time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		assert.NotContains(t, store.users, int64(1))
+		assert.Contains(t, store.users, int64(2))
+		assert.Empty(t, softDeleted.users)
+	})
+
+	t.Run("soft delete needs a store", func(t *testing.T) {
+		require.ErrorIs(t, (&Implementation{}).SoftDeleteExternalUser(context.Background(), "alice"), login.ErrSoftDeleteDisabled)
+		_, err := (&Implementation{}).PurgeSoftDeleted(context.Background(), time.Hour)
+		require.ErrorIs(t, err, login.ErrSoftDeleteDisabled)
+	})
+}
+
+func TestSoftDeleteExternalUser_sqlStore(t *testing.T) {
+	ctx := context.Background()
+	loginService, sqlStore := newSQLLoginService(t)
+	clk := clock.NewMock()
+	clk.Set(time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC))
+	loginService.Clock = clk
+	softDeleteStore := logindatabase.ProvideSoftDeleteStore(sqlStore)
+	loginService.SoftDeleteStore = softDeleteStore
+	loginService.DisableSourceStore = logindatabase.ProvideDisableSourceStore(sqlStore)
+	loginService.OnSoftDeletedLogin = SoftDeletedLoginRestore
+	upsert := func(name string) *models.User {
+		cmd := &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic",
+			AuthId:     name + "-id",
+			Login:      name,
+			Email:      name + "@example.org",
+		}}
+		require.NoError(t, loginService.UpsertUser(ctx, cmd))
+		return cmd.Result
+	}
+	alice := upsert("alice")
+
+	require.NoError(t, loginService.SoftDeleteExternalUser(ctx, "alice"))
+	deleted, err := softDeleteStore.GetSoftDeletedUser(ctx, alice.Id)
+	require.NoError(t, err)
+	require.NotNil(t, deleted)
+	assert.True(t, clk.Now().Equal(deleted.Deleted))
+	assert.False(t, deleted.WasDisabled)
+
+	upsert("alice")
+	deleted, err = softDeleteStore.GetSoftDeletedUser(ctx, alice.Id)
+	require.NoError(t, err)
+	assert.Nil(t, deleted, "logging in should restore the user")
+
+	bob := upsert("bob")
+	require.NoError(t, loginService.SoftDeleteExternalUser(ctx, "bob"))
+	clk.Add(2 * time.Hour)
+	require.NoError(t, loginService.SoftDeleteExternalUser(ctx, "alice"))
+	before, err := softDeleteStore.GetSoftDeletedUsersBefore(ctx, clk.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, before, 1)
+	assert.Equal(t, bob.Id, before[0].UserId)
+
+	purged, err := loginService.PurgeSoftDeleted(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	require.ErrorIs(t, sqlStore.GetUserById(ctx, &models.GetUserByIdQuery{Id: bob.Id}), models.ErrUserNotFound)
+
+	require.NoError(t, sqlStore.DeleteUser(ctx, &models.DeleteUserCommand{UserId: alice.Id}))
+	deleted, err = softDeleteStore.GetSoftDeletedUser(ctx, alice.Id)
+	require.NoError(t, err)
+	assert.Nil(t, deleted)
+}
@@ -0,0 +1,102 @@
+package loginservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// StaleDisableReport is the result of DisableStaleExternalUsers.
+type StaleDisableReport struct {
+	DryRun bool
+	Cutoff time.Time
+	// Disabled are the users that were disabled, or would be in a dry run.
+	Disabled []*StaleUser
+	// Skipped are stale users that were kept enabled, see StaleUser.Reason.
+	Skipped []*StaleUser
+}
+
+// StaleUser is an external user not seen since the cutoff.
+type StaleUser struct {
+	UserId     int64
+	Login      string
+	LastSeenAt time.Time
+	Reason     string
+}
+
+// DisableStaleExternalUsers disables the enabled external users that haven't been
+// seen for longer than olderThan. Users that are the only admin of an org are
+// skipped, not counting the admins that are disabled or picked for disabling.
+// With dryRun nothing is disabled. The admin checks run one user at a time,
+// then up to BatchConcurrency users are disabled in parallel. The report keeps
+// the order of the users.
+func (ls *Implementation) DisableStaleExternalUsers(ctx context.Context, olderThan time.Duration, dryRun bool) (*StaleDisableReport, error) {
+	report := &StaleDisableReport{DryRun: dryRun, Cutoff: ls.now().Add(-olderThan)}
+
+	// collect all candidates first, disabling users changes the pages
+	enabled := false
+	query := ExternalUserQuery{IsDisabled: &enabled, LastSeenBefore: report.Cutoff, Page: 1, PerPage: defaultExternalUserPageSize}
+	candidates := []*models.UserSearchHitDTO{}
+	for {
+		page, err := ls.QueryExternalUsers(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, page.Users...)
+		if len(page.Users) == 0 || int64(len(candidates)) >= page.TotalCount {
+			break
+		}
+		query.Page++
+	}
+
+	// the admin checks must see the users picked before them, so they can't run
+	// in parallel
+	picked := map[int64]bool{}
+	for _, candidate := range candidates {
+		u := &StaleUser{UserId: candidate.Id, Login: candidate.Login, LastSeenAt: candidate.LastSeenAt}
+
+		soleAdmin, err := ls.isSoleAdminOfAnyOrg(ctx, candidate.Id, picked)
+		if err != nil {
+			return nil, err
+		}
+		if soleAdmin {
+			u.Reason = "only admin of an organization"
+			report.Skipped = append(report.Skipped, u)
+			continue
+		}
+		picked[u.UserId] = true
+		report.Disabled = append(report.Disabled, u)
+	}
+
+	if !dryRun {
+		err := runBounded(ctx, len(report.Disabled), ls.BatchConcurrency, func(ctx context.Context, i int) error {
+			return ls.DisableUserWithSource(ctx, report.Disabled[i].UserId, login.DisableSourceInactivity)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("Disabled stale external users", "cutoff", report.Cutoff, "dryRun", dryRun, "disabled", len(report.Disabled), "skipped", len(report.Skipped))
+	return report, nil
+}
+
+func (ls *Implementation) isSoleAdminOfAnyOrg(ctx context.Context, userID int64, excluded map[int64]bool) (bool, error) {
+	orgsQuery := &models.GetUserOrgListQuery{UserId: userID}
+	if err := ls.readStore(ctx, false).GetUserOrgList(ctx, orgsQuery); err != nil {
+		return false, err
+	}
+
+	for _, org := range orgsQuery.Result {
+		if org.Role != models.ROLE_ADMIN {
+			continue
+		}
+		soleAdmin, err := ls.isSoleOrgAdmin(ctx, org.OrgId, userID, excluded)
+		if err != nil || soleAdmin {
+			return soleAdmin, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,124 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/sqlstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DisableStaleExternalUsers(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	ctx := context.Background()
+
+	createUser := func(login, authModule string, recentlySeen, ownOrg bool) int64 {
+		user, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: login, Email: login + "@example.org", SkipOrgSetup: !ownOrg})
+		require.NoError(t, err)
+
+		if !ownOrg {
+			err = sqlStore.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: 1, UserId: user.Id, Role: models.ROLE_VIEWER})
+			require.NoError(t, err)
+		}
+		if authModule != "" {
+			err = sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+				_, err := sess.Insert(&models.UserAuth{UserId: user.Id, AuthModule: authModule, AuthId: login, Created: time.Now()})
+				return err
+			})
+			require.NoError(t, err)
+		}
+		if recentlySeen {
+			require.NoError(t, sqlStore.UpdateUserLastSeenAt(ctx, &models.UpdateUserLastSeenAtCommand{UserId: user.Id}))
+		}
+		return user.Id
+	}
+
+	// the first user creates org 1 and is its only admin
+	ownerID := createUser("owner", models.AuthModuleLDAP, false, true)
+	staleID := createUser("stale", models.AuthModuleLDAP, false, false)
+	activeID := createUser("active", models.AuthModuleLDAP, true, false)
+	localID := createUser("local", "", false, false)
+
+	loginService := &Implementation{SQLStore: sqlStore}
+
+	isDisabled := func(userID int64) bool {
+		query := &models.GetUserByIdQuery{Id: userID}
+		require.NoError(t, sqlStore.GetUserById(ctx, query))
+		return query.Result.IsDisabled
+	}
+	reportedIDs := func(users []*StaleUser) []int64 {
+		ids := []int64{}
+		for _, u := range users {
+			ids = append(ids, u.UserId)
+		}
+		return ids
+	}
+
+	t.Run("dry run only reports", func(t *testing.T) {
+		report, err := loginService.DisableStaleExternalUsers(ctx, 24*time.Hour, true)
+		require.NoError(t, err)
+
+		assert.True(t, report.DryRun)
+		assert.Equal(t, []int64{staleID}, reportedIDs(report.Disabled))
+		assert.Equal(t, []int64{ownerID}, reportedIDs(report.Skipped))
+		for _, id := range []int64{ownerID, staleID, activeID, localID} {
+			assert.False(t, isDisabled(id))
+		}
+	})
+
+	t.Run("disables stale users", func(t *testing.T) {
+		report, err := loginService.DisableStaleExternalUsers(ctx, 24*time.Hour, false)
+		require.NoError(t, err)
+
+		assert.Equal(t, []int64{staleID}, reportedIDs(report.Disabled))
+		assert.Equal(t, []int64{ownerID}, reportedIDs(report.Skipped))
+		assert.True(t, isDisabled(staleID))
+		assert.False(t, isDisabled(ownerID), "only admin of an org should be kept")
+		assert.False(t, isDisabled(activeID))
+		assert.False(t, isDisabled(localID), "local users aren't external")
+	})
+}
+
+func Test_DisableStaleExternalUsers_staleAdmins(t *testing.T) {
+	sqlStore := sqlstore.InitTestDB(t)
+	ctx := context.Background()
+
+	createAdmin := func(login string) int64 {
+		// the first user creates org 1
+		firstUser := login == "first"
+		user, err := sqlStore.CreateUser(ctx, models.CreateUserCommand{Login: login, Email: login + "@example.org", SkipOrgSetup: !firstUser})
+		require.NoError(t, err)
+		if !firstUser {
+			require.NoError(t, sqlStore.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: 1, UserId: user.Id, Role: models.ROLE_ADMIN}))
+		}
+		err = sqlStore.WithDbSession(ctx, func(sess *sqlstore.DBSession) error {
+			_, err := sess.Insert(&models.UserAuth{UserId: user.Id, AuthModule: models.AuthModuleLDAP, AuthId: login, Created: time.Now()})
+			return err
+		})
+		require.NoError(t, err)
+		return user.Id
+	}
+
+	// both stale admins are the only enabled admins of org 1
+	firstID := createAdmin("first")
+	secondID := createAdmin("second")
+	retiredID := createAdmin("retired")
+	require.NoError(t, sqlStore.DisableUser(ctx, &models.DisableUserCommand{UserId: retiredID, IsDisabled: true}))
+
+	loginService := &Implementation{SQLStore: sqlStore, BatchConcurrency: 2}
+	report, err := loginService.DisableStaleExternalUsers(ctx, 24*time.Hour, false)
+	require.NoError(t, err)
+
+	require.Len(t, report.Disabled, 1)
+	require.Len(t, report.Skipped, 1)
+	assert.Equal(t, firstID, report.Disabled[0].UserId)
+	assert.Equal(t, secondID, report.Skipped[0].UserId)
+	assert.Equal(t, "only admin of an organization", report.Skipped[0].Reason)
+
+	query := &models.GetUserByIdQuery{Id: secondID}
+	require.NoError(t, sqlStore.GetUserById(ctx, query))
+	assert.False(t, query.Result.IsDisabled, "the last enabled admin should be kept")
+}
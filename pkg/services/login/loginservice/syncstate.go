@@ -0,0 +1,114 @@
+package loginservice
+
+import (
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// syncState carries the state of a single UpsertUser call through the sync steps.
+type syncState struct {
+	result *models.ExternalUserSyncResult
+	now    func() time.Time
+	// deadline is the soft deadline of the call, zero if there is none.
+	deadline time.Time
+	// userCreated is set when the user was created in this call.
+	userCreated bool
+	// observing is set when org and admin changes are only recorded, see ObserveUntil.
+	observing bool
+	// disabledMatch is the disabled user that matched the external user by email
+	// or login, see OnCollisionWithDisabled.
+	disabledMatch *models.User
+	// strictRoles, asyncOrgSync and allowAboveMinimum are StrictRoleValidation,
+	// AsyncOrgSync and AllowAutoCreateAboveMinimum with the overrides of the
+	// call applied.
+	strictRoles       bool
+	asyncOrgSync      bool
+	allowAboveMinimum bool
+	// topNDeferred are the orgs deferred by SyncTopNOrgs.
+	topNDeferred map[int64]bool
+	// deadlineDeferred is the number of orgs deferred by the soft deadline.
+	deadlineDeferred int
+	// fullSync is set when no org may be deferred, see ForceFullSync.
+	fullSync bool
+	// degraded is set when a degraded login is synced, see syncDegraded.
+	degraded bool
+	// writtenOrgRoles and writtenAdmin are the org roles and server admin flag
+	// the sync wrote, an empty role for a removed membership, see verifySync.
+	writtenOrgRoles map[int64]models.RoleType
+	writtenAdmin    *bool
+	// auditChanges are the changes collected for the entry of AuditAggregated.
+	auditChanges []login.LoginAuditChange
+}
+
+func (ls *Implementation) newSyncState() *syncState {
+	state := &syncState{result: &models.ExternalUserSyncResult{}, now: ls.now}
+	state.observing = !ls.ObserveUntil.IsZero() && ls.now().Before(ls.ObserveUntil)
+	if ls.SoftDeadline > 0 {
+		state.deadline = ls.now().Add(ls.SoftDeadline)
+	}
+	state.strictRoles = ls.StrictRoleValidation
+	state.asyncOrgSync = ls.AsyncOrgSync
+	state.allowAboveMinimum = ls.AllowAutoCreateAboveMinimum
+	return state
+}
+
+// applyOverrides applies the per call overrides of the configuration.
+func (s *syncState) applyOverrides(o *models.UpsertUserOverrides) {
+	if o == nil {
+		return
+	}
+	if o.ObserveOnly != nil {
+		s.observing = *o.ObserveOnly
+	}
+	if o.StrictRoleValidation != nil {
+		s.strictRoles = *o.StrictRoleValidation
+	}
+	if o.AsyncOrgSync != nil {
+		s.asyncOrgSync = *o.AsyncOrgSync
+	}
+	if o.AllowAutoCreateAboveMinimum != nil {
+		s.allowAboveMinimum = *o.AllowAutoCreateAboveMinimum
+	}
+}
+
+// orgRoleWritten records that the sync wrote the role of the user in an org,
+// an empty role for a removed membership.
+func (s *syncState) orgRoleWritten(orgID int64, role models.RoleType) {
+	if s.writtenOrgRoles == nil {
+		s.writtenOrgRoles = map[int64]models.RoleType{}
+	}
+	s.writtenOrgRoles[orgID] = role
+}
+
+// deferOrg reports whether the sync of an org should be deferred because it's
+// beyond SyncTopNOrgs or the soft deadline was exceeded, recording the org in
+// the result if so. Orgs are never deferred in a full sync.
+func (s *syncState) deferOrg(orgID int64) bool {
+	if s.fullSync {
+		return false
+	}
+	if s.topNDeferred[orgID] {
+		s.result.DeferredOrgIds = append(s.result.DeferredOrgIds, orgID)
+		return true
+	}
+	if s.deadline.IsZero() || s.now().Before(s.deadline) {
+		return false
+	}
+
+	s.deadlineDeferred++
+	s.result.DeferredOrgIds = append(s.result.DeferredOrgIds, orgID)
+	return true
+}
+
+// deferUpgrade reports whether a change of the role of the user in an org from
+// current, empty if the user isn't a member, to role should be deferred because
+// it grants more in a degraded login, recording the org in the result if so.
+func (s *syncState) deferUpgrade(orgID int64, current, role models.RoleType) bool {
+	if !s.degraded || (current != "" && !exceedsRole(role, current)) {
+		return false
+	}
+	s.result.DeferredOrgIds = append(s.result.DeferredOrgIds, orgID)
+	return true
+}
@@ -0,0 +1,35 @@
+package loginservice
+
+import (
+	"context"
+	"strings"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// recordSyncStatus annotates the user with the error the sync failed with, or
+// else its warnings, for AnnotateSyncErrors. The annotation is cleared by a
+// sync without either. The user is only updated when the annotation changes.
+func (ls *Implementation) recordSyncStatus(ctx context.Context, user *models.User, state *syncState, syncErr error) {
+	if !ls.AnnotateSyncErrors || user == nil || state.observing {
+		return
+	}
+
+	var status models.UserSyncStatus
+	var message string
+	if syncErr != nil {
+		status, message = models.UserSyncHadErrors, syncErr.Error()
+	} else if len(state.result.Warnings) > 0 {
+		status, message = models.UserSyncHadErrors, strings.Join(state.result.Warnings, "; ")
+	}
+	if status == user.LastSyncStatus && message == user.LastSyncError {
+		return
+	}
+
+	cmd := &models.UpdateUserSyncStatusCommand{UserId: user.Id, Status: status, Error: message}
+	if err := ls.SQLStore.UpdateUserSyncStatus(ctx, cmd); err != nil {
+		logger.Warn("Failed to record the sync status of the user", "userId", user.Id, "error", err)
+		return
+	}
+	user.LastSyncStatus, user.LastSyncError = status, message
+}
@@ -0,0 +1,77 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupAnnotateSyncErrors() (*Implementation, *fakeStore, *models.User) {
+	user := &models.User{Id: 1, Login: "alice", Email: "alice@example.org"}
+	store := newFakeStore(user)
+	store.addOrg(1)
+	return &Implementation{
+		SQLStore:           store,
+		AuthInfoService:    &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		AnnotateSyncErrors: true,
+	}, store, user
+}
+
+func Test_UpsertUser_annotateSyncErrors(t *testing.T) {
+	partial := &models.ExternalUserInfo{
+		Login:          "alice",
+		Email:          "alice@example.org",
+		OrgRolesByName: map[string]models.RoleType{"org-1": models.ROLE_VIEWER, "typo": models.ROLE_ADMIN},
+	}
+	clean := &models.ExternalUserInfo{
+		Login:    "alice",
+		Email:    "alice@example.org",
+		OrgRoles: map[int64]models.RoleType{1: models.ROLE_VIEWER},
+	}
+
+	t.Run("status is set after a partial failure and cleared after a success", func(t *testing.T) {
+		loginService, store, user := setupAnnotateSyncErrors()
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: partial}))
+		assert.Equal(t, models.UserSyncHadErrors, user.LastSyncStatus)
+		assert.Equal(t, `skipped role for unknown organization "typo"`, user.LastSyncError)
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: clean}))
+		assert.Empty(t, user.LastSyncStatus)
+		assert.Empty(t, user.LastSyncError)
+		assert.Len(t, store.syncStatusCmds, 2)
+		assert.Empty(t, store.updateUserCmds)
+	})
+
+	t.Run("status is set when the sync fails", func(t *testing.T) {
+		loginService, _, user := setupAnnotateSyncErrors()
+		loginService.TeamSync = func(user *models.User, externalUser *models.ExternalUserInfo) error {
+			return errors.New("team sync failed")
+		}
+
+		require.Error(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: clean}))
+		assert.Equal(t, models.UserSyncHadErrors, user.LastSyncStatus)
+		assert.Contains(t, user.LastSyncError, "team sync failed")
+	})
+
+	t.Run("user isn't updated when the status doesn't change", func(t *testing.T) {
+		loginService, store, _ := setupAnnotateSyncErrors()
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: clean}))
+		assert.Empty(t, store.syncStatusCmds)
+	})
+
+	t.Run("status isn't recorded when disabled", func(t *testing.T) {
+		loginService, store, user := setupAnnotateSyncErrors()
+		loginService.AnnotateSyncErrors = false
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: partial}))
+		assert.Empty(t, user.LastSyncStatus)
+		assert.Empty(t, store.syncStatusCmds)
+	})
+}
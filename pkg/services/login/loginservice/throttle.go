@@ -0,0 +1,90 @@
+package loginservice
+
+import (
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// defaultThrottleWindow is used when ThrottleLimit is set without a ThrottleWindow.
+const defaultThrottleWindow = time.Minute
+
+// throttleWindow counts the calls of a remote address in a fixed window.
+type throttleWindow struct {
+	start time.Time
+	count int
+}
+
+// loginThrottler counts UpsertUser calls per remote address, see ThrottleLimit.
+type loginThrottler struct {
+	mu      sync.Mutex
+	windows map[string]*throttleWindow
+}
+
+// take counts a call of addr and reports whether it's within the limit, and if
+// not when the window ends.
+func (t *loginThrottler) take(addr string, now time.Time, limit int, window time.Duration) (bool, time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.windows == nil {
+		t.windows = map[string]*throttleWindow{}
+	}
+	w, ok := t.windows[addr]
+	if !ok || !now.Before(w.start.Add(window)) {
+		// drop ended windows so that addresses seen once don't pile up
+		for a, other := range t.windows {
+			if !now.Before(other.start.Add(window)) {
+				delete(t.windows, a)
+			}
+		}
+		w = &throttleWindow{start: now}
+		t.windows[addr] = w
+	}
+
+	if w.count >= limit {
+		return false, w.start.Add(window)
+	}
+	w.count++
+	return true, time.Time{}
+}
+
+// refund uncounts a call of addr, e.g. a login that's exempt from throttling.
+func (t *loginThrottler) refund(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if w, ok := t.windows[addr]; ok && w.count > 0 {
+		w.count--
+	}
+}
+
+// throttleKey returns the remote address of the request of cmd, empty if there
+// is no request.
+func throttleKey(cmd *models.UpsertUserCommand) string {
+	c := cmd.ReqContext
+	if c == nil || c.Context == nil || c.Req == nil {
+		return ""
+	}
+	return c.RemoteAddr()
+}
+
+// throttle counts a call from remoteAddr and returns login.ErrLoginThrottled if
+// the remote address exceeded the ThrottleLimit.
+func (ls *Implementation) throttle(remoteAddr string) error {
+	if ls.ThrottleLimit <= 0 || remoteAddr == "" {
+		return nil
+	}
+
+	window := ls.ThrottleWindow
+	if window <= 0 {
+		window = defaultThrottleWindow
+	}
+	if ok, retryAfter := ls.throttler.take(remoteAddr, ls.now(), ls.ThrottleLimit, window); !ok {
+		logger.Warn("Throttling external login", "remoteAddr", remoteAddr, "retryAfter", retryAfter)
+		return &login.ErrLoginThrottled{RemoteAddr: remoteAddr, RetryAfter: retryAfter}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package loginservice
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/web"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_UpsertUser_throttle(t *testing.T) {
+	setup := func(exemptExisting bool) (*Implementation, *clock.Mock) {
+		clk := clock.NewMock()
+		user := &models.User{Id: 1, Login: "alice"}
+		return &Implementation{
+			Clock:                  clk,
+			SQLStore:               newFakeStore(user),
+			AuthInfoService:        &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			ThrottleLimit:          2,
+			ThrottleWindow:         time.Minute,
+			ThrottleExemptExisting: exemptExisting,
+		}, clk
+	}
+	upsertFrom := func(loginService *Implementation, remoteAddr string) error {
+		req := httptest.NewRequest("GET", "/login/generic_oauth", nil)
+		req.RemoteAddr = remoteAddr
+		cmd := &models.UpsertUserCommand{
+			ReqContext:   &models.ReqContext{Context: &web.Context{Req: req}, Logger: logger},
+			ExternalUser: &models.ExternalUserInfo{Login: "alice"},
+		}
+		return loginService.UpsertUser(context.Background(), cmd)
+	}
+
+	t.Run("calls past the limit are throttled per remote address", func(t *testing.T) {
+		loginService, clk := setup(false)
+
+		require.NoError(t, upsertFrom(loginService, "192.0.2.1:1234"))
+		require.NoError(t, upsertFrom(loginService, "192.0.2.1:5678"))
+
+		var throttled *login.ErrLoginThrottled
+		require.ErrorAs(t, upsertFrom(loginService, "192.0.2.1:1234"), &throttled)
+		assert.Equal(t, "192.0.2.1", throttled.RemoteAddr)
+		assert.Equal(t, clk.Now().Add(time.Minute), throttled.RetryAfter)
+
+		require.NoError(t, upsertFrom(loginService, "198.51.100.1:1234"), "other addresses have their own limit")
+
+		clk.Add(time.Minute)
+		require.NoError(t, upsertFrom(loginService, "192.0.2.1:1234"))
+	})
+
+	t.Run("calls without a request aren't throttled", func(t *testing.T) {
+		loginService, _ := setup(false)
+
+		for i := 0; i < 3; i++ {
+			cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice"}}
+			require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+		}
+	})
+
+	t.Run("logins of existing users can be exempt", func(t *testing.T) {
+		loginService, _ := setup(true)
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, upsertFrom(loginService, "192.0.2.1:1234"))
+		}
+
+		// failed logins still count
+		loginService.AuthInfoService = &logintest.AuthInfoServiceFake{ExpectedError: models.ErrUserNotFound}
+		require.ErrorIs(t, upsertFrom(loginService, "192.0.2.1:1234"), login.ErrSignupNotAllowed)
+		require.ErrorIs(t, upsertFrom(loginService, "192.0.2.1:1234"), login.ErrSignupNotAllowed)
+		var throttled *login.ErrLoginThrottled
+		require.ErrorAs(t, upsertFrom(loginService, "192.0.2.1:1234"), &throttled)
+	})
+}
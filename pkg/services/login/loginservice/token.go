@@ -0,0 +1,54 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// TokenPersistFailurePolicy controls what happens when persisting the OAuth
+// token of a user fails.
+type TokenPersistFailurePolicy int
+
+const (
+	// TokenPersistFailFatal fails the login (default).
+	TokenPersistFailFatal TokenPersistFailurePolicy = iota
+	// TokenPersistFailWarn logs in the user without storing the token and
+	// records the failure in the sync result. Users are still linked to their
+	// external identity, failures to store the link stay fatal.
+	TokenPersistFailWarn
+)
+
+// setAuthInfo links a created user to its external identity. With
+// TokenPersistFailWarn a failed link is retried without the token, and the user
+// is logged in if that succeeds.
+func (ls *Implementation) setAuthInfo(ctx context.Context, cmd *models.SetAuthInfoCommand, state *syncState) error {
+	err := ls.AuthInfoService.SetAuthInfo(ctx, cmd)
+	if err == nil || cmd.OAuthToken == nil || ls.OnTokenPersistFailure != TokenPersistFailWarn {
+		return err
+	}
+
+	withoutToken := *cmd
+	withoutToken.OAuthToken = nil
+	if linkErr := ls.AuthInfoService.SetAuthInfo(ctx, &withoutToken); linkErr != nil {
+		logger.Debug("Failed to link user without token", "userId", cmd.UserId, "authmodule", cmd.AuthModule, "error", linkErr)
+		return err
+	}
+
+	ls.tokenPersistFailed(cmd.UserId, cmd.AuthModule, state, err)
+	return nil
+}
+
+// tokenPersistFailed applies the OnTokenPersistFailure policy to a failure to
+// store a token, it reports whether the login may continue.
+func (ls *Implementation) tokenPersistFailed(userID int64, authModule string, state *syncState, err error) bool {
+	if ls.OnTokenPersistFailure != TokenPersistFailWarn {
+		return false
+	}
+
+	logger.Warn("Failed to persist OAuth token, continuing", "userId", userID, "authmodule", authModule, "error", err)
+	state.result.TokenPersistFailed = true
+	state.result.Warnings = append(state.result.Warnings, fmt.Sprintf("failed to persist token of auth module %s: %v", authModule, err))
+	return true
+}
@@ -0,0 +1,124 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// failingTokenAuthInfoService fails to store auth info with a token. Users are
+// found if ExpectedUser is set.
+type failingTokenAuthInfoService struct {
+	*logintest.AuthInfoServiceFake
+	err error
+
+	linked []*models.SetAuthInfoCommand
+}
+
+func (s *failingTokenAuthInfoService) LookupAndUpdate(ctx context.Context, query *models.GetUserByAuthInfoQuery) (*models.User, error) {
+	if s.ExpectedUser == nil {
+		return nil, models.ErrUserNotFound
+	}
+	return s.ExpectedUser, nil
+}
+
+func (s *failingTokenAuthInfoService) SetAuthInfo(ctx context.Context, cmd *models.SetAuthInfoCommand) error {
+	if cmd.OAuthToken != nil {
+		return s.err
+	}
+	s.linked = append(s.linked, cmd)
+	return nil
+}
+
+func (s *failingTokenAuthInfoService) UpdateAuthInfo(ctx context.Context, cmd *models.UpdateAuthInfoCommand) error {
+	return s.err
+}
+
+func Test_UpsertUser_tokenPersistFailurePolicy(t *testing.T) {
+	errEncrypt := errors.New("failed to encrypt token")
+
+	setup := func(policy TokenPersistFailurePolicy, users ...*models.User) (*Implementation, *fakeStore, *failingTokenAuthInfoService) {
+		store := newFakeStore(users...)
+		authInfoService := &failingTokenAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}, err: errEncrypt}
+		if len(users) > 0 {
+			authInfoService.ExpectedUser = users[0]
+		}
+		return &Implementation{
+			SQLStore:              store,
+			QuotaService:          &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService:       authInfoService,
+			OnTokenPersistFailure: policy,
+		}, store, authInfoService
+	}
+	upsertCmd := func() *models.UpsertUserCommand {
+		return &models.UpsertUserCommand{SignupAllowed: true, ExternalUser: &models.ExternalUserInfo{
+			AuthModule: "oauth_generic_oauth",
+			AuthId:     "abc",
+			Login:      "alice",
+			OAuthToken: &oauth2.Token{AccessToken: "access"},
+		}}
+	}
+
+	t.Run("new user", func(t *testing.T) {
+		t.Run("failure is fatal by default", func(t *testing.T) {
+			loginService, store, _ := setup(TokenPersistFailFatal)
+			cmd := upsertCmd()
+
+			err := loginService.UpsertUser(context.Background(), cmd)
+			require.ErrorIs(t, err, errEncrypt)
+			assert.ErrorIs(t, err, &login.ErrUpsertUser{Phase: login.UpsertPhaseAuthInfo})
+			assert.Empty(t, store.users)
+		})
+
+		t.Run("user is linked without token when warning", func(t *testing.T) {
+			loginService, store, authInfoService := setup(TokenPersistFailWarn)
+			cmd := upsertCmd()
+
+			require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+			assert.Len(t, store.users, 1)
+			require.Len(t, authInfoService.linked, 1)
+			assert.Equal(t, "abc", authInfoService.linked[0].AuthId)
+			assert.True(t, cmd.SyncResult.TokenPersistFailed)
+			assert.Len(t, cmd.SyncResult.Warnings, 1)
+		})
+
+		t.Run("link failures stay fatal when warning", func(t *testing.T) {
+			loginService, store, _ := setup(TokenPersistFailWarn)
+			loginService.AuthInfoService = &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{ExpectedSetAuthInfoError: errEncrypt}}
+			cmd := upsertCmd()
+
+			require.ErrorIs(t, loginService.UpsertUser(context.Background(), cmd), errEncrypt)
+			assert.Empty(t, store.users)
+		})
+	})
+
+	t.Run("existing user", func(t *testing.T) {
+		t.Run("failure is fatal by default", func(t *testing.T) {
+			loginService, _, _ := setup(TokenPersistFailFatal, &models.User{Id: 1, Login: "alice"})
+			cmd := upsertCmd()
+
+			err := loginService.UpsertUser(context.Background(), cmd)
+			require.ErrorIs(t, err, errEncrypt)
+			assert.ErrorIs(t, err, &login.ErrUpsertUser{Phase: login.UpsertPhaseAuthInfo})
+		})
+
+		t.Run("failure is recorded when warning", func(t *testing.T) {
+			loginService, _, _ := setup(TokenPersistFailWarn, &models.User{Id: 1, Login: "alice"})
+			cmd := upsertCmd()
+
+			require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+			assert.Equal(t, int64(1), cmd.Result.Id)
+			assert.True(t, cmd.SyncResult.TokenPersistFailed)
+			assert.Len(t, cmd.SyncResult.Warnings, 1)
+		})
+	})
+}
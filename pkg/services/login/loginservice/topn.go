@@ -0,0 +1,51 @@
+package loginservice
+
+import (
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// deferBeyondTopN defers the org role additions and updates beyond the
+// SyncTopNOrgs with the highest external roles, ties going to the lower org
+// id. Removals are never deferred.
+func (ls *Implementation) deferBeyondTopN(user *models.User, extUser *models.ExternalUserInfo, current []*models.UserOrgDTO, state *syncState) {
+	if ls.SyncTopNOrgs <= 0 || state.fullSync {
+		return
+	}
+
+	currentByOrg := make(map[int64]*models.UserOrgDTO, len(current))
+	for _, org := range current {
+		currentByOrg[org.OrgId] = org
+	}
+
+	changed := []int64{}
+	for orgID := range extUser.OrgRoles {
+		role := ls.externalOrgRole(extUser, orgID)
+		if role == "" {
+			continue
+		}
+		if org, ok := currentByOrg[orgID]; ok && org.Role == role && expiryEqual(orgRoleExpiry(extUser, orgID), org.Expires) {
+			continue
+		}
+		changed = append(changed, orgID)
+	}
+	if len(changed) <= ls.SyncTopNOrgs {
+		return
+	}
+
+	sort.Slice(changed, func(i, j int) bool {
+		ri, rj := ls.externalOrgRole(extUser, changed[i]), ls.externalOrgRole(extUser, changed[j])
+		if ri != rj {
+			return exceedsRole(ri, rj)
+		}
+		return changed[i] < changed[j]
+	})
+
+	deferred := changed[ls.SyncTopNOrgs:]
+	logger.Debug("Deferring organization role sync beyond the top organizations", "userId", user.Id, "syncTopNOrgs", ls.SyncTopNOrgs, "deferredOrgIds", deferred)
+	state.topNDeferred = make(map[int64]bool, len(deferred))
+	for _, orgID := range deferred {
+		state.topNDeferred[orgID] = true
+	}
+}
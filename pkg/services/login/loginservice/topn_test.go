@@ -0,0 +1,112 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupSyncTopNOrgs(n int) (*Implementation, *fakeStore) {
+	user := &models.User{Id: 1, Login: "alice"}
+	store := newFakeStore(user)
+	for orgID := int64(1); orgID <= 5; orgID++ {
+		store.addOrg(orgID)
+	}
+	return &Implementation{
+		SQLStore:        store,
+		AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		SyncTopNOrgs:    n,
+	}, store
+}
+
+func Test_UpsertUser_syncTopNOrgs(t *testing.T) {
+	t.Run("only the orgs with the highest roles are synced", func(t *testing.T) {
+		loginService, store := setupSyncTopNOrgs(2)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login: "alice",
+			OrgRoles: map[int64]models.RoleType{
+				1: models.ROLE_VIEWER,
+				2: models.ROLE_ADMIN,
+				3: models.ROLE_EDITOR,
+				4: models.ROLE_VIEWER,
+			},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_ADMIN}, store.orgUsers[2])
+		assert.Equal(t, map[int64]models.RoleType{1: models.ROLE_EDITOR}, store.orgUsers[3])
+		assert.Empty(t, store.orgUsers[1])
+		assert.Empty(t, store.orgUsers[4])
+		assert.ElementsMatch(t, []int64{1, 4}, cmd.SyncResult.DeferredOrgIds)
+		assert.Empty(t, cmd.SyncResult.Warnings)
+	})
+
+	t.Run("unchanged memberships don't count", func(t *testing.T) {
+		loginService, store := setupSyncTopNOrgs(1)
+		store.addOrgUser(2, 1, models.ROLE_ADMIN)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login: "alice",
+			OrgRoles: map[int64]models.RoleType{
+				1: models.ROLE_VIEWER,
+				2: models.ROLE_ADMIN,
+				3: models.ROLE_EDITOR,
+			},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, []string{"AddOrgUser:3"}, store.calls)
+		assert.Equal(t, []int64{1}, cmd.SyncResult.DeferredOrgIds)
+	})
+
+	t.Run("removals are not deferred", func(t *testing.T) {
+		loginService, store := setupSyncTopNOrgs(1)
+		store.addOrgUser(5, 1, models.ROLE_VIEWER)
+		store.addOrgUser(5, 2, models.ROLE_ADMIN)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			OrgRoles: map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: models.ROLE_VIEWER},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, []string{"AddOrgUser:1", "RemoveOrgUser:5"}, store.calls)
+		assert.Equal(t, []int64{2}, cmd.SyncResult.DeferredOrgIds)
+	})
+
+	t.Run("forced calls sync all orgs", func(t *testing.T) {
+		loginService, store := setupSyncTopNOrgs(1)
+		loginService.SoftDeadline = time.Nanosecond
+
+		cmd := &models.UpsertUserCommand{
+			ExternalUser: &models.ExternalUserInfo{
+				Login:    "alice",
+				OrgRoles: map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: models.ROLE_VIEWER, 3: models.ROLE_ADMIN},
+			},
+			ForceFullSync: true,
+		}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Len(t, store.calls, 3)
+		assert.Empty(t, cmd.SyncResult.DeferredOrgIds)
+	})
+
+	t.Run("all orgs are synced within the limit", func(t *testing.T) {
+		loginService, store := setupSyncTopNOrgs(2)
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{
+			Login:    "alice",
+			OrgRoles: map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: models.ROLE_VIEWER},
+		}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Len(t, store.calls, 2)
+		assert.Empty(t, cmd.SyncResult.DeferredOrgIds)
+	})
+}
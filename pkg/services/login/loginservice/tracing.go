@@ -0,0 +1,44 @@
+package loginservice
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// Span names of the UpsertUser phases.
+const (
+	spanLookup   = "login.upsert_user.lookup"
+	spanCreate   = "login.upsert_user.create"
+	spanUpdate   = "login.upsert_user.update"
+	spanToken    = "login.upsert_user.token"
+	spanOrgSync  = "login.upsert_user.org_sync"
+	spanTeamSync = "login.upsert_user.team_sync"
+)
+
+// startSpan starts a span for a phase of UpsertUser. The returned function ends
+// it, recording the outcome of the phase. Both are no-ops without a Tracer,
+// apart from recording the duration of the phase for RecordPhaseDurations.
+func (ls *Implementation) startSpan(ctx context.Context, state *syncState, name string, extUser *models.ExternalUserInfo) (context.Context, func(error)) {
+	endPhase := ls.startPhase(state, name)
+	if ls.Tracer == nil {
+		return ctx, func(error) { endPhase() }
+	}
+
+	ctx, span := ls.Tracer.Start(ctx, name)
+	span.SetAttributes("authModule", extUser.AuthModule, attribute.Key("authModule").String(extUser.AuthModule))
+
+	return ctx, func(err error) {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes("outcome", outcome, attribute.Key("outcome").String(outcome))
+		span.End()
+		endPhase()
+	}
+}
@@ -0,0 +1,139 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/infra/tracing"
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/grafana/grafana/pkg/services/quota"
+	"github.com/grafana/grafana/pkg/setting"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+func (t *fakeTracer) Run(context.Context) error {
+	return nil
+}
+
+func (t *fakeTracer) Start(ctx context.Context, spanName string, opts ...trace.SpanStartOption) (context.Context, tracing.Span) {
+	span := &fakeSpan{name: spanName, attributes: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (t *fakeTracer) Inject(context.Context, http.Header, tracing.Span) {}
+
+func (t *fakeTracer) spanNames() []string {
+	names := []string{}
+	for _, s := range t.spans {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+type fakeSpan struct {
+	name       string
+	attributes map[string]interface{}
+	ended      bool
+	status     codes.Code
+}
+
+func (s *fakeSpan) End() {
+	s.ended = true
+}
+
+func (s *fakeSpan) SetAttributes(key string, value interface{}, kv attribute.KeyValue) {
+	s.attributes[key] = value
+}
+
+func (s *fakeSpan) SetName(name string) {
+	s.name = name
+}
+
+func (s *fakeSpan) SetStatus(code codes.Code, description string) {
+	s.status = code
+}
+
+func (s *fakeSpan) RecordError(err error, options ...trace.EventOption) {}
+
+func (s *fakeSpan) AddEvents(keys []string, values []tracing.EventValue) {}
+
+// notFoundAuthInfoService doesn't find any user but sets auth info successfully.
+type notFoundAuthInfoService struct {
+	*logintest.AuthInfoServiceFake
+}
+
+func (s *notFoundAuthInfoService) LookupAndUpdate(ctx context.Context, query *models.GetUserByAuthInfoQuery) (*models.User, error) {
+	return nil, models.ErrUserNotFound
+}
+
+func Test_UpsertUser_tracing(t *testing.T) {
+	t.Run("new user", func(t *testing.T) {
+		tracer := &fakeTracer{}
+		loginService := &Implementation{
+			SQLStore:        newFakeStore(),
+			QuotaService:    &quota.QuotaService{Cfg: setting.NewCfg()},
+			AuthInfoService: &notFoundAuthInfoService{AuthInfoServiceFake: &logintest.AuthInfoServiceFake{}},
+			Tracer:          tracer,
+			TeamSync: func(user *models.User, externalUser *models.ExternalUserInfo) error {
+				return nil
+			},
+		}
+
+		cmd := &models.UpsertUserCommand{
+			SignupAllowed: true,
+			ExternalUser:  &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", AuthId: "abc", Login: "alice"},
+		}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, []string{spanLookup, spanCreate, spanToken, spanOrgSync, spanTeamSync}, tracer.spanNames())
+		for _, span := range tracer.spans {
+			assert.True(t, span.ended, span.name)
+			assert.Equal(t, "oauth_generic_oauth", span.attributes["authModule"], span.name)
+			assert.Equal(t, "success", span.attributes["outcome"], span.name)
+		}
+	})
+
+	t.Run("existing user with a failing phase", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice"}
+		tracer := &fakeTracer{}
+		loginService := &Implementation{
+			SQLStore:        newFakeStore(user),
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			Tracer:          tracer,
+			TeamSync: func(user *models.User, externalUser *models.ExternalUserInfo) error {
+				return errors.New("team sync failed")
+			},
+		}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{AuthModule: "oauth_generic_oauth", Login: "alice"}}
+		require.Error(t, loginService.UpsertUser(context.Background(), cmd))
+
+		assert.Equal(t, []string{spanLookup, spanUpdate, spanOrgSync, spanTeamSync}, tracer.spanNames())
+		teamSync := tracer.spans[3]
+		assert.Equal(t, "error", teamSync.attributes["outcome"])
+		assert.Equal(t, codes.Error, teamSync.status)
+	})
+
+	t.Run("no tracer", func(t *testing.T) {
+		user := &models.User{Id: 1, Login: "alice"}
+		loginService := &Implementation{
+			SQLStore:        newFakeStore(user),
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+		}
+
+		cmd := &models.UpsertUserCommand{ExternalUser: &models.ExternalUserInfo{Login: "alice"}}
+		require.NoError(t, loginService.UpsertUser(context.Background(), cmd))
+	})
+}
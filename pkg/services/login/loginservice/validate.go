@@ -0,0 +1,123 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// ValidationError is a problem with an UpsertUserCommand found by
+// ValidateUpsertCommand.
+type ValidationError struct {
+	// Field is the invalid field of the command, e.g. "ExternalUser.OrgRoles[2]".
+	Field  string
+	Reason string
+	// Err is the error UpsertUser fails with because of the problem. It's nil
+	// if the invalid value is skipped, or if the error depends on lookups.
+	Err error
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Reason)
+}
+
+// identityKeyFields are the fields of the command that hold the identity keys.
+var identityKeyFields = map[IdentityKey]string{
+	IdentityKeyEmail:       "ExternalUser.Email",
+	IdentityKeyLogin:       "ExternalUser.Login",
+	IdentityKeyAuthSubject: "ExternalUser.AuthId",
+}
+
+// ValidateUpsertCommand checks an UpsertUserCommand without looking anything up
+// or writing anything, and returns the problems found. Org ids and names are
+// only checked for their format, not for existence.
+func (ls *Implementation) ValidateUpsertCommand(ctx context.Context, cmd *models.UpsertUserCommand) []ValidationError {
+	extUser := cmd.ExternalUser
+	if extUser == nil {
+		return []ValidationError{{Field: "ExternalUser", Reason: "is required"}}
+	}
+
+	strict := ls.StrictRoleValidation
+	if cmd.Overrides != nil && cmd.Overrides.StrictRoleValidation != nil {
+		strict = *cmd.Overrides.StrictRoleValidation
+	}
+
+	var problems []ValidationError
+	if extUser.AuthModule == "" && extUser.AuthId != "" {
+		err := &login.ErrMissingAuthModule{AuthId: extUser.AuthId}
+		problems = append(problems, ValidationError{Field: "ExternalUser.AuthModule", Reason: "is required with an auth id", Err: err})
+	}
+	if extUser.Login == "" && extUser.Email == "" && extUser.AuthId == "" {
+		problems = append(problems, ValidationError{Field: "ExternalUser", Reason: "login, email or auth id is required"})
+	}
+	if err := ls.checkIdentityKey(extUser); err != nil {
+		problems = append(problems, ValidationError{Field: identityKeyFields[ls.IdentityKey], Reason: "is required as the identity key", Err: err})
+	}
+	if extUser.Email != "" && !isValidEmail(extUser.Email) {
+		problems = append(problems, ValidationError{Field: "ExternalUser.Email", Reason: fmt.Sprintf("invalid email %q", extUser.Email)})
+	}
+	for i, alias := range extUser.EmailAliases {
+		if !isValidEmail(alias) {
+			problems = append(problems, ValidationError{Field: fmt.Sprintf("ExternalUser.EmailAliases[%d]", i), Reason: fmt.Sprintf("invalid email %q", alias)})
+		}
+	}
+	if cmd.ProvisioningOrgID < 0 {
+		problems = append(problems, ValidationError{Field: "ProvisioningOrgID", Reason: "invalid organization id", Err: login.ErrInvalidOrgId})
+	}
+
+	orgIDs := make([]int64, 0, len(extUser.OrgRoles))
+	for orgID := range extUser.OrgRoles {
+		orgIDs = append(orgIDs, orgID)
+	}
+	sort.Slice(orgIDs, func(i, j int) bool { return orgIDs[i] < orgIDs[j] })
+	for _, orgID := range orgIDs {
+		field := fmt.Sprintf("ExternalUser.OrgRoles[%d]", orgID)
+		if orgID <= 0 {
+			problems = append(problems, ValidationError{Field: field, Reason: "invalid organization id", Err: strictErr(strict, fmt.Errorf("%w: %d", login.ErrInvalidOrgId, orgID))})
+			continue
+		}
+		if role := extUser.OrgRoles[orgID]; role != "" {
+			if _, ok := ls.resolveRoleAlias(role); !ok {
+				problems = append(problems, ValidationError{Field: field, Reason: fmt.Sprintf("unknown role %q", role), Err: strictErr(strict, &login.ErrInvalidOrgRole{OrgId: orgID, Role: role})})
+			}
+		}
+	}
+
+	names := make([]string, 0, len(extUser.OrgRolesByName))
+	for name := range extUser.OrgRolesByName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		field := fmt.Sprintf("ExternalUser.OrgRolesByName[%q]", name)
+		if name == "" {
+			problems = append(problems, ValidationError{Field: field, Reason: "empty organization name"})
+			continue
+		}
+		if role := extUser.OrgRolesByName[name]; role != "" {
+			if _, ok := ls.resolveRoleAlias(role); !ok {
+				problems = append(problems, ValidationError{Field: field, Reason: fmt.Sprintf("unknown role %q", role)})
+			}
+		}
+	}
+
+	return problems
+}
+
+// strictErr returns err if the sync fails on it, see StrictRoleValidation.
+func strictErr(strict bool, err error) error {
+	if !strict {
+		return nil
+	}
+	return err
+}
+
+// isValidEmail reports whether email is a bare address, without a display name.
+func isValidEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
@@ -0,0 +1,105 @@
+package loginservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateUpsertCommand(t *testing.T) {
+	valid := func() *models.ExternalUserInfo {
+		return &models.ExternalUserInfo{
+			AuthModule:     "oauth_generic_oauth",
+			AuthId:         "abc",
+			Login:          "alice",
+			Email:          "alice@example.org",
+			EmailAliases:   []string{"alice@example.com"},
+			OrgRoles:       map[int64]models.RoleType{1: models.ROLE_EDITOR, 2: "administrator", 3: ""},
+			OrgRolesByName: map[string]models.RoleType{"Main Org.": models.ROLE_VIEWER},
+		}
+	}
+
+	tests := []struct {
+		desc          string
+		modify        func(cmd *models.UpsertUserCommand)
+		expectedField string
+	}{
+		{desc: "missing external user", modify: func(cmd *models.UpsertUserCommand) { cmd.ExternalUser = nil }, expectedField: "ExternalUser"},
+		{desc: "auth id without auth module", modify: func(cmd *models.UpsertUserCommand) { cmd.ExternalUser.AuthModule = "" }, expectedField: "ExternalUser.AuthModule"},
+		{desc: "no identity", modify: func(cmd *models.UpsertUserCommand) {
+			cmd.ExternalUser.Login, cmd.ExternalUser.Email, cmd.ExternalUser.AuthId = "", "", ""
+		}, expectedField: "ExternalUser"},
+		{desc: "invalid email", modify: func(cmd *models.UpsertUserCommand) { cmd.ExternalUser.Email = "alice" }, expectedField: "ExternalUser.Email"},
+		{desc: "email with display name", modify: func(cmd *models.UpsertUserCommand) { cmd.ExternalUser.Email = "Alice <alice@example.org>" }, expectedField: "ExternalUser.Email"},
+		{desc: "invalid email alias", modify: func(cmd *models.UpsertUserCommand) {
+			cmd.ExternalUser.EmailAliases = append(cmd.ExternalUser.EmailAliases, "@")
+		}, expectedField: "ExternalUser.EmailAliases[1]"},
+		{desc: "invalid provisioning org id", modify: func(cmd *models.UpsertUserCommand) { cmd.ProvisioningOrgID = -1 }, expectedField: "ProvisioningOrgID"},
+		{desc: "invalid org id", modify: func(cmd *models.UpsertUserCommand) { cmd.ExternalUser.OrgRoles[0] = models.ROLE_VIEWER }, expectedField: "ExternalUser.OrgRoles[0]"},
+		{desc: "unknown org role", modify: func(cmd *models.UpsertUserCommand) { cmd.ExternalUser.OrgRoles[4] = "Owner" }, expectedField: "ExternalUser.OrgRoles[4]"},
+		{desc: "empty org name", modify: func(cmd *models.UpsertUserCommand) { cmd.ExternalUser.OrgRolesByName[""] = models.ROLE_VIEWER }, expectedField: `ExternalUser.OrgRolesByName[""]`},
+		{desc: "unknown org role by name", modify: func(cmd *models.UpsertUserCommand) { cmd.ExternalUser.OrgRolesByName["Main Org."] = "Owner" }, expectedField: `ExternalUser.OrgRolesByName["Main Org."]`},
+	}
+
+	t.Run("valid command", func(t *testing.T) {
+		loginService := &Implementation{}
+		assert.Empty(t, loginService.ValidateUpsertCommand(context.Background(), &models.UpsertUserCommand{ExternalUser: valid()}))
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			// no store is set, validation must not look anything up
+			loginService := &Implementation{}
+			cmd := &models.UpsertUserCommand{ExternalUser: valid()}
+			tt.modify(cmd)
+
+			problems := loginService.ValidateUpsertCommand(context.Background(), cmd)
+			require.Len(t, problems, 1)
+			assert.Equal(t, tt.expectedField, problems[0].Field)
+		})
+	}
+
+	t.Run("reports all problems in order", func(t *testing.T) {
+		loginService := &Implementation{}
+		cmd := &models.UpsertUserCommand{ExternalUser: valid()}
+		cmd.ExternalUser.Email = "alice"
+		cmd.ExternalUser.OrgRoles[-1] = models.ROLE_VIEWER
+		cmd.ExternalUser.OrgRoles[4] = "Owner"
+
+		problems := loginService.ValidateUpsertCommand(context.Background(), cmd)
+		fields := make([]string, 0, len(problems))
+		for _, p := range problems {
+			fields = append(fields, p.Field)
+		}
+		assert.Equal(t, []string{"ExternalUser.Email", "ExternalUser.OrgRoles[-1]", "ExternalUser.OrgRoles[4]"}, fields)
+	})
+
+	t.Run("missing identity key", func(t *testing.T) {
+		cmd := &models.UpsertUserCommand{ExternalUser: valid()}
+		cmd.ExternalUser.AuthId = ""
+
+		problems := (&Implementation{IdentityKey: IdentityKeyAuthSubject}).ValidateUpsertCommand(context.Background(), cmd)
+		require.Len(t, problems, 1)
+		assert.Equal(t, "ExternalUser.AuthId", problems[0].Field)
+		assert.ErrorIs(t, problems[0].Err, login.ErrMissingAuthId)
+	})
+
+	t.Run("org problems fail the upsert with strict validation", func(t *testing.T) {
+		cmd := &models.UpsertUserCommand{ExternalUser: valid()}
+		cmd.ExternalUser.OrgRoles[4] = "Owner"
+
+		lax := (&Implementation{}).ValidateUpsertCommand(context.Background(), cmd)
+		require.Len(t, lax, 1)
+		assert.NoError(t, lax[0].Err)
+
+		strict := (&Implementation{StrictRoleValidation: true}).ValidateUpsertCommand(context.Background(), cmd)
+		require.Len(t, strict, 1)
+		var roleErr *login.ErrInvalidOrgRole
+		require.ErrorAs(t, strict[0].Err, &roleErr)
+		assert.Equal(t, int64(4), roleErr.OrgId)
+	})
+}
@@ -0,0 +1,62 @@
+package loginservice
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+)
+
+// verifySync reads back the org memberships and server admin flag the sync
+// wrote, for VerifyAfterSync. Reads don't use the ReadStore, which may lag
+// behind the writes.
+func (ls *Implementation) verifySync(ctx context.Context, user *models.User, state *syncState) error {
+	if !ls.VerifyAfterSync || (len(state.writtenOrgRoles) == 0 && state.writtenAdmin == nil) {
+		return nil
+	}
+
+	mismatches := []string{}
+	if len(state.writtenOrgRoles) > 0 {
+		query := &models.GetUserOrgListQuery{UserId: user.Id}
+		if err := ls.SQLStore.GetUserOrgList(ctx, query); err != nil {
+			return err
+		}
+		stored := make(map[int64]models.RoleType, len(query.Result))
+		for _, org := range query.Result {
+			stored[org.OrgId] = org.Role
+		}
+
+		orgIDs := make([]int64, 0, len(state.writtenOrgRoles))
+		for orgID := range state.writtenOrgRoles {
+			orgIDs = append(orgIDs, orgID)
+		}
+		sort.Slice(orgIDs, func(i, j int) bool { return orgIDs[i] < orgIDs[j] })
+		for _, orgID := range orgIDs {
+			written, role := state.writtenOrgRoles[orgID], stored[orgID]
+			switch {
+			case written == "" && role != "":
+				mismatches = append(mismatches, fmt.Sprintf("removed membership of organization %d still has role %q", orgID, role))
+			case written != "" && role != written:
+				mismatches = append(mismatches, fmt.Sprintf("role in organization %d is %q instead of %q", orgID, role, written))
+			}
+		}
+	}
+
+	if state.writtenAdmin != nil {
+		query := &models.GetUserByIdQuery{Id: user.Id}
+		if err := ls.SQLStore.GetUserById(ctx, query); err != nil {
+			return err
+		}
+		if query.Result.IsAdmin != *state.writtenAdmin {
+			mismatches = append(mismatches, fmt.Sprintf("server admin flag is %t instead of %t", query.Result.IsAdmin, *state.writtenAdmin))
+		}
+	}
+
+	if len(mismatches) == 0 {
+		return nil
+	}
+	logger.Error("Stored state of the user doesn't match the sync", "userId", user.Id, "mismatches", mismatches)
+	return &login.ErrSyncVerificationFailed{UserId: user.Id, Mismatches: mismatches}
+}
@@ -0,0 +1,100 @@
+package loginservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/grafana/grafana/pkg/models"
+	"github.com/grafana/grafana/pkg/services/login"
+	"github.com/grafana/grafana/pkg/services/login/logintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// staleReadStore accepts writes but keeps returning the org memberships of the
+// first read and the server admin flag the user started with.
+type staleReadStore struct {
+	*fakeStore
+	orgs    []*models.UserOrgDTO
+	isAdmin bool
+}
+
+func (s *staleReadStore) GetUserOrgList(ctx context.Context, query *models.GetUserOrgListQuery) error {
+	if s.orgs != nil {
+		query.Result = s.orgs
+		return nil
+	}
+	if err := s.fakeStore.GetUserOrgList(ctx, query); err != nil {
+		return err
+	}
+	s.orgs = query.Result
+	return nil
+}
+
+func (s *staleReadStore) GetUserById(ctx context.Context, query *models.GetUserByIdQuery) error {
+	if err := s.fakeStore.GetUserById(ctx, query); err != nil {
+		return err
+	}
+	stale := *query.Result
+	stale.IsAdmin = s.isAdmin
+	query.Result = &stale
+	return nil
+}
+
+func Test_UpsertUser_verifyAfterSync(t *testing.T) {
+	isAdmin := true
+	extUser := &models.ExternalUserInfo{
+		Login:          "alice",
+		OrgRoles:       map[int64]models.RoleType{1: models.ROLE_ADMIN},
+		IsGrafanaAdmin: &isAdmin,
+	}
+
+	setup := func(stale bool) (*Implementation, *fakeStore) {
+		user := &models.User{Id: 1, Login: "alice", OrgId: 1}
+		store := newFakeStore(user)
+		store.addOrg(1)
+		store.addOrg(2)
+		store.addOrgUser(2, 1, models.ROLE_EDITOR)
+		loginService := &Implementation{
+			SQLStore:        store,
+			AuthInfoService: &logintest.AuthInfoServiceFake{ExpectedUser: user},
+			VerifyAfterSync: true,
+		}
+		if stale {
+			loginService.SQLStore = &staleReadStore{fakeStore: store}
+		}
+		return loginService, store
+	}
+
+	t.Run("stale reads fail the sync", func(t *testing.T) {
+		loginService, store := setup(true)
+
+		err := loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: extUser})
+
+		var verificationErr *login.ErrSyncVerificationFailed
+		require.True(t, errors.As(err, &verificationErr))
+		assert.Equal(t, int64(1), verificationErr.UserId)
+		assert.Equal(t, []string{
+			`role in organization 1 is "" instead of "Admin"`,
+			`removed membership of organization 2 still has role "Editor"`,
+			"server admin flag is false instead of true",
+		}, verificationErr.Mismatches)
+		// the writes were still made
+		assert.Equal(t, models.ROLE_ADMIN, store.orgUsers[1][1])
+		assert.True(t, store.users[1].IsAdmin)
+	})
+
+	t.Run("a matching read back passes", func(t *testing.T) {
+		loginService, _ := setup(false)
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: extUser}))
+	})
+
+	t.Run("nothing is read back when disabled", func(t *testing.T) {
+		loginService, _ := setup(true)
+		loginService.VerifyAfterSync = false
+
+		require.NoError(t, loginService.UpsertUser(context.Background(), &models.UpsertUserCommand{ExternalUser: extUser}))
+	})
+}
@@ -21,10 +21,14 @@ func (l *LoginServiceFake) DisableExternalUser(ctx context.Context, username str
 func (l *LoginServiceFake) SetTeamSyncFunc(login.TeamSyncFunc) {}
 
 type AuthInfoServiceFake struct {
-	LatestUserID         int64
-	ExpectedUser         *models.User
-	ExpectedExternalUser *models.ExternalUserInfo
-	ExpectedError        error
+	LatestUserID            int64
+	LatestSetAuthInfoCmd    *models.SetAuthInfoCommand
+	LatestUpdateAuthInfoCmd *models.UpdateAuthInfoCommand
+	ExpectedUser            *models.User
+	ExpectedExternalUser    *models.ExternalUserInfo
+	ExpectedError           error
+	// ExpectedSetAuthInfoError overrides ExpectedError for SetAuthInfo when set.
+	ExpectedSetAuthInfoError error
 }
 
 func (a *AuthInfoServiceFake) LookupAndUpdate(ctx context.Context, query *models.GetUserByAuthInfoQuery) (*models.User, error) {
@@ -38,10 +42,15 @@ func (a *AuthInfoServiceFake) GetAuthInfo(ctx context.Context, query *models.Get
 }
 
 func (a *AuthInfoServiceFake) SetAuthInfo(ctx context.Context, cmd *models.SetAuthInfoCommand) error {
+	a.LatestSetAuthInfoCmd = cmd
+	if a.ExpectedSetAuthInfoError != nil {
+		return a.ExpectedSetAuthInfoError
+	}
 	return a.ExpectedError
 }
 
 func (a *AuthInfoServiceFake) UpdateAuthInfo(ctx context.Context, cmd *models.UpdateAuthInfoCommand) error {
+	a.LatestUpdateAuthInfoCmd = cmd
 	return a.ExpectedError
 }
 
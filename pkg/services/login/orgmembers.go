@@ -0,0 +1,21 @@
+package login
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// OrgMemberCount is the number of members of an org with a role.
+type OrgMemberCount struct {
+	OrgId int64
+	Role  models.RoleType
+	Count int64
+}
+
+// OrgMemberCountStore counts the members of orgs, e.g. for capacity planning.
+type OrgMemberCountStore interface {
+	// GetOrgMemberCounts returns the number of members of each org by role,
+	// ordered by org id and role. Service accounts aren't counted.
+	GetOrgMemberCounts(ctx context.Context) ([]*OrgMemberCount, error)
+}
@@ -0,0 +1,29 @@
+package login
+
+import (
+	"context"
+	"time"
+)
+
+// OutboxEvent is a bus event stored in the outbox until it's delivered.
+type OutboxEvent struct {
+	Id int64
+	// Type names the event, e.g. "UserCreated", and decides how the payload
+	// is decoded.
+	Type     string
+	Payload  string
+	Created  time.Time
+	Attempts int
+}
+
+// OutboxStore persists the events of the login outbox. AddOutboxEvent must
+// join the transaction of ctx, if any, so the event is only stored with the
+// change it's about.
+type OutboxStore interface {
+	AddOutboxEvent(ctx context.Context, event *OutboxEvent) error
+	// GetOutboxEvents returns up to limit events, oldest first.
+	GetOutboxEvents(ctx context.Context, limit int) ([]*OutboxEvent, error)
+	DeleteOutboxEvent(ctx context.Context, id int64) error
+	// IncrementOutboxAttempts records a failed delivery of the event.
+	IncrementOutboxAttempts(ctx context.Context, id int64) error
+}
@@ -0,0 +1,25 @@
+package login
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// PendingOrgRole is an org role that couldn't be applied during sync because the
+// org didn't exist yet.
+type PendingOrgRole struct {
+	OrgId   int64
+	UserId  int64
+	Role    models.RoleType
+	Created time.Time
+}
+
+// PendingRoleStore stores pending org roles until their org is created. Saving a
+// role for an org and user that already have a pending role replaces it.
+type PendingRoleStore interface {
+	SavePendingOrgRole(ctx context.Context, role *PendingOrgRole) error
+	GetPendingOrgRoles(ctx context.Context, orgID int64) ([]*PendingOrgRole, error)
+	DeletePendingOrgRole(ctx context.Context, orgID, userID int64) error
+}
@@ -0,0 +1,19 @@
+package login
+
+import (
+	"context"
+)
+
+// UserPreferences are the user preferences that can be synced from the identity
+// provider. Empty values mean the default.
+type UserPreferences struct {
+	Locale   string
+	Timezone string
+}
+
+// UserPreferencesStore persists the synced user preferences. GetUserPreferences
+// returns nil when the user has none.
+type UserPreferencesStore interface {
+	GetUserPreferences(ctx context.Context, userID int64) (*UserPreferences, error)
+	SetUserPreferences(ctx context.Context, userID int64, prefs *UserPreferences) error
+}
@@ -0,0 +1,27 @@
+package login
+
+import (
+	"context"
+	"time"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// OrgRoleProvenance records the last org role set for a user by external sync.
+type OrgRoleProvenance struct {
+	UserId     int64
+	OrgId      int64
+	Role       models.RoleType
+	AuthModule string
+	// Source is the claim or group that produced the role, if known.
+	Source string
+	Synced time.Time
+}
+
+// RoleProvenanceStore persists org role provenance. GetOrgRoleProvenance returns a
+// nil provenance when the role was never set by external sync.
+type RoleProvenanceStore interface {
+	SaveOrgRoleProvenance(ctx context.Context, provenance *OrgRoleProvenance) error
+	GetOrgRoleProvenance(ctx context.Context, userID, orgID int64) (*OrgRoleProvenance, error)
+	DeleteOrgRoleProvenance(ctx context.Context, userID, orgID int64) error
+}
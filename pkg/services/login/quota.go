@@ -0,0 +1,11 @@
+package login
+
+import "context"
+
+// QuotaUsageService reports how much of a global quota is used, e.g. to warn
+// before it's reached.
+type QuotaUsageService interface {
+	// GlobalQuotaUsage returns the usage and the limit of the global quota of
+	// target. The limit is negative when the target is unlimited.
+	GlobalQuotaUsage(ctx context.Context, target string) (used int64, limit int64, err error)
+}
@@ -0,0 +1,25 @@
+package login
+
+import (
+	"context"
+	"time"
+)
+
+// SoftDeletedUser is a user marked as deleted that's kept until it's purged.
+type SoftDeletedUser struct {
+	UserId  int64
+	Deleted time.Time
+	// WasDisabled is set when the user was already disabled when it was soft
+	// deleted, restoring it doesn't enable it then.
+	WasDisabled bool
+}
+
+// SoftDeleteStore persists soft deleted users. GetSoftDeletedUser returns nil
+// when the user isn't soft deleted.
+type SoftDeleteStore interface {
+	GetSoftDeletedUser(ctx context.Context, userID int64) (*SoftDeletedUser, error)
+	SetSoftDeletedUser(ctx context.Context, user *SoftDeletedUser) error
+	DeleteSoftDeletedUser(ctx context.Context, userID int64) error
+	// GetSoftDeletedUsersBefore returns the users soft deleted before t.
+	GetSoftDeletedUsersBefore(ctx context.Context, t time.Time) ([]*SoftDeletedUser, error)
+}
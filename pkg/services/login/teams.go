@@ -0,0 +1,15 @@
+package login
+
+import (
+	"context"
+
+	"github.com/grafana/grafana/pkg/models"
+)
+
+// TeamMembershipService manages the team memberships of users. Memberships
+// added by external sync are flagged as external.
+type TeamMembershipService interface {
+	GetUserTeamMemberships(ctx context.Context, orgID, userID int64, external bool) ([]*models.TeamMemberDTO, error)
+	AddTeamMember(userID, orgID, teamID int64, isExternal bool, permission models.PermissionType) error
+	RemoveTeamMember(ctx context.Context, cmd *models.RemoveTeamMemberCommand) error
+}
@@ -0,0 +1,21 @@
+package login
+
+import (
+	"context"
+	"time"
+)
+
+// UserLock is a lock placed on a user, typically by the identity provider.
+type UserLock struct {
+	UserId  int64
+	Reason  string
+	Created time.Time
+}
+
+// UserLockStore persists user locks. GetUserLock returns a nil lock when the
+// user isn't locked.
+type UserLockStore interface {
+	GetUserLock(ctx context.Context, userID int64) (*UserLock, error)
+	SetUserLock(ctx context.Context, lock *UserLock) error
+	DeleteUserLock(ctx context.Context, userID int64) error
+}
@@ -20,3 +20,17 @@ type Store interface {
 	GetUserByLogin(ctx context.Context, login string) (*models.User, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
 }
+
+// UserProjectionStore is implemented by stores that can fetch a subset of the
+// user columns, see models.GetUserByAuthInfoQuery.UserColumns.
+type UserProjectionStore interface {
+	GetUserColumnsById(ctx context.Context, id int64, columns []string) (*models.User, error)
+}
+
+// DisplayNameStore is implemented by stores that can check whether a display
+// name is taken, for unique display names.
+type DisplayNameStore interface {
+	// IsDisplayNameTaken reports whether a user other than exceptUserId has the
+	// name, service accounts aside.
+	IsDisplayNameTaken(ctx context.Context, name string, exceptUserId int64) (bool, error)
+}
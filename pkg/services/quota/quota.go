@@ -144,6 +144,32 @@ func (qs *QuotaService) CheckQuotaReached(ctx context.Context, target string, sc
 	return false, nil
 }
 
+// GlobalQuotaUsage returns the usage and the limit of the global quota of a
+// target. The limit is -1 if quotas are disabled or the target is unlimited.
+func (qs *QuotaService) GlobalQuotaUsage(ctx context.Context, target string) (int64, int64, error) {
+	if !qs.Cfg.Quota.Enabled {
+		return 0, -1, nil
+	}
+	scopes, err := qs.getQuotaScopes(target)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, scope := range scopes {
+		if scope.Name != "global" {
+			continue
+		}
+		if scope.DefaultLimit < 0 {
+			return 0, -1, nil
+		}
+		query := models.GetGlobalQuotaByTargetQuery{Target: scope.Target, UnifiedAlertingEnabled: qs.Cfg.UnifiedAlerting.IsEnabled()}
+		if err := qs.SQLStore.GetGlobalQuotaByTarget(ctx, &query); err != nil {
+			return 0, 0, err
+		}
+		return query.Result.Used, scope.DefaultLimit, nil
+	}
+	return 0, -1, nil
+}
+
 func (qs *QuotaService) getQuotaScopes(target string) ([]models.QuotaScope, error) {
 	scopes := make([]models.QuotaScope, 0)
 	switch target {
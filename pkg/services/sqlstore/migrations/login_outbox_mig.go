@@ -0,0 +1,18 @@
+package migrations
+
+import . "github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+
+func addLoginOutboxMigrations(mg *Migrator) {
+	loginOutboxV1 := Table{
+		Name: "login_outbox",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "type", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "payload", Type: DB_Text, Nullable: false},
+			{Name: "created", Type: DB_DateTime, Nullable: false},
+			{Name: "attempts", Type: DB_Int, Default: "0", Nullable: false},
+		},
+	}
+
+	mg.AddMigration("create login_outbox table", NewAddTableMigration(loginOutboxV1))
+}
@@ -80,6 +80,14 @@ func (*OSSMigrations) AddMigration(mg *Migrator) {
 		}
 	}
 	addQueryHistoryStarMigrations(mg)
+	addUserLockMigrations(mg)
+	addPendingOrgRoleMigrations(mg)
+	addOrgRoleProvenanceMigrations(mg)
+	addUserDisableSourceMigrations(mg)
+	addUserSyncedPreferencesMigrations(mg)
+	addUserSoftDeleteMigrations(mg)
+	addLoginOutboxMigrations(mg)
+	addUserLabelMigrations(mg)
 
 	if mg.Cfg != nil && mg.Cfg.IsFeatureToggleEnabled != nil {
 		if mg.Cfg.IsFeatureToggleEnabled(featuremgmt.FlagDashboardComments) || mg.Cfg.IsFeatureToggleEnabled(featuremgmt.FlagAnnotationComments) {
@@ -66,4 +66,8 @@ func addOrgMigrations(mg *Migrator) {
 
 	const migrateReadOnlyViewersToViewers = `UPDATE org_user SET role = 'Viewer' WHERE role = 'Read Only Editor'`
 	mg.AddMigration("Migrate all Read Only Viewers to Viewers", NewRawSQLMigration(migrateReadOnlyViewersToViewers))
+
+	mg.AddMigration("Add expires to org_user table", NewAddColumnMigration(orgUserV1, &Column{
+		Name: "expires", Type: DB_BigInt, Nullable: true,
+	}))
 }
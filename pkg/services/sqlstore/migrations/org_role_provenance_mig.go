@@ -0,0 +1,25 @@
+package migrations
+
+import . "github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+
+func addOrgRoleProvenanceMigrations(mg *Migrator) {
+	orgRoleProvenanceV1 := Table{
+		Name: "org_role_provenance",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "user_id", Type: DB_BigInt, Nullable: false},
+			{Name: "org_id", Type: DB_BigInt, Nullable: false},
+			{Name: "role", Type: DB_NVarchar, Length: 20, Nullable: false},
+			{Name: "auth_module", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "source", Type: DB_NVarchar, Length: 255, Nullable: false},
+			{Name: "synced", Type: DB_DateTime, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"user_id", "org_id"}, Type: UniqueIndex},
+			{Cols: []string{"org_id"}},
+		},
+	}
+
+	mg.AddMigration("create org_role_provenance table", NewAddTableMigration(orgRoleProvenanceV1))
+	addTableIndicesMigrations(mg, "v1", orgRoleProvenanceV1)
+}
@@ -46,4 +46,12 @@ func addUserAuthMigrations(mg *Migrator) {
 	mg.AddMigration("Add OAuth ID token to user_auth", NewAddColumnMigration(userAuthV1, &Column{
 		Name: "o_auth_id_token", Type: DB_Text, Nullable: true,
 	}))
+
+	mg.AddMigration("Add provider label to user_auth", NewAddColumnMigration(userAuthV1, &Column{
+		Name: "provider_label", Type: DB_NVarchar, Length: 190, Nullable: true,
+	}))
+
+	mg.AddMigration("Add raw claims to user_auth", NewAddColumnMigration(userAuthV1, &Column{
+		Name: "raw_claims", Type: DB_Text, Nullable: true,
+	}))
 }
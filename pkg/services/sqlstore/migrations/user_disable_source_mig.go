@@ -0,0 +1,20 @@
+package migrations
+
+import . "github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+
+func addUserDisableSourceMigrations(mg *Migrator) {
+	userDisableSourceV1 := Table{
+		Name: "user_disable_source",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "user_id", Type: DB_BigInt, Nullable: false},
+			{Name: "source", Type: DB_NVarchar, Length: 40, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"user_id"}, Type: UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create user_disable_source table", NewAddTableMigration(userDisableSourceV1))
+	addTableIndicesMigrations(mg, "v1", userDisableSourceV1)
+}
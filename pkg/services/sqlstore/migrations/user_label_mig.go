@@ -0,0 +1,22 @@
+package migrations
+
+import . "github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+
+func addUserLabelMigrations(mg *Migrator) {
+	userLabelV1 := Table{
+		Name: "user_label",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "user_id", Type: DB_BigInt, Nullable: false},
+			{Name: "label", Type: DB_NVarchar, Length: 190, Nullable: false},
+			{Name: "created", Type: DB_DateTime, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"user_id", "label"}, Type: UniqueIndex},
+			{Cols: []string{"label"}},
+		},
+	}
+
+	mg.AddMigration("create user_label table", NewAddTableMigration(userLabelV1))
+	addTableIndicesMigrations(mg, "v1", userLabelV1)
+}
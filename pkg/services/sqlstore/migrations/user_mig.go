@@ -139,6 +139,15 @@ func addUserMigrations(mg *Migrator) {
 			SQLite(migSQLITEisServiceAccountNullable).
 			Postgres("ALTER TABLE `user` ALTER COLUMN is_service_account DROP NOT NULL;").
 			Mysql("ALTER TABLE user MODIFY is_service_account BOOLEAN DEFAULT 0;"))
+
+	// last_sync_status and last_sync_error annotate users whose last external login sync had errors.
+	mg.AddMigration("Add last_sync_status column to user", NewAddColumnMigration(userV2, &Column{
+		Name: "last_sync_status", Type: DB_NVarchar, Length: 40, Nullable: true,
+	}))
+
+	mg.AddMigration("Add last_sync_error column to user", NewAddColumnMigration(userV2, &Column{
+		Name: "last_sync_error", Type: DB_Text, Nullable: true,
+	}))
 }
 
 const migSQLITEisServiceAccountNullable = `ALTER TABLE user ADD COLUMN tmp_service_account BOOLEAN DEFAULT 0;
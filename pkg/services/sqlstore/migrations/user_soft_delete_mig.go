@@ -0,0 +1,22 @@
+package migrations
+
+import . "github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+
+func addUserSoftDeleteMigrations(mg *Migrator) {
+	userSoftDeleteV1 := Table{
+		Name: "user_soft_delete",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "user_id", Type: DB_BigInt, Nullable: false},
+			{Name: "deleted", Type: DB_DateTime, Nullable: false},
+			{Name: "was_disabled", Type: DB_Bool, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"user_id"}, Type: UniqueIndex},
+			{Cols: []string{"deleted"}},
+		},
+	}
+
+	mg.AddMigration("create user_soft_delete table", NewAddTableMigration(userSoftDeleteV1))
+	addTableIndicesMigrations(mg, "v1", userSoftDeleteV1)
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import . "github.com/grafana/grafana/pkg/services/sqlstore/migrator"
+
+func addUserSyncedPreferencesMigrations(mg *Migrator) {
+	userSyncedPreferencesV1 := Table{
+		Name: "user_synced_preferences",
+		Columns: []*Column{
+			{Name: "id", Type: DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "user_id", Type: DB_BigInt, Nullable: false},
+			{Name: "locale", Type: DB_NVarchar, Length: 50, Nullable: false},
+			{Name: "timezone", Type: DB_NVarchar, Length: 50, Nullable: false},
+		},
+		Indices: []*Index{
+			{Cols: []string{"user_id"}, Type: UniqueIndex},
+		},
+	}
+
+	mg.AddMigration("create user_synced_preferences table", NewAddTableMigration(userSyncedPreferencesV1))
+	addTableIndicesMigrations(mg, "v1", userSyncedPreferencesV1)
+}
@@ -178,6 +178,10 @@ func (m *SQLStoreMock) UpdateUserLastSeenAt(ctx context.Context, cmd *models.Upd
 	return m.ExpectedError
 }
 
+func (m *SQLStoreMock) UpdateUserSyncStatus(ctx context.Context, cmd *models.UpdateUserSyncStatusCommand) error {
+	return m.ExpectedError
+}
+
 func (m *SQLStoreMock) SetUsingOrg(ctx context.Context, cmd *models.SetUsingOrgCommand) error {
 	return m.ExpectedSetUsingOrgError
 }
@@ -476,6 +480,14 @@ func (m *SQLStoreMock) RemoveOrgUser(ctx context.Context, cmd *models.RemoveOrgU
 	return testData.Response
 }
 
+func (m *SQLStoreMock) GetExpiredOrgUsers(ctx context.Context, query *models.GetExpiredOrgUsersQuery) error {
+	return m.ExpectedError
+}
+
+func (m *SQLStoreMock) CountOrgAdmins(ctx context.Context, query *models.CountOrgAdminsQuery) error {
+	return m.ExpectedError
+}
+
 func (m *SQLStoreMock) SaveDashboard(cmd models.SaveDashboardCommand) (*models.Dashboard, error) {
 	return nil, m.ExpectedError
 }
@@ -40,6 +40,7 @@ func (ss *SQLStore) AddOrgUser(ctx context.Context, cmd *models.AddOrgUserComman
 			Role:    cmd.Role,
 			Created: time.Now(),
 			Updated: time.Now(),
+			Expires: cmd.Expires,
 		}
 
 		_, err := sess.Insert(&entity)
@@ -80,6 +81,11 @@ func (ss *SQLStore) UpdateOrgUser(ctx context.Context, cmd *models.UpdateOrgUser
 
 		orgUser.Role = cmd.Role
 		orgUser.Updated = time.Now()
+		if cmd.UpdateExpires {
+			// all columns, so that a nil expiry is written too
+			orgUser.Expires = cmd.Expires
+			sess.AllCols()
+		}
 		_, err = sess.ID(orgUser.Id).Update(&orgUser)
 		if err != nil {
 			return err
@@ -237,6 +243,33 @@ func (ss *SQLStore) SearchOrgUsers(ctx context.Context, query *models.SearchOrgU
 	})
 }
 
+// GetExpiredOrgUsers returns the org memberships that expired at or before query.Now.
+func (ss *SQLStore) GetExpiredOrgUsers(ctx context.Context, query *models.GetExpiredOrgUsersQuery) error {
+	return ss.WithDbSession(ctx, func(sess *DBSession) error {
+		query.Result = make([]*models.OrgUser, 0)
+		return sess.Where("expires IS NOT NULL AND expires <= ?", query.Now).Find(&query.Result)
+	})
+}
+
+// CountOrgAdmins counts the enabled admins of an org, service accounts and
+// query.ExcludeUserIds aside.
+func (ss *SQLStore) CountOrgAdmins(ctx context.Context, query *models.CountOrgAdminsQuery) error {
+	return ss.WithDbSession(ctx, func(dbSession *DBSession) error {
+		user := ss.Dialect.Quote("user")
+		sess := dbSession.Table("org_user")
+		sess.Join("INNER", user, fmt.Sprintf("org_user.user_id=%s.id", user))
+		sess.Where("org_user.org_id = ? AND org_user.role = ?", query.OrgId, models.ROLE_ADMIN)
+		sess.And(fmt.Sprintf("%s.is_disabled = ? AND %s.is_service_account = ?", user, user), dialect.BooleanStr(false), dialect.BooleanStr(false))
+		if len(query.ExcludeUserIds) > 0 {
+			sess.NotIn("org_user.user_id", query.ExcludeUserIds)
+		}
+
+		var err error
+		query.Result, err = sess.Count()
+		return err
+	})
+}
+
 func (ss *SQLStore) RemoveOrgUser(ctx context.Context, cmd *models.RemoveOrgUserCommand) error {
 	return ss.WithTransactionalDbSession(ctx, func(sess *DBSession) error {
 		// check if user exists
@@ -191,6 +191,99 @@ func TestSQLStore_RemoveOrgUser(t *testing.T) {
 	require.Equal(t, user.Result.OrgId, int64(0))
 }
 
+func TestSQLStore_GetExpiredOrgUsers(t *testing.T) {
+	store := InitTestDB(t)
+
+	_, err := store.CreateUser(context.Background(), models.CreateUserCommand{
+		Login: "admin",
+		OrgId: 1,
+	})
+	require.NoError(t, err)
+
+	expires := int64(1000)
+	for i, userExpires := range []*int64{&expires, nil} {
+		user, err := store.CreateUser(context.Background(), models.CreateUserCommand{
+			Login:        fmt.Sprintf("user-%d", i),
+			SkipOrgSetup: true,
+		})
+		require.NoError(t, err)
+
+		err = store.AddOrgUser(context.Background(), &models.AddOrgUserCommand{
+			Role:    models.ROLE_VIEWER,
+			OrgId:   1,
+			UserId:  user.Id,
+			Expires: userExpires,
+		})
+		require.NoError(t, err)
+	}
+
+	query := &models.GetExpiredOrgUsersQuery{Now: 999}
+	require.NoError(t, store.GetExpiredOrgUsers(context.Background(), query))
+	require.Empty(t, query.Result)
+
+	query = &models.GetExpiredOrgUsersQuery{Now: 1000}
+	require.NoError(t, store.GetExpiredOrgUsers(context.Background(), query))
+	require.Len(t, query.Result, 1)
+	require.Equal(t, int64(2), query.Result[0].UserId)
+
+	t.Run("updating the role keeps the expiry", func(t *testing.T) {
+		err := store.UpdateOrgUser(context.Background(), &models.UpdateOrgUserCommand{
+			Role:   models.ROLE_EDITOR,
+			OrgId:  1,
+			UserId: 2,
+		})
+		require.NoError(t, err)
+
+		query := &models.GetExpiredOrgUsersQuery{Now: 1000}
+		require.NoError(t, store.GetExpiredOrgUsers(context.Background(), query))
+		require.Len(t, query.Result, 1)
+		require.Equal(t, models.ROLE_EDITOR, query.Result[0].Role)
+	})
+
+	t.Run("updating the expiry replaces it", func(t *testing.T) {
+		err := store.UpdateOrgUser(context.Background(), &models.UpdateOrgUserCommand{
+			Role:          models.ROLE_EDITOR,
+			OrgId:         1,
+			UserId:        2,
+			UpdateExpires: true,
+		})
+		require.NoError(t, err)
+
+		query := &models.GetExpiredOrgUsersQuery{Now: 1000}
+		require.NoError(t, store.GetExpiredOrgUsers(context.Background(), query))
+		require.Empty(t, query.Result)
+	})
+}
+
+func TestSQLStore_CountOrgAdmins(t *testing.T) {
+	ctx := context.Background()
+	store := InitTestDB(t)
+
+	// the owner is the admin of its own org
+	owner, err := store.CreateUser(ctx, models.CreateUserCommand{Login: "owner"})
+	require.NoError(t, err)
+	addMember := func(cmd models.CreateUserCommand, role models.RoleType) *models.User {
+		cmd.SkipOrgSetup = true
+		user, err := store.CreateUser(ctx, cmd)
+		require.NoError(t, err)
+		require.NoError(t, store.AddOrgUser(ctx, &models.AddOrgUserCommand{OrgId: owner.OrgId, UserId: user.Id, Role: role}))
+		return user
+	}
+	admin := addMember(models.CreateUserCommand{Login: "admin"}, models.ROLE_ADMIN)
+	addMember(models.CreateUserCommand{Login: "disabled", IsDisabled: true}, models.ROLE_ADMIN)
+	addMember(models.CreateUserCommand{Login: "editor"}, models.ROLE_EDITOR)
+	_, err = store.CreateUser(ctx, models.CreateUserCommand{Login: "sa-admin", OrgId: owner.OrgId, DefaultOrgRole: string(models.ROLE_ADMIN), IsServiceAccount: true})
+	require.NoError(t, err)
+
+	query := &models.CountOrgAdminsQuery{OrgId: owner.OrgId}
+	require.NoError(t, store.CountOrgAdmins(ctx, query))
+	assert.Equal(t, int64(2), query.Result)
+
+	query = &models.CountOrgAdminsQuery{OrgId: owner.OrgId, ExcludeUserIds: []int64{owner.Id, admin.Id}}
+	require.NoError(t, store.CountOrgAdmins(ctx, query))
+	assert.Equal(t, int64(0), query.Result)
+}
+
 func seedOrgUsers(t *testing.T, store *SQLStore, numUsers int) {
 	t.Helper()
 	// Seed users
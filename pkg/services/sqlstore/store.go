@@ -35,6 +35,7 @@ type Store interface {
 	UpdateUser(ctx context.Context, cmd *models.UpdateUserCommand) error
 	ChangeUserPassword(ctx context.Context, cmd *models.ChangeUserPasswordCommand) error
 	UpdateUserLastSeenAt(ctx context.Context, cmd *models.UpdateUserLastSeenAtCommand) error
+	UpdateUserSyncStatus(ctx context.Context, cmd *models.UpdateUserSyncStatusCommand) error
 	SetUsingOrg(ctx context.Context, cmd *models.SetUsingOrgCommand) error
 	GetUserProfile(ctx context.Context, query *models.GetUserProfileQuery) error
 	GetUserOrgList(ctx context.Context, query *models.GetUserOrgListQuery) error
@@ -103,6 +104,8 @@ type Store interface {
 	GetOrgUsers(ctx context.Context, query *models.GetOrgUsersQuery) error
 	SearchOrgUsers(ctx context.Context, query *models.SearchOrgUsersQuery) error
 	RemoveOrgUser(ctx context.Context, cmd *models.RemoveOrgUserCommand) error
+	GetExpiredOrgUsers(ctx context.Context, query *models.GetExpiredOrgUsersQuery) error
+	CountOrgAdmins(ctx context.Context, query *models.CountOrgAdminsQuery) error
 	GetDashboard(ctx context.Context, query *models.GetDashboardQuery) error
 	GetDashboardTags(ctx context.Context, query *models.GetDashboardTagsQuery) error
 	SearchDashboards(ctx context.Context, query *models.FindPersistedDashboardsQuery) error
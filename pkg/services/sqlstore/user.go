@@ -394,6 +394,20 @@ func (ss *SQLStore) UpdateUserLastSeenAt(ctx context.Context, cmd *models.Update
 	})
 }
 
+// UpdateUserSyncStatus only writes the sync status columns, and publishes no
+// UserUpdated event as the user itself didn't change.
+func (ss *SQLStore) UpdateUserSyncStatus(ctx context.Context, cmd *models.UpdateUserSyncStatusCommand) error {
+	return ss.WithTransactionalDbSession(ctx, func(sess *DBSession) error {
+		user := models.User{
+			LastSyncStatus: cmd.Status,
+			LastSyncError:  cmd.Error,
+		}
+
+		_, err := sess.ID(cmd.UserId).Cols("last_sync_status", "last_sync_error").Update(&user)
+		return err
+	})
+}
+
 func (ss *SQLStore) SetUsingOrg(ctx context.Context, cmd *models.SetUsingOrgCommand) error {
 	getOrgsForUserCmd := &models.GetUserOrgListQuery{UserId: cmd.UserId}
 	if err := ss.GetUserOrgList(ctx, getOrgsForUserCmd); err != nil {
@@ -492,7 +506,7 @@ func (ss *SQLStore) GetUserOrgList(ctx context.Context, query *models.GetUserOrg
 		sess.Join("INNER", x.Dialect().Quote("user"), fmt.Sprintf("org_user.user_id=%s.id", x.Dialect().Quote("user")))
 		sess.Where("org_user.user_id=?", query.UserId)
 		sess.Where(notServiceAccountFilter(ss))
-		sess.Cols("org.name", "org_user.role", "org_user.org_id")
+		sess.Cols("org.name", "org_user.role", "org_user.org_id", "org_user.expires")
 		sess.OrderBy("org.name")
 		err := sess.Find(&query.Result)
 		sort.Sort(byOrgName(query.Result))
@@ -753,6 +767,9 @@ func deleteUserInTransaction(ss *SQLStore, sess *DBSession, cmd *models.DeleteUs
 	if !has {
 		return models.ErrUserNotFound
 	}
+	if err := deleteUserAccessControl(sess, cmd.UserId); err != nil {
+		return err
+	}
 	for _, sql := range UserDeletions() {
 		_, err := sess.Exec(sql, cmd.UserId)
 		if err != nil {
@@ -760,7 +777,7 @@ func deleteUserInTransaction(ss *SQLStore, sess *DBSession, cmd *models.DeleteUs
 		}
 	}
 
-	return deleteUserAccessControl(sess, cmd.UserId)
+	return nil
 }
 
 func deleteUserAccessControl(sess *DBSession, userID int64) error {
@@ -804,9 +821,9 @@ func deleteUserAccessControl(sess *DBSession, userID int64) error {
 }
 
 func UserDeletions() []string {
+	// the user is deleted last so that rows referencing it never outlive it
 	deletes := []string{
 		"DELETE FROM star WHERE user_id = ?",
-		"DELETE FROM " + dialect.Quote("user") + " WHERE id = ?",
 		"DELETE FROM org_user WHERE user_id = ?",
 		"DELETE FROM dashboard_acl WHERE user_id = ?",
 		"DELETE FROM preferences WHERE user_id = ?",
@@ -814,6 +831,14 @@ func UserDeletions() []string {
 		"DELETE FROM user_auth WHERE user_id = ?",
 		"DELETE FROM user_auth_token WHERE user_id = ?",
 		"DELETE FROM quota WHERE user_id = ?",
+		"DELETE FROM user_lock WHERE user_id = ?",
+		"DELETE FROM pending_org_role WHERE user_id = ?",
+		"DELETE FROM org_role_provenance WHERE user_id = ?",
+		"DELETE FROM user_disable_source WHERE user_id = ?",
+		"DELETE FROM user_synced_preferences WHERE user_id = ?",
+		"DELETE FROM user_soft_delete WHERE user_id = ?",
+		"DELETE FROM user_label WHERE user_id = ?",
+		"DELETE FROM " + dialect.Quote("user") + " WHERE id = ?",
 	}
 	return deletes
 }
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/grafana/grafana/pkg/bus"
+	"github.com/grafana/grafana/pkg/events"
 	"github.com/grafana/grafana/pkg/models"
 	"github.com/grafana/grafana/pkg/setting"
 	"github.com/stretchr/testify/require"
@@ -450,6 +452,37 @@ func TestUserDataAccess(t *testing.T) {
 		})
 	})
 
+	t.Run("Testing DB - sets and clears the last sync status", func(t *testing.T) {
+		ss = InitTestDB(t)
+		user, err := ss.CreateUser(context.Background(), models.CreateUserCommand{Login: "synced", Email: "synced@test.com"})
+		require.NoError(t, err)
+
+		updated := 0
+		bus.AddEventListener(func(_ context.Context, e *events.UserUpdated) error {
+			updated++
+			return nil
+		})
+
+		status, message := models.UserSyncHadErrors, "team sync failed"
+		err = ss.UpdateUserSyncStatus(context.Background(), &models.UpdateUserSyncStatusCommand{UserId: user.Id, Status: status, Error: message})
+		require.NoError(t, err)
+
+		query := models.GetUserByIdQuery{Id: user.Id}
+		require.NoError(t, ss.GetUserById(context.Background(), &query))
+		require.Equal(t, models.UserSyncHadErrors, query.Result.LastSyncStatus)
+		require.Equal(t, "team sync failed", query.Result.LastSyncError)
+		require.Equal(t, "synced", query.Result.Login)
+
+		status, message = "", ""
+		err = ss.UpdateUserSyncStatus(context.Background(), &models.UpdateUserSyncStatusCommand{UserId: user.Id, Status: status, Error: message})
+		require.NoError(t, err)
+
+		require.NoError(t, ss.GetUserById(context.Background(), &query))
+		require.Empty(t, query.Result.LastSyncStatus)
+		require.Empty(t, query.Result.LastSyncError)
+		require.Zero(t, updated, "a sync status update shouldn't publish a user update")
+	})
+
 	t.Run("Testing DB - grafana admin users", func(t *testing.T) {
 
 		ss = InitTestDB(t)
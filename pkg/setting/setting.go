@@ -408,6 +408,11 @@ type Cfg struct {
 	AutoAssignOrgRole          string
 	OAuthSkipOrgRoleUpdateSync bool
 
+	// LoginOutboxEnabled stores the events of external user sync in the login
+	// outbox, relayed to the bus every LoginOutboxRelayInterval.
+	LoginOutboxEnabled       bool
+	LoginOutboxRelayInterval time.Duration
+
 	// ExpressionsEnabled specifies whether expressions are enabled.
 	ExpressionsEnabled bool
 
@@ -1271,6 +1276,8 @@ func readAuthSettings(iniFile *ini.File, cfg *Cfg) (err error) {
 	cfg.OAuthCookieMaxAge = auth.Key("oauth_state_cookie_max_age").MustInt(600)
 	SignoutRedirectUrl = valueAsString(auth, "signout_redirect_url", "")
 	cfg.OAuthSkipOrgRoleUpdateSync = auth.Key("oauth_skip_org_role_update_sync").MustBool(false)
+	cfg.LoginOutboxEnabled = auth.Key("login_outbox_enabled").MustBool(false)
+	cfg.LoginOutboxRelayInterval = auth.Key("login_outbox_relay_interval").MustDuration(10 * time.Second)
 
 	// SigV4
 	SigV4AuthEnabled = auth.Key("sigv4_auth_enabled").MustBool(false)